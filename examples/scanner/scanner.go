@@ -0,0 +1,73 @@
+/* ZDNS Copyright 2024 Regents of the University of Michigan
+*
+* Licensed under the Apache License, Version 2.0 (the "License"); you may not
+* use this file except in compliance with the License. You may obtain a copy
+* of the License at http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+* implied. See the License for the specific language governing
+* permissions and limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/signal"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/zmap/zdns/examples/utils"
+	"github.com/zmap/zdns/src/zdns"
+)
+
+func main() {
+	localAddr, err := utils.GetLocalIPByConnecting()
+	if err != nil {
+		log.Fatal("Error getting local IP: ", err)
+	}
+	resolverConfig := zdns.NewResolverConfig()
+	resolverConfig.LocalAddrsV4 = []net.IP{localAddr}
+	resolverConfig.ExternalNameServersV4 = []zdns.NameServer{{IP: net.ParseIP("1.1.1.1"), Port: 53}}
+	resolverConfig.RootNameServersV4 = []zdns.NameServer{{IP: net.ParseIP("198.41.0.4"), Port: 53}}
+	resolverConfig.IPVersionMode = zdns.IPv4Only
+
+	// The scanner creates one resolver per worker from the config, sharing a single cache between them
+	scanner, err := zdns.NewScanner(resolverConfig, 10, zdns.IterativeLookupFunc)
+	if err != nil {
+		log.Fatal("Error creating scanner: ", err)
+	}
+	// Cancelling the context, here with Ctrl-C, stops the scan early
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	questions := make(chan *zdns.Question)
+	results, err := scanner.Scan(ctx, questions)
+	if err != nil {
+		log.Fatal("Error starting scan: ", err)
+	}
+	go func() {
+		defer close(questions)
+		for _, domain := range []string{"google.com", "facebook.com", "yahoo.com", "wikipedia.org"} {
+			select {
+			case questions <- &zdns.Question{Name: domain, Type: dns.TypeA, Class: dns.ClassINET}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	// Results arrive in the order lookups complete, each one identifies the question it answers
+	for res := range results {
+		if res.Err != nil {
+			log.Warnf("%s: %s (%v)", res.Question.Name, res.Status, res.Err)
+			continue
+		}
+		log.Warnf("%s: %s in %v, %d answers", res.Question.Name, res.Status, res.Duration, len(res.Result.Answers))
+	}
+	log.Warn("All lookups complete")
+}
@@ -26,10 +26,20 @@ import (
 	_ "github.com/zmap/zdns/src/modules/alookup"
 	_ "github.com/zmap/zdns/src/modules/axfr"
 	_ "github.com/zmap/zdns/src/modules/bindversion"
+	_ "github.com/zmap/zdns/src/modules/caalookup"
+	_ "github.com/zmap/zdns/src/modules/dane"
+	_ "github.com/zmap/zdns/src/modules/delegationcheck"
+	_ "github.com/zmap/zdns/src/modules/dkim"
 	_ "github.com/zmap/zdns/src/modules/dmarc"
+	_ "github.com/zmap/zdns/src/modules/httpslookup"
+	_ "github.com/zmap/zdns/src/modules/lamecheck"
+	_ "github.com/zmap/zdns/src/modules/mtasts"
 	_ "github.com/zmap/zdns/src/modules/mxlookup"
 	_ "github.com/zmap/zdns/src/modules/nslookup"
+	_ "github.com/zmap/zdns/src/modules/soasync"
 	_ "github.com/zmap/zdns/src/modules/spf"
+	_ "github.com/zmap/zdns/src/modules/subenum"
+	_ "github.com/zmap/zdns/src/modules/zonewalk"
 )
 
 func main() {
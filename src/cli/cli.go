@@ -26,6 +26,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	flags "github.com/zmap/zflags"
 
+	"github.com/zmap/zdns/src/cli/iohandlers"
 	"github.com/zmap/zdns/src/zdns"
 )
 
@@ -37,6 +38,13 @@ type InputHandler interface {
 type OutputHandler interface {
 	WriteResults(results <-chan string, wg *sync.WaitGroup) error
 }
+
+// CheckpointedOutputHandler is an OutputHandler that can resume writing where a previous, interrupted scan left off.
+// Implementations must only mark a result as done in the checkpoint once it has been durably written.
+type CheckpointedOutputHandler interface {
+	OutputHandler
+	WriteCheckpointedResults(results <-chan iohandlers.CheckpointedResult, cp *iohandlers.Checkpoint, wg *sync.WaitGroup) error
+}
 type StatusHandler interface {
 	LogPeriodicUpdates(statusChan <-chan zdns.Status, wg *sync.WaitGroup) error
 }
@@ -45,6 +53,7 @@ type StatusHandler interface {
 // Order here is the order they'll be printed to the user, so preserve alphabetical order
 type GeneralOptions struct {
 	LookupAllNameServers bool   `long:"all-nameservers" description:"Behavior is dependent on --iterative. In --iterative, --all-name-servers will query all root servers, then all gtld servers, etc. recording the responses at each layer. In non-iterative mode, the query will be sent to all external resolvers specified in --name-servers."`
+	CacheFilePath        string `long:"cache-file" description:"file to persist the cache in between runs. Loaded at startup if it exists and saved on exit, expired records are discarded"`
 	CacheSize            int    `long:"cache-size" default:"10000" description:"how many items can be stored in internal recursive cache"`
 	GoMaxProcs           int    `long:"go-processes" default:"0" description:"number of OS processes to use, GOMAXPROCS if 0"`
 	IterationTimeout     int    `long:"iteration-timeout" default:"8" description:"timeout for a single iterative step in an iterative query, in seconds. Only applicable with --iterative"`
@@ -67,7 +76,7 @@ type QueryOptions struct {
 	ClassString        string `long:"class" default:"INET" description:"DNS class to query. Options: INET, CSNET, CHAOS, HESIOD, NONE, ANY."`
 	ClientSubnetString string `long:"client-subnet" description:"Client subnet in CIDR format for EDNS0."`
 	Dnssec             bool   `long:"dnssec" description:"Requests DNSSEC records by setting the DNSSEC OK (DO) bit"`
-	ValidateDNSSEC     bool   `long:"validate-dnssec" description:"Validate DNSSEC records, only applicable with --iterative"`
+	ValidateDNSSEC     bool   `long:"validate-dnssec" description:"Validate DNSSEC records. External lookups fetch DNSKEY and DS records through the same resolver and report its AD bit alongside"`
 	UseNSID            bool   `long:"nsid" description:"Request NSID."`
 }
 
@@ -75,23 +84,28 @@ type QueryOptions struct {
 type NetworkOptions struct {
 	IPv4TransportOnly     bool   `long:"4" description:"utilize IPv4 query transport only, incompatible with --6"`
 	IPv6TransportOnly     bool   `long:"6" description:"utilize IPv6 query transport only, incompatible with --4"`
-	DNSOverHTTPS          bool   `long:"https" description:"Use DNS over HTTPS for lookups, mutually exclusive with --udp-only, --iterative, and --tls"`
+	DNSOverHTTPS          bool   `long:"https" description:"Use DNS over HTTPS for lookups, mutually exclusive with --udp-only, --iterative, --tls, and --quic"`
 	LocalAddrString       string `long:"local-addr" description:"comma-delimited list of local addresses to use, serve as the source IP for outbound queries"`
 	LocalIfaceString      string `long:"local-interface" description:"local interface to use"`
 	DisableRecycleSockets bool   `long:"no-recycle-sockets" description:"do not create long-lived unbound UDP socket for each thread at launch and reuse for all (UDP) queries"`
+	OpportunisticTLS      bool   `long:"opportunistic-tls" description:"During iterative resolution, try DNS over TLS to each name server first and fall back to UDP/TCP if it isn't supported. Requires --iterative, mutually exclusive with --udp-only"`
+	NameServerRateLimit   int    `long:"per-nameserver-rate-limit" default:"0" description:"maximum queries per second sent to any single name server IP, 0 for no limit"`
 	PreferIPv4Iteration   bool   `long:"prefer-ipv4-iteration" description:"Prefer IPv4/A record lookups during iterative resolution. Ignored unless used with both IPv4 and IPv6 query transport"`
 	PreferIPv6Iteration   bool   `long:"prefer-ipv6-iteration" description:"Prefer IPv6/AAAA record lookups during iterative resolution. Ignored unless used with both IPv4 and IPv6 query transport"`
+	DNSOverQUIC           bool   `long:"quic" description:"Use DNS over QUIC for lookups, mutually exclusive with --tcp-only, --iterative, --tls, and --https"`
+	RateLimit             int    `long:"rate-limit" default:"0" description:"maximum queries per second sent across all name servers, 0 for no limit"`
 	RootCAsFile           string `long:"root-cas-file" description:"Path to a file containing PEM-encoded root CAs to use for verifying server certificates, required for --verify-server-cert"`
 	TCPOnly               bool   `long:"tcp-only" description:"Only perform lookups over TCP"`
-	DNSOverTLS            bool   `long:"tls" description:"Use DNS over TLS for lookups, mutually exclusive with --udp-only, --iterative, and --https"`
+	DNSOverTLS            bool   `long:"tls" description:"Use DNS over TLS for lookups, mutually exclusive with --udp-only, --iterative, --https, and --quic"`
 	UDPOnly               bool   `long:"udp-only" description:"Only perform lookups over UDP"`
-	VerifyServerCert      bool   `long:"verify-server-cert" description:"Verify the server's certificate when using DNS over TLS or DNS over HTTPS"`
+	VerifyServerCert      bool   `long:"verify-server-cert" description:"Verify the server's certificate when using DNS over TLS, DNS over HTTPS, or DNS over QUIC"`
 }
 
 // InputOutputOptions options for controlling the input and output behavior of zdns. Applicable to all modules.
 type InputOutputOptions struct {
 	AlexaFormat                  bool   `long:"alexa" description:"is input file from Alexa Top Million download"`
 	BlacklistFilePath            string `long:"blacklist-file" description:"blacklist file for servers to exclude from lookups"`
+	CheckpointFilePath           string `long:"checkpoint-file" description:"file to record scan progress in. If the file exists, the scan resumes where it left off, appending to --output-file. The input must be identical between runs."`
 	DNSConfigFilePath            string `long:"conf-file" default:"/etc/resolv.conf" description:"config file for DNS servers"`
 	MultipleModuleConfigFilePath string `short:"c" long:"multi-config-file" description:"config file path for multiple module"`
 	IncludeInOutput              string `long:"include-fields" description:"Comma separated list of fields to additionally output beyond result verbosity. Options: class, protocol, ttl, resolver, flags, dnssec"`
@@ -99,10 +113,13 @@ type InputOutputOptions struct {
 	LogFilePath                  string `long:"log-file" default:"-" description:"where should JSON logs be saved, defaults to stderr"`
 	MetadataFilePath             string `long:"metadata-file" description:"where should JSON metadata be saved, defaults to no metadata output. Use '-' for stderr."`
 	MetadataFormat               bool   `long:"metadata-passthrough" description:"if input records have the form 'name,METADATA', METADATA will be propagated to the output"`
-	OutputFilePath               string `short:"o" long:"output-file" default:"-" description:"where should JSON output be saved, defaults to stdout"`
+	MetricsAddr                  string `long:"metrics-addr" description:"address to serve Prometheus metrics on at /metrics, ex: localhost:9153. Disabled by default"`
+	OutputFilePath               string `short:"o" long:"output-file" default:"-" description:"where should the output be saved, in the format set by --output-format, defaults to stdout"`
+	OutputFormat                 string `long:"output-format" default:"json" description:"format of the output. Options: json (one object per line), csv and parquet (one row per record, with the columns selected by --result-verbosity and --include-fields)"`
 	QuietStatusUpdates           bool   `short:"q" long:"quiet" description:"do not print status updates"`
 	NameOverride                 string `long:"override-name" description:"name overrides all passed in names. Commonly used with --name-server-mode."`
 	NamePrefix                   string `long:"prefix" description:"name to be prepended to what's passed in (e.g., www.)"`
+	RejectsFilePath              string `long:"rejects-file" description:"file to write malformed input lines to, which are otherwise only reported with status ILLEGAL_INPUT in the output"`
 	ResultVerbosity              string `long:"result-verbosity" default:"normal" description:"Sets verbosity of each output record. Options: short, normal, long, trace"`
 	StatusUpdatesFilePath        string `short:"u" long:"status-updates-file" default:"-" description:"file to write scan progress to, defaults to stderr"`
 	Verbosity                    int    `long:"verbosity" default:"3" description:"log verbosity: 1 (lowest)--5 (highest)"`
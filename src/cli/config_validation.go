@@ -51,6 +51,26 @@ func populateNetworkingConfig(gc *CLIConf) error {
 		return errors.New("--https and --tls cannot both be specified")
 	}
 
+	if gc.DNSOverQUIC && gc.IterativeResolution {
+		return errors.New("--quic and --iterative cannot both be specified")
+	}
+
+	if gc.TCPOnly && gc.DNSOverQUIC {
+		return errors.New("--tcp-only and --quic cannot both be specified")
+	}
+
+	if gc.DNSOverQUIC && (gc.DNSOverTLS || gc.DNSOverHTTPS) {
+		return errors.New("--quic cannot be specified with --tls or --https")
+	}
+
+	if gc.OpportunisticTLS && !gc.IterativeResolution {
+		return errors.New("--opportunistic-tls requires --iterative")
+	}
+
+	if gc.OpportunisticTLS && gc.UDPOnly {
+		return errors.New("--udp-only and --opportunistic-tls cannot both be specified")
+	}
+
 	if err := parseNameServers(gc); err != nil {
 		return errors.Wrap(err, "name servers could not be parsed")
 	}
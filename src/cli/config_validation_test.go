@@ -54,4 +54,25 @@ func TestValidateNetworkingConfig(t *testing.T) {
 		require.Nil(t, err, "Expected no error but got %v", err)
 		require.Equal(t, "127.0.0.1:53", gc.NameServers[0], "Expected user supplied port to not be changed")
 	})
+	t.Run("QUIC with an incompatible transport", func(t *testing.T) {
+		for _, networkOptions := range []NetworkOptions{
+			{DNSOverQUIC: true, TCPOnly: true},
+			{DNSOverQUIC: true, DNSOverTLS: true},
+			{DNSOverQUIC: true, DNSOverHTTPS: true},
+		} {
+			networkOptions.IPv4TransportOnly = true
+			gc := &CLIConf{NetworkOptions: networkOptions}
+			err := populateNetworkingConfig(gc)
+			require.NotNil(t, err, "Expected an error but got nil")
+		}
+	})
+	t.Run("Opportunistic TLS without iteration", func(t *testing.T) {
+		gc := &CLIConf{NetworkOptions: NetworkOptions{OpportunisticTLS: true, IPv4TransportOnly: true}}
+		require.Error(t, populateNetworkingConfig(gc))
+		gc = &CLIConf{
+			GeneralOptions: GeneralOptions{IterativeResolution: true},
+			NetworkOptions: NetworkOptions{OpportunisticTLS: true, UDPOnly: true, IPv4TransportOnly: true},
+		}
+		require.Error(t, populateNetworkingConfig(gc))
+	})
 }
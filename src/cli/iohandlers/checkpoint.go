@@ -0,0 +1,149 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package iohandlers
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/zmap/zdns/src/internal/util"
+)
+
+// InputLine is a single line of input, tagged with its zero-indexed position in the input
+type InputLine struct {
+	ID   int
+	Line string
+}
+
+// CheckpointedResult is the output produced for a single InputLine. Result is empty if the line produced no output.
+type CheckpointedResult struct {
+	ID     int
+	Result string
+}
+
+// Checkpoint tracks which input lines have been fully written to the output file so an interrupted scan can be resumed.
+// It is persisted as a small JSON document that is atomically replaced on every save, so a crash never leaves a
+// partially-written checkpoint behind.
+type Checkpoint struct {
+	OutputOffset   int64 `json:"output_offset"`       // size of the output file when the checkpoint was taken
+	CompletedBelow int   `json:"completed_below"`     // every input line with a lower ID has been written
+	Completed      []int `json:"completed,omitempty"` // input lines at or above CompletedBelow that have been written
+
+	path      string
+	resumed   bool
+	mu        sync.Mutex
+	completed map[int]struct{}
+}
+
+// LoadCheckpoint reads the checkpoint at path. If no checkpoint exists yet, an empty one is returned which will be
+// created on the first save.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	cp := &Checkpoint{path: path, completed: make(map[int]struct{})}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "unable to read checkpoint file")
+	}
+	if err = json.Unmarshal(data, cp); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse checkpoint file %s", path)
+	}
+	if cp.OutputOffset < 0 || cp.CompletedBelow < 0 {
+		return nil, errors.Errorf("checkpoint file %s is corrupt", path)
+	}
+	for _, id := range cp.Completed {
+		cp.completed[id] = struct{}{}
+	}
+	cp.Completed = nil
+	cp.resumed = true
+	return cp, nil
+}
+
+// Resumed returns true if the checkpoint was loaded from a previous run
+func (cp *Checkpoint) Resumed() bool {
+	return cp.resumed
+}
+
+// IsDone returns true if the output for the input line with the given ID has already been written
+func (cp *Checkpoint) IsDone(id int) bool {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if id < cp.CompletedBelow {
+		return true
+	}
+	_, ok := cp.completed[id]
+	return ok
+}
+
+// MarkDone records that the output for the input line with the given ID has been written. It is not persisted until
+// the next call to Save.
+func (cp *Checkpoint) MarkDone(id int) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if id < cp.CompletedBelow {
+		return
+	}
+	cp.completed[id] = struct{}{}
+	// input is mostly processed in-order, so advancing the watermark keeps the set of out-of-order lines small
+	for {
+		if _, ok := cp.completed[cp.CompletedBelow]; !ok {
+			break
+		}
+		delete(cp.completed, cp.CompletedBelow)
+		cp.CompletedBelow++
+	}
+}
+
+// Save persists the checkpoint along with the size of the output file. The caller must ensure all output up to
+// outputOffset has been flushed to stable storage before calling Save.
+func (cp *Checkpoint) Save(outputOffset int64) error {
+	cp.mu.Lock()
+	cp.OutputOffset = outputOffset
+	cp.Completed = make([]int, 0, len(cp.completed))
+	for id := range cp.completed {
+		cp.Completed = append(cp.Completed, id)
+	}
+	sort.Ints(cp.Completed)
+	data, err := json.Marshal(cp)
+	cp.Completed = nil
+	cp.mu.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "unable to encode checkpoint")
+	}
+	tmpPath := cp.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, util.DefaultFilePermissions)
+	if err != nil {
+		return errors.Wrap(err, "unable to open temporary checkpoint file")
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return errors.Wrap(err, "unable to write temporary checkpoint file")
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "unable to sync temporary checkpoint file")
+	}
+	if err = f.Close(); err != nil {
+		return errors.Wrap(err, "unable to close temporary checkpoint file")
+	}
+	if err = os.Rename(tmpPath, cp.path); err != nil {
+		return errors.Wrap(err, "unable to replace checkpoint file")
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package iohandlers
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	cp, err := LoadCheckpoint(path)
+	require.NoError(t, err)
+	require.False(t, cp.Resumed())
+
+	// out-of-order completion only advances the watermark once the gap is filled
+	cp.MarkDone(0)
+	cp.MarkDone(2)
+	cp.MarkDone(4)
+	require.Equal(t, 1, cp.CompletedBelow)
+	cp.MarkDone(1)
+	require.Equal(t, 3, cp.CompletedBelow)
+	require.NoError(t, cp.Save(42))
+
+	loaded, err := LoadCheckpoint(path)
+	require.NoError(t, err)
+	require.True(t, loaded.Resumed())
+	require.Equal(t, int64(42), loaded.OutputOffset)
+	for id, done := range []bool{true, true, true, false, true, false} {
+		require.Equal(t, done, loaded.IsDone(id), "line %d", id)
+	}
+}
+
+func TestCheckpointCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	require.NoError(t, os.WriteFile(path, []byte("{not json"), 0600))
+	_, err := LoadCheckpoint(path)
+	require.Error(t, err)
+}
+
+func TestWriteCheckpointedResultsDiscardsUncheckpointedOutput(t *testing.T) {
+	dir := t.TempDir()
+	outPath := filepath.Join(dir, "out.jsonl")
+	cp, err := LoadCheckpoint(filepath.Join(dir, "checkpoint"))
+	require.NoError(t, err)
+	cp.MarkDone(0)
+	cp.OutputOffset = int64(len("a\n"))
+	// simulate output that was written after the last checkpoint before a crash
+	require.NoError(t, os.WriteFile(outPath, []byte("a\npartial"), 0600))
+
+	results := make(chan CheckpointedResult, 3)
+	results <- CheckpointedResult{ID: 1, Result: "b"}
+	results <- CheckpointedResult{ID: 2}
+	results <- CheckpointedResult{ID: 3, Result: "c"}
+	close(results)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	require.NoError(t, NewFileOutputHandler(outPath).WriteCheckpointedResults(results, cp, &wg))
+
+	out, err := os.ReadFile(outPath)
+	require.NoError(t, err)
+	require.Equal(t, "a\nb\nc\n", string(out))
+	require.Equal(t, int64(len(out)), cp.OutputOffset)
+	require.Equal(t, 4, cp.CompletedBelow)
+}
+
+func TestAppendingFileOutputHandlerKeepsExistingOutput(t *testing.T) {
+	outPath := filepath.Join(t.TempDir(), "rejects.txt")
+	require.NoError(t, os.WriteFile(outPath, []byte("a\n"), 0600))
+
+	results := make(chan string, 1)
+	results <- "b"
+	close(results)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	require.NoError(t, NewAppendingFileOutputHandler(outPath).WriteResults(results, &wg))
+
+	out, err := os.ReadFile(outPath)
+	require.NoError(t, err)
+	require.Equal(t, "a\nb\n", string(out))
+}
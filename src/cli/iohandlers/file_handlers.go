@@ -16,8 +16,10 @@ package iohandlers
 
 import (
 	"bufio"
+	"io"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -62,6 +64,7 @@ func (h *FileInputHandler) FeedChannel(in chan<- string, wg *sync.WaitGroup) err
 
 type FileOutputHandler struct {
 	filepath string
+	append   bool // keep the existing contents of the file instead of truncating it
 }
 
 func NewFileOutputHandler(filepath string) *FileOutputHandler {
@@ -70,6 +73,14 @@ func NewFileOutputHandler(filepath string) *FileOutputHandler {
 	}
 }
 
+// NewAppendingFileOutputHandler returns a handler that appends results to the file, such as when resuming a scan
+func NewAppendingFileOutputHandler(filepath string) *FileOutputHandler {
+	return &FileOutputHandler{
+		filepath: filepath,
+		append:   true,
+	}
+}
+
 func (h *FileOutputHandler) WriteResults(results <-chan string, wg *sync.WaitGroup) error {
 	defer (*wg).Done()
 
@@ -77,8 +88,12 @@ func (h *FileOutputHandler) WriteResults(results <-chan string, wg *sync.WaitGro
 	if h.filepath == "" || h.filepath == "-" {
 		f = os.Stdout
 	} else {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if h.append {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
 		var err error
-		f, err = os.OpenFile(h.filepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, util.DefaultFilePermissions)
+		f, err = os.OpenFile(h.filepath, flags, util.DefaultFilePermissions)
 		if err != nil {
 			log.Fatalf("unable to open output file: %v", err)
 		}
@@ -97,3 +112,72 @@ func (h *FileOutputHandler) WriteResults(results <-chan string, wg *sync.WaitGro
 	}
 	return nil
 }
+
+// checkpointInterval is how often buffered output is synced to disk and the checkpoint is saved
+const checkpointInterval = time.Second
+
+// WriteCheckpointedResults writes results to the output file, periodically syncing the file and saving cp. Any output
+// written after the last checkpoint of a previous run is discarded, since those lines will be looked up again.
+func (h *FileOutputHandler) WriteCheckpointedResults(results <-chan CheckpointedResult, cp *Checkpoint, wg *sync.WaitGroup) error {
+	defer (*wg).Done()
+
+	if h.filepath == "" || h.filepath == "-" {
+		return errors.New("checkpointing requires an output file")
+	}
+	f, err := os.OpenFile(h.filepath, os.O_WRONLY|os.O_CREATE, util.DefaultFilePermissions)
+	if err != nil {
+		log.Fatalf("unable to open output file: %v", err)
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Fatalf("unable to close output file: %v", err)
+		}
+	}(f)
+	offset := cp.OutputOffset
+	if err = f.Truncate(offset); err != nil {
+		return errors.Wrap(err, "unable to truncate output file to last checkpoint")
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return errors.Wrap(err, "unable to seek to last checkpoint in output file")
+	}
+
+	w := bufio.NewWriter(f)
+	// IDs of lines whose output is buffered but not yet synced
+	pending := make([]int, 0)
+	checkpoint := func() error {
+		if err := w.Flush(); err != nil {
+			return errors.Wrap(err, "unable to write to output file")
+		}
+		if err := f.Sync(); err != nil {
+			return errors.Wrap(err, "unable to sync output file")
+		}
+		for _, id := range pending {
+			cp.MarkDone(id)
+		}
+		pending = pending[:0]
+		return cp.Save(offset)
+	}
+	ticker := time.NewTicker(checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case res, ok := <-results:
+			if !ok {
+				return checkpoint()
+			}
+			if len(res.Result) > 0 {
+				n, err := w.WriteString(res.Result + "\n")
+				if err != nil {
+					return errors.Wrap(err, "unable to write to output file")
+				}
+				offset += int64(n)
+			}
+			pending = append(pending, res.ID)
+		case <-ticker.C:
+			if err = checkpoint(); err != nil {
+				return err
+			}
+		}
+	}
+}
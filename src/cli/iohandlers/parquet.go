@@ -0,0 +1,91 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package iohandlers
+
+import (
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/pkg/errors"
+)
+
+// parquetRowGroupSize is the number of rows buffered in memory before they're written out as a row group
+const parquetRowGroupSize = 65536
+
+// parquetWriter writes rows of a TabularSchema to a Parquet file with one optional column per Column. Parquet orders
+// the columns of a group by name, so the file's columns are in alphabetical order rather than the schema's.
+type parquetWriter struct {
+	w       *parquet.Writer
+	indexes []int // index of each Column among the file's columns
+	row     parquet.Row
+}
+
+func newParquetWriter(w io.Writer, columns []Column) (*parquetWriter, error) {
+	group := make(parquet.Group, len(columns))
+	for _, column := range columns {
+		group[column.Name] = parquet.Optional(parquetNode(column.Type))
+	}
+	schema := parquet.NewSchema("zdns", group)
+	p := &parquetWriter{
+		w:       parquet.NewWriter(w, schema, parquet.MaxRowsPerRowGroup(parquetRowGroupSize)),
+		indexes: make([]int, len(columns)),
+		row:     make(parquet.Row, len(columns)),
+	}
+	for i, column := range columns {
+		leaf, ok := schema.Lookup(column.Name)
+		if !ok {
+			return nil, errors.Errorf("duplicate column %s", column.Name)
+		}
+		p.indexes[i] = leaf.ColumnIndex
+	}
+	return p, nil
+}
+
+// parquetNode returns the Parquet type of the values of a column of columnType
+func parquetNode(columnType ColumnType) parquet.Node {
+	switch columnType {
+	case ColumnInt:
+		return parquet.Int(64)
+	case ColumnFloat:
+		return parquet.Leaf(parquet.DoubleType)
+	case ColumnBool:
+		return parquet.Leaf(parquet.BooleanType)
+	default:
+		return parquet.String()
+	}
+}
+
+// Write writes a row, which has a value for each column as returned by TabularSchema.Rows
+func (p *parquetWriter) Write(row []interface{}) error {
+	if len(row) != len(p.indexes) {
+		return errors.Errorf("row has %d values, expected %d", len(row), len(p.indexes))
+	}
+	for i, value := range row {
+		index := p.indexes[i]
+		if value == nil {
+			p.row[index] = parquet.NullValue().Level(0, 0, index)
+		} else {
+			// the definition level of 1 marks the value of an optional column as present
+			p.row[index] = parquet.ValueOf(value).Level(0, 1, index)
+		}
+	}
+	_, err := p.w.WriteRows([]parquet.Row{p.row})
+	return errors.Wrap(err, "unable to write parquet file")
+}
+
+// Close writes any buffered rows and the file's footer. It doesn't close the underlying writer.
+func (p *parquetWriter) Close() error {
+	return errors.Wrap(p.w.Close(), "unable to write parquet file")
+}
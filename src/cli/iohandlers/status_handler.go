@@ -30,7 +30,8 @@ import (
 )
 
 type StatusHandler struct {
-	filePath string
+	filePath    string
+	rateLimiter *zdns.RateLimiter // optional, used to report throttling
 }
 
 type scanStats struct {
@@ -38,6 +39,7 @@ type scanStats struct {
 	domainsScanned  int
 	domainsSuccess  int // number of domains that returned either NXDOMAIN or NOERROR
 	statusOccurance map[zdns.Status]int
+	rateLimitStats  zdns.RateLimiterStatistics // rate limiter statistics as of the last status update
 }
 
 func NewStatusHandler(filePath string) *StatusHandler {
@@ -46,6 +48,11 @@ func NewStatusHandler(filePath string) *StatusHandler {
 	}
 }
 
+// SetRateLimiter causes status updates to report when queries are being throttled by rl
+func (h *StatusHandler) SetRateLimiter(rl *zdns.RateLimiter) {
+	h.rateLimiter = rl
+}
+
 // LogPeriodicUpdates prints a per-second update to the user scan progress and per-status statistics
 func (h *StatusHandler) LogPeriodicUpdates(statusChan <-chan zdns.Status, wg *sync.WaitGroup) error {
 	defer wg.Done()
@@ -86,14 +93,15 @@ statusLoop:
 		case <-ticker.C:
 			// print per-second summary
 			timeSinceStart := time.Since(stats.scanStartTime)
-			s := fmt.Sprintf("%02dh:%02dm:%02ds; %d names scanned; %.02f names/sec; %.01f%% success rate; %s\n",
+			s := fmt.Sprintf("%02dh:%02dm:%02ds; %d names scanned; %.02f names/sec; %.01f%% success rate; %s%s\n",
 				int(timeSinceStart.Hours()),
 				int(timeSinceStart.Minutes())%60,
 				int(timeSinceStart.Seconds())%60,
 				stats.domainsScanned,
 				float64(stats.domainsScanned)/timeSinceStart.Seconds(),
 				float64(stats.domainsSuccess*100)/float64(stats.domainsScanned),
-				getStatusOccuranceString(stats.statusOccurance))
+				getStatusOccuranceString(stats.statusOccurance),
+				h.getRateLimitString(&stats))
 			if _, err := statusFile.WriteString(s); err != nil {
 				return errors.Wrap(err, "unable to write periodic status update")
 			}
@@ -114,20 +122,42 @@ statusLoop:
 		}
 	}
 	timeSinceStart := time.Since(stats.scanStartTime)
-	s := fmt.Sprintf("%02dh:%02dm:%02ds; Scan Complete; %d names scanned; %.02f names/sec; %.01f%% success rate; %s\n",
+	s := fmt.Sprintf("%02dh:%02dm:%02ds; Scan Complete; %d names scanned; %.02f names/sec; %.01f%% success rate; %s%s\n",
 		int(timeSinceStart.Hours()),
 		int(timeSinceStart.Minutes())%60,
 		int(timeSinceStart.Seconds())%60,
 		stats.domainsScanned,
 		float64(stats.domainsScanned)/time.Since(stats.scanStartTime).Seconds(),
 		float64(stats.domainsSuccess*100)/float64(stats.domainsScanned),
-		getStatusOccuranceString(stats.statusOccurance))
+		getStatusOccuranceString(stats.statusOccurance),
+		h.getRateLimitString(&stats))
 	if _, err := statusFile.WriteString(s); err != nil {
 		return errors.Wrap(err, "unable to write final status update")
 	}
 	return nil
 }
 
+// getRateLimitString returns a summary of queries throttled by the rate limiter since the previous call, or an empty
+// string if the rate limiter is not throttling
+func (h *StatusHandler) getRateLimitString(stats *scanStats) string {
+	if h.rateLimiter == nil {
+		return ""
+	}
+	current := h.rateLimiter.GetStatistics()
+	queries := current.Queries - stats.rateLimitStats.Queries
+	throttled := current.Throttled - stats.rateLimitStats.Throttled
+	waited := current.Waited - stats.rateLimitStats.Waited
+	stats.rateLimitStats = current
+	if throttled == 0 && current.Waiting == 0 {
+		return ""
+	}
+	s := fmt.Sprintf("; rate limited: %d queries waiting", current.Waiting)
+	if throttled > 0 {
+		s += fmt.Sprintf(", %d/%d queries delayed, avg wait %s", throttled, queries, (waited / time.Duration(throttled)).Round(time.Millisecond))
+	}
+	return s
+}
+
 func getStatusOccuranceString(statusOccurances map[zdns.Status]int) string {
 	type statusAndOccurance struct {
 		status    zdns.Status
@@ -0,0 +1,322 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package iohandlers
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/zmap/zdns/src/zdns"
+)
+
+// ColumnType is the type of the values of a Column
+type ColumnType int
+
+const (
+	ColumnString ColumnType = iota
+	ColumnInt
+	ColumnFloat
+	ColumnBool
+)
+
+// columnScope is the part of a result a column's value is read from
+type columnScope int
+
+const (
+	scopeName   columnScope = iota // the zdns.Result for the input name
+	scopeModule                    // a zdns.SingleModuleResult, one per lookup module
+	scopeQuery                     // the zdns.SingleQueryResult in a module result's data
+	scopeRecord                    // a single record in the answers, authorities or additionals of a query result
+)
+
+// Column is a column of the tabular output formats
+type Column struct {
+	Name  string
+	Type  ColumnType
+	scope columnScope
+	keys  []string // JSON keys of the value within its scope, empty for the columns filled in by Rows itself
+}
+
+// Names of the columns that don't correspond to a single field of a result
+const (
+	moduleColumn      = "module"       // name of the lookup module
+	sectionColumn     = "section"      // section of the response the record is from: answers, authorities or additionals
+	answerExtraColumn = "answer_extra" // JSON object of the fields of a record that are specific to its type, ex. an MX preference
+	dataColumn        = "data"         // JSON of the module's data if it isn't a single query result, ex. for ALOOKUP
+)
+
+// recordSections are the JSON keys of the sections of a zdns.SingleQueryResult that hold records, in output order
+var recordSections = []string{"answers", "authorities", "additionals"}
+
+// TabularSchema flattens JSON results into rows with a fixed set of columns. There is one row per record in a module's
+// result, so an input name has at least one row per lookup module. Results with no records, such as NXDOMAINs, have a
+// single row whose record columns are null.
+type TabularSchema struct {
+	Columns []Column
+
+	queryKeys   map[string]struct{} // JSON keys of a zdns.SingleQueryResult
+	answerKeys  map[string]struct{} // JSON keys of a zdns.Answer
+	module      int                 // index of the module column
+	section     int                 // index of the section column
+	answerExtra int                 // index of the answer_extra column
+	data        int                 // index of the data column
+}
+
+// NewTabularSchema derives the columns of the tabular output from the fields of zdns.Result, zdns.SingleModuleResult,
+// zdns.SingleQueryResult and zdns.Answer that are output for groups, the same output groups used for JSON output.
+// Fields that can't be represented by a single value, such as the trace, are left out.
+func NewTabularSchema(groups []string) *TabularSchema {
+	s := &TabularSchema{
+		queryKeys:  jsonKeys(reflect.TypeOf(zdns.SingleQueryResult{})),
+		answerKeys: jsonKeys(reflect.TypeOf(zdns.Answer{})),
+	}
+	s.Columns = appendColumns(s.Columns, reflect.TypeOf(zdns.Result{}), groups, scopeName, "", nil)
+	s.module = len(s.Columns)
+	s.Columns = append(s.Columns, Column{Name: moduleColumn, Type: ColumnString, scope: scopeModule})
+	s.Columns = appendColumns(s.Columns, reflect.TypeOf(zdns.SingleModuleResult{}), groups, scopeModule, "", nil)
+	s.Columns = appendColumns(s.Columns, reflect.TypeOf(zdns.SingleQueryResult{}), groups, scopeQuery, "", nil)
+	s.section = len(s.Columns)
+	s.Columns = append(s.Columns, Column{Name: sectionColumn, Type: ColumnString, scope: scopeRecord})
+	s.Columns = appendColumns(s.Columns, reflect.TypeOf(zdns.Answer{}), groups, scopeRecord, "answer_", nil)
+	s.answerExtra = len(s.Columns)
+	s.Columns = append(s.Columns, Column{Name: answerExtraColumn, Type: ColumnString, scope: scopeRecord})
+	s.data = len(s.Columns)
+	s.Columns = append(s.Columns, Column{Name: dataColumn, Type: ColumnString, scope: scopeModule})
+	return s
+}
+
+// appendColumns appends a column for each scalar field of t that is output for groups. Nested structs are flattened,
+// with their column names prefixed by the name of the field holding them.
+func appendColumns(columns []Column, t reflect.Type, groups []string, scope columnScope, prefix string, keys []string) []Column {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := jsonName(field)
+		if name == "" || !inGroups(field, groups) {
+			continue
+		}
+		fieldKeys := append(append([]string(nil), keys...), name)
+		columnName := prefix + name
+		if prefix == "answer_" && name == "answer" {
+			// the record's data, "answer_answer" would be redundant
+			columnName = name
+		}
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		switch fieldType.Kind() {
+		case reflect.Struct:
+			columns = appendColumns(columns, fieldType, groups, scope, columnName+"_", fieldKeys)
+		case reflect.String:
+			columns = append(columns, Column{Name: columnName, Type: ColumnString, scope: scope, keys: fieldKeys})
+		case reflect.Bool:
+			columns = append(columns, Column{Name: columnName, Type: ColumnBool, scope: scope, keys: fieldKeys})
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			columns = append(columns, Column{Name: columnName, Type: ColumnInt, scope: scope, keys: fieldKeys})
+		case reflect.Float32, reflect.Float64:
+			columns = append(columns, Column{Name: columnName, Type: ColumnFloat, scope: scope, keys: fieldKeys})
+		default:
+			// slices, maps and interfaces don't fit in a single column
+		}
+	}
+	return columns
+}
+
+// jsonName returns the JSON key of a struct field, or an empty string if the field isn't output
+func jsonName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// inGroups returns true if the field is output for any of groups, the same way sheriff decides which fields to marshal
+func inGroups(field reflect.StructField, groups []string) bool {
+	for _, fieldGroup := range strings.Split(field.Tag.Get("groups"), ",") {
+		for _, group := range groups {
+			if fieldGroup == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonKeys returns the JSON keys of the fields of t
+func jsonKeys(t reflect.Type) map[string]struct{} {
+	keys := make(map[string]struct{})
+	for i := 0; i < t.NumField(); i++ {
+		if name := jsonName(t.Field(i)); name != "" {
+			keys[name] = struct{}{}
+		}
+	}
+	return keys
+}
+
+// Rows flattens a single line of JSON output into rows. Each row has a value for every column, which is nil if the
+// result doesn't have one, or else a string, int64, float64 or bool depending on the column's type.
+func (s *TabularSchema) Rows(result string) ([][]interface{}, error) {
+	var name map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(result))
+	decoder.UseNumber()
+	if err := decoder.Decode(&name); err != nil {
+		return nil, errors.Wrap(err, "unable to parse result")
+	}
+	base := make([]interface{}, len(s.Columns))
+	s.fill(base, scopeName, name)
+
+	modules, _ := name["results"].(map[string]interface{})
+	rows := make([][]interface{}, 0, len(modules))
+	for _, moduleName := range sortedKeys(modules) {
+		moduleResult, _ := modules[moduleName].(map[string]interface{})
+		moduleRow := append([]interface{}(nil), base...)
+		moduleRow[s.module] = moduleName
+		s.fill(moduleRow, scopeModule, moduleResult)
+
+		data, hasData := moduleResult["data"]
+		query, isQuery := data.(map[string]interface{})
+		if isQuery && s.isQueryResult(query) {
+			s.fill(moduleRow, scopeQuery, query)
+		} else if hasData {
+			// not a single query result, keep the data as is so it isn't lost
+			encoded, err := json.Marshal(data)
+			if err != nil {
+				return nil, errors.Wrap(err, "unable to encode module data")
+			}
+			moduleRow[s.data] = string(encoded)
+			rows = append(rows, moduleRow)
+			continue
+		}
+
+		numRecords := 0
+		for _, section := range recordSections {
+			records, _ := query[section].([]interface{})
+			for _, record := range records {
+				recordMap, _ := record.(map[string]interface{})
+				row := append([]interface{}(nil), moduleRow...)
+				row[s.section] = section
+				s.fill(row, scopeRecord, recordMap)
+				extra, err := s.answerExtraJSON(recordMap)
+				if err != nil {
+					return nil, err
+				}
+				row[s.answerExtra] = extra
+				rows = append(rows, row)
+				numRecords++
+			}
+		}
+		if numRecords == 0 {
+			rows = append(rows, moduleRow)
+		}
+	}
+	return rows, nil
+}
+
+// isQueryResult returns true if the module's data looks like a zdns.SingleQueryResult, ie. it only has its keys
+func (s *TabularSchema) isQueryResult(data map[string]interface{}) bool {
+	for key := range data {
+		if _, ok := s.queryKeys[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// answerExtraJSON returns the fields of a record that aren't common to all records as a JSON object, or nil if it has none
+func (s *TabularSchema) answerExtraJSON(record map[string]interface{}) (interface{}, error) {
+	extra := make(map[string]interface{})
+	for key, value := range record {
+		if _, ok := s.answerKeys[key]; !ok {
+			extra[key] = value
+		}
+	}
+	if len(extra) == 0 {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(extra)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to encode record fields")
+	}
+	return string(encoded), nil
+}
+
+// fill sets the values of the columns in scope from obj
+func (s *TabularSchema) fill(row []interface{}, scope columnScope, obj map[string]interface{}) {
+	for i, column := range s.Columns {
+		if column.scope != scope || len(column.keys) == 0 {
+			continue
+		}
+		row[i] = columnValue(column, obj)
+	}
+}
+
+// columnValue returns the value of column in obj, or nil if obj doesn't have one of the column's type
+func columnValue(column Column, obj map[string]interface{}) interface{} {
+	var value interface{} = obj
+	for _, key := range column.keys {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	switch column.Type {
+	case ColumnString:
+		if str, ok := value.(string); ok {
+			return str
+		}
+	case ColumnBool:
+		if b, ok := value.(bool); ok {
+			return b
+		}
+	case ColumnInt:
+		if n, ok := value.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				return i
+			}
+		}
+	case ColumnFloat:
+		if n, ok := value.(json.Number); ok {
+			if f, err := n.Float64(); err == nil {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
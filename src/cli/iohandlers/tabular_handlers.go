@@ -0,0 +1,175 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package iohandlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/zmap/zdns/src/internal/util"
+)
+
+// CSVOutputHandler writes results as CSV with a header row, flattened into one row per record by a TabularSchema
+type CSVOutputHandler struct {
+	file   *FileOutputHandler
+	schema *TabularSchema
+}
+
+// NewCSVOutputHandler creates a CSVOutputHandler writing to filepath, with the columns output for groups
+func NewCSVOutputHandler(filepath string, groups []string) *CSVOutputHandler {
+	return &CSVOutputHandler{
+		file:   NewFileOutputHandler(filepath),
+		schema: NewTabularSchema(groups),
+	}
+}
+
+func (h *CSVOutputHandler) WriteResults(results <-chan string, wg *sync.WaitGroup) error {
+	rows := make(chan string)
+	go func() {
+		defer close(rows)
+		rows <- h.header()
+		for res := range results {
+			rows <- h.toCSV(res)
+		}
+	}()
+	return h.file.WriteResults(rows, wg)
+}
+
+// WriteCheckpointedResults writes results as CSV, resuming the output file from the checkpoint. The header is only
+// written when starting a new file.
+func (h *CSVOutputHandler) WriteCheckpointedResults(results <-chan CheckpointedResult, cp *Checkpoint, wg *sync.WaitGroup) error {
+	rows := make(chan CheckpointedResult)
+	go func() {
+		defer close(rows)
+		if cp.OutputOffset == 0 {
+			// the header doesn't belong to an input line, and marking a negative ID as done is a no-op
+			rows <- CheckpointedResult{ID: -1, Result: h.header()}
+		}
+		for res := range results {
+			if len(res.Result) > 0 {
+				res.Result = h.toCSV(res.Result)
+			}
+			rows <- res
+		}
+	}()
+	return h.file.WriteCheckpointedResults(rows, cp, wg)
+}
+
+func (h *CSVOutputHandler) header() string {
+	names := make([]string, len(h.schema.Columns))
+	for i, column := range h.schema.Columns {
+		names[i] = column.Name
+	}
+	return encodeCSV([][]string{names})
+}
+
+// toCSV converts a JSON result to CSV rows, without a trailing newline since the file handler adds one
+func (h *CSVOutputHandler) toCSV(result string) string {
+	rows, err := h.schema.Rows(result)
+	if err != nil {
+		log.Fatalf("unable to convert result to CSV: %v", err)
+	}
+	records := make([][]string, len(rows))
+	for i, row := range rows {
+		records[i] = make([]string, len(row))
+		for j, value := range row {
+			records[i][j] = csvValue(value)
+		}
+	}
+	return encodeCSV(records)
+}
+
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return ""
+	}
+}
+
+func encodeCSV(records [][]string) string {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.WriteAll(records); err != nil {
+		log.Fatalf("unable to encode CSV: %v", err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// ParquetOutputHandler writes results to a Parquet file, flattened into one row per record by a TabularSchema. Since
+// the file's metadata is written once all results are, it can't resume an interrupted scan.
+type ParquetOutputHandler struct {
+	filepath string
+	schema   *TabularSchema
+}
+
+// NewParquetOutputHandler creates a ParquetOutputHandler writing to filepath, with the columns output for groups
+func NewParquetOutputHandler(filepath string, groups []string) *ParquetOutputHandler {
+	return &ParquetOutputHandler{
+		filepath: filepath,
+		schema:   NewTabularSchema(groups),
+	}
+}
+
+func (h *ParquetOutputHandler) WriteResults(results <-chan string, wg *sync.WaitGroup) error {
+	defer (*wg).Done()
+
+	var f *os.File
+	if h.filepath == "" || h.filepath == "-" {
+		f = os.Stdout
+	} else {
+		var err error
+		f, err = os.OpenFile(h.filepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, util.DefaultFilePermissions)
+		if err != nil {
+			log.Fatalf("unable to open output file: %v", err)
+		}
+		defer func(f *os.File) {
+			err := f.Close()
+			if err != nil {
+				log.Fatalf("unable to close output file: %v", err)
+			}
+		}(f)
+	}
+	w, err := newParquetWriter(f, h.schema.Columns)
+	if err != nil {
+		return err
+	}
+	for res := range results {
+		rows, err := h.schema.Rows(res)
+		if err != nil {
+			return errors.Wrap(err, "unable to convert result to parquet")
+		}
+		for _, row := range rows {
+			if err = w.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	return w.Close()
+}
@@ -0,0 +1,202 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package iohandlers
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/require"
+)
+
+const tabularTestResult = `{"name":"example.com","class":"IN","results":{` +
+	`"A":{"status":"NOERROR","timestamp":"2024-01-01T00:00:00Z","duration":0.5,"data":{` +
+	`"answers":[{"ttl":300,"type":"A","class":"IN","name":"example.com","answer":"192.0.2.1"},` +
+	`{"ttl":300,"type":"A","class":"IN","name":"example.com","answer":"192.0.2.2"}],` +
+	`"additionals":[{"type":"EDNS0","udpsize":1232,"version":0,"flags":""}],` +
+	`"protocol":"udp","resolver":"192.0.2.53:53","flags":{"authoritative":true,"error_code":0}}},` +
+	`"ALOOKUP":{"status":"NOERROR","data":{"ipv4_addresses":["192.0.2.1"]}},` +
+	`"MX":{"status":"NXDOMAIN","error":"no such name","data":{"protocol":"udp","resolver":"192.0.2.53:53"}}}}`
+
+func columnNames(s *TabularSchema) []string {
+	names := make([]string, len(s.Columns))
+	for i, column := range s.Columns {
+		names[i] = column.Name
+	}
+	return names
+}
+
+func TestTabularSchemaColumns(t *testing.T) {
+	short := columnNames(NewTabularSchema([]string{"short"}))
+	require.Contains(t, short, "name")
+	require.Contains(t, short, "answer")
+	require.NotContains(t, short, "resolver")
+	require.NotContains(t, short, "answer_ttl")
+	require.NotContains(t, short, "flags_authoritative")
+
+	withFields := columnNames(NewTabularSchema([]string{"short", "resolver", "ttl", "flags"}))
+	require.Contains(t, withFields, "resolver")
+	require.Contains(t, withFields, "answer_ttl")
+	require.Contains(t, withFields, "flags_authoritative")
+	require.NotContains(t, withFields, "protocol")
+}
+
+func TestTabularSchemaRows(t *testing.T) {
+	s := NewTabularSchema([]string{"normal", "flags"})
+	rows, err := s.Rows(tabularTestResult)
+	require.NoError(t, err)
+	values := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		values[i] = make(map[string]interface{})
+		for j, column := range s.Columns {
+			values[i][column.Name] = row[j]
+		}
+	}
+	// modules are in sorted order, with a row per record
+	require.Len(t, values, 5)
+	for _, row := range values[:3] {
+		require.Equal(t, "A", row["module"])
+		require.Equal(t, "example.com", row["name"])
+		require.Equal(t, 0.5, row["duration"])
+		require.Equal(t, "192.0.2.53:53", row["resolver"])
+		require.Equal(t, true, row["flags_authoritative"])
+	}
+	require.Equal(t, "answers", values[0]["section"])
+	require.Equal(t, "192.0.2.1", values[0]["answer"])
+	require.Equal(t, int64(300), values[0]["answer_ttl"])
+	require.Nil(t, values[0]["answer_extra"])
+	require.Equal(t, "192.0.2.2", values[1]["answer"])
+	require.Equal(t, "additionals", values[2]["section"])
+	require.Equal(t, "EDNS0", values[2]["answer_type"])
+	require.Equal(t, `{"flags":"","udpsize":1232,"version":0}`, values[2]["answer_extra"])
+
+	require.Equal(t, "ALOOKUP", values[3]["module"])
+	require.Equal(t, `{"ipv4_addresses":["192.0.2.1"]}`, values[3]["data"])
+	require.Nil(t, values[3]["section"])
+
+	require.Equal(t, "MX", values[4]["module"])
+	require.Equal(t, "NXDOMAIN", values[4]["status"])
+	require.Equal(t, "no such name", values[4]["error"])
+	require.Equal(t, "udp", values[4]["protocol"])
+	require.Nil(t, values[4]["answer"])
+}
+
+func TestCSVOutputHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.csv")
+	h := NewCSVOutputHandler(path, []string{"short"})
+	results := make(chan string, 1)
+	results <- tabularTestResult
+	close(results)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	require.NoError(t, h.WriteResults(results, &wg))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 6) // header and 5 rows
+	require.Equal(t, columnNames(h.schema), records[0])
+	require.Contains(t, records[1], "192.0.2.1")
+}
+
+func TestCSVOutputHandlerCheckpointed(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.csv")
+	h := NewCSVOutputHandler(path, []string{"short"})
+	writeLine := func(id int) {
+		cp, err := LoadCheckpoint(filepath.Join(dir, "checkpoint"))
+		require.NoError(t, err)
+		results := make(chan CheckpointedResult, 1)
+		results <- CheckpointedResult{ID: id, Result: tabularTestResult}
+		close(results)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		require.NoError(t, h.WriteCheckpointedResults(results, cp, &wg))
+	}
+	writeLine(0)
+	// the header isn't repeated when the scan is resumed
+	writeLine(1)
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 11)
+	require.Equal(t, "name", records[0][1])
+	require.Equal(t, "example.com", records[6][1])
+}
+
+func TestParquetOutputHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.parquet")
+	h := NewParquetOutputHandler(path, []string{"normal", "flags"})
+	results := make(chan string, 1)
+	results <- tabularTestResult
+	close(results)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	require.NoError(t, h.WriteResults(results, &wg))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	info, err := f.Stat()
+	require.NoError(t, err)
+	file, err := parquet.OpenFile(f, info.Size())
+	require.NoError(t, err)
+	require.Equal(t, int64(5), file.NumRows())
+	var names []string
+	for _, column := range file.Schema().Columns() {
+		names = append(names, column[0])
+	}
+	require.ElementsMatch(t, columnNames(h.schema), names)
+
+	// read back the answer and ttl columns, which only have values for the two A answers
+	answer, ok := file.Schema().Lookup("answer")
+	require.True(t, ok)
+	ttl, ok := file.Schema().Lookup("answer_ttl")
+	require.True(t, ok)
+	rows := make([]parquet.Row, 10)
+	n, err := parquet.NewReader(file).ReadRows(rows)
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, 5, n)
+	var answers, ttls []interface{}
+	for _, row := range rows[:n] {
+		answers = append(answers, parquetTestValue(row[answer.ColumnIndex]))
+		ttls = append(ttls, parquetTestValue(row[ttl.ColumnIndex]))
+	}
+	// the EDNS0 record, ALOOKUP and MX don't have an answer
+	require.Equal(t, []interface{}{"192.0.2.1", "192.0.2.2", nil, nil, nil}, answers)
+	require.Equal(t, []interface{}{int64(300), int64(300), nil, nil, nil}, ttls)
+}
+
+// parquetTestValue converts a string or int64 Parquet value to a Go value, nil if it's null
+func parquetTestValue(value parquet.Value) interface{} {
+	switch {
+	case value.IsNull():
+		return nil
+	case value.Kind() == parquet.Int64:
+		return value.Int64()
+	default:
+		return value.String()
+	}
+}
@@ -0,0 +1,217 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/zmap/zdns/src/zdns"
+)
+
+const (
+	metricsNamespace       = "zdns"
+	metricsShutdownTimeout = 5 * time.Second
+)
+
+// scanMetrics holds the Prometheus metrics published on --metrics-addr. A nil *scanMetrics ignores all updates, so
+// workers can record metrics unconditionally.
+type scanMetrics struct {
+	registry       *prometheus.Registry
+	lookups        *prometheus.CounterVec
+	lookupDuration *prometheus.HistogramVec
+	names          prometheus.Counter
+	inFlight       prometheus.Gauge
+	server         *http.Server
+}
+
+// newScanMetrics creates the scan's metrics. Wire query and cache metrics are read from queryStats and cache when
+// scraped, so cache statistics must be enabled with CaptureStatistics.
+func newScanMetrics(queryStats *zdns.QueryStatistics, cache *zdns.Cache) *scanMetrics {
+	m := &scanMetrics{
+		registry: prometheus.NewRegistry(),
+		lookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "lookups_total",
+			Help:      "Number of lookups performed, by module and result status.",
+		}, []string{"module", "status"}),
+		lookupDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "lookup_duration_seconds",
+			Help:      "Time taken to perform a lookup, by module.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+		}, []string{"module"}),
+		names: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "names_total",
+			Help:      "Number of input names processed.",
+		}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "workers_in_flight",
+			Help:      "Number of workers currently processing an input name.",
+		}),
+	}
+	m.registry.MustRegister(
+		m.lookups,
+		m.lookupDuration,
+		m.names,
+		m.inFlight,
+		&wireQueryCollector{stats: queryStats},
+		&cacheCollector{stats: &cache.Stats},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// serve starts the HTTP server for the /metrics endpoint on addr. The listener is opened before returning so
+// an unusable address is reported immediately.
+func (m *scanMetrics) serve(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("unable to listen on metrics address %s: %w", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	m.server = &http.Server{Addr: listener.Addr().String(), Handler: mux, ReadHeaderTimeout: metricsShutdownTimeout}
+	go func() {
+		if serveErr := m.server.Serve(listener); serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+			log.Errorf("metrics server failed: %v", serveErr)
+		}
+	}()
+	log.Infof("serving metrics on http://%s/metrics", m.server.Addr)
+	return nil
+}
+
+// close stops the metrics server, waiting for in-progress scrapes to finish
+func (m *scanMetrics) close() {
+	if m == nil || m.server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+	defer cancel()
+	if err := m.server.Shutdown(ctx); err != nil {
+		log.Warnf("unable to shut down metrics server: %v", err)
+	}
+}
+
+func (m *scanMetrics) observeLookup(module string, status zdns.Status, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.lookups.WithLabelValues(module, string(status)).Inc()
+	m.lookupDuration.WithLabelValues(module).Observe(duration.Seconds())
+}
+
+// observeIllegalInput records a lookup that wasn't performed because its input line was malformed
+func (m *scanMetrics) observeIllegalInput(module string) {
+	if m == nil {
+		return
+	}
+	m.lookups.WithLabelValues(module, string(zdns.StatusIllegalInput)).Inc()
+}
+
+// startName records that a worker has started processing a name, and returns a function to call once it's done
+func (m *scanMetrics) startName() func() {
+	if m == nil {
+		return func() {}
+	}
+	m.inFlight.Inc()
+	return func() {
+		m.inFlight.Dec()
+		m.names.Inc()
+	}
+}
+
+var (
+	wireQueriesDesc = prometheus.NewDesc(metricsNamespace+"_wire_queries_total",
+		"Number of queries sent on the wire, by protocol.", []string{"protocol"}, nil)
+	truncationFallbacksDesc = prometheus.NewDesc(metricsNamespace+"_truncation_fallbacks_total",
+		"Number of truncated UDP responses that were retried over TCP.", nil, nil)
+	retriesDesc = prometheus.NewDesc(metricsNamespace+"_retries_total",
+		"Number of queries retried after a failure.", nil, nil)
+	cacheHitsDesc = prometheus.NewDesc(metricsNamespace+"_cache_hits_total",
+		"Number of cache lookups that were hits.", nil, nil)
+	cacheMissesDesc = prometheus.NewDesc(metricsNamespace+"_cache_misses_total",
+		"Number of cache lookups that were misses.", nil, nil)
+	cacheWritesDesc = prometheus.NewDesc(metricsNamespace+"_cache_writes_total",
+		"Number of entries written to the cache.", nil, nil)
+	cacheEvictionsDesc = prometheus.NewDesc(metricsNamespace+"_cache_evictions_total",
+		"Number of cache entries evicted to make room for new ones.", nil, nil)
+	cacheNegativeHitsDesc = prometheus.NewDesc(metricsNamespace+"_cache_negative_hits_total",
+		"Number of cache lookups answered by a cached NXDOMAIN or NODATA response.", nil, nil)
+	cacheNegativeMissesDesc = prometheus.NewDesc(metricsNamespace+"_cache_negative_misses_total",
+		"Number of cache lookups that missed both positive and negative entries.", nil, nil)
+	cacheSynthesizedDesc = prometheus.NewDesc(metricsNamespace+"_cache_synthesized_total",
+		"Number of NXDOMAIN and NODATA answers synthesized from cached, validated NSEC and NSEC3 records.", nil, nil)
+)
+
+// wireQueryCollector publishes the counters of a zdns.QueryStatistics
+type wireQueryCollector struct {
+	stats *zdns.QueryStatistics
+}
+
+func (c *wireQueryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- wireQueriesDesc
+	ch <- truncationFallbacksDesc
+	ch <- retriesDesc
+}
+
+func (c *wireQueryCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.stats.GetStatistics()
+	ch <- prometheus.MustNewConstMetric(wireQueriesDesc, prometheus.CounterValue, float64(stats.UDP), "udp")
+	ch <- prometheus.MustNewConstMetric(wireQueriesDesc, prometheus.CounterValue, float64(stats.TCP), "tcp")
+	ch <- prometheus.MustNewConstMetric(wireQueriesDesc, prometheus.CounterValue, float64(stats.DoT), "dot")
+	ch <- prometheus.MustNewConstMetric(wireQueriesDesc, prometheus.CounterValue, float64(stats.DoH), "doh")
+	ch <- prometheus.MustNewConstMetric(wireQueriesDesc, prometheus.CounterValue, float64(stats.DoQ), "doq")
+	ch <- prometheus.MustNewConstMetric(truncationFallbacksDesc, prometheus.CounterValue, float64(stats.TruncationFallbacks))
+	ch <- prometheus.MustNewConstMetric(retriesDesc, prometheus.CounterValue, float64(stats.Retries))
+}
+
+// cacheCollector publishes the counters of a zdns.CacheStatistics
+type cacheCollector struct {
+	stats *zdns.CacheStatistics
+}
+
+func (c *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheHitsDesc
+	ch <- cacheMissesDesc
+	ch <- cacheWritesDesc
+	ch <- cacheEvictionsDesc
+	ch <- cacheNegativeHitsDesc
+	ch <- cacheNegativeMissesDesc
+	ch <- cacheSynthesizedDesc
+}
+
+func (c *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.stats.GetStatistics()
+	ch <- prometheus.MustNewConstMetric(cacheHitsDesc, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(cacheMissesDesc, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(cacheWritesDesc, prometheus.CounterValue, float64(stats.Writes))
+	ch <- prometheus.MustNewConstMetric(cacheEvictionsDesc, prometheus.CounterValue, float64(stats.Ejects))
+	ch <- prometheus.MustNewConstMetric(cacheNegativeHitsDesc, prometheus.CounterValue, float64(stats.NegativeHits))
+	ch <- prometheus.MustNewConstMetric(cacheNegativeMissesDesc, prometheus.CounterValue, float64(stats.NegativeMisses))
+	ch <- prometheus.MustNewConstMetric(cacheSynthesizedDesc, prometheus.CounterValue, float64(stats.Synthesized))
+}
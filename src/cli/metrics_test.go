@@ -0,0 +1,86 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package cli
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/zmap/zdns/src/zdns"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	queryStats := new(zdns.QueryStatistics)
+	cache := new(zdns.Cache)
+	cache.Init(10)
+	cache.Stats.CaptureStatistics()
+	m := newScanMetrics(queryStats, cache)
+	require.NoError(t, m.serve("127.0.0.1:0"))
+	defer m.close()
+
+	done := m.startName()
+	m.observeLookup("A", zdns.StatusNoError, 20*time.Millisecond)
+	m.observeLookup("A", zdns.StatusNXDomain, 5*time.Millisecond)
+	queryStats.IncrementQueries(zdns.UDPProtocol)
+	queryStats.IncrementQueries(zdns.UDPProtocol)
+	queryStats.IncrementTruncationFallbacks()
+	queryStats.IncrementQueries(zdns.TCPProtocol)
+	cache.Stats.IncrementMisses()
+	cache.Stats.IncrementNegativeHits()
+	cache.Stats.IncrementSynthesized()
+	inFlightBody := scrapeMetrics(t, m)
+	require.Contains(t, inFlightBody, "zdns_workers_in_flight 1\n")
+	done()
+
+	body := scrapeMetrics(t, m)
+	for _, expected := range []string{
+		`zdns_lookups_total{module="A",status="NOERROR"} 1`,
+		`zdns_lookups_total{module="A",status="NXDOMAIN"} 1`,
+		`zdns_lookup_duration_seconds_count{module="A"} 2`,
+		`zdns_wire_queries_total{protocol="udp"} 2`,
+		`zdns_wire_queries_total{protocol="tcp"} 1`,
+		`zdns_wire_queries_total{protocol="doh"} 0`,
+		"zdns_truncation_fallbacks_total 1",
+		"zdns_cache_misses_total 1",
+		"zdns_cache_negative_hits_total 1",
+		"zdns_cache_synthesized_total 1",
+		"zdns_names_total 1",
+		"zdns_workers_in_flight 0",
+	} {
+		require.Contains(t, body, expected+"\n")
+	}
+}
+
+func TestNilMetricsIgnoresUpdates(t *testing.T) {
+	var m *scanMetrics
+	m.observeLookup("A", zdns.StatusNoError, time.Second)
+	m.observeIllegalInput("A")
+	m.startName()()
+	m.close()
+}
+
+func scrapeMetrics(t *testing.T, m *scanMetrics) string {
+	resp, err := http.Get("http://" + m.server.Addr + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
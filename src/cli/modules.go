@@ -196,6 +196,16 @@ func (lm *BasicLookupModule) Lookup(resolver *zdns.Resolver, lookupName string,
 	return resolver.ExternalLookup(context.Background(), &zdns.Question{Type: lm.DNSType, Class: lm.DNSClass, Name: lookupName}, nameServer)
 }
 
+// LookupType looks up the records of type qType at name, iteratively or through nameServer like Lookup. It always makes
+// a single lookup, for modules that combine several lookups of different types into one result.
+func (lm *BasicLookupModule) LookupType(resolver *zdns.Resolver, name string, qType uint16, nameServer *zdns.NameServer) (*zdns.SingleQueryResult, zdns.Trace, zdns.Status, error) {
+	q := &zdns.Question{Name: name, Type: qType, Class: lm.DNSClass}
+	if lm.IsIterative {
+		return resolver.IterativeLookup(context.Background(), q)
+	}
+	return resolver.ExternalLookup(context.Background(), q, nameServer)
+}
+
 func GetLookupModule(name string) (LookupModule, error) {
 	module, ok := moduleToLookupModule[name]
 	if !ok {
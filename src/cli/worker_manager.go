@@ -59,6 +59,8 @@ type Metadata struct {
 	Conf            *CLIConf                      `json:"conf"`
 	ZDNSVersion     string                        `json:"zdns_version"`
 	CacheStatistics *zdns.CacheStatisticsMetadata `json:"cache_statistics,omitempty"`
+	Resumed         bool                          `json:"resumed,omitempty"`       // scan was resumed from --checkpoint-file
+	SkippedNames    int                           `json:"skipped_names,omitempty"` // input lines already completed by a previous run
 }
 
 func populateCLIConfig(gc *CLIConf) *CLIConf {
@@ -152,6 +154,21 @@ func populateCLIConfig(gc *CLIConf) *CLIConf {
 	gc.OutputGroups = append(gc.OutputGroups, gc.ResultVerbosity)
 	gc.OutputGroups = append(gc.OutputGroups, groups...)
 
+	if gc.OutputFormat != "json" && gc.OutputFormat != "csv" && gc.OutputFormat != "parquet" {
+		log.Fatal("Invalid output format. Options: json, csv, parquet")
+	}
+	if gc.OutputFormat != "json" && gc.ResultVerbosity == "trace" {
+		log.Fatal("--result-verbosity=trace is only supported with --output-format=json")
+	}
+
+	if gc.CheckpointFilePath != "" && (gc.OutputFilePath == "" || gc.OutputFilePath == "-") {
+		log.Fatal("--checkpoint-file requires --output-file to be a file")
+	}
+	if gc.CheckpointFilePath != "" && gc.OutputFormat == "parquet" {
+		// the metadata of a parquet file is only written once all rows are, so there's nothing to resume from
+		log.Fatal("--checkpoint-file is not supported with --output-format=parquet")
+	}
+
 	// setup i/o if not specified
 	if len(GC.Domains) > 0 {
 		// using domains from command line
@@ -160,7 +177,14 @@ func populateCLIConfig(gc *CLIConf) *CLIConf {
 		gc.InputHandler = iohandlers.NewFileInputHandler(gc.InputFilePath)
 	}
 	if gc.OutputHandler == nil {
-		gc.OutputHandler = iohandlers.NewFileOutputHandler(gc.OutputFilePath)
+		switch gc.OutputFormat {
+		case "csv":
+			gc.OutputHandler = iohandlers.NewCSVOutputHandler(gc.OutputFilePath, gc.OutputGroups)
+		case "parquet":
+			gc.OutputHandler = iohandlers.NewParquetOutputHandler(gc.OutputFilePath, gc.OutputGroups)
+		default:
+			gc.OutputHandler = iohandlers.NewFileOutputHandler(gc.OutputFilePath)
+		}
 	}
 	if gc.StatusHandler == nil {
 		gc.StatusHandler = iohandlers.NewStatusHandler(gc.StatusUpdatesFilePath)
@@ -174,6 +198,12 @@ func populateResolverConfig(gc *CLIConf) *zdns.ResolverConfig {
 	config.TransportMode = zdns.GetTransportMode(gc.UDPOnly, gc.TCPOnly)
 	config.DNSOverHTTPS = gc.DNSOverHTTPS
 	config.DNSOverTLS = gc.DNSOverTLS
+	config.DNSOverQUIC = gc.DNSOverQUIC
+	config.OpportunisticTLS = gc.OpportunisticTLS
+	if gc.OpportunisticTLS {
+		// shared by all threads, so each name server that doesn't support TLS is only tried over TLS once
+		config.NoTLSNameServers = zdns.NewNoTLSNameServers(gc.CacheSize)
+	}
 	config.VerifyServerCert = gc.VerifyServerCert
 
 	// Read in the CA file if it exists
@@ -207,9 +237,28 @@ func populateResolverConfig(gc *CLIConf) *zdns.ResolverConfig {
 	}
 	config.Cache = new(zdns.Cache)
 	config.Cache.Init(gc.CacheSize)
-	if gc.Verbosity >= 5 {
+	if gc.CacheFilePath != "" {
+		loaded, err := config.Cache.LoadFromFile(gc.CacheFilePath)
+		if errors.Is(err, os.ErrNotExist) {
+			log.Infof("cache file %s does not exist, starting with an empty cache", gc.CacheFilePath)
+		} else if err != nil {
+			log.Warnf("unable to load cache file, starting with an empty cache: %v", err)
+		} else {
+			log.Infof("loaded %d entries from cache file %s", loaded, gc.CacheFilePath)
+		}
+	}
+	if gc.Verbosity >= 5 || gc.MetricsAddr != "" {
 		config.Cache.Stats.CaptureStatistics()
 	}
+	if gc.MetricsAddr != "" {
+		config.QueryStatistics = new(zdns.QueryStatistics)
+	}
+	if gc.RateLimit < 0 || gc.NameServerRateLimit < 0 {
+		log.Fatal("--rate-limit and --per-nameserver-rate-limit must be non-negative")
+	}
+	if gc.RateLimit > 0 || gc.NameServerRateLimit > 0 {
+		config.RateLimiter = zdns.NewRateLimiter(float64(gc.RateLimit), float64(gc.NameServerRateLimit))
+	}
 	config.Retries = gc.Retries
 	config.MaxDepth = gc.MaxDepth
 	config.CheckingDisabledBit = gc.CheckingDisabled
@@ -218,9 +267,6 @@ func populateResolverConfig(gc *CLIConf) *zdns.ResolverConfig {
 	config.ShouldValidateDNSSEC = gc.ValidateDNSSEC
 	if config.ShouldValidateDNSSEC {
 		config.DNSSecEnabled = true
-		if !gc.IterativeResolution {
-			log.Fatal("DNSSEC validation is only supported with iterative resolution")
-		}
 	} else {
 		config.DNSSecEnabled = gc.Dnssec
 	}
@@ -322,7 +368,7 @@ func populateIPTransportMode(gc *CLIConf, config *zdns.ResolverConfig) (*zdns.Re
 	// Domains could have either A or AAAA and that tells us nothing about the host's IPv4/6 capabilities
 	if gc.NameServersString != "" && len(ipOnlyNSes) > 0 {
 		// User provided name servers, so we can determine the IPVersionMode based on the provided name servers
-		nses, err = convertNameServerStringSliceToNameServers(ipOnlyNSes, zdns.IPv4OrIPv6, config.DNSOverTLS, config.DNSOverHTTPS)
+		nses, err = convertNameServerStringSliceToNameServers(ipOnlyNSes, zdns.IPv4OrIPv6, config.DNSOverTLS || config.DNSOverQUIC, config.DNSOverHTTPS)
 		if err != nil {
 			return nil, fmt.Errorf("could not parse name servers from --name-server: %v", err)
 		}
@@ -396,6 +442,13 @@ func populateNameServers(gc *CLIConf, config *zdns.ResolverConfig) (*zdns.Resolv
 		config.ExternalNameServersV6 = zdns.DefaultExternalDoTResolversV6
 		return config, nil
 	}
+	if gc.DNSOverQUIC {
+		config.RootNameServersV4 = zdns.DefaultExternalDoQResolversV4
+		config.ExternalNameServersV4 = zdns.DefaultExternalDoQResolversV4
+		config.RootNameServersV6 = zdns.DefaultExternalDoQResolversV6
+		config.ExternalNameServersV6 = zdns.DefaultExternalDoQResolversV6
+		return config, nil
+	}
 	if gc.DNSOverHTTPS {
 		defaultDoHNameServers := []string{zdns.CloudflareDoHDomainName, zdns.GoogleDoHDomainName}
 		return useNameServerStringToPopulateNameServers(defaultDoHNameServers, config)
@@ -409,11 +462,11 @@ func populateNameServers(gc *CLIConf, config *zdns.ResolverConfig) (*zdns.Resolv
 			log.Warn("Unable to parse resolvers file. Using ZDNS defaults")
 		} else {
 			// convert string slices to NameServers
-			v4NameServers, err = convertNameServerStringSliceToNameServers(v4NameServerStrings, config.IPVersionMode, config.DNSOverTLS, config.DNSOverHTTPS)
+			v4NameServers, err = convertNameServerStringSliceToNameServers(v4NameServerStrings, config.IPVersionMode, config.DNSOverTLS || config.DNSOverQUIC, config.DNSOverHTTPS)
 			if err != nil {
 				return nil, fmt.Errorf("could not convert IPv4 nameservers %s to NameServers: %v", strings.Join(v4NameServerStrings, ", "), err)
 			}
-			v6NameServers, err = convertNameServerStringSliceToNameServers(v6NameServersStrings, config.IPVersionMode, config.DNSOverTLS, config.DNSOverHTTPS)
+			v6NameServers, err = convertNameServerStringSliceToNameServers(v6NameServersStrings, config.IPVersionMode, config.DNSOverTLS || config.DNSOverQUIC, config.DNSOverHTTPS)
 			if err != nil {
 				return nil, fmt.Errorf("could not convert IPv6 nameservers %s to NameServers: %v", strings.Join(v6NameServersStrings, ", "), err)
 			}
@@ -436,7 +489,7 @@ func populateNameServers(gc *CLIConf, config *zdns.ResolverConfig) (*zdns.Resolv
 
 func useNameServerStringToPopulateNameServers(nameServers []string, config *zdns.ResolverConfig) (*zdns.ResolverConfig, error) {
 	var v4NameServers, v6NameServers []zdns.NameServer
-	nses, err := convertNameServerStringSliceToNameServers(nameServers, config.IPVersionMode, config.DNSOverTLS, config.DNSOverHTTPS)
+	nses, err := convertNameServerStringSliceToNameServers(nameServers, config.IPVersionMode, config.DNSOverTLS || config.DNSOverQUIC, config.DNSOverHTTPS)
 	if err != nil {
 		return nil, fmt.Errorf("could not parse name server: %v. Correct IPv4 format: 1.1.1.1:53 or IPv6 format: [::1]:53\"", err)
 	}
@@ -497,6 +550,14 @@ func Run(gc CLIConf) {
 			log.Fatalf("could not initialize lookup module (type: %s): %v", gc.CLIModule, err)
 		}
 	}
+	var metrics *scanMetrics
+	if gc.MetricsAddr != "" {
+		metrics = newScanMetrics(resolverConfig.QueryStatistics, resolverConfig.Cache)
+		if err = metrics.serve(gc.MetricsAddr); err != nil {
+			log.Fatalf("could not start metrics server: %v", err)
+		}
+		defer metrics.close()
+	}
 	// DoLookup:
 	//	- n threads that do processing from in and place results in out
 	//	- process until inChan closes, then wg.done()
@@ -504,6 +565,9 @@ func Run(gc CLIConf) {
 	// output and metadata threads have completed
 	inChan := make(chan string)
 	outChan := make(chan string)
+	// workers consume numbered lines and produce one result per line, so progress can be checkpointed
+	lineChan := make(chan iohandlers.InputLine)
+	resultChan := make(chan iohandlers.CheckpointedResult)
 	metaChan := make(chan routineMetadata, gc.Threads)
 	statusChan := make(chan zdns.Status)
 	var routineWG sync.WaitGroup
@@ -522,6 +586,9 @@ func Run(gc CLIConf) {
 	if statusHandler == nil {
 		log.Fatal("Status handler is nil")
 	}
+	if h, ok := statusHandler.(*iohandlers.StatusHandler); ok && resolverConfig.RateLimiter != nil {
+		h.SetRateLimiter(resolverConfig.RateLimiter)
+	}
 
 	// Use handlers to populate the input and output/results channel
 	go func() {
@@ -530,12 +597,72 @@ func Run(gc CLIConf) {
 		}
 	}()
 
+	var checkpoint *iohandlers.Checkpoint
+	if gc.CheckpointFilePath != "" {
+		cpHandler, ok := outHandler.(CheckpointedOutputHandler)
+		if !ok {
+			log.Fatal("Output handler does not support --checkpoint-file")
+		}
+		checkpoint, err = iohandlers.LoadCheckpoint(gc.CheckpointFilePath)
+		if err != nil {
+			log.Fatalf("could not load checkpoint: %v", err)
+		}
+		if checkpoint.Resumed() {
+			log.Infof("resuming scan from checkpoint %s", gc.CheckpointFilePath)
+		}
+		go func() {
+			if outErr := cpHandler.WriteCheckpointedResults(resultChan, checkpoint, &routineWG); outErr != nil {
+				log.Fatal(fmt.Sprintf("could not write output results from output channel: %v", outErr))
+			}
+		}()
+	} else {
+		go func() {
+			for res := range resultChan {
+				if len(res.Result) > 0 {
+					outChan <- res.Result
+				}
+			}
+			close(outChan)
+		}()
+		go func() {
+			if outErr := outHandler.WriteResults(outChan, &routineWG); outErr != nil {
+				log.Fatal(fmt.Sprintf("could not write output results from output channel: %v", outErr))
+			}
+		}()
+	}
+	routineWG.Add(2) // input and output handlers
+
+	// number each input line, skipping any that were completed before the checkpoint
+	skippedNames := 0
 	go func() {
-		if outErr := outHandler.WriteResults(outChan, &routineWG); outErr != nil {
-			log.Fatal(fmt.Sprintf("could not write output results from output channel: %v", outErr))
+		id := 0
+		for line := range inChan {
+			if checkpoint != nil && checkpoint.IsDone(id) {
+				skippedNames++
+			} else {
+				lineChan <- iohandlers.InputLine{ID: id, Line: line}
+			}
+			id++
 		}
+		close(lineChan)
 	}()
-	routineWG.Add(2) // input and output handlers
+
+	// malformed input lines are written to the rejects file, if any, so they can be fixed and re-scanned
+	var rejectChan chan string
+	if gc.RejectsFilePath != "" {
+		rejectChan = make(chan string)
+		rejectsHandler := iohandlers.NewFileOutputHandler(gc.RejectsFilePath)
+		if checkpoint != nil && checkpoint.Resumed() {
+			// keep the lines rejected before the scan was interrupted, since they won't be read again
+			rejectsHandler = iohandlers.NewAppendingFileOutputHandler(gc.RejectsFilePath)
+		}
+		go func() {
+			if rejectsErr := rejectsHandler.WriteResults(rejectChan, &routineWG); rejectsErr != nil {
+				log.Fatal(fmt.Sprintf("could not write rejected input lines: %v", rejectsErr))
+			}
+		}()
+		routineWG.Add(1) // rejects handler
+	}
 
 	if !gc.QuietStatusUpdates {
 		go func() {
@@ -554,17 +681,27 @@ func Run(gc CLIConf) {
 	for i := 0; i < gc.Threads; i++ {
 		i := i
 		go func(threadID int) {
-			initWorkerErr := doLookupWorker(&gc, resolverConfig, inChan, outChan, metaChan, statusChan, &lookupWG)
+			initWorkerErr := doLookupWorker(&gc, resolverConfig, metrics, lineChan, resultChan, metaChan, statusChan, rejectChan, &lookupWG)
 			if initWorkerErr != nil {
 				log.Fatalf("could not start lookup worker #%d: %v", i, initWorkerErr)
 			}
 		}(i)
 	}
 	lookupWG.Wait()
-	close(outChan)
+	close(resultChan)
 	close(metaChan)
 	close(statusChan)
+	if rejectChan != nil {
+		close(rejectChan)
+	}
 	routineWG.Wait()
+	if gc.CacheFilePath != "" {
+		if saved, saveErr := resolverConfig.Cache.SaveToFile(gc.CacheFilePath); saveErr != nil {
+			log.Errorf("unable to save cache file: %v", saveErr)
+		} else {
+			log.Infof("saved %d entries to cache file %s", saved, gc.CacheFilePath)
+		}
+	}
 	if gc.MetadataFilePath != "" {
 		// we're done processing data. aggregate all the data from individual routines
 		metaData := aggregateMetadata(metaChan)
@@ -581,6 +718,10 @@ func Run(gc CLIConf) {
 		// back to an integer here.
 		metaData.Timeout = gc.Timeout
 		metaData.Conf = &gc
+		if checkpoint != nil {
+			metaData.Resumed = checkpoint.Resumed()
+			metaData.SkippedNames = skippedNames
+		}
 		// add global lookup-related metadata
 		// write out metadata
 		var f *os.File
@@ -610,7 +751,7 @@ func Run(gc CLIConf) {
 }
 
 // doLookupWorker is a single worker thread that processes lookups from the input channel. It calls wg.Done when it is finished.
-func doLookupWorker(gc *CLIConf, rc *zdns.ResolverConfig, inputChan <-chan string, outputChan chan<- string, metaChan chan<- routineMetadata, statusChan chan<- zdns.Status, wg *sync.WaitGroup) error {
+func doLookupWorker(gc *CLIConf, rc *zdns.ResolverConfig, metrics *scanMetrics, inputChan <-chan iohandlers.InputLine, outputChan chan<- iohandlers.CheckpointedResult, metaChan chan<- routineMetadata, statusChan chan<- zdns.Status, rejectChan chan<- string, wg *sync.WaitGroup) error {
 	defer wg.Done()
 	resolver, err := zdns.InitResolver(rc)
 	if err != nil {
@@ -620,7 +761,9 @@ func doLookupWorker(gc *CLIConf, rc *zdns.ResolverConfig, inputChan <-chan strin
 	metadata.Status = make(map[zdns.Status]int)
 
 	for line := range inputChan {
-		handleWorkerInput(gc, rc, line, resolver, &metadata, outputChan, statusChan)
+		done := metrics.startName()
+		handleWorkerInput(gc, rc, line, resolver, metrics, &metadata, outputChan, statusChan, rejectChan)
+		done()
 	}
 	// close the resolver, freeing up resources
 	resolver.Close()
@@ -628,48 +771,21 @@ func doLookupWorker(gc *CLIConf, rc *zdns.ResolverConfig, inputChan <-chan strin
 	return nil
 }
 
-func handleWorkerInput(gc *CLIConf, rc *zdns.ResolverConfig, line string, resolver *zdns.Resolver, metadata *routineMetadata, outputChan chan<- string, statusChan chan<- zdns.Status) {
+// handleWorkerInput performs all lookups for a single input line and sends exactly one result to outputChan. The result
+// is empty if the line produced no output.
+func handleWorkerInput(gc *CLIConf, rc *zdns.ResolverConfig, input iohandlers.InputLine, resolver *zdns.Resolver, metrics *scanMetrics, metadata *routineMetadata, outputChan chan<- iohandlers.CheckpointedResult, statusChan chan<- zdns.Status, rejectChan chan<- string) {
+	line := input.Line
+	output := iohandlers.CheckpointedResult{ID: input.ID}
 	// we'll process each module sequentially, parallelism is per-domain
 	res := zdns.Result{Results: make(map[string]zdns.SingleModuleResult, len(gc.ActiveModules))}
 	// get the fields that won't change for each lookup module
-	rawName := ""
-	var nameServer *zdns.NameServer
-	var nameServers []zdns.NameServer
-	nameServerString := ""
-	var rank int
-	var entryMetadata string
-	var err error
-	if gc.AlexaFormat {
-		rawName, rank = parseAlexa(line)
-		res.AlexaRank = rank
-	} else if gc.MetadataFormat {
-		rawName, entryMetadata = parseMetadataInputLine(line)
-		res.Metadata = entryMetadata
-	} else if gc.NameServerMode {
-		nameServers, err = convertNameServerStringToNameServer(line, rc.IPVersionMode, rc.DNSOverTLS, rc.DNSOverHTTPS)
-		if err != nil {
-			log.Fatal("unable to parse name server: ", line)
-		}
-		if len(nameServers) == 0 {
-			log.Fatal("no name servers found in line: ", line)
-		}
-		// if user provides a domain name for the name server (one.one.one.one) we'll pick one of the IPs at random
-		nameServer = &nameServers[rand.Intn(len(nameServers))]
-	} else {
-		rawName, nameServerString = parseNormalInputLine(line)
-		if len(nameServerString) != 0 {
-			nameServers, err = convertNameServerStringToNameServer(nameServerString, rc.IPVersionMode, rc.DNSOverTLS, rc.DNSOverHTTPS)
-			if err != nil {
-				log.Fatal("unable to parse name server: ", line)
-			}
-			if len(nameServers) == 0 {
-				log.Fatal("no name servers found in line: ", line)
-			}
-			// if user provides a domain name for the name server (one.one.one.one) we'll pick one of the IPs at random
-			nameServer = &nameServers[rand.Intn(len(nameServers))]
+	nameServer, parseErr := parseInputLine(gc, rc, line, &res)
+	if parseErr != nil {
+		log.Debugf("illegal input line %q: %v", line, parseErr)
+		if rejectChan != nil {
+			rejectChan <- line
 		}
 	}
-	res.Name = rawName
 	// handle per-module lookups
 	for moduleName, module := range gc.ActiveModules {
 		var innerRes interface{}
@@ -678,7 +794,21 @@ func handleWorkerInput(gc *CLIConf, rc *zdns.ResolverConfig, line string, resolv
 		var err error
 		var changed bool
 		var lookupName string
-		lookupName, changed = makeName(rawName, gc.NamePrefix, gc.NameOverride)
+		if parseErr != nil {
+			// the line can't be looked up, but record it so it isn't silently dropped from the output
+			res.Results[moduleName] = zdns.SingleModuleResult{
+				Timestamp: time.Now().Format(gc.TimeFormat),
+				Status:    string(zdns.StatusIllegalInput),
+				Error:     parseErr.Error(),
+			}
+			metrics.observeIllegalInput(moduleName)
+			if !gc.QuietStatusUpdates {
+				statusChan <- zdns.StatusIllegalInput
+			}
+			metadata.Status[zdns.StatusIllegalInput]++
+			continue
+		}
+		lookupName, changed = makeName(res.Name, gc.NamePrefix, gc.NameOverride)
 		if changed {
 			res.AlteredName = lookupName
 		}
@@ -687,9 +817,12 @@ func handleWorkerInput(gc *CLIConf, rc *zdns.ResolverConfig, line string, resolv
 		startTime := time.Now()
 		innerRes, trace, status, err = module.Lookup(resolver, lookupName, nameServer)
 
+		duration := time.Since(startTime)
+		metrics.observeLookup(moduleName, status, duration)
+
 		lookupRes := zdns.SingleModuleResult{
 			Timestamp: time.Now().Format(gc.TimeFormat),
-			Duration:  time.Since(startTime).Seconds(),
+			Duration:  duration.Seconds(),
 		}
 		if status != zdns.StatusNoOutput {
 			lookupRes.Status = string(status)
@@ -722,18 +855,56 @@ func handleWorkerInput(gc *CLIConf, rc *zdns.ResolverConfig, line string, resolv
 		if err != nil {
 			log.Fatalf("unable to marshal JSON result: %v", err)
 		}
-		outputChan <- string(jsonRes)
+		output.Result = string(jsonRes)
 	}
+	outputChan <- output
 	metadata.Names++
 }
 
-func parseAlexa(line string) (string, int) {
+// parseInputLine populates the name and any Alexa rank or metadata of res from an input line, returning the name
+// server to use for the line's lookups if it has one. An error is returned if the line is malformed.
+func parseInputLine(gc *CLIConf, rc *zdns.ResolverConfig, line string, res *zdns.Result) (*zdns.NameServer, error) {
+	var err error
+	if gc.AlexaFormat {
+		res.Name, res.AlexaRank, err = parseAlexa(line)
+		return nil, err
+	} else if gc.MetadataFormat {
+		res.Name, res.Metadata = parseMetadataInputLine(line)
+		return nil, nil
+	} else if gc.NameServerMode {
+		return parseInputNameServer(rc, line)
+	}
+	var nameServerString string
+	res.Name, nameServerString = parseNormalInputLine(line)
+	if len(nameServerString) == 0 {
+		return nil, nil
+	}
+	return parseInputNameServer(rc, nameServerString)
+}
+
+// parseInputNameServer parses a name server given in an input line. If it's a domain name (one.one.one.one) that
+// resolves to several IPs, one of them is picked at random.
+func parseInputNameServer(rc *zdns.ResolverConfig, nameServerString string) (*zdns.NameServer, error) {
+	nameServers, err := convertNameServerStringToNameServer(nameServerString, rc.IPVersionMode, rc.DNSOverTLS || rc.DNSOverQUIC, rc.DNSOverHTTPS)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse name server %s: %w", nameServerString, err)
+	}
+	if len(nameServers) == 0 {
+		return nil, fmt.Errorf("no name servers found for %s", nameServerString)
+	}
+	return &nameServers[rand.Intn(len(nameServers))], nil
+}
+
+func parseAlexa(line string) (string, int, error) {
 	s := strings.SplitN(line, ",", 2)
+	if len(s) != 2 {
+		return "", 0, fmt.Errorf("malformed Alexa Top Million line, expected rank,name: %s", line)
+	}
 	rank, err := strconv.Atoi(s[0])
 	if err != nil {
-		log.Fatal("Malformed Alexa Top Million file")
+		return "", 0, fmt.Errorf("malformed Alexa Top Million rank: %s", s[0])
 	}
-	return s[1], rank
+	return s[1], rank, nil
 }
 
 func parseMetadataInputLine(line string) (string, string) {
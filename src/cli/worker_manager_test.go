@@ -15,11 +15,14 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/zmap/zdns/src/cli/iohandlers"
 	"github.com/zmap/zdns/src/zdns"
 )
 
@@ -163,3 +166,67 @@ func TestRemoveDomainsFromNameServersString(t *testing.T) {
 		})
 	}
 }
+
+func TestParseAlexa(t *testing.T) {
+	name, rank, err := parseAlexa("42,example.com")
+	require.NoError(t, err)
+	require.Equal(t, "example.com", name)
+	require.Equal(t, 42, rank)
+
+	for _, line := range []string{"example.com", "first,example.com", ""} {
+		_, _, err = parseAlexa(line)
+		require.Error(t, err, line)
+	}
+}
+
+// stubModule is a LookupModule that answers every lookup with NOERROR without sending any queries
+type stubModule struct {
+	BasicLookupModule
+}
+
+func (m *stubModule) Lookup(resolver *zdns.Resolver, lookupName string, nameServer *zdns.NameServer) (interface{}, zdns.Trace, zdns.Status, error) {
+	return nil, nil, zdns.StatusNoError, nil
+}
+
+func TestHandleWorkerInputIllegalInput(t *testing.T) {
+	gc := &CLIConf{
+		ActiveModules: map[string]LookupModule{"A": &stubModule{}},
+		OutputGroups:  []string{"short"},
+		TimeFormat:    time.RFC3339,
+	}
+	gc.QuietStatusUpdates = true
+	rc := zdns.NewResolverConfig()
+	metadata := &routineMetadata{Status: make(map[zdns.Status]int)}
+	outputChan := make(chan iohandlers.CheckpointedResult, 2)
+	rejectChan := make(chan string, 2)
+
+	handleWorkerInput(gc, rc, iohandlers.InputLine{ID: 0, Line: "example.com,ns.example.com:notaport"}, nil, nil, metadata, outputChan, nil, rejectChan)
+	handleWorkerInput(gc, rc, iohandlers.InputLine{ID: 1, Line: "example.com"}, nil, nil, metadata, outputChan, nil, rejectChan)
+	close(rejectChan)
+
+	var rejected struct {
+		Name    string `json:"name"`
+		Results map[string]struct {
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		} `json:"results"`
+	}
+	output := <-outputChan
+	require.Equal(t, 0, output.ID)
+	require.NoError(t, json.Unmarshal([]byte(output.Result), &rejected))
+	require.Equal(t, "example.com", rejected.Name)
+	require.Equal(t, string(zdns.StatusIllegalInput), rejected.Results["A"].Status)
+	require.Contains(t, rejected.Results["A"].Error, "ns.example.com:notaport")
+
+	output = <-outputChan
+	require.Equal(t, 1, output.ID)
+	require.Contains(t, output.Result, string(zdns.StatusNoError))
+
+	var rejects []string
+	for line := range rejectChan {
+		rejects = append(rejects, line)
+	}
+	require.Equal(t, []string{"example.com,ns.example.com:notaport"}, rejects)
+	require.Equal(t, map[zdns.Status]int{zdns.StatusIllegalInput: 1, zdns.StatusNoError: 1}, metadata.Status)
+	require.Equal(t, 2, metadata.Names)
+}
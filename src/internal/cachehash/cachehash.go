@@ -174,6 +174,20 @@ func (c *CacheHash) Delete(k interface{}) (v interface{}, found bool) {
 	return kv.Value, true
 }
 
+// Range calls f for each key-value pair in the cache, from least to most recently used, without changing their order.
+// If f returns false, iteration stops. f must not modify the cache.
+func (c *CacheHash) Range(f func(k interface{}, v interface{}) bool) {
+	for e := c.l.Back(); e != nil; e = e.Prev() {
+		kv, ok := e.Value.(keyValue)
+		if !ok {
+			log.Panic("CacheHash: Range: invalid list element value type")
+		}
+		if !f(kv.Key, kv.Value) {
+			return
+		}
+	}
+}
+
 // Len returns the number of key-value pairs in the cache.
 func (c *CacheHash) Len() int {
 	return c.len
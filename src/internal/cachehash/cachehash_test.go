@@ -179,3 +179,27 @@ func TestGetNoMove(t *testing.T) {
 	assert.Equal(t, "key2", k, "First key should still be key2 post GetNoMove")
 	assert.Equal(t, "value2", v, "First value should be value2")
 }
+
+func TestRange(t *testing.T) {
+	ch := new(CacheHash)
+	ch.Init(5)
+	ch.Upsert("key1", "value1")
+	ch.Upsert("key2", "value2")
+	ch.Upsert("key3", "value3")
+	ch.Get("key1")
+	var keys []interface{}
+	ch.Range(func(k, v interface{}) bool {
+		keys = append(keys, k)
+		return true
+	})
+	assert.Equal(t, []interface{}{"key2", "key3", "key1"}, keys, "Range should visit least recently used first")
+	if k, _ := ch.First(); k != "key1" {
+		t.Error("Range should not change the order of the cache")
+	}
+	keys = keys[:0]
+	ch.Range(func(k, v interface{}) bool {
+		keys = append(keys, k)
+		return false
+	})
+	assert.Len(t, keys, 1, "Range should stop when f returns false")
+}
@@ -62,6 +62,23 @@ func (c *ShardedCacheHash) Delete(k interface{}) (interface{}, bool) {
 	return c.getShard(k).Delete(k)
 }
 
+// Range calls f for each key-value pair in the cache, locking one shard at a time. Within a shard, pairs are visited
+// from least to most recently used. If f returns false, iteration stops. f must not modify the cache.
+func (c *ShardedCacheHash) Range(f func(k interface{}, v interface{}) bool) {
+	for i := 0; i < c.shardsLen; i++ {
+		c.shards[i].Lock()
+		keepGoing := true
+		c.shards[i].Range(func(k interface{}, v interface{}) bool {
+			keepGoing = f(k, v)
+			return keepGoing
+		})
+		c.shards[i].Unlock()
+		if !keepGoing {
+			return
+		}
+	}
+}
+
 func (c *ShardedCacheHash) RegisterCB(newCB func(interface{}, interface{})) {
 	for i := 0; i < c.shardsLen; i++ {
 		c.shards[i].RegisterCB(newCB)
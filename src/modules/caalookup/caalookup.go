@@ -0,0 +1,189 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+/*
+ * Discovery of the relevant CAA RRset of a domain.
+ * RFC reference: https://datatracker.ietf.org/doc/html/rfc8659#section-3
+ */
+
+package caalookup
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+
+	"github.com/zmap/zdns/src/cli"
+	"github.com/zmap/zdns/src/zdns"
+)
+
+// maxAliases bounds the CNAME chain followed at each name, in case the resolver doesn't follow CNAMEs itself
+const maxAliases = 8
+
+// Result is the relevant CAA RRset of a domain, the one a CA checks before issuing a certificate for it
+type Result struct {
+	// RelevantName is the name the RRset was found at, the input domain or the closest ancestor with CAA records
+	RelevantName string `json:"relevant_name,omitempty" groups:"short,normal,long,trace"`
+	// Aliases are the CNAME targets followed from RelevantName to the RRset
+	Aliases    []string   `json:"aliases,omitempty" groups:"short,normal,long,trace"`
+	Properties []Property `json:"properties,omitempty" groups:"short,normal,long,trace"`
+	Issue      []Issuer   `json:"issue,omitempty" groups:"short,normal,long,trace"`
+	// IssueWild is empty if wildcard certificates are governed by Issue instead
+	IssueWild []Issuer `json:"issuewild,omitempty" groups:"short,normal,long,trace"`
+	IODEF     []string `json:"iodef,omitempty" groups:"short,normal,long,trace"`
+	// UnknownCritical lists the tags of critical properties this module doesn't know, which forbid any CA to issue
+	UnknownCritical []string `json:"unknown_critical,omitempty" groups:"short,normal,long,trace"`
+	// Checked is every name looked up while climbing the tree, in order
+	Checked []string `json:"checked" groups:"normal,long,trace"`
+}
+
+func init() {
+	mod := new(CAALookupModule)
+	cli.RegisterLookupModule("CAALOOKUP", mod)
+}
+
+type CAALookupModule struct {
+	cli.BasicLookupModule
+}
+
+// CLIInit initializes the CAALookup module
+func (mod *CAALookupModule) CLIInit(gc *cli.CLIConf, rc *zdns.ResolverConfig) error {
+	if gc.LookupAllNameServers {
+		return errors.New("CAALOOKUP module does not support --all-nameservers")
+	}
+	mod.DNSType = dns.TypeCAA
+	mod.DNSClass = dns.ClassINET
+	return mod.BasicLookupModule.CLIInit(gc, rc)
+}
+
+// Lookup finds the relevant CAA RRset of lookupName: the CAA records of the name itself, or else of its closest
+// ancestor below the root that has any, following CNAMEs at each name (RFC 8659, Section 3). A failed lookup at any
+// name stops the search with its status, since a CA must not issue if it can't tell whether CAA records exist.
+func (mod *CAALookupModule) Lookup(r *zdns.Resolver, lookupName string, nameServer *zdns.NameServer) (interface{}, zdns.Trace, zdns.Status, error) {
+	res := &Result{}
+	var trace zdns.Trace
+	name := strings.ToLower(strings.TrimSuffix(lookupName, "."))
+	for len(name) > 0 {
+		records, aliases, innerTrace, status, err := mod.relevantRecords(r, name, nameServer, res)
+		trace = append(trace, innerTrace...)
+		if status != zdns.StatusNoError {
+			return res, trace, status, err
+		}
+		if len(records) > 0 {
+			res.RelevantName = name
+			res.Aliases = aliases
+			res.summarize(records)
+			return res, trace, zdns.StatusNoError, nil
+		}
+		// RFC 8659 never looks up the root
+		_, name, _ = strings.Cut(name, ".")
+	}
+	return res, trace, zdns.StatusNoRecord, nil
+}
+
+// relevantRecords returns the CAA records of name, following its CNAME chain. Missing names and empty answers aren't
+// failures, they just mean the search continues at the parent.
+func (mod *CAALookupModule) relevantRecords(r *zdns.Resolver, name string, nameServer *zdns.NameServer, res *Result) ([]zdns.CAAAnswer, []string, zdns.Trace, zdns.Status, error) {
+	var trace zdns.Trace
+	var aliases []string
+	seen := map[string]bool{name: true}
+	target := name
+	for i := 0; i <= maxAliases; i++ {
+		res.Checked = append(res.Checked, target)
+		innerRes, innerTrace, status, err := mod.LookupType(r, target, mod.DNSType, nameServer)
+		trace = append(trace, innerTrace...)
+		if status == zdns.StatusNXDomain || status == zdns.StatusNoAnswer || status == zdns.StatusNoRecord {
+			return nil, aliases, trace, zdns.StatusNoError, nil
+		}
+		if status != zdns.StatusNoError {
+			return nil, aliases, trace, status, err
+		}
+		// a resolver following CNAMEs returns the whole chain, so walk it as far as the answers go
+		cnames := make(map[string]string)
+		caa := make(map[string][]zdns.CAAAnswer)
+		for _, a := range innerRes.Answers {
+			switch ans := a.(type) {
+			case zdns.CAAAnswer:
+				owner := strings.ToLower(ans.Name)
+				caa[owner] = append(caa[owner], ans)
+			case zdns.Answer:
+				if ans.RrType == dns.TypeCNAME {
+					cnames[strings.ToLower(ans.Name)] = strings.ToLower(strings.TrimSuffix(ans.Answer, "."))
+				}
+			}
+		}
+		advanced := false
+		for {
+			if records := caa[target]; len(records) > 0 {
+				return records, aliases, trace, zdns.StatusNoError, nil
+			}
+			next, ok := cnames[target]
+			if !ok || seen[next] {
+				break
+			}
+			seen[next] = true
+			aliases = append(aliases, next)
+			target = next
+			advanced = true
+		}
+		if !advanced {
+			return nil, aliases, trace, zdns.StatusNoError, nil
+		}
+	}
+	return nil, aliases, trace, zdns.StatusServFail, errors.New("too many CNAMEs")
+}
+
+// summarize parses the relevant RRset into the properties and issuers of the result
+func (res *Result) summarize(records []zdns.CAAAnswer) {
+	for _, record := range records {
+		p := parseProperty(record.Flag, record.Tag, record.Value)
+		res.Properties = append(res.Properties, p)
+		issuer := Issuer{}
+		if p.Issuer != nil {
+			issuer = *p.Issuer
+		}
+		// a malformed issuer is kept as one without a domain, which CAs treat as forbidding issuance
+		switch p.Tag {
+		case tagIssue:
+			res.Issue = append(res.Issue, issuer)
+		case tagIssueWild:
+			res.IssueWild = append(res.IssueWild, issuer)
+		case tagIODEF:
+			if len(p.Error) == 0 {
+				res.IODEF = append(res.IODEF, p.Value)
+			}
+		default:
+			if p.Critical {
+				res.UnknownCritical = append(res.UnknownCritical, p.Tag)
+			}
+		}
+	}
+}
+
+func (mod *CAALookupModule) Help() string {
+	return ""
+}
+
+func (mod *CAALookupModule) Validate(args []string) error {
+	return nil
+}
+
+func (mod *CAALookupModule) GetDescription() string {
+	return "CAALOOKUP climbs the tree from a domain to find its relevant CAA RRset as described in RFC 8659, following CNAMEs, and parses its issue, issuewild and iodef properties."
+}
+
+func (mod *CAALookupModule) NewFlags() interface{} {
+	return mod
+}
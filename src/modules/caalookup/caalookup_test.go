@@ -0,0 +1,139 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package caalookup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/zmap/zdns/src/cli"
+	"github.com/zmap/zdns/src/zdns"
+	"github.com/zmap/zdns/src/zdns/testserver"
+	"github.com/zmap/zdns/src/zdns/testserver/testresolver"
+)
+
+func initTest(t *testing.T) (*CAALookupModule, *zdns.Resolver) {
+	network := testserver.NewNetwork()
+	server := network.AddServer(
+		testserver.MustParseZone("test.", `@ SOA ns1.nic hostmaster 1 7200 900 1209600 300`),
+		testserver.MustParseZone("example.test.", `
+@ SOA ns1 hostmaster 1 7200 900 1209600 300
+@ CAA 0 issue "ca.test; accounturi=https://ca.test/acct/1; validationmethods=dns-01"
+@ CAA 0 ISSUEWILD ";"
+@ CAA 0 iodef "mailto:security@example.test"
+www A 192.0.2.1
+critical CAA 128 tbs "unknown"
+critical CAA 0 issue "ca.test"
+alias CNAME cdn.provider.test.
+dangling CNAME missing.provider.test.
+malformed CAA 0 issue "ca.test; =value"
+malformed CAA 0 iodef "ftp://example.test"
+`),
+		testserver.MustParseZone("provider.test.", `
+@ SOA ns1 hostmaster 1 7200 900 1209600 300
+cdn CAA 0 issue "other-ca.test"
+`),
+	)
+	require.NoError(t, network.Start())
+	t.Cleanup(network.Close)
+
+	r := testresolver.New(t, testresolver.Config(network, server))
+
+	mod := &CAALookupModule{}
+	require.NoError(t, mod.CLIInit(&cli.CLIConf{}, &zdns.ResolverConfig{}))
+	return mod, r
+}
+
+func lookup(t *testing.T, mod *CAALookupModule, r *zdns.Resolver, name string) (*Result, zdns.Status) {
+	res, _, status, _ := mod.Lookup(r, name, nil)
+	return res.(*Result), status
+}
+
+func TestCAALookupClimbsTree(t *testing.T) {
+	mod, r := initTest(t)
+
+	res, status := lookup(t, mod, r, "deep.www.example.test.")
+	require.Equal(t, zdns.StatusNoError, status)
+	require.Equal(t, "example.test", res.RelevantName)
+	require.Equal(t, []string{"deep.www.example.test", "www.example.test", "example.test"}, res.Checked)
+	require.Len(t, res.Properties, 3)
+	require.Equal(t, []Issuer{{
+		Domain:     "ca.test",
+		Parameters: map[string]string{"accounturi": "https://ca.test/acct/1", "validationmethods": "dns-01"},
+	}}, res.Issue)
+	require.Equal(t, []Issuer{{}}, res.IssueWild)
+	require.Equal(t, []string{"mailto:security@example.test"}, res.IODEF)
+	require.Empty(t, res.UnknownCritical)
+
+	res, status = lookup(t, mod, r, "nothing.test")
+	require.Equal(t, zdns.StatusNoRecord, status)
+	require.Empty(t, res.RelevantName)
+	require.Equal(t, []string{"nothing.test", "test"}, res.Checked)
+}
+
+func TestCAALookupFollowsCNAMEs(t *testing.T) {
+	mod, r := initTest(t)
+
+	res, status := lookup(t, mod, r, "alias.example.test")
+	require.Equal(t, zdns.StatusNoError, status)
+	require.Equal(t, "alias.example.test", res.RelevantName)
+	require.Equal(t, []string{"cdn.provider.test"}, res.Aliases)
+	require.Equal(t, []Issuer{{Domain: "other-ca.test"}}, res.Issue)
+
+	// the target of a dangling CNAME has no records, so the search continues at the parent of the alias
+	res, status = lookup(t, mod, r, "dangling.example.test")
+	require.Equal(t, zdns.StatusNoError, status)
+	require.Equal(t, "example.test", res.RelevantName)
+	require.Empty(t, res.Aliases)
+}
+
+func TestCAALookupProperties(t *testing.T) {
+	mod, r := initTest(t)
+
+	res, status := lookup(t, mod, r, "critical.example.test")
+	require.Equal(t, zdns.StatusNoError, status)
+	require.Equal(t, []string{"tbs"}, res.UnknownCritical)
+	require.True(t, res.Properties[0].Critical)
+
+	res, status = lookup(t, mod, r, "malformed.example.test")
+	require.Equal(t, zdns.StatusNoError, status)
+	require.Equal(t, []Issuer{{}}, res.Issue)
+	require.Empty(t, res.IODEF)
+	for _, p := range res.Properties {
+		require.NotEmpty(t, p.Error, p.Tag)
+	}
+}
+
+func TestCAALookupFailure(t *testing.T) {
+	mod, r := initTest(t)
+
+	// the server refuses names outside its zones, and a CA must not climb past a failed lookup
+	res, status := lookup(t, mod, r, "www.example.invalid")
+	require.NotEqual(t, zdns.StatusNoError, status)
+	require.NotEqual(t, zdns.StatusNoRecord, status)
+	require.Equal(t, []string{"www.example.invalid"}, res.Checked)
+}
+
+func TestParseIssuer(t *testing.T) {
+	issuer, err := parseIssuer(" CA.Example ;  accounturi = https://ca.example/1 ; ")
+	require.NoError(t, err)
+	require.Equal(t, &Issuer{Domain: "ca.example", Parameters: map[string]string{"accounturi": "https://ca.example/1"}}, issuer)
+
+	for _, value := range []string{"-ca.example", "ca..example", "ca.example; key", "ca.example; key=a b", "ca.example; -key=a"} {
+		_, err = parseIssuer(value)
+		require.Error(t, err, value)
+	}
+}
@@ -0,0 +1,108 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+/*
+ * Parsing of CAA properties.
+ * RFC reference: https://datatracker.ietf.org/doc/html/rfc8659#section-4
+ */
+
+package caalookup
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	tagIssue     = "issue"
+	tagIssueWild = "issuewild"
+	tagIODEF     = "iodef"
+	// criticalFlag is the Issuer Critical Flag, CAs must not issue if they don't understand a critical property
+	criticalFlag = 128
+)
+
+var (
+	// labelRegexp matches a label of an issuer domain name or a parameter tag: letters and digits, with inner hyphens
+	labelRegexp = regexp.MustCompile(`^[a-zA-Z0-9](?:-*[a-zA-Z0-9])*$`)
+	// parameterValueRegexp matches the printable characters other than whitespace and ; allowed in parameter values
+	parameterValueRegexp = regexp.MustCompile(`^[\x21-\x3a\x3c-\x7e]*$`)
+)
+
+// Property is a CAA record of the relevant RRset
+type Property struct {
+	Flag     uint8  `json:"flag" groups:"short,normal,long,trace"`
+	Tag      string `json:"tag" groups:"short,normal,long,trace"`
+	Value    string `json:"value" groups:"short,normal,long,trace"`
+	Critical bool   `json:"critical" groups:"short,normal,long,trace"`
+	// Issuer is the parsed value of an issue or issuewild property
+	Issuer *Issuer `json:"issuer,omitempty" groups:"short,normal,long,trace"`
+	Error  string  `json:"error,omitempty" groups:"short,normal,long,trace"`
+}
+
+// Issuer is the value of an issue or issuewild property. An empty Domain allows no CA to issue.
+type Issuer struct {
+	Domain     string            `json:"domain,omitempty" groups:"short,normal,long,trace"`
+	Parameters map[string]string `json:"parameters,omitempty" groups:"short,normal,long,trace"`
+}
+
+// parseIssuer parses the value of an issue or issuewild property (RFC 8659, Section 4.2)
+func parseIssuer(value string) (*Issuer, error) {
+	domain, rest, _ := strings.Cut(value, ";")
+	issuer := &Issuer{Domain: strings.ToLower(strings.Trim(domain, " \t"))}
+	if len(issuer.Domain) > 0 {
+		for _, label := range strings.Split(issuer.Domain, ".") {
+			if !labelRegexp.MatchString(label) {
+				return nil, fmt.Errorf("invalid issuer domain name: %s", issuer.Domain)
+			}
+		}
+	}
+	for _, parameter := range strings.Split(rest, ";") {
+		parameter = strings.Trim(parameter, " \t")
+		if len(parameter) == 0 {
+			continue
+		}
+		tag, paramValue, found := strings.Cut(parameter, "=")
+		tag = strings.Trim(tag, " \t")
+		paramValue = strings.Trim(paramValue, " \t")
+		if !found || !labelRegexp.MatchString(tag) || !parameterValueRegexp.MatchString(paramValue) {
+			return nil, fmt.Errorf("invalid parameter: %s", parameter)
+		}
+		if issuer.Parameters == nil {
+			issuer.Parameters = make(map[string]string)
+		}
+		issuer.Parameters[tag] = paramValue
+	}
+	return issuer, nil
+}
+
+// parseProperty parses a CAA record. Tags are matched case insensitively.
+func parseProperty(flag uint8, tag, value string) Property {
+	p := Property{Flag: flag, Tag: strings.ToLower(tag), Value: value, Critical: flag&criticalFlag != 0}
+	switch p.Tag {
+	case tagIssue, tagIssueWild:
+		issuer, err := parseIssuer(value)
+		if err != nil {
+			p.Error = err.Error()
+		}
+		p.Issuer = issuer
+	case tagIODEF:
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "mailto" && u.Scheme != "http" && u.Scheme != "https") {
+			p.Error = "invalid iodef URL: " + value
+		}
+	}
+	return p
+}
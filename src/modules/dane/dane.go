@@ -0,0 +1,187 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+/*
+ * Discovery of DANE TLSA records for the mail exchanges and web server of a domain.
+ * RFC reference:
+ * - https://datatracker.ietf.org/doc/html/rfc6698 (DANE)
+ * - https://datatracker.ietf.org/doc/html/rfc7672 (DANE for SMTP)
+ */
+
+package dane
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+
+	"github.com/zmap/zdns/src/cli"
+	"github.com/zmap/zdns/src/modules/mxlookup"
+	"github.com/zmap/zdns/src/zdns"
+)
+
+const (
+	smtpPort  = 25
+	httpsPort = 443
+)
+
+// Endpoint is a TLS service of the domain along with its TLSA RRset
+type Endpoint struct {
+	Service string `json:"service" groups:"short,normal,long,trace"`
+	Host    string `json:"host" groups:"short,normal,long,trace"`
+	Port    uint16 `json:"port" groups:"short,normal,long,trace"`
+	// Name is the name the TLSA records were looked up at, _<port>._tcp.<host>
+	Name       string  `json:"name" groups:"normal,long,trace"`
+	Preference *uint16 `json:"preference,omitempty" groups:"short,normal,long,trace"`
+	Status     string  `json:"status" groups:"short,normal,long,trace"`
+	// DNSSECStatus is the validation status of the TLSA lookup, unsigned TLSA records can be forged and are ignored
+	DNSSECStatus zdns.DNSSECStatus `json:"dnssec_status,omitempty" groups:"short,normal,long,trace"`
+	DNSSECReason string            `json:"dnssec_reason,omitempty" groups:"normal,long,trace"`
+	Records      []TLSARecord      `json:"records,omitempty" groups:"short,normal,long,trace"`
+	// DANE is whether a client would authenticate the endpoint with its TLSA records: they are secure, at least one
+	// is usable, and for a mail exchange, the MX RRset it came from is secure as well
+	DANE bool `json:"dane" groups:"short,normal,long,trace"`
+}
+
+type Result struct {
+	// MXDNSSECStatus is the validation status of the MX lookup, whose exchanges are only trusted if it is secure
+	MXDNSSECStatus zdns.DNSSECStatus `json:"mx_dnssec_status,omitempty" groups:"short,normal,long,trace"`
+	Endpoints      []Endpoint        `json:"endpoints" groups:"short,normal,long,trace"`
+	Errors         []string          `json:"errors,omitempty" groups:"short,normal,long,trace"`
+}
+
+func init() {
+	mod := new(DANELookupModule)
+	cli.RegisterLookupModule("DANE", mod)
+}
+
+type DANELookupModule struct {
+	cli.BasicLookupModule
+	SkipMX  bool `long:"skip-mx" description:"don't look up TLSA records for the mail exchanges of the domain"`
+	SkipWeb bool `long:"skip-web" description:"don't look up TLSA records for HTTPS on the domain itself"`
+
+	mx mxlookup.MXLookupModule
+}
+
+// CLIInit initializes the DANE lookup module. TLSA records are only meaningful if they are signed, so DNSSEC
+// validation is always enabled for this module.
+func (mod *DANELookupModule) CLIInit(gc *cli.CLIConf, rc *zdns.ResolverConfig) error {
+	if gc.LookupAllNameServers {
+		return errors.New("DANE module does not support --all-nameservers")
+	}
+	if mod.SkipMX && mod.SkipWeb {
+		return errors.New("--skip-mx and --skip-web can't both be set")
+	}
+	rc.DNSSecEnabled = true
+	rc.ShouldValidateDNSSEC = true
+	mod.DNSType = dns.TypeTLSA
+	mod.DNSClass = dns.ClassINET
+	if err := mod.BasicLookupModule.CLIInit(gc, rc); err != nil {
+		return err
+	}
+	mod.mx.IsIterative = mod.IsIterative
+	return nil
+}
+
+// Lookup finds the TLSA records of the mail exchanges of lookupName on port 25, and of lookupName itself on port 443
+func (mod *DANELookupModule) Lookup(r *zdns.Resolver, lookupName string, nameServer *zdns.NameServer) (interface{}, zdns.Trace, zdns.Status, error) {
+	domain := strings.ToLower(strings.TrimSuffix(lookupName, "."))
+	res := &Result{Endpoints: []Endpoint{}}
+	var trace zdns.Trace
+
+	if !mod.SkipMX {
+		exchanges, mxRes, mxTrace, status, err := mod.mx.LookupExchanges(r, domain, nameServer)
+		trace = append(trace, mxTrace...)
+		if mxRes != nil && mxRes.DNSSECResult != nil {
+			res.MXDNSSECStatus = mxRes.DNSSECResult.Status
+		}
+		if status != zdns.StatusNoError && !zdns.IsMissingStatus(status) {
+			if mod.SkipWeb {
+				return res, trace, status, err
+			}
+			res.Errors = append(res.Errors, fmt.Sprintf("MX lookup failed with status %s", status))
+		}
+		seen := make(map[string]bool)
+		for _, exchange := range exchanges {
+			host := strings.ToLower(exchange.Name)
+			// a null MX (RFC 7505) means the domain doesn't accept mail
+			if len(host) == 0 || seen[host] {
+				continue
+			}
+			seen[host] = true
+			preference := exchange.Preference
+			endpoint := Endpoint{Service: "smtp", Host: host, Port: smtpPort, Preference: &preference}
+			trace = append(trace, mod.lookupEndpoint(r, &endpoint, nameServer)...)
+			endpoint.DANE = endpoint.DANE && res.MXDNSSECStatus == zdns.DNSSECSecure
+			res.Endpoints = append(res.Endpoints, endpoint)
+		}
+	}
+	if !mod.SkipWeb {
+		endpoint := Endpoint{Service: "https", Host: domain, Port: httpsPort}
+		trace = append(trace, mod.lookupEndpoint(r, &endpoint, nameServer)...)
+		res.Endpoints = append(res.Endpoints, endpoint)
+	}
+
+	for _, endpoint := range res.Endpoints {
+		if len(endpoint.Records) > 0 {
+			return res, trace, zdns.StatusNoError, nil
+		}
+	}
+	return res, trace, zdns.StatusNoRecord, nil
+}
+
+// lookupEndpoint looks up and parses the TLSA records of an endpoint
+func (mod *DANELookupModule) lookupEndpoint(r *zdns.Resolver, endpoint *Endpoint, nameServer *zdns.NameServer) zdns.Trace {
+	endpoint.Name = fmt.Sprintf("_%d._tcp.%s", endpoint.Port, endpoint.Host)
+	innerRes, trace, status, _ := mod.LookupType(r, endpoint.Name, mod.DNSType, nameServer)
+	endpoint.Status = string(status)
+	if innerRes == nil {
+		return trace
+	}
+	if innerRes.DNSSECResult != nil {
+		endpoint.DNSSECStatus = innerRes.DNSSECResult.Status
+		endpoint.DNSSECReason = innerRes.DNSSECResult.Reason
+	}
+	if status != zdns.StatusNoError {
+		return trace
+	}
+	usable := false
+	for _, a := range innerRes.Answers {
+		if ans, ok := a.(zdns.TLSAAnswer); ok && ans.RrType == dns.TypeTLSA {
+			rec := parseTLSA(ans, endpoint.Service == "smtp")
+			usable = usable || rec.Usable
+			endpoint.Records = append(endpoint.Records, rec)
+		}
+	}
+	endpoint.DANE = usable && endpoint.DNSSECStatus == zdns.DNSSECSecure
+	return trace
+}
+
+func (mod *DANELookupModule) Help() string {
+	return ""
+}
+
+func (mod *DANELookupModule) Validate(args []string) error {
+	return nil
+}
+
+func (mod *DANELookupModule) GetDescription() string {
+	return "DANE looks up the TLSA records of the mail exchanges of a domain on port 25 and of the domain itself on port 443, reporting whether each RRset is DNSSEC-secure and usable for DANE."
+}
+
+func (mod *DANELookupModule) NewFlags() interface{} {
+	return mod
+}
@@ -0,0 +1,152 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package dane
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zmap/zdns/src/cli"
+	"github.com/zmap/zdns/src/zdns"
+	"github.com/zmap/zdns/src/zdns/testserver"
+	"github.com/zmap/zdns/src/zdns/testserver/testresolver"
+)
+
+var digest = strings.Repeat("ab", 32)
+
+// initTest serves a signed root -> test. -> example.test. chain and an unsigned insecure.test. from one server, which
+// the resolver uses as its external name server
+func initTest(t *testing.T, mod *DANELookupModule) *zdns.Resolver {
+	network := testserver.NewNetwork()
+	server := network.AddServer()
+	root := testserver.MustParseZone(".", `. SOA a.root-servers.test. hostmaster.test. 1 7200 900 1209600 86400`)
+	tld := testserver.MustParseZone("test.", `@ SOA ns1.nic.test. hostmaster.test. 1 7200 900 1209600 300`)
+	sld := testserver.MustParseZone("example.test.", `
+@ SOA ns1 hostmaster 1 7200 900 1209600 300
+@ MX 10 mx1.example.test.
+@ MX 20 mx.insecure.test.
+_25._tcp.mx1 TLSA 3 1 1 `+digest+`
+_25._tcp.mx1 TLSA 1 0 1 `+digest+`
+_443._tcp TLSA 2 0 2 `+digest+`
+pkix MX 10 mx.pkix.example.test.
+_25._tcp.mx.pkix TLSA 1 1 1 `+digest+`
+`)
+	insecure := testserver.MustParseZone("insecure.test.", `
+@ SOA ns hostmaster 1 7200 900 1209600 300
+@ MX 10 mx1.example.test.
+@ MX 20 mx.insecure.test.
+_25._tcp.mx TLSA 3 1 1 `+digest+`
+`)
+	root.Delegate(tld, "ns1.nic.test.", server.IP)
+	tld.Delegate(sld, "ns1.example.test.", server.IP)
+	tld.Delegate(insecure, "ns.insecure.test.", server.IP)
+	require.NoError(t, sld.Sign())
+	require.NoError(t, tld.Sign())
+	require.NoError(t, root.Sign())
+	server.Zones = []*testserver.Zone{root, tld, sld, insecure}
+	require.NoError(t, network.Start())
+	t.Cleanup(network.Close)
+
+	rc := testresolver.Config(network, server)
+	rc.RootTrustAnchors = nil
+	for _, ds := range root.DS() {
+		rc.RootTrustAnchors = append(rc.RootTrustAnchors, *ds)
+	}
+	require.NoError(t, mod.CLIInit(&cli.CLIConf{}, rc))
+	require.True(t, rc.ShouldValidateDNSSEC)
+	return testresolver.New(t, rc)
+}
+
+func TestDANELookup(t *testing.T) {
+	mod := &DANELookupModule{}
+	r := initTest(t, mod)
+
+	res, _, status, err := mod.Lookup(r, "example.test", nil)
+	require.NoError(t, err)
+	require.Equal(t, zdns.StatusNoError, status)
+	result := res.(*Result)
+	require.Equal(t, zdns.DNSSECSecure, result.MXDNSSECStatus)
+	require.Len(t, result.Endpoints, 3)
+
+	mx1 := result.Endpoints[0]
+	require.Equal(t, "smtp", mx1.Service)
+	require.Equal(t, "_25._tcp.mx1.example.test", mx1.Name)
+	require.Equal(t, uint16(10), *mx1.Preference)
+	require.Equal(t, zdns.DNSSECSecure, mx1.DNSSECStatus, mx1.DNSSECReason)
+	require.True(t, mx1.DANE)
+	require.Len(t, mx1.Records, 2)
+	records := make(map[string]TLSARecord)
+	for _, rec := range mx1.Records {
+		records[rec.UsageName] = rec
+	}
+	require.Equal(t, TLSARecord{
+		Usage: 3, UsageName: "DANE-EE", Selector: 1, SelectorName: "SPKI", MatchingType: 1, MatchingTypeName: "SHA2-256",
+		Data: digest, Usable: true,
+	}, records["DANE-EE"])
+	require.Equal(t, "PKIX-EE records are not used for SMTP", records["PKIX-EE"].Error)
+
+	// the TLSA records of an MX host in an unsigned zone can't be trusted
+	insecureMX := result.Endpoints[1]
+	require.Equal(t, "mx.insecure.test", insecureMX.Host)
+	require.Equal(t, zdns.DNSSECInsecure, insecureMX.DNSSECStatus)
+	require.Len(t, insecureMX.Records, 1)
+	require.True(t, insecureMX.Records[0].Usable)
+	require.False(t, insecureMX.DANE)
+
+	// SHA2-512 data must be 64 bytes
+	web := result.Endpoints[2]
+	require.Equal(t, "https", web.Service)
+	require.Equal(t, "_443._tcp.example.test", web.Name)
+	require.Equal(t, "SHA2-512 data must be 64 bytes, not 32", web.Records[0].Error)
+	require.False(t, web.DANE)
+}
+
+func TestDANELookupInsecureMX(t *testing.T) {
+	mod := &DANELookupModule{SkipWeb: true}
+	r := initTest(t, mod)
+
+	// the exchanges of an unsigned MX RRset could be forged, so even secure TLSA records don't apply
+	res, _, status, _ := mod.Lookup(r, "insecure.test", nil)
+	require.Equal(t, zdns.StatusNoError, status)
+	result := res.(*Result)
+	require.Equal(t, zdns.DNSSECInsecure, result.MXDNSSECStatus)
+	require.Equal(t, "mx1.example.test", result.Endpoints[0].Host)
+	require.Equal(t, zdns.DNSSECSecure, result.Endpoints[0].DNSSECStatus)
+	require.False(t, result.Endpoints[0].DANE)
+
+	// a PKIX usage is the only record, so DANE doesn't apply
+	res, _, status, _ = mod.Lookup(r, "pkix.example.test", nil)
+	require.Equal(t, zdns.StatusNoError, status)
+	require.False(t, res.(*Result).Endpoints[0].DANE)
+
+	res, _, status, _ = mod.Lookup(r, "missing.example.test", nil)
+	require.Equal(t, zdns.StatusNoRecord, status)
+	require.Empty(t, res.(*Result).Endpoints)
+}
+
+func TestParseTLSA(t *testing.T) {
+	tlsa := func(usage, selector, matchingType uint8, data string) zdns.TLSAAnswer {
+		return zdns.TLSAAnswer{Answer: zdns.Answer{RrType: dns.TypeTLSA}, CertUsage: usage, Selector: selector, MatchingType: matchingType, Certificate: data}
+	}
+	require.True(t, parseTLSA(tlsa(1, 0, 1, digest), false).Usable)
+	require.Equal(t, "unknown usage: 4", parseTLSA(tlsa(4, 0, 1, digest), false).Error)
+	require.Equal(t, "unknown selector: 2", parseTLSA(tlsa(3, 2, 1, digest), true).Error)
+	require.Equal(t, "unknown matching type: 3", parseTLSA(tlsa(3, 1, 3, digest), true).Error)
+	require.Equal(t, "invalid data", parseTLSA(tlsa(3, 1, 0, "xyz"), true).Error)
+	require.True(t, parseTLSA(tlsa(3, 0, 0, "3082"), true).Usable)
+}
@@ -0,0 +1,84 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+/*
+ * Parsing of TLSA records.
+ * RFC reference:
+ * - https://datatracker.ietf.org/doc/html/rfc6698#section-2.1 (TLSA records)
+ * - https://datatracker.ietf.org/doc/html/rfc7218 (acronyms for the field values)
+ * - https://datatracker.ietf.org/doc/html/rfc7672#section-3.1 (TLSA records for SMTP)
+ */
+
+package dane
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/zmap/zdns/src/zdns"
+)
+
+var (
+	usages        = map[uint8]string{0: "PKIX-TA", 1: "PKIX-EE", 2: "DANE-TA", 3: "DANE-EE"}
+	selectors     = map[uint8]string{0: "Cert", 1: "SPKI"}
+	matchingTypes = map[uint8]string{0: "Full", 1: "SHA2-256", 2: "SHA2-512"}
+	// digestSizes are the lengths in bytes of the data of the digest matching types
+	digestSizes = map[uint8]int{1: 32, 2: 64}
+)
+
+// TLSARecord is a TLSA record, with the RFC 7218 names of its fields
+type TLSARecord struct {
+	Usage            uint8  `json:"usage" groups:"short,normal,long,trace"`
+	UsageName        string `json:"usage_name,omitempty" groups:"short,normal,long,trace"`
+	Selector         uint8  `json:"selector" groups:"short,normal,long,trace"`
+	SelectorName     string `json:"selector_name,omitempty" groups:"short,normal,long,trace"`
+	MatchingType     uint8  `json:"matching_type" groups:"short,normal,long,trace"`
+	MatchingTypeName string `json:"matching_type_name,omitempty" groups:"short,normal,long,trace"`
+	Data             string `json:"data" groups:"normal,long,trace"`
+	// Usable is whether a client of the service would use the record to authenticate the server
+	Usable bool   `json:"usable" groups:"short,normal,long,trace"`
+	Error  string `json:"error,omitempty" groups:"short,normal,long,trace"`
+}
+
+// parseTLSA parses a TLSA record. SMTP clients only use the DANE-TA and DANE-EE usages, since MX hosts can't be
+// expected to have certificates a public CA would issue (RFC 7672, Section 3.1.3).
+func parseTLSA(ans zdns.TLSAAnswer, smtp bool) TLSARecord {
+	rec := TLSARecord{
+		Usage:            ans.CertUsage,
+		UsageName:        usages[ans.CertUsage],
+		Selector:         ans.Selector,
+		SelectorName:     selectors[ans.Selector],
+		MatchingType:     ans.MatchingType,
+		MatchingTypeName: matchingTypes[ans.MatchingType],
+		Data:             ans.Certificate,
+	}
+	data, err := hex.DecodeString(ans.Certificate)
+	switch {
+	case len(rec.UsageName) == 0:
+		rec.Error = fmt.Sprintf("unknown usage: %d", rec.Usage)
+	case len(rec.SelectorName) == 0:
+		rec.Error = fmt.Sprintf("unknown selector: %d", rec.Selector)
+	case len(rec.MatchingTypeName) == 0:
+		rec.Error = fmt.Sprintf("unknown matching type: %d", rec.MatchingType)
+	case err != nil || len(data) == 0:
+		rec.Error = "invalid data"
+	case digestSizes[rec.MatchingType] != 0 && len(data) != digestSizes[rec.MatchingType]:
+		rec.Error = fmt.Sprintf("%s data must be %d bytes, not %d", rec.MatchingTypeName, digestSizes[rec.MatchingType], len(data))
+	case smtp && rec.Usage < 2:
+		rec.Error = rec.UsageName + " records are not used for SMTP"
+	default:
+		rec.Usable = true
+	}
+	return rec
+}
@@ -0,0 +1,269 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package delegationcheck
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+
+	"github.com/zmap/zdns/src/cli"
+	"github.com/zmap/zdns/src/zdns"
+)
+
+// GlueComparison compares the glue for a name server in the parent's referral with the addresses of the name server
+// in its own zone
+type GlueComparison struct {
+	Glue      []string `json:"glue,omitempty" groups:"short,normal,long,trace"`
+	GlueTTL   uint32   `json:"glue_ttl,omitempty" groups:"normal,long,trace"`
+	Addresses []string `json:"addresses,omitempty" groups:"short,normal,long,trace"`
+	TTL       uint32   `json:"ttl,omitempty" groups:"normal,long,trace"`
+	// Match is set if the glue and addresses are the same set
+	Match bool `json:"match" groups:"short,normal,long,trace"`
+	// Missing lists the addresses the parent has no glue for, Extra the glue the name server doesn't have
+	Missing []string `json:"missing,omitempty" groups:"short,normal,long,trace"`
+	Extra   []string `json:"extra,omitempty" groups:"short,normal,long,trace"`
+}
+
+// NameServer is a name server of the zone, from the delegation in the parent zone, the NS RRset of the zone itself,
+// or both
+type NameServer struct {
+	Name     string `json:"name" groups:"short,normal,long,trace"`
+	InParent bool   `json:"in_parent" groups:"short,normal,long,trace"`
+	InChild  bool   `json:"in_child" groups:"short,normal,long,trace"`
+	// InBailiwick is set if the name server is beneath the zone, so the parent must have glue for it
+	InBailiwick bool `json:"in_bailiwick" groups:"short,normal,long,trace"`
+	// IPv4 and IPv6 are only compared for name servers that are in bailiwick or have glue
+	IPv4 *GlueComparison `json:"ipv4,omitempty" groups:"short,normal,long,trace"`
+	IPv6 *GlueComparison `json:"ipv6,omitempty" groups:"short,normal,long,trace"`
+}
+
+type Result struct {
+	// Parent is the zone the referral came from, ParentServer and ChildServer the servers that answered
+	Parent       string       `json:"parent" groups:"short,normal,long,trace"`
+	ParentServer string       `json:"parent_server,omitempty" groups:"normal,long,trace"`
+	ChildServer  string       `json:"child_server,omitempty" groups:"normal,long,trace"`
+	ParentTTL    uint32       `json:"parent_ttl" groups:"short,normal,long,trace"`
+	ChildTTL     uint32       `json:"child_ttl" groups:"short,normal,long,trace"`
+	NameServers  []NameServer `json:"name_servers" groups:"short,normal,long,trace"`
+	// MissingInChild lists the name servers the zone is delegated to that aren't in its own NS RRset, MissingInParent
+	// the other way around
+	MissingInChild  []string `json:"missing_in_child,omitempty" groups:"short,normal,long,trace"`
+	MissingInParent []string `json:"missing_in_parent,omitempty" groups:"short,normal,long,trace"`
+	// GlueMismatches lists the name servers whose glue doesn't match their addresses
+	GlueMismatches []string `json:"glue_mismatches,omitempty" groups:"short,normal,long,trace"`
+	// TTLMismatch is set if the TTL of the NS RRset or of any glue differs between parent and child. It doesn't
+	// affect Consistent, since parents often set their own TTLs for delegations.
+	TTLMismatch bool `json:"ttl_mismatch" groups:"short,normal,long,trace"`
+	Consistent  bool `json:"consistent" groups:"short,normal,long,trace"`
+}
+
+func init() {
+	mod := new(DelegationCheckModule)
+	cli.RegisterLookupModule("DELEGATIONCHECK", mod)
+}
+
+type DelegationCheckModule struct {
+	cli.BasicLookupModule
+}
+
+// CLIInit initializes the DelegationCheck module
+func (mod *DelegationCheckModule) CLIInit(gc *cli.CLIConf, rc *zdns.ResolverConfig) error {
+	if gc.LookupAllNameServers {
+		return errors.New("DELEGATIONCHECK module does not support --all-nameservers")
+	}
+	mod.DNSType = dns.TypeNS
+	mod.DNSClass = dns.ClassINET
+	return mod.BasicLookupModule.CLIInit(gc, rc)
+}
+
+// Lookup iteratively looks up the NS RRset of the zone lookupName, which is always done from the root since only
+// iteration sees the referral from the parent. The NS records and glue of the last referral to the zone are compared
+// with the authoritative NS RRset of the zone and the A and AAAA records of each name server.
+func (mod *DelegationCheckModule) Lookup(r *zdns.Resolver, lookupName string, nameServer *zdns.NameServer) (interface{}, zdns.Trace, zdns.Status, error) {
+	zone := strings.ToLower(strings.TrimSuffix(lookupName, "."))
+	q := &zdns.Question{Name: zone, Type: mod.DNSType, Class: mod.DNSClass}
+	childRes, trace, status, err := r.IterativeLookup(context.Background(), q)
+	if status != zdns.StatusNoError {
+		return nil, trace, status, err
+	}
+	childNS, childTTL := nsRecords(childRes.Answers, zone)
+	if len(childNS) == 0 {
+		return nil, trace, zdns.StatusNoRecord, errors.New("no NS records found for zone " + zone)
+	}
+	referral := findReferral(trace, zone)
+	if referral == nil {
+		// the parent's servers also serve the zone, and answered for it instead of referring to it
+		return nil, trace, zdns.StatusNoAuth, errors.New("no referral to zone " + zone + " found")
+	}
+	parentNS, parentTTL := nsRecords(referral.Result.Authorities, zone)
+
+	res := &Result{
+		Parent:       referral.Layer,
+		ParentServer: referral.NameServer,
+		ChildServer:  childRes.Resolver,
+		ParentTTL:    parentTTL,
+		ChildTTL:     childTTL,
+		TTLMismatch:  parentTTL != childTTL,
+	}
+	servers := make(map[string]*NameServer)
+	server := func(name string) *NameServer {
+		if _, ok := servers[name]; !ok {
+			servers[name] = &NameServer{Name: name, InBailiwick: isBeneath(name, zone)}
+		}
+		return servers[name]
+	}
+	for _, ns := range parentNS {
+		server(ns).InParent = true
+	}
+	for _, ns := range childNS {
+		server(ns).InChild = true
+	}
+	for _, ns := range servers {
+		var addrTrace zdns.Trace
+		ns.IPv4, addrTrace = mod.compareGlue(r, ns, dns.TypeA, referral.Result.Additionals)
+		trace = append(trace, addrTrace...)
+		ns.IPv6, addrTrace = mod.compareGlue(r, ns, dns.TypeAAAA, referral.Result.Additionals)
+		trace = append(trace, addrTrace...)
+		if !ns.InChild {
+			res.MissingInChild = append(res.MissingInChild, ns.Name)
+		}
+		if !ns.InParent {
+			res.MissingInParent = append(res.MissingInParent, ns.Name)
+		}
+		glueMismatch := false
+		for _, c := range []*GlueComparison{ns.IPv4, ns.IPv6} {
+			if c == nil {
+				continue
+			}
+			glueMismatch = glueMismatch || !c.Match
+			if len(c.Glue) > 0 && len(c.Addresses) > 0 && c.GlueTTL != c.TTL {
+				res.TTLMismatch = true
+			}
+		}
+		if glueMismatch {
+			res.GlueMismatches = append(res.GlueMismatches, ns.Name)
+		}
+		res.NameServers = append(res.NameServers, *ns)
+	}
+	sort.Slice(res.NameServers, func(i, j int) bool { return res.NameServers[i].Name < res.NameServers[j].Name })
+	sort.Strings(res.MissingInChild)
+	sort.Strings(res.MissingInParent)
+	sort.Strings(res.GlueMismatches)
+	res.Consistent = len(res.MissingInChild) == 0 && len(res.MissingInParent) == 0 && len(res.GlueMismatches) == 0
+	return res, trace, zdns.StatusNoError, nil
+}
+
+// findReferral returns the last referral to zone in the trace of its iterative lookup, the one whose name servers
+// were followed to the answer
+func findReferral(trace zdns.Trace, zone string) *zdns.TraceStep {
+	var referral *zdns.TraceStep
+	for i := range trace {
+		step := &trace[i]
+		if step.DNSType != dns.TypeNS || !strings.EqualFold(strings.TrimSuffix(step.Name, "."), zone) || step.Result.Flags.Authoritative {
+			continue
+		}
+		if ns, _ := nsRecords(step.Result.Authorities, zone); len(ns) > 0 {
+			referral = step
+		}
+	}
+	return referral
+}
+
+// compareGlue compares the glue of type qType for ns in additionals with the records the name server has in its own
+// zone. Glue is only expected for name servers in bailiwick, so other name servers without glue aren't compared.
+func (mod *DelegationCheckModule) compareGlue(r *zdns.Resolver, ns *NameServer, qType uint16, additionals []interface{}) (*GlueComparison, zdns.Trace) {
+	c := &GlueComparison{}
+	for _, a := range additionals {
+		if ans, ok := a.(zdns.Answer); ok && ans.RrType == qType && strings.EqualFold(strings.TrimSuffix(ans.Name, "."), ns.Name) {
+			c.Glue = append(c.Glue, net.ParseIP(ans.Answer).String())
+			c.GlueTTL = ans.TTL
+		}
+	}
+	if len(c.Glue) == 0 && !ns.InBailiwick {
+		return nil, nil
+	}
+	res, trace, status, _ := r.IterativeLookup(context.Background(), &zdns.Question{Name: ns.Name, Type: qType, Class: mod.DNSClass})
+	if status == zdns.StatusNoError && res != nil {
+		for _, a := range res.Answers {
+			if ans, ok := a.(zdns.Answer); ok && ans.RrType == qType && strings.EqualFold(strings.TrimSuffix(ans.Name, "."), ns.Name) {
+				c.Addresses = append(c.Addresses, net.ParseIP(ans.Answer).String())
+				c.TTL = ans.TTL
+			}
+		}
+	}
+	if len(c.Glue) == 0 && len(c.Addresses) == 0 {
+		return nil, trace
+	}
+	sort.Strings(c.Glue)
+	sort.Strings(c.Addresses)
+	c.Missing = difference(c.Addresses, c.Glue)
+	c.Extra = difference(c.Glue, c.Addresses)
+	c.Match = len(c.Missing) == 0 && len(c.Extra) == 0
+	return c, trace
+}
+
+// nsRecords returns the lowercased targets of the NS records of zone in records, and the TTL of the RRset
+func nsRecords(records []interface{}, zone string) ([]string, uint32) {
+	var targets []string
+	var ttl uint32
+	for _, a := range records {
+		if ans, ok := a.(zdns.Answer); ok && ans.RrType == dns.TypeNS && strings.EqualFold(strings.TrimSuffix(ans.Name, "."), zone) {
+			targets = append(targets, strings.ToLower(strings.TrimSuffix(ans.Answer, ".")))
+			ttl = ans.TTL
+		}
+	}
+	return targets, ttl
+}
+
+// difference returns the elements of a that aren't in b
+func difference(a, b []string) []string {
+	inB := make(map[string]bool, len(b))
+	for _, s := range b {
+		inB[s] = true
+	}
+	var diff []string
+	for _, s := range a {
+		if !inB[s] {
+			diff = append(diff, s)
+		}
+	}
+	return diff
+}
+
+// isBeneath returns whether name is zone or a name beneath it
+func isBeneath(name, zone string) bool {
+	return dns.IsSubDomain(dns.Fqdn(zone), dns.Fqdn(name))
+}
+
+func (mod *DelegationCheckModule) Help() string {
+	return ""
+}
+
+func (mod *DelegationCheckModule) Validate(args []string) error {
+	return nil
+}
+
+func (mod *DelegationCheckModule) GetDescription() string {
+	return "DELEGATIONCHECK compares the NS records and glue of the parent's referral to a zone with the zone's own NS RRset and the addresses of its name servers."
+}
+
+func (mod *DelegationCheckModule) NewFlags() interface{} {
+	return mod
+}
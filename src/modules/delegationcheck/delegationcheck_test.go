@@ -0,0 +1,130 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package delegationcheck
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/zmap/zdns/src/cli"
+	"github.com/zmap/zdns/src/zdns"
+	"github.com/zmap/zdns/src/zdns/testserver"
+	"github.com/zmap/zdns/src/zdns/testserver/testresolver"
+)
+
+type testServers struct {
+	child   *testserver.Server
+	refuser *testserver.Server
+}
+
+// initTest serves the root, test. and its children from separate servers, so that iteration gets referrals:
+//   - good.test. is delegated consistently
+//   - ttl.test. is delegated consistently, but with a longer TTL in the parent
+//   - example.test. has stale glue for ns2, is delegated to ns.other.test. which it doesn't list itself, and lists ns3
+//     which it isn't delegated to
+func initTest(t *testing.T) (*zdns.Resolver, testServers) {
+	network := testserver.NewNetwork()
+	rootServer := network.AddServer()
+	tldServer := network.AddServer()
+	servers := testServers{child: network.AddServer(), refuser: network.AddServer()}
+	childIP := servers.child.IP.String()
+
+	root := testserver.MustParseZone(".", `
+. SOA a.root-servers.test. hostmaster.test. 1 7200 900 1209600 86400
+. NS a.root-servers.test.
+a.root-servers.test. A `+rootServer.IP.String())
+	tld := testserver.MustParseZone("test.", `@ SOA ns1.nic.test. hostmaster.test. 1 7200 900 1209600 300`)
+	good := testserver.MustParseZone("good.test.", `@ SOA ns hostmaster 1 7200 900 1209600 300`)
+	ttl := testserver.MustParseZone("ttl.test.", `
+@ SOA ns hostmaster 1 7200 900 1209600 300
+@ NS ns
+ns A `+childIP)
+	sld := testserver.MustParseZone("example.test.", `
+@ SOA ns1 hostmaster 1 7200 900 1209600 300
+@ NS ns2
+@ NS ns3
+ns2 A `+childIP+`
+ns3 A `+childIP)
+	root.Delegate(tld, "ns1.nic.test.", tldServer.IP)
+	tld.Delegate(good, "ns.good.test.", servers.child.IP)
+	tld.Add(testserver.MustParseRR("ttl.test. 86400 IN NS ns.ttl.test."), testserver.MustParseRR("ns.ttl.test. 86400 IN A "+childIP))
+	tld.Delegate(sld, "ns1.example.test.", servers.child.IP)
+	tld.Add(
+		testserver.MustParseRR("example.test. 3600 IN NS ns2.example.test."),
+		testserver.MustParseRR("ns2.example.test. 3600 IN A "+servers.refuser.IP.String()),
+		testserver.MustParseRR("example.test. 3600 IN NS ns.other.test."),
+	)
+	rootServer.Zones = []*testserver.Zone{root}
+	tldServer.Zones = []*testserver.Zone{tld}
+	servers.child.Zones = []*testserver.Zone{good, ttl, sld}
+	require.NoError(t, network.Start())
+	t.Cleanup(network.Close)
+
+	r := testresolver.New(t, testresolver.Config(network, rootServer))
+	return r, servers
+}
+
+func TestDelegationCheck(t *testing.T) {
+	r, servers := initTest(t)
+	mod := &DelegationCheckModule{}
+	require.NoError(t, mod.CLIInit(&cli.CLIConf{}, &zdns.ResolverConfig{}))
+	childIP := servers.child.IP.String()
+
+	res, _, status, err := mod.Lookup(r, "good.test", nil)
+	require.NoError(t, err)
+	require.Equal(t, zdns.StatusNoError, status)
+	result := res.(*Result)
+	require.Equal(t, "test", result.Parent)
+	require.True(t, result.Consistent)
+	require.False(t, result.TTLMismatch)
+	require.Equal(t, []NameServer{{
+		Name: "ns.good.test", InParent: true, InChild: true, InBailiwick: true,
+		IPv4: &GlueComparison{Glue: []string{childIP}, GlueTTL: 3600, Addresses: []string{childIP}, TTL: 3600, Match: true},
+	}}, result.NameServers)
+
+	res, _, status, _ = mod.Lookup(r, "ttl.test", nil)
+	require.Equal(t, zdns.StatusNoError, status)
+	result = res.(*Result)
+	require.True(t, result.Consistent)
+	require.True(t, result.TTLMismatch)
+	require.Equal(t, uint32(86400), result.ParentTTL)
+	require.Equal(t, uint32(3600), result.ChildTTL)
+
+	res, _, status, _ = mod.Lookup(r, "example.test", nil)
+	require.Equal(t, zdns.StatusNoError, status)
+	result = res.(*Result)
+	require.False(t, result.Consistent)
+	require.Equal(t, []string{"ns.other.test"}, result.MissingInChild)
+	require.Equal(t, []string{"ns3.example.test"}, result.MissingInParent)
+	require.Equal(t, []string{"ns2.example.test", "ns3.example.test"}, result.GlueMismatches)
+	nameServers := make(map[string]NameServer)
+	for _, ns := range result.NameServers {
+		nameServers[ns.Name] = ns
+	}
+	require.Len(t, nameServers, 4)
+	require.True(t, nameServers["ns1.example.test"].IPv4.Match)
+	// glue isn't expected for name servers outside the zone
+	require.False(t, nameServers["ns.other.test"].InBailiwick)
+	require.Nil(t, nameServers["ns.other.test"].IPv4)
+	stale := nameServers["ns2.example.test"].IPv4
+	require.Equal(t, []string{childIP}, stale.Missing)
+	require.Equal(t, []string{servers.refuser.IP.String()}, stale.Extra)
+	require.Equal(t, []string{childIP}, nameServers["ns3.example.test"].IPv4.Missing)
+
+	// a name with addresses but no NS records isn't a zone
+	_, _, status, _ = mod.Lookup(r, "ns.good.test", nil)
+	require.Equal(t, zdns.StatusNoRecord, status)
+}
@@ -0,0 +1,191 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package dkim
+
+import (
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+
+	"github.com/zmap/zdns/src/cli"
+	"github.com/zmap/zdns/src/zdns"
+)
+
+const domainKeyLabel = "_domainkey"
+
+// defaultSelectors are selectors commonly used by mail providers and signing software
+const defaultSelectors = "default,dkim,mail,email,selector1,selector2,google,k1,k2,k3,s1,s2,s1024,s2048,key1,key2," +
+	"dkim1,dkim2,smtp,mx,fm1,fm2,fm3,mandrill,mxvault,everlytickey1,everlytickey2,protonmail,protonmail2,protonmail3," +
+	"zoho,amazonses,sendgrid,smtpapi,pm,cm,hs1,hs2"
+
+// Selector is a selector that has a key record, or whose lookup failed
+type Selector struct {
+	Selector string `json:"selector" groups:"short,normal,long,trace"`
+	Name     string `json:"name" groups:"normal,long,trace"`
+	Status   string `json:"status" groups:"short,normal,long,trace"`
+	Record   string `json:"record,omitempty" groups:"short,normal,long,trace"`
+	Key      *Key   `json:"key,omitempty" groups:"short,normal,long,trace"`
+	Error    string `json:"error,omitempty" groups:"short,normal,long,trace"`
+}
+
+// Result lists the selectors of a domain found to have a key record. Selectors without one are only counted in Probed.
+type Result struct {
+	Selectors []Selector `json:"selectors" groups:"short,normal,long,trace"`
+	Probed    int        `json:"probed" groups:"normal,long,trace"`
+	// WeakKeys counts the valid keys marked weak, excluding revoked keys
+	WeakKeys int `json:"weak_keys" groups:"short,normal,long,trace"`
+}
+
+func init() {
+	mod := new(DKIMLookupModule)
+	cli.RegisterLookupModule("DKIM", mod)
+}
+
+type DKIMLookupModule struct {
+	cli.BasicLookupModule
+	SelectorsString string `long:"selectors" default:"" description:"comma-delimited list of selectors to probe, or @/path/to/file with one selector per line. Defaults to a list of selectors used by common mail providers"`
+
+	selectors []string
+}
+
+// CLIInit initializes the DKIM lookup module, reading the selectors
+func (mod *DKIMLookupModule) CLIInit(gc *cli.CLIConf, rc *zdns.ResolverConfig) error {
+	if gc.LookupAllNameServers {
+		return errors.New("DKIM module does not support --all-nameservers")
+	}
+	selectors := defaultSelectors
+	if strings.HasPrefix(mod.SelectorsString, "@") {
+		f, err := os.ReadFile(mod.SelectorsString[1:])
+		if err != nil {
+			return errors.Wrap(err, "unable to read selectors file")
+		}
+		selectors = strings.ReplaceAll(string(f), "\n", ",")
+	} else if len(mod.SelectorsString) > 0 {
+		selectors = mod.SelectorsString
+	}
+	mod.Init(strings.Split(selectors, ","))
+	if len(mod.selectors) == 0 {
+		return errors.New("--selectors must list at least one selector")
+	}
+	return mod.BasicLookupModule.CLIInit(gc, rc)
+}
+
+// Init initializes the DKIM lookup module with the given selectors, used to call DKIM programmatically. Blank
+// selectors, comments starting with # and duplicates are skipped.
+func (mod *DKIMLookupModule) Init(selectors []string) {
+	mod.DNSType = dns.TypeTXT
+	mod.DNSClass = dns.ClassINET
+	mod.selectors = nil
+	seen := make(map[string]struct{}, len(selectors))
+	for _, selector := range selectors {
+		selector = strings.Trim(strings.ToLower(strings.TrimSpace(selector)), ".")
+		if _, ok := seen[selector]; ok || len(selector) == 0 || strings.HasPrefix(selector, "#") {
+			continue
+		}
+		seen[selector] = struct{}{}
+		mod.selectors = append(mod.selectors, selector)
+	}
+}
+
+// Lookup probes <selector>._domainkey.<lookupName> for every selector, parsing the key records found
+func (mod *DKIMLookupModule) Lookup(r *zdns.Resolver, lookupName string, nameServer *zdns.NameServer) (interface{}, zdns.Trace, zdns.Status, error) {
+	domain := strings.ToLower(strings.TrimSuffix(lookupName, "."))
+	res := &Result{Selectors: []Selector{}}
+	var trace zdns.Trace
+	var failed zdns.Status
+	var failedErr error
+	for _, selector := range mod.selectors {
+		name := selector + "." + domainKeyLabel + "." + domain
+		res.Probed++
+		innerRes, innerTrace, status, err := mod.LookupType(r, name, mod.DNSType, nameServer)
+		trace = append(trace, innerTrace...)
+		if zdns.IsMissingStatus(status) {
+			continue
+		}
+		sel := Selector{Selector: selector, Name: name, Status: string(status)}
+		if status != zdns.StatusNoError {
+			if err != nil {
+				sel.Error = err.Error()
+			}
+			if len(failed) == 0 {
+				failed, failedErr = status, err
+			}
+			res.Selectors = append(res.Selectors, sel)
+			continue
+		}
+		records := txtRecords(innerRes)
+		if len(records) == 0 {
+			continue
+		}
+		// RFC 6376, Section 3.6.2.2: with several records, verifiers may use any of them, so the first valid one is kept
+		for _, record := range records {
+			key, err := parseKey(record)
+			if err != nil {
+				if len(sel.Record) == 0 {
+					sel.Record, sel.Error = record, err.Error()
+				}
+				continue
+			}
+			sel.Record, sel.Key, sel.Error = record, key, ""
+			break
+		}
+		if sel.Key != nil && sel.Key.Weak && !sel.Key.Revoked {
+			res.WeakKeys++
+		}
+		res.Selectors = append(res.Selectors, sel)
+	}
+	for _, sel := range res.Selectors {
+		if sel.Status == string(zdns.StatusNoError) {
+			return res, trace, zdns.StatusNoError, nil
+		}
+	}
+	if len(failed) > 0 {
+		return res, trace, failed, failedErr
+	}
+	return res, trace, zdns.StatusNoRecord, nil
+}
+
+// txtRecords returns the TXT records of a result with the strings of each record joined together. Key records are
+// often published as a CNAME to the mail provider, whose answers are followed to the TXT record.
+func txtRecords(res *zdns.SingleQueryResult) []string {
+	if res == nil {
+		return nil
+	}
+	var records []string
+	for _, a := range res.Answers {
+		if ans, ok := a.(zdns.Answer); ok && ans.RrType == dns.TypeTXT {
+			records = append(records, strings.ReplaceAll(ans.Answer, "\n", ""))
+		}
+	}
+	return records
+}
+
+func (mod *DKIMLookupModule) Help() string {
+	return ""
+}
+
+func (mod *DKIMLookupModule) Validate(args []string) error {
+	return nil
+}
+
+func (mod *DKIMLookupModule) GetDescription() string {
+	return "DKIM probes a list of selectors for DKIM key records, parsing each key and marking weak ones, such as RSA keys shorter than 1024 bits."
+}
+
+func (mod *DKIMLookupModule) NewFlags() interface{} {
+	return mod
+}
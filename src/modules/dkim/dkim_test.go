@@ -0,0 +1,147 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package dkim
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/zmap/zdns/src/cli"
+	"github.com/zmap/zdns/src/zdns"
+	"github.com/zmap/zdns/src/zdns/testserver"
+	"github.com/zmap/zdns/src/zdns/testserver/testresolver"
+)
+
+// rsaKey returns a base64 SubjectPublicKeyInfo for an RSA key of the given size. Only the size matters for parsing,
+// so the modulus is just the smallest number of that length.
+func rsaKey(t *testing.T, bits int) string {
+	n := new(big.Int).Lsh(big.NewInt(1), uint(bits-1))
+	der, err := x509.MarshalPKIXPublicKey(&rsa.PublicKey{N: n.Add(n, big.NewInt(1)), E: 65537})
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(der)
+}
+
+// txt quotes a record as TXT character strings of at most 255 bytes each
+func txt(record string) string {
+	var parts []string
+	for len(record) > 255 {
+		parts = append(parts, fmt.Sprintf("%q", record[:255]))
+		record = record[255:]
+	}
+	return strings.Join(append(parts, fmt.Sprintf("%q", record)), " ")
+}
+
+func newTestResolver(t *testing.T, zone string) *zdns.Resolver {
+	network := testserver.NewNetwork()
+	server := network.AddServer(testserver.MustParseZone("example.test.", zone))
+	require.NoError(t, network.Start())
+	t.Cleanup(network.Close)
+
+	return testresolver.New(t, testresolver.Config(network, server))
+}
+
+func TestDKIMLookup(t *testing.T) {
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	r := newTestResolver(t, `
+@ SOA ns1 hostmaster 1 7200 900 1209600 300
+selector1._domainkey TXT `+txt("v=DKIM1; k=rsa; p="+rsaKey(t, 2048))+`
+s512._domainkey TXT "v=DKIM1; t=y:s; p=`+rsaKey(t, 512)+`"
+ed._domainkey TXT "v=DKIM1; k=ed25519; h=sha256; p=`+base64.StdEncoding.EncodeToString(edKey)+`"
+old._domainkey TXT "v=DKIM1; h=sha1; p=`+rsaKey(t, 1024)+`"
+revoked._domainkey TXT "v=DKIM1; p="
+google._domainkey CNAME selector1._domainkey
+broken._domainkey TXT "p=MII; v=DKIM1"
+`)
+	mod := &DKIMLookupModule{SelectorsString: "selector1,S512 ,ed,old,revoked,google,broken,missing,selector1"}
+	require.NoError(t, mod.CLIInit(&cli.CLIConf{}, &zdns.ResolverConfig{}))
+
+	res, _, status, err := mod.Lookup(r, "example.test", nil)
+	require.NoError(t, err)
+	require.Equal(t, zdns.StatusNoError, status)
+	result := res.(*Result)
+	require.Equal(t, 8, result.Probed)
+	require.Equal(t, 2, result.WeakKeys)
+
+	selectors := make(map[string]Selector)
+	for _, sel := range result.Selectors {
+		selectors[sel.Selector] = sel
+	}
+	require.Len(t, selectors, 7)
+
+	key := selectors["selector1"].Key
+	require.Equal(t, "DKIM1", key.Version)
+	require.Equal(t, "rsa", key.KeyType)
+	require.Equal(t, 2048, key.KeySize)
+	require.False(t, key.Weak)
+	require.Equal(t, key, selectors["google"].Key)
+
+	key = selectors["s512"].Key
+	require.Equal(t, 512, key.KeySize)
+	require.True(t, key.Weak)
+	require.True(t, key.Testing)
+	require.Equal(t, []string{"y", "s"}, key.Flags)
+	require.Equal(t, []string{"RSA key shorter than 1024 bits"}, key.WeakReasons)
+
+	key = selectors["ed"].Key
+	require.Equal(t, "ed25519", key.KeyType)
+	require.Equal(t, 256, key.KeySize)
+	require.False(t, key.Weak)
+
+	require.Equal(t, []string{"only allows sha1 signatures"}, selectors["old"].Key.WeakReasons)
+
+	require.True(t, selectors["revoked"].Key.Revoked)
+	require.Zero(t, selectors["revoked"].Key.KeySize)
+
+	require.Nil(t, selectors["broken"].Key)
+	require.Equal(t, "invalid v tag: DKIM1", selectors["broken"].Error)
+}
+
+func TestDKIMLookupNoRecord(t *testing.T) {
+	r := newTestResolver(t, `@ SOA ns1 hostmaster 1 7200 900 1209600 300`)
+	mod := &DKIMLookupModule{}
+	require.NoError(t, mod.CLIInit(&cli.CLIConf{}, &zdns.ResolverConfig{}))
+
+	res, _, status, _ := mod.Lookup(r, "example.test", nil)
+	require.Equal(t, zdns.StatusNoRecord, status)
+	require.Empty(t, res.(*Result).Selectors)
+	require.Equal(t, len(strings.Split(defaultSelectors, ",")), res.(*Result).Probed)
+}
+
+func TestParseKey(t *testing.T) {
+	key, err := parseKey("k=rsa; p=" + rsaKey(t, 1024)[:40] + " \t" + rsaKey(t, 1024)[40:])
+	require.NoError(t, err)
+	require.Empty(t, key.Version)
+	require.Equal(t, 1024, key.KeySize)
+	require.Equal(t, []string{"*"}, key.ServiceTypes)
+
+	_, err = parseKey("v=DKIM1; k=rsa")
+	require.EqualError(t, err, "missing p tag")
+	_, err = parseKey("v=DKIM1; p=; p=")
+	require.EqualError(t, err, "duplicate tag: p")
+	_, err = parseKey("v=DKIM1; k=dsa; p=AAAA")
+	require.EqualError(t, err, "unknown key type: dsa")
+	_, err = parseKey("v=DKIM1; p=not base64!")
+	require.ErrorContains(t, err, "invalid p tag")
+}
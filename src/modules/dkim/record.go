@@ -0,0 +1,174 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+/*
+ * Parsing of DKIM key records.
+ * RFC reference:
+ * - https://datatracker.ietf.org/doc/html/rfc6376#section-3.6.1 (key records)
+ * - https://datatracker.ietf.org/doc/html/rfc8301 (minimum key size and hash algorithms)
+ * - https://datatracker.ietf.org/doc/html/rfc8463 (Ed25519 keys)
+ */
+
+package dkim
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+const (
+	dkimVersion = "DKIM1"
+	// minRSAKeySize is the smallest RSA key verifiers must accept (RFC 8301, Section 3.2)
+	minRSAKeySize = 1024
+)
+
+// Key holds the tags of a DKIM key record, with the defaults of RFC 6376 filled in for those that are missing
+type Key struct {
+	Version        string   `json:"v,omitempty" groups:"short,normal,long,trace"`
+	KeyType        string   `json:"k" groups:"short,normal,long,trace"`
+	KeySize        int      `json:"key_size,omitempty" groups:"short,normal,long,trace"`
+	HashAlgorithms []string `json:"h,omitempty" groups:"short,normal,long,trace"`
+	ServiceTypes   []string `json:"s" groups:"normal,long,trace"`
+	Flags          []string `json:"t,omitempty" groups:"short,normal,long,trace"`
+	Notes          string   `json:"n,omitempty" groups:"normal,long,trace"`
+	PublicKey      string   `json:"p,omitempty" groups:"long,trace"`
+	// Testing is set by the y flag, asking verifiers not to treat failures differently from unsigned mail
+	Testing bool `json:"testing" groups:"short,normal,long,trace"`
+	// Revoked is set for a record with an empty public key
+	Revoked bool `json:"revoked" groups:"short,normal,long,trace"`
+	// Weak is set for keys verifiers must reject or should not rely on, with the reasons in WeakReasons
+	Weak        bool     `json:"weak" groups:"short,normal,long,trace"`
+	WeakReasons []string `json:"weak_reasons,omitempty" groups:"short,normal,long,trace"`
+}
+
+// parseKey parses a DKIM key record. Errors are returned for records verifiers must ignore.
+func parseKey(record string) (*Key, error) {
+	key := &Key{KeyType: "rsa", ServiceTypes: []string{"*"}}
+	seen := make(map[string]bool)
+	hasKey := false
+	for i, part := range strings.Split(record, ";") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+		tag, value, found := strings.Cut(part, "=")
+		tag = strings.TrimSpace(tag)
+		value = strings.TrimSpace(value)
+		if !found || len(tag) == 0 {
+			return nil, fmt.Errorf("invalid tag: %s", part)
+		}
+		if seen[tag] {
+			return nil, fmt.Errorf("duplicate tag: %s", tag)
+		}
+		seen[tag] = true
+		switch tag {
+		case "v":
+			// the version is optional, but must be the first tag if present
+			if i != 0 || value != dkimVersion {
+				return nil, fmt.Errorf("invalid v tag: %s", value)
+			}
+			key.Version = value
+		case "k":
+			key.KeyType = strings.ToLower(value)
+		case "h":
+			key.HashAlgorithms = splitList(strings.ToLower(value))
+		case "s":
+			key.ServiceTypes = splitList(strings.ToLower(value))
+		case "t":
+			key.Flags = splitList(strings.ToLower(value))
+		case "n":
+			key.Notes = value
+		case "p":
+			hasKey = true
+			// base64 values may be folded with whitespace
+			key.PublicKey = strings.Join(strings.Fields(value), "")
+		}
+	}
+	if !hasKey {
+		return nil, fmt.Errorf("missing p tag")
+	}
+	for _, flag := range key.Flags {
+		if flag == "y" {
+			key.Testing = true
+		}
+	}
+	if len(key.PublicKey) == 0 {
+		key.Revoked = true
+		return key, nil
+	}
+	der, err := base64.StdEncoding.DecodeString(key.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p tag: %v", err)
+	}
+	if key.KeySize, err = keySize(key.KeyType, der); err != nil {
+		return nil, err
+	}
+	if key.KeyType == "rsa" && key.KeySize < minRSAKeySize {
+		key.WeakReasons = append(key.WeakReasons, fmt.Sprintf("RSA key shorter than %d bits", minRSAKeySize))
+	}
+	if len(key.HashAlgorithms) > 0 {
+		sha1Only := true
+		for _, h := range key.HashAlgorithms {
+			if h != "sha1" {
+				sha1Only = false
+			}
+		}
+		if sha1Only {
+			key.WeakReasons = append(key.WeakReasons, "only allows sha1 signatures")
+		}
+	}
+	key.Weak = len(key.WeakReasons) > 0
+	return key, nil
+}
+
+// keySize returns the size in bits of a public key. RSA keys are normally a SubjectPublicKeyInfo, but some signers
+// publish a bare RSAPublicKey, which verifiers accept as well.
+func keySize(keyType string, der []byte) (int, error) {
+	switch keyType {
+	case "rsa":
+		if pub, err := x509.ParsePKIXPublicKey(der); err == nil {
+			rsaPub, ok := pub.(*rsa.PublicKey)
+			if !ok {
+				return 0, fmt.Errorf("p tag is not an RSA key")
+			}
+			return rsaPub.N.BitLen(), nil
+		}
+		rsaPub, err := x509.ParsePKCS1PublicKey(der)
+		if err != nil {
+			return 0, fmt.Errorf("invalid RSA key: %v", err)
+		}
+		return rsaPub.N.BitLen(), nil
+	case "ed25519":
+		if len(der) != ed25519.PublicKeySize {
+			return 0, fmt.Errorf("invalid Ed25519 key length: %d", len(der))
+		}
+		return ed25519.PublicKeySize * 8, nil
+	default:
+		return 0, fmt.Errorf("unknown key type: %s", keyType)
+	}
+}
+
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ":") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			values = append(values, v)
+		}
+	}
+	return values
+}
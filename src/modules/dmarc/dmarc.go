@@ -16,18 +16,32 @@ package dmarc
 import (
 	"errors"
 	"regexp"
+	"strings"
 
 	"github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
 
 	"github.com/zmap/zdns/src/cli"
 	"github.com/zmap/zdns/src/zdns"
 )
 
-const dmarcPrefixRegexp = "^[vV][\x09\x20]*=[\x09\x20]*DMARC1[\x09\x20]*;[\x09\x20]*"
+const (
+	dmarcPrefixRegexp = "^[vV][\x09\x20]*=[\x09\x20]*DMARC1[\x09\x20]*;[\x09\x20]*"
+	dmarcLabel        = "_dmarc"
+)
 
 // result to be returned by scan of host
 type Result struct {
 	Dmarc string `json:"dmarc,omitempty" groups:"short,normal,long,trace"`
+	// Domain is where the record was found: the input domain, or its organizational domain if the input has none
+	Domain               string `json:"domain,omitempty" groups:"short,normal,long,trace"`
+	OrganizationalDomain string `json:"organizational_domain,omitempty" groups:"normal,long,trace"`
+	Inherited            bool   `json:"inherited,omitempty" groups:"short,normal,long,trace"`
+	// AppliedPolicy is the policy for mail from the input domain, the sp tag if the record was inherited
+	AppliedPolicy string   `json:"applied_policy,omitempty" groups:"short,normal,long,trace"`
+	Record        *Record  `json:"record,omitempty" groups:"short,normal,long,trace"`
+	Errors        []string `json:"errors,omitempty" groups:"short,normal,long,trace"`
+	Warnings      []string `json:"warnings,omitempty" groups:"normal,long,trace"`
 }
 
 func init() {
@@ -51,15 +65,114 @@ func (dmarcMod *DmarcLookupModule) CLIInit(gc *cli.CLIConf, rc *zdns.ResolverCon
 	return dmarcMod.BasicLookupModule.CLIInit(gc, rc)
 }
 
+// Lookup returns the DMARC record of lookupName, which may be given with or without the _dmarc label, and parses it.
+// If lookupName has no record, the record of its organizational domain applies instead (RFC 7489, Section 6.6.3).
+// Report destinations outside the organizational domain are checked for an authorization record.
 func (dmarcMod *DmarcLookupModule) Lookup(r *zdns.Resolver, lookupName string, nameServer *zdns.NameServer) (interface{}, zdns.Trace, zdns.Status, error) {
-	innerRes, trace, status, err := dmarcMod.BasicLookupModule.Lookup(r, lookupName, nameServer)
+	domain := strings.ToLower(strings.TrimSuffix(lookupName, "."))
+	queryName := lookupName
+	if trimmed, ok := strings.CutPrefix(domain, dmarcLabel+"."); ok {
+		domain = trimmed
+	} else {
+		queryName = dmarcLabel + "." + lookupName
+	}
+	resString, records, trace, status, err := dmarcMod.lookupRecords(r, queryName, nameServer)
+	res := Result{Dmarc: resString}
+	orgDomain := organizationalDomain(domain)
+	recordDomain := domain
+	if zdns.IsMissingStatus(status) && orgDomain != domain {
+		orgString, orgRecords, orgTrace, orgStatus, orgErr := dmarcMod.lookupRecords(r, dmarcLabel+"."+orgDomain, nameServer)
+		trace = append(trace, orgTrace...)
+		if orgStatus == zdns.StatusNoError || !zdns.IsMissingStatus(orgStatus) {
+			resString, records, status, err = orgString, orgRecords, orgStatus, orgErr
+			res = Result{Dmarc: resString, Inherited: orgStatus == zdns.StatusNoError}
+			recordDomain = orgDomain
+		}
+	}
+	if status != zdns.StatusNoError {
+		return res, trace, status, err
+	}
+
+	res.Domain = recordDomain
+	res.OrganizationalDomain = orgDomain
+	if len(records) > 1 {
+		// RFC 7489, Section 6.6.3: with more than one record, none of them apply
+		res.Errors = append(res.Errors, "more than one DMARC record")
+		return res, trace, status, nil
+	}
+	rec, warnings, parseErr := parseRecord(records[0])
+	res.Warnings = warnings
+	if parseErr != nil {
+		res.Errors = append(res.Errors, parseErr.Error())
+		return res, trace, status, nil
+	}
+	res.Record = rec
+	res.AppliedPolicy = rec.Policy
+	if res.Inherited {
+		res.AppliedPolicy = rec.SubdomainPolicy
+	}
+	authorized := make(map[string]*bool)
+	for _, uris := range [][]ReportURI{rec.AggregateReportURIs, rec.FailureReportURIs} {
+		for i := range uris {
+			uri := &uris[i]
+			if len(uri.Domain) == 0 || organizationalDomain(uri.Domain) == orgDomain {
+				continue
+			}
+			uri.External = true
+			if _, ok := authorized[uri.Domain]; !ok {
+				var authTrace zdns.Trace
+				authorized[uri.Domain], authTrace = dmarcMod.isAuthorized(r, recordDomain, uri.Domain, nameServer)
+				trace = append(trace, authTrace...)
+				if authorized[uri.Domain] == nil {
+					res.Warnings = append(res.Warnings, "could not verify the authorization of report destination "+uri.Domain)
+				}
+			}
+			uri.Authorized = authorized[uri.Domain]
+		}
+	}
+	return res, trace, status, nil
+}
+
+// lookupRecords returns the first DMARC record at name, as output by earlier versions of the module, along with
+// every DMARC record found there
+func (dmarcMod *DmarcLookupModule) lookupRecords(r *zdns.Resolver, name string, nameServer *zdns.NameServer) (string, []string, zdns.Trace, zdns.Status, error) {
+	innerRes, trace, status, err := dmarcMod.BasicLookupModule.Lookup(r, name, nameServer)
 	castedInnerRes, ok := innerRes.(*zdns.SingleQueryResult)
 	if !ok {
-		return nil, trace, status, errors.New("lookup didn't return a single query result type")
+		return "", nil, trace, status, errors.New("lookup didn't return a single query result type")
 	}
 	resString, resStatus, err := zdns.CheckTxtRecords(castedInnerRes, status, dmarcMod.re, err)
-	res := Result{Dmarc: resString}
-	return res, trace, resStatus, err
+	var records []string
+	if resStatus == zdns.StatusNoError {
+		for _, a := range castedInnerRes.Answers {
+			if ans, ok := a.(zdns.Answer); ok && dmarcMod.re.MatchString(ans.Answer) {
+				// TXT records split into several strings are joined back together
+				records = append(records, strings.ReplaceAll(ans.Answer, "\n", ""))
+			}
+		}
+	}
+	return resString, records, trace, resStatus, err
+}
+
+// isAuthorized checks whether destination accepts reports about domain, by publishing a DMARC record at
+// <domain>._report._dmarc.<destination> (RFC 7489, Section 7.1). It returns nil if the lookup failed.
+func (dmarcMod *DmarcLookupModule) isAuthorized(r *zdns.Resolver, domain, destination string, nameServer *zdns.NameServer) (*bool, zdns.Trace) {
+	_, records, trace, status, _ := dmarcMod.lookupRecords(r, domain+"._report."+dmarcLabel+"."+destination, nameServer)
+	if status != zdns.StatusNoError && !zdns.IsMissingStatus(status) {
+		return nil, trace
+	}
+	authorized := len(records) > 0
+	return &authorized, trace
+}
+
+// organizationalDomain returns the registered domain of name according to the public suffix list bundled with the
+// binary, or name itself if it's a public suffix
+func organizationalDomain(name string) string {
+	orgDomain, err := publicsuffix.EffectiveTLDPlusOne(name)
+	if err != nil {
+		return name
+	}
+	return orgDomain
 }
 
 func (dmarcMod *DmarcLookupModule) Help() string {
@@ -71,7 +184,7 @@ func (dmarcMod *DmarcLookupModule) Validate(args []string) error {
 }
 
 func (dmarcMod *DmarcLookupModule) GetDescription() string {
-	return ""
+	return "DMARC returns the parsed DMARC record of a domain, prepending the _dmarc label to names without it, falling back to its organizational domain, and checks that external report destinations authorized the reports."
 }
 
 func (dmarcMod *DmarcLookupModule) NewFlags() interface{} {
@@ -183,3 +183,90 @@ func TestDmarcLookup_NotValid_3(t *testing.T) {
 	assert.Equal(t, zdns.StatusNoRecord, status)
 	assert.Equal(t, res.(Result).Dmarc, "")
 }
+
+func txtResult(name string, values ...string) *zdns.SingleQueryResult {
+	res := &zdns.SingleQueryResult{}
+	for _, value := range values {
+		res.Answers = append(res.Answers, zdns.Answer{Name: name, Type: "TXT", RrType: dns.TypeTXT, Answer: value})
+	}
+	return res
+}
+
+func TestDmarcLookupParsed(t *testing.T) {
+	resolver := InitTest(t)
+	mockResults["_dmarc.example.com"] = txtResult("_dmarc.example.com",
+		"v=DMARC1; p=reject; sp=quarantine; pct=50; rua=mailto:agg@example.com,mailto:dmarc@reports.example.net!10m; ruf=mailto:forensic@other.example.org; adkim=s; fo=1:d; future=1")
+	mockResults["example.com._report._dmarc.reports.example.net"] = txtResult("example.com._report._dmarc.reports.example.net", "v=DMARC1;")
+	dmarcMod := DmarcLookupModule{}
+	assert.NilError(t, dmarcMod.CLIInit(&cli.CLIConf{}, &zdns.ResolverConfig{}))
+
+	res, _, status, err := dmarcMod.Lookup(resolver, "example.com", nil)
+	assert.NilError(t, err)
+	assert.Equal(t, zdns.StatusNoError, status)
+	result := res.(Result)
+	assert.Equal(t, result.Domain, "example.com")
+	assert.Equal(t, result.Inherited, false)
+	assert.Equal(t, result.AppliedPolicy, "reject")
+	rec := result.Record
+	assert.Equal(t, rec.Policy, "reject")
+	assert.Equal(t, rec.SubdomainPolicy, "quarantine")
+	assert.Equal(t, rec.Percent, 50)
+	assert.Equal(t, rec.DKIMAlignment, "s")
+	assert.Equal(t, rec.SPFAlignment, "r")
+	assert.DeepEqual(t, rec.FailureOptions, []string{"1", "d"})
+	assert.DeepEqual(t, rec.UnknownTags, []string{"future"})
+	authorized, unauthorized := true, false
+	assert.DeepEqual(t, rec.AggregateReportURIs, []ReportURI{
+		{URI: "mailto:agg@example.com", Domain: "example.com"},
+		{URI: "mailto:dmarc@reports.example.net", MaxSize: "10m", Domain: "reports.example.net", External: true, Authorized: &authorized},
+	})
+	assert.DeepEqual(t, rec.FailureReportURIs, []ReportURI{
+		{URI: "mailto:forensic@other.example.org", Domain: "other.example.org", External: true, Authorized: &unauthorized},
+	})
+}
+
+func TestDmarcLookupOrganizationalDomain(t *testing.T) {
+	resolver := InitTest(t)
+	mockResults["_dmarc.example.co.uk"] = txtResult("_dmarc.example.co.uk", "v=DMARC1; p=reject; sp=none")
+	dmarcMod := DmarcLookupModule{}
+	assert.NilError(t, dmarcMod.CLIInit(&cli.CLIConf{}, &zdns.ResolverConfig{}))
+
+	res, _, status, err := dmarcMod.Lookup(resolver, "_dmarc.mail.example.co.uk", nil)
+	assert.NilError(t, err)
+	assert.Equal(t, zdns.StatusNoError, status)
+	result := res.(Result)
+	assert.Equal(t, result.Dmarc, "v=DMARC1; p=reject; sp=none")
+	assert.Equal(t, result.Domain, "example.co.uk")
+	assert.Equal(t, result.OrganizationalDomain, "example.co.uk")
+	assert.Equal(t, result.Inherited, true)
+	assert.Equal(t, result.AppliedPolicy, "none")
+
+	// public suffixes have no organizational domain to fall back to
+	_, _, status, _ = dmarcMod.Lookup(resolver, "co.uk", nil)
+	assert.Equal(t, zdns.StatusNoAnswer, status)
+}
+
+func TestDmarcLookupInvalid(t *testing.T) {
+	resolver := InitTest(t)
+	mockResults["_dmarc.multiple.com"] = txtResult("_dmarc.multiple.com", "v=DMARC1; p=none", "v=DMARC1; p=reject")
+	mockResults["_dmarc.nopolicy.com"] = txtResult("_dmarc.nopolicy.com", "v=DMARC1; pct=20")
+	mockResults["_dmarc.rua.com"] = txtResult("_dmarc.rua.com", "v=DMARC1; rua=mailto:dmarc@rua.com; pct=200")
+	dmarcMod := DmarcLookupModule{}
+	assert.NilError(t, dmarcMod.CLIInit(&cli.CLIConf{}, &zdns.ResolverConfig{}))
+
+	res, _, status, _ := dmarcMod.Lookup(resolver, "multiple.com", nil)
+	assert.Equal(t, zdns.StatusNoError, status)
+	assert.DeepEqual(t, res.(Result).Errors, []string{"more than one DMARC record"})
+	assert.Assert(t, res.(Result).Record == nil)
+
+	res, _, _, _ = dmarcMod.Lookup(resolver, "nopolicy.com", nil)
+	assert.DeepEqual(t, res.(Result).Errors, []string{"missing p tag"})
+
+	// a record with a valid rua but no policy is treated as p=none
+	res, _, _, _ = dmarcMod.Lookup(resolver, "rua.com", nil)
+	result := res.(Result)
+	assert.Equal(t, len(result.Errors), 0)
+	assert.Equal(t, result.Record.Policy, "none")
+	assert.Equal(t, result.Record.Percent, 100)
+	assert.DeepEqual(t, result.Warnings, []string{"invalid pct tag, using 100: 200", "missing p tag, using none"})
+}
@@ -0,0 +1,193 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+/*
+ * Parsing of DMARC records.
+ * RFC reference: https://datatracker.ietf.org/doc/html/rfc7489#section-6.3
+ */
+
+package dmarc
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// reportURIRegexp splits a report URI from its optional maximum report size, ex. mailto:dmarc@example.com!10m
+var reportURIRegexp = regexp.MustCompile(`^(.+?)(?:!([0-9]+[kmgt]?))?$`)
+
+// Record holds the tags of a DMARC record, with the defaults of RFC 7489 filled in for those that are missing
+type Record struct {
+	Policy                string      `json:"p,omitempty" groups:"short,normal,long,trace"`
+	SubdomainPolicy       string      `json:"sp,omitempty" groups:"short,normal,long,trace"`
+	Percent               int         `json:"pct" groups:"short,normal,long,trace"`
+	AggregateReportURIs   []ReportURI `json:"rua,omitempty" groups:"short,normal,long,trace"`
+	FailureReportURIs     []ReportURI `json:"ruf,omitempty" groups:"short,normal,long,trace"`
+	DKIMAlignment         string      `json:"adkim" groups:"short,normal,long,trace"`
+	SPFAlignment          string      `json:"aspf" groups:"short,normal,long,trace"`
+	FailureOptions        []string    `json:"fo" groups:"short,normal,long,trace"`
+	ReportFormats         []string    `json:"rf" groups:"normal,long,trace"`
+	ReportIntervalSeconds uint32      `json:"ri" groups:"normal,long,trace"`
+	UnknownTags           []string    `json:"unknown_tags,omitempty" groups:"normal,long,trace"`
+}
+
+// ReportURI is a destination for aggregate or failure reports. Reports sent to a domain other than the one the record
+// was found at must be authorized by that domain, see Authorized.
+type ReportURI struct {
+	URI     string `json:"uri" groups:"short,normal,long,trace"`
+	MaxSize string `json:"max_size,omitempty" groups:"short,normal,long,trace"`
+	// Domain is the host of a mailto URI
+	Domain   string `json:"domain,omitempty" groups:"short,normal,long,trace"`
+	External bool   `json:"external" groups:"short,normal,long,trace"`
+	// Authorized is whether an external destination has published a record accepting the reports (RFC 7489, Section 7.1)
+	Authorized *bool `json:"authorized,omitempty" groups:"short,normal,long,trace"`
+}
+
+// parseRecord parses a DMARC record. Errors are returned for values that make the record unusable, and warnings for
+// those that RFC 7489 says to ignore or replace with their default.
+func parseRecord(record string) (*Record, []string, error) {
+	rec := &Record{
+		Percent:               100,
+		DKIMAlignment:         "r",
+		SPFAlignment:          "r",
+		FailureOptions:        []string{"0"},
+		ReportFormats:         []string{"afrf"},
+		ReportIntervalSeconds: 86400,
+	}
+	var warnings []string
+	seen := make(map[string]bool)
+	for i, part := range strings.Split(record, ";") {
+		part = strings.Trim(part, " \t")
+		if len(part) == 0 {
+			continue
+		}
+		tag, value, found := strings.Cut(part, "=")
+		tag = strings.ToLower(strings.Trim(tag, " \t"))
+		value = strings.Trim(value, " \t")
+		if !found {
+			return nil, warnings, fmt.Errorf("invalid tag: %s", part)
+		}
+		if i == 0 {
+			// the record prefix was already matched, so only the version can be here
+			continue
+		}
+		if seen[tag] {
+			return nil, warnings, fmt.Errorf("duplicate tag: %s", tag)
+		}
+		seen[tag] = true
+		switch tag {
+		case "p", "sp":
+			value = strings.ToLower(value)
+			if value != "none" && value != "quarantine" && value != "reject" {
+				if tag == "p" {
+					return nil, warnings, fmt.Errorf("invalid p tag: %s", value)
+				}
+				warnings = append(warnings, "invalid sp tag, using p: "+value)
+				continue
+			}
+			if tag == "p" {
+				rec.Policy = value
+			} else {
+				rec.SubdomainPolicy = value
+			}
+		case "pct":
+			pct, err := strconv.Atoi(value)
+			if err != nil || pct < 0 || pct > 100 {
+				warnings = append(warnings, "invalid pct tag, using 100: "+value)
+				continue
+			}
+			rec.Percent = pct
+		case "adkim", "aspf":
+			value = strings.ToLower(value)
+			if value != "r" && value != "s" {
+				warnings = append(warnings, fmt.Sprintf("invalid %s tag, using r: %s", tag, value))
+				continue
+			}
+			if tag == "adkim" {
+				rec.DKIMAlignment = value
+			} else {
+				rec.SPFAlignment = value
+			}
+		case "fo":
+			var options []string
+			for _, option := range strings.Split(value, ":") {
+				option = strings.ToLower(strings.Trim(option, " \t"))
+				if option != "0" && option != "1" && option != "d" && option != "s" {
+					warnings = append(warnings, "invalid fo option: "+option)
+					continue
+				}
+				options = append(options, option)
+			}
+			if len(options) > 0 {
+				rec.FailureOptions = options
+			}
+		case "rf":
+			rec.ReportFormats = splitList(value, ":")
+		case "ri":
+			ri, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				warnings = append(warnings, "invalid ri tag, using 86400: "+value)
+				continue
+			}
+			rec.ReportIntervalSeconds = uint32(ri)
+		case "rua", "ruf":
+			var uris []ReportURI
+			for _, uri := range splitList(value, ",") {
+				m := reportURIRegexp.FindStringSubmatch(uri)
+				if m == nil {
+					continue
+				}
+				reportURI := ReportURI{URI: m[1], MaxSize: m[2]}
+				if address, ok := strings.CutPrefix(strings.ToLower(m[1]), "mailto:"); ok {
+					if _, domain, ok := strings.Cut(address, "@"); ok {
+						reportURI.Domain = strings.TrimSuffix(domain, ".")
+					}
+				}
+				uris = append(uris, reportURI)
+			}
+			if tag == "rua" {
+				rec.AggregateReportURIs = uris
+			} else {
+				rec.FailureReportURIs = uris
+			}
+		default:
+			// unknown tags are ignored, so they can be added by later versions
+			rec.UnknownTags = append(rec.UnknownTags, tag)
+		}
+	}
+	if len(rec.Policy) == 0 {
+		if len(rec.AggregateReportURIs) == 0 {
+			return nil, warnings, fmt.Errorf("missing p tag")
+		}
+		// RFC 7489, Section 6.6.3: a record without a policy, but with a valid rua, is treated as p=none
+		warnings = append(warnings, "missing p tag, using none")
+		rec.Policy = "none"
+	}
+	if len(rec.SubdomainPolicy) == 0 {
+		rec.SubdomainPolicy = rec.Policy
+	}
+	return rec, warnings, nil
+}
+
+func splitList(value, sep string) []string {
+	var values []string
+	for _, v := range strings.Split(value, sep) {
+		if v = strings.Trim(v, " \t"); len(v) > 0 {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,312 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+/*
+ * Resolution of HTTPS and SVCB records.
+ * RFC reference: https://datatracker.ietf.org/doc/html/rfc9460
+ */
+
+package httpslookup
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+
+	"github.com/zmap/zdns/src/cli"
+	"github.com/zmap/zdns/src/zdns"
+)
+
+const (
+	defaultHTTPSPort = 443
+	// maxAliasChain bounds the AliasMode records followed, RFC 9460 leaves the limit to implementations
+	maxAliasChain = 8
+	alpnHTTP3     = "h3"
+)
+
+// portPrefixRegexp matches names that already carry an attrleaf port prefix, ex. _8443._https.example.com
+var portPrefixRegexp = regexp.MustCompile(`^_[0-9]+\._[a-z0-9-]+\.`)
+
+// HintComparison compares the address hints of a ServiceMode record with the addresses its target resolves to.
+// Clients may use hints before the real addresses arrive, so hints that don't resolve send them to stale addresses.
+type HintComparison struct {
+	Hints     []string `json:"hints,omitempty" groups:"short,normal,long,trace"`
+	Addresses []string `json:"addresses,omitempty" groups:"short,normal,long,trace"`
+	// Match is set if the hints and addresses are the same set
+	Match bool `json:"match" groups:"short,normal,long,trace"`
+	// Stale lists the hints the target doesn't resolve to, Unhinted the addresses without a hint
+	Stale    []string `json:"stale,omitempty" groups:"short,normal,long,trace"`
+	Unhinted []string `json:"unhinted,omitempty" groups:"short,normal,long,trace"`
+}
+
+// ServiceRecord is a ServiceMode record of the final name of the alias chain
+type ServiceRecord struct {
+	Priority uint16 `json:"priority" groups:"short,normal,long,trace"`
+	// Target is the effective target name, the owner name of the record if its target is "."
+	Target        string                 `json:"target" groups:"short,normal,long,trace"`
+	ALPN          []string               `json:"alpn,omitempty" groups:"short,normal,long,trace"`
+	NoDefaultALPN bool                   `json:"no_default_alpn" groups:"normal,long,trace"`
+	HTTP3         bool                   `json:"http3" groups:"short,normal,long,trace"`
+	Port          uint16                 `json:"port,omitempty" groups:"short,normal,long,trace"`
+	ECH           bool                   `json:"ech" groups:"short,normal,long,trace"`
+	ECHConfigSize int                    `json:"ech_config_size,omitempty" groups:"normal,long,trace"`
+	IPv4          *HintComparison        `json:"ipv4,omitempty" groups:"short,normal,long,trace"`
+	IPv6          *HintComparison        `json:"ipv6,omitempty" groups:"short,normal,long,trace"`
+	SVCParams     map[string]interface{} `json:"svcparams,omitempty" groups:"long,trace"`
+}
+
+type Result struct {
+	// Name is the name looked up, after adding any port prefix
+	Name string `json:"name" groups:"short,normal,long,trace"`
+	// Aliases are the AliasMode targets followed from Name
+	Aliases  []string        `json:"aliases,omitempty" groups:"short,normal,long,trace"`
+	Services []ServiceRecord `json:"services,omitempty" groups:"short,normal,long,trace"`
+	Errors   []string        `json:"errors,omitempty" groups:"short,normal,long,trace"`
+}
+
+func init() {
+	mod := new(HTTPSLookupModule)
+	cli.RegisterLookupModule("HTTPSLOOKUP", mod)
+}
+
+type HTTPSLookupModule struct {
+	cli.BasicLookupModule
+	Port int  `long:"port" default:"443" description:"port of the HTTPS origin, names are looked up as _<port>._https.<name> unless it is 443"`
+	SVCB bool `long:"svcb" description:"look up SVCB records instead of HTTPS records, input names must include any attrleaf prefix, ex. _dns.resolver.example"`
+}
+
+// CLIInit initializes the HTTPSLookup module
+func (mod *HTTPSLookupModule) CLIInit(gc *cli.CLIConf, rc *zdns.ResolverConfig) error {
+	if gc.LookupAllNameServers {
+		return errors.New("HTTPSLOOKUP module does not support --all-nameservers")
+	}
+	if mod.Port <= 0 || mod.Port > 65535 {
+		return errors.New("--port must be between 1 and 65535")
+	}
+	mod.DNSType = dns.TypeHTTPS
+	if mod.SVCB {
+		mod.DNSType = dns.TypeSVCB
+	}
+	mod.DNSClass = dns.ClassINET
+	return mod.BasicLookupModule.CLIInit(gc, rc)
+}
+
+// Lookup looks up the HTTPS (or SVCB) records of lookupName, follows AliasMode records to the ServiceMode records of the
+// final name, and compares the address hints of each with the addresses of its target
+func (mod *HTTPSLookupModule) Lookup(r *zdns.Resolver, lookupName string, nameServer *zdns.NameServer) (interface{}, zdns.Trace, zdns.Status, error) {
+	name := strings.ToLower(strings.TrimSuffix(lookupName, "."))
+	if !mod.SVCB && mod.Port != defaultHTTPSPort && !portPrefixRegexp.MatchString(name) {
+		name = fmt.Sprintf("_%d._https.%s", mod.Port, name)
+	}
+	res := &Result{Name: name}
+	var trace zdns.Trace
+
+	seen := map[string]bool{name: true}
+	var services []zdns.SVCBAnswer
+	for {
+		records, innerTrace, status, err := mod.lookupSVCB(r, name, nameServer)
+		trace = append(trace, innerTrace...)
+		if zdns.IsMissingStatus(status) {
+			return res, trace, zdns.StatusNoRecord, nil
+		}
+		if status != zdns.StatusNoError {
+			return res, trace, status, err
+		}
+		var aliases []zdns.SVCBAnswer
+		services = services[:0]
+		for _, record := range records {
+			if record.Priority == 0 {
+				aliases = append(aliases, record)
+			} else {
+				services = append(services, record)
+			}
+		}
+		if len(aliases) == 0 {
+			break
+		}
+		// RFC 9460, Section 2.4.2: ServiceMode records alongside an AliasMode record are ignored
+		if len(aliases) > 1 {
+			res.Errors = append(res.Errors, "more than one AliasMode record at "+name)
+		}
+		if len(services) > 0 {
+			res.Errors = append(res.Errors, "ServiceMode records ignored alongside an AliasMode record at "+name)
+		}
+		target := strings.ToLower(strings.TrimSuffix(aliases[0].Target, "."))
+		if len(target) == 0 {
+			// an AliasMode target of "." means the service doesn't exist
+			return res, trace, zdns.StatusNoRecord, nil
+		}
+		if seen[target] {
+			res.Errors = append(res.Errors, "AliasMode loop at "+target)
+			return res, trace, zdns.StatusServFail, errors.New("AliasMode loop")
+		}
+		if len(res.Aliases) == maxAliasChain {
+			res.Errors = append(res.Errors, "too many AliasMode records")
+			return res, trace, zdns.StatusServFail, errors.New("too many AliasMode records")
+		}
+		seen[target] = true
+		res.Aliases = append(res.Aliases, target)
+		name = target
+	}
+	if len(services) == 0 {
+		return res, trace, zdns.StatusNoRecord, nil
+	}
+	sort.SliceStable(services, func(i, j int) bool { return services[i].Priority < services[j].Priority })
+
+	addresses := make(map[string][]string)
+	for _, record := range services {
+		svc := serviceRecord(record, name, mod.DNSType == dns.TypeHTTPS)
+		for _, qType := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			key := fmt.Sprintf("%s/%d", svc.Target, qType)
+			if _, ok := addresses[key]; !ok {
+				addrs, innerTrace, status := mod.lookupAddresses(r, svc.Target, qType, nameServer)
+				trace = append(trace, innerTrace...)
+				if !zdns.IsMissingStatus(status) && status != zdns.StatusNoError {
+					res.Errors = append(res.Errors, fmt.Sprintf("%s lookup of %s failed with status %s", dns.TypeToString[qType], svc.Target, status))
+				}
+				addresses[key] = addrs
+			}
+			hintKey := "ipv4hint"
+			if qType == dns.TypeAAAA {
+				hintKey = "ipv6hint"
+			}
+			comparison := compareHints(hintStrings(record.SVCParams[hintKey]), addresses[key])
+			if qType == dns.TypeA {
+				svc.IPv4 = comparison
+			} else {
+				svc.IPv6 = comparison
+			}
+		}
+		res.Services = append(res.Services, svc)
+	}
+	return res, trace, zdns.StatusNoError, nil
+}
+
+// serviceRecord extracts the parameters of a ServiceMode record. For HTTPS records, http/1.1 is supported unless
+// no-default-alpn is set, but ALPN only lists the protocols in the record.
+func serviceRecord(record zdns.SVCBAnswer, owner string, isHTTPS bool) ServiceRecord {
+	svc := ServiceRecord{Priority: record.Priority, SVCParams: record.SVCParams}
+	svc.Target = strings.ToLower(strings.TrimSuffix(record.Target, "."))
+	if len(svc.Target) == 0 {
+		// RFC 9460, Section 2.5.2: a ServiceMode target of "." is the owner name
+		svc.Target = owner
+	}
+	if alpn, ok := record.SVCParams["alpn"].([]string); ok {
+		svc.ALPN = alpn
+		for _, protocol := range alpn {
+			if protocol == alpnHTTP3 {
+				svc.HTTP3 = true
+			}
+		}
+	}
+	_, svc.NoDefaultALPN = record.SVCParams["no-default-alpn"]
+	if port, ok := record.SVCParams["port"].(uint16); ok {
+		svc.Port = port
+	}
+	if ech, ok := record.SVCParams["ech"].([]byte); ok && len(ech) > 0 {
+		svc.ECH = true
+		svc.ECHConfigSize = len(ech)
+	}
+	return svc
+}
+
+// lookupSVCB returns the HTTPS or SVCB records of name, after any CNAMEs the resolver followed
+func (mod *HTTPSLookupModule) lookupSVCB(r *zdns.Resolver, name string, nameServer *zdns.NameServer) ([]zdns.SVCBAnswer, zdns.Trace, zdns.Status, error) {
+	res, trace, status, err := mod.LookupType(r, name, mod.DNSType, nameServer)
+	if status != zdns.StatusNoError || res == nil {
+		return nil, trace, status, err
+	}
+	var records []zdns.SVCBAnswer
+	for _, a := range res.Answers {
+		if ans, ok := a.(zdns.SVCBAnswer); ok && ans.RrType == mod.DNSType {
+			records = append(records, ans)
+		}
+	}
+	if len(records) == 0 {
+		return nil, trace, zdns.StatusNoRecord, nil
+	}
+	return records, trace, status, nil
+}
+
+// lookupAddresses returns the A or AAAA records of name
+func (mod *HTTPSLookupModule) lookupAddresses(r *zdns.Resolver, name string, qType uint16, nameServer *zdns.NameServer) ([]string, zdns.Trace, zdns.Status) {
+	res, trace, status, _ := mod.LookupType(r, name, qType, nameServer)
+	if status != zdns.StatusNoError || res == nil {
+		return nil, trace, status
+	}
+	var addrs []string
+	for _, a := range res.Answers {
+		if ans, ok := a.(zdns.Answer); ok && ans.RrType == qType {
+			addrs = append(addrs, ans.Answer)
+		}
+	}
+	return addrs, trace, status
+}
+
+// hintStrings returns the addresses of an ipv4hint or ipv6hint parameter
+func hintStrings(param interface{}) []string {
+	ips, _ := param.([]net.IP)
+	hints := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		hints = append(hints, ip.String())
+	}
+	return hints
+}
+
+// compareHints compares hints with the addresses the target resolves to, returning nil if there are neither
+func compareHints(hints, addresses []string) *HintComparison {
+	if len(hints) == 0 && len(addresses) == 0 {
+		return nil
+	}
+	c := &HintComparison{Hints: hints, Addresses: addresses}
+	resolved := make(map[string]bool, len(addresses))
+	for _, addr := range addresses {
+		resolved[net.ParseIP(addr).String()] = true
+	}
+	hinted := make(map[string]bool, len(hints))
+	for _, hint := range hints {
+		hinted[hint] = true
+		if !resolved[hint] {
+			c.Stale = append(c.Stale, hint)
+		}
+	}
+	for _, addr := range addresses {
+		if !hinted[net.ParseIP(addr).String()] {
+			c.Unhinted = append(c.Unhinted, addr)
+		}
+	}
+	c.Match = len(c.Stale) == 0 && len(c.Unhinted) == 0
+	return c
+}
+
+func (mod *HTTPSLookupModule) Help() string {
+	return ""
+}
+
+func (mod *HTTPSLookupModule) Validate(args []string) error {
+	return nil
+}
+
+func (mod *HTTPSLookupModule) GetDescription() string {
+	return "HTTPSLOOKUP looks up the HTTPS or SVCB records of a name, follows AliasMode records, and reports the ALPN, ECH and port of each ServiceMode record, comparing its address hints with the addresses of its target."
+}
+
+func (mod *HTTPSLookupModule) NewFlags() interface{} {
+	return mod
+}
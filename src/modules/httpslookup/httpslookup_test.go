@@ -0,0 +1,136 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package httpslookup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/zmap/zdns/src/cli"
+	"github.com/zmap/zdns/src/zdns"
+	"github.com/zmap/zdns/src/zdns/testserver"
+	"github.com/zmap/zdns/src/zdns/testserver/testresolver"
+)
+
+const testZone = `
+@ SOA ns1 hostmaster 1 7200 900 1209600 300
+@ HTTPS 1 . alpn="h3,h2" ipv4hint=192.0.2.1 ipv6hint=2001:db8::1 ech=AAQAAQID
+@ A 192.0.2.1
+@ AAAA 2001:db8::1
+alias HTTPS 0 svc.example.test.
+svc HTTPS 2 . alpn=h2
+svc HTTPS 1 pool.example.test. alpn=h2 no-default-alpn port=8443 ipv4hint=192.0.2.10,192.0.2.99
+pool A 192.0.2.10
+pool A 192.0.2.11
+_8443._https HTTPS 1 pool.example.test. alpn=h3
+cname CNAME alias.example.test.
+mixed HTTPS 0 svc.example.test.
+mixed HTTPS 1 . alpn=h2
+loop1 HTTPS 0 loop2.example.test.
+loop2 HTTPS 0 loop1.example.test.
+gone HTTPS 0 .
+`
+
+func initTest(t *testing.T, port int) (*HTTPSLookupModule, *zdns.Resolver) {
+	network := testserver.NewNetwork()
+	server := network.AddServer(testserver.MustParseZone("example.test.", testZone))
+	require.NoError(t, network.Start())
+	t.Cleanup(network.Close)
+
+	r := testresolver.New(t, testresolver.Config(network, server))
+
+	mod := &HTTPSLookupModule{Port: port}
+	require.NoError(t, mod.CLIInit(&cli.CLIConf{}, &zdns.ResolverConfig{}))
+	return mod, r
+}
+
+func lookup(t *testing.T, mod *HTTPSLookupModule, r *zdns.Resolver, name string) (*Result, zdns.Status) {
+	res, _, status, _ := mod.Lookup(r, name, nil)
+	return res.(*Result), status
+}
+
+func TestHTTPSLookupServiceMode(t *testing.T) {
+	mod, r := initTest(t, 443)
+
+	res, status := lookup(t, mod, r, "example.test")
+	require.Equal(t, zdns.StatusNoError, status)
+	require.Empty(t, res.Aliases)
+	require.Len(t, res.Services, 1)
+	svc := res.Services[0]
+	require.Equal(t, "example.test", svc.Target)
+	require.Equal(t, []string{"h3", "h2"}, svc.ALPN)
+	require.True(t, svc.HTTP3)
+	require.True(t, svc.ECH)
+	require.Equal(t, 6, svc.ECHConfigSize)
+	require.Equal(t, &HintComparison{Hints: []string{"192.0.2.1"}, Addresses: []string{"192.0.2.1"}, Match: true}, svc.IPv4)
+	require.True(t, svc.IPv6.Match)
+}
+
+func TestHTTPSLookupAliasMode(t *testing.T) {
+	mod, r := initTest(t, 443)
+
+	for _, name := range []string{"alias.example.test", "cname.example.test"} {
+		res, status := lookup(t, mod, r, name)
+		require.Equal(t, zdns.StatusNoError, status, name)
+		require.Equal(t, []string{"svc.example.test"}, res.Aliases, name)
+		require.Len(t, res.Services, 2, name)
+
+		svc := res.Services[0]
+		require.Equal(t, uint16(1), svc.Priority)
+		require.Equal(t, "pool.example.test", svc.Target)
+		require.Equal(t, uint16(8443), svc.Port)
+		require.True(t, svc.NoDefaultALPN)
+		require.False(t, svc.HTTP3)
+		require.False(t, svc.ECH)
+		require.Equal(t, &HintComparison{
+			Hints:     []string{"192.0.2.10", "192.0.2.99"},
+			Addresses: []string{"192.0.2.10", "192.0.2.11"},
+			Stale:     []string{"192.0.2.99"},
+			Unhinted:  []string{"192.0.2.11"},
+		}, svc.IPv4)
+		require.Nil(t, svc.IPv6)
+
+		// the target of "." is the owner name at the end of the alias chain
+		require.Equal(t, "svc.example.test", res.Services[1].Target)
+		require.Nil(t, res.Services[1].IPv4)
+	}
+
+	res, status := lookup(t, mod, r, "mixed.example.test")
+	require.Equal(t, zdns.StatusNoError, status)
+	require.Equal(t, []string{"svc.example.test"}, res.Aliases)
+	require.Equal(t, []string{"ServiceMode records ignored alongside an AliasMode record at mixed.example.test"}, res.Errors)
+
+	res, status = lookup(t, mod, r, "loop1.example.test")
+	require.Equal(t, zdns.StatusServFail, status)
+	require.Equal(t, []string{"AliasMode loop at loop1.example.test"}, res.Errors)
+
+	_, status = lookup(t, mod, r, "gone.example.test")
+	require.Equal(t, zdns.StatusNoRecord, status)
+	_, status = lookup(t, mod, r, "missing.example.test")
+	require.Equal(t, zdns.StatusNoRecord, status)
+}
+
+func TestHTTPSLookupPortPrefix(t *testing.T) {
+	mod, r := initTest(t, 8443)
+
+	for _, name := range []string{"example.test", "_8443._https.example.test"} {
+		res, status := lookup(t, mod, r, name)
+		require.Equal(t, zdns.StatusNoError, status, name)
+		require.Equal(t, "_8443._https.example.test", res.Name)
+		require.True(t, res.Services[0].HTTP3)
+		require.Equal(t, []string{"192.0.2.10", "192.0.2.11"}, res.Services[0].IPv4.Unhinted)
+	}
+}
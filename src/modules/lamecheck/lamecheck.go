@@ -0,0 +1,294 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package lamecheck
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+
+	"github.com/zmap/zdns/src/cli"
+	"github.com/zmap/zdns/src/zdns"
+)
+
+// Reasons a name server is lame
+const (
+	reasonUnresolvable     = "unresolvable"
+	reasonNotQueried       = "not queried"
+	reasonNoResponse       = "no response"
+	reasonNotAuthoritative = "not authoritative"
+	reasonRefused          = "refused"
+	reasonServFail         = "servfail"
+)
+
+// NameServer is a name server of the zone, from the delegation in the parent zone, the NS RRset of the zone itself,
+// or both
+type NameServer struct {
+	Name     string `json:"name" groups:"short,normal,long,trace"`
+	InParent bool   `json:"in_parent" groups:"short,normal,long,trace"`
+	InChild  bool   `json:"in_child" groups:"short,normal,long,trace"`
+	// Resolves is whether the name of the server has any A or AAAA records
+	Resolves      bool     `json:"resolves" groups:"short,normal,long,trace"`
+	IPv4Addresses []string `json:"ipv4_addresses,omitempty" groups:"normal,long,trace"`
+	IPv6Addresses []string `json:"ipv6_addresses,omitempty" groups:"normal,long,trace"`
+	// Responses are the responses of each address of the server to the SOA query, sorted by address
+	Responses []Response `json:"responses,omitempty" groups:"short,normal,long,trace"`
+	// Authoritative is set if every address of the server answered authoritatively
+	Authoritative bool `json:"authoritative" groups:"short,normal,long,trace"`
+	// Lame is set if the server couldn't be queried or any of its addresses is lame, Reason is why
+	Lame   bool   `json:"lame" groups:"short,normal,long,trace"`
+	Reason string `json:"reason,omitempty" groups:"short,normal,long,trace"`
+}
+
+// Response is the response of one address of a name server to the SOA query
+type Response struct {
+	Address       string `json:"address" groups:"short,normal,long,trace"`
+	Status        string `json:"status" groups:"short,normal,long,trace"`
+	Authoritative bool   `json:"authoritative" groups:"short,normal,long,trace"`
+	Reason        string `json:"reason,omitempty" groups:"short,normal,long,trace"`
+}
+
+type Result struct {
+	NameServers []NameServer `json:"name_servers" groups:"short,normal,long,trace"`
+	LameCount   int          `json:"lame_count" groups:"short,normal,long,trace"`
+}
+
+func init() {
+	mod := new(LameCheckModule)
+	cli.RegisterLookupModule("LAMECHECK", mod)
+}
+
+type LameCheckModule struct {
+	cli.BasicLookupModule
+	queryIPv4 bool
+	queryIPv6 bool
+}
+
+// CLIInit initializes the LameCheck module
+func (mod *LameCheckModule) CLIInit(gc *cli.CLIConf, rc *zdns.ResolverConfig) error {
+	if gc.LookupAllNameServers {
+		return errors.New("LAMECHECK module always queries all name servers, --all-nameservers is not needed")
+	}
+	mod.DNSType = dns.TypeSOA
+	mod.DNSClass = dns.ClassINET
+	mod.Init(rc.IPVersionMode != zdns.IPv6Only, rc.IPVersionMode != zdns.IPv4Only)
+	return mod.BasicLookupModule.CLIInit(gc, rc)
+}
+
+// Init sets which addresses of the name servers are queried, only those the resolver can reach should be
+func (mod *LameCheckModule) Init(queryIPv4, queryIPv6 bool) {
+	mod.queryIPv4 = queryIPv4
+	mod.queryIPv6 = queryIPv6
+}
+
+// Lookup walks the delegation of the zone lookupName from the root, querying every name server it is delegated to and
+// every name server in its own NS RRset for its SOA record. Addresses of the servers the walk didn't query are queried
+// afterwards, so that every address gets a response. A server is lame if it can't be queried because its name doesn't
+// resolve, or if any of its addresses doesn't respond, or responds without authority, with REFUSED or with SERVFAIL.
+func (mod *LameCheckModule) Lookup(r *zdns.Resolver, lookupName string, nameServer *zdns.NameServer) (interface{}, zdns.Trace, zdns.Status, error) {
+	zone := strings.ToLower(strings.TrimSuffix(lookupName, "."))
+	q := &zdns.Question{Name: zone, Type: mod.DNSType, Class: mod.DNSClass}
+	allRes, trace, status, err := r.LookupAllNameserversIterative(q, nil)
+	if status != zdns.StatusNoError {
+		return nil, trace, status, err
+	}
+
+	servers := make(map[string]*NameServer)
+	server := func(name string) *NameServer {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if _, ok := servers[name]; !ok {
+			servers[name] = &NameServer{Name: name}
+		}
+		return servers[name]
+	}
+	for layer, layerResults := range allRes.LayeredResponses {
+		for _, extRes := range layerResults {
+			// the delegation is in the authority section of referrals from the parent, the NS RRset of the zone in
+			// the answers of the zone's own servers
+			if layer != zone {
+				for _, ns := range nsRecords(extRes.Res.Authorities, zone) {
+					server(ns).InParent = true
+				}
+			} else if extRes.Type == dns.TypeToString[dns.TypeNS] {
+				for _, ns := range nsRecords(extRes.Res.Answers, zone) {
+					server(ns).InChild = true
+				}
+			}
+		}
+	}
+	if len(servers) == 0 {
+		return nil, trace, zdns.StatusNoRecord, errors.New("no name servers found for zone " + zone)
+	}
+	for _, extRes := range allRes.LayeredResponses[zone] {
+		// the walk only queries one address of each server, and the address is unknown if the query failed
+		if extRes.Type == dns.TypeToString[mod.DNSType] && len(extRes.Res.Resolver) > 0 {
+			ns := server(extRes.Nameserver)
+			ns.Responses = append(ns.Responses, response(extRes, extRes.Res.Resolver))
+		}
+	}
+
+	res := &Result{NameServers: make([]NameServer, 0, len(servers))}
+	for _, ns := range servers {
+		var addrTrace zdns.Trace
+		ns.IPv4Addresses, addrTrace = mod.lookupAddresses(r, ns.Name, dns.TypeA, nameServer)
+		trace = append(trace, addrTrace...)
+		ns.IPv6Addresses, addrTrace = mod.lookupAddresses(r, ns.Name, dns.TypeAAAA, nameServer)
+		trace = append(trace, addrTrace...)
+		ns.Resolves = len(ns.IPv4Addresses) > 0 || len(ns.IPv6Addresses) > 0
+		trace = append(trace, mod.queryAddresses(r, zone, ns)...)
+		ns.summarize()
+		if ns.Lame {
+			res.LameCount++
+		}
+		res.NameServers = append(res.NameServers, *ns)
+	}
+	sort.Slice(res.NameServers, func(i, j int) bool { return res.NameServers[i].Name < res.NameServers[j].Name })
+	return res, trace, zdns.StatusNoError, nil
+}
+
+// queryAddresses queries the addresses of ns that the walk didn't for the SOA of zone, adding their responses
+func (mod *LameCheckModule) queryAddresses(r *zdns.Resolver, zone string, ns *NameServer) zdns.Trace {
+	var addrs []string
+	if mod.queryIPv4 {
+		addrs = append(addrs, ns.IPv4Addresses...)
+	}
+	if mod.queryIPv6 {
+		addrs = append(addrs, ns.IPv6Addresses...)
+	}
+	var trace zdns.Trace
+	q := &zdns.Question{Name: zone, Type: mod.DNSType, Class: mod.DNSClass}
+	for _, addr := range zdns.Unique(addrs) {
+		ip := net.ParseIP(addr)
+		if ip == nil || ns.queried(ip) {
+			continue
+		}
+		nsResults, soaTrace, _ := r.LookupEachNameServer(q, []zdns.NameServer{{IP: ip, DomainName: ns.Name}})
+		trace = append(trace, soaTrace...)
+		for _, nsRes := range nsResults {
+			ns.Responses = append(ns.Responses, response(nsRes.ExtendedResult, nsRes.NameServer.IP.String()))
+		}
+	}
+	return trace
+}
+
+// queried returns whether the server has a response from ip
+func (ns *NameServer) queried(ip net.IP) bool {
+	for _, resp := range ns.Responses {
+		if ip.Equal(net.ParseIP(resp.Address)) {
+			return true
+		}
+	}
+	return false
+}
+
+// summarize sorts the responses of the server and sets whether it's lame from them. A server that couldn't be queried
+// is lame, either because its name doesn't resolve or because it has no addresses the resolver can reach.
+func (ns *NameServer) summarize() {
+	sort.Slice(ns.Responses, func(i, j int) bool { return ns.Responses[i].Address < ns.Responses[j].Address })
+	switch {
+	case len(ns.Responses) == 0 && !ns.Resolves:
+		ns.Reason = reasonUnresolvable
+	case len(ns.Responses) == 0:
+		ns.Reason = reasonNotQueried
+	default:
+		ns.Authoritative = true
+		for _, resp := range ns.Responses {
+			ns.Authoritative = ns.Authoritative && resp.Authoritative
+			if len(ns.Reason) == 0 {
+				ns.Reason = resp.Reason
+			}
+		}
+	}
+	ns.Lame = len(ns.Reason) > 0
+}
+
+// response converts the response of the server at address to the SOA query, where address may include a port
+func response(extRes zdns.ExtendedResult, address string) Response {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	return Response{
+		Address:       address,
+		Status:        string(extRes.Status),
+		Authoritative: extRes.Status == zdns.StatusNoError && extRes.Res.Flags.Authoritative,
+		Reason:        lameReason(extRes),
+	}
+}
+
+// lookupAddresses returns the addresses of type qType in the answers for name. Unlike DoTargetedLookup it ignores the
+// additional section, where the glue for other name servers of the zone often is.
+func (mod *LameCheckModule) lookupAddresses(r *zdns.Resolver, name string, qType uint16, nameServer *zdns.NameServer) ([]string, zdns.Trace) {
+	res, trace, status, _ := mod.LookupType(r, name, qType, nameServer)
+	if status != zdns.StatusNoError || res == nil {
+		return nil, trace
+	}
+	var addrs []string
+	for _, a := range res.Answers {
+		if ans, ok := a.(zdns.Answer); ok && ans.RrType == qType {
+			addrs = append(addrs, ans.Answer)
+		}
+	}
+	return addrs, trace
+}
+
+// nsRecords returns the targets of the NS records of zone in records
+func nsRecords(records []interface{}, zone string) []string {
+	var targets []string
+	for _, a := range records {
+		if ans, ok := a.(zdns.Answer); ok && ans.RrType == dns.TypeNS && strings.EqualFold(strings.TrimSuffix(ans.Name, "."), zone) {
+			targets = append(targets, ans.Answer)
+		}
+	}
+	return targets
+}
+
+// lameReason returns why the response of a name server to the SOA query makes it lame, or an empty string if it
+// doesn't
+func lameReason(extRes zdns.ExtendedResult) string {
+	switch extRes.Status {
+	case zdns.StatusNoError:
+		if !extRes.Res.Flags.Authoritative {
+			return reasonNotAuthoritative
+		}
+		return ""
+	case zdns.StatusRefused:
+		return reasonRefused
+	case zdns.StatusServFail:
+		return reasonServFail
+	case zdns.StatusTimeout, zdns.StatusIterTimeout, zdns.StatusError:
+		return reasonNoResponse
+	default:
+		return strings.ToLower(string(extRes.Status))
+	}
+}
+
+func (mod *LameCheckModule) Help() string {
+	return ""
+}
+
+func (mod *LameCheckModule) Validate(args []string) error {
+	return nil
+}
+
+func (mod *LameCheckModule) GetDescription() string {
+	return "LAMECHECK queries every name server a zone is delegated to, and every name server in its NS RRset, for the SOA of the zone, reporting the lame ones and why."
+}
+
+func (mod *LameCheckModule) NewFlags() interface{} {
+	return mod
+}
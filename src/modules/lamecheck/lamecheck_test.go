@@ -0,0 +1,135 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package lamecheck
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/zmap/zdns/src/cli"
+	"github.com/zmap/zdns/src/zdns"
+	"github.com/zmap/zdns/src/zdns/testserver"
+	"github.com/zmap/zdns/src/zdns/testserver/testresolver"
+)
+
+// TestLameCheck delegates example.test. to a working server, one that refuses queries, one that doesn't respond and
+// one whose name doesn't resolve, while the zone itself also lists a server that only serves the parent zone, a server
+// with the addresses of both the working and the refusing server, and a server with only an IPv6 address
+func TestLameCheck(t *testing.T) {
+	network := testserver.NewNetwork()
+	rootServer := network.AddServer()
+	tldServer := network.AddServer()
+	ns1 := network.AddServer()
+	ns2 := network.AddServer()
+	ns3 := network.AddServer()
+	ns4 := network.AddServer()
+	resolver := network.AddServer()
+
+	root := testserver.MustParseZone(".", `
+. SOA a.root-servers.test. hostmaster.test. 1 7200 900 1209600 86400
+. NS a.root-servers.test.
+a.root-servers.test. A `+rootServer.IP.String())
+	tld := testserver.MustParseZone("test.", `@ SOA ns1.nic.test. hostmaster.test. 1 7200 900 1209600 300`)
+	sld := testserver.MustParseZone("example.test.", `@ SOA ns1 hostmaster 1 7200 900 1209600 300`)
+	root.Delegate(tld, "ns1.nic.test.", tldServer.IP)
+	tld.Delegate(sld, "ns1.example.test.", ns1.IP)
+	tld.Delegate(sld, "ns2.example.test.", ns2.IP)
+	tld.Delegate(sld, "ns3.example.test.", ns3.IP)
+	tld.Add(testserver.MustParseRR("example.test. 3600 IN NS ns.missing.test."))
+	sld.Add(
+		testserver.MustParseRR("example.test. 3600 IN NS ns4.example.test."),
+		testserver.MustParseRR("ns4.example.test. 3600 IN A "+ns4.IP.String()),
+		testserver.MustParseRR("example.test. 3600 IN NS ns5.example.test."),
+		testserver.MustParseRR("ns5.example.test. 3600 IN A "+ns1.IP.String()),
+		testserver.MustParseRR("ns5.example.test. 3600 IN A "+ns2.IP.String()),
+		testserver.MustParseRR("example.test. 3600 IN NS ns6.example.test."),
+		testserver.MustParseRR("ns6.example.test. 3600 IN AAAA 2001:db8::6"),
+	)
+	rootServer.Zones = []*testserver.Zone{root}
+	tldServer.Zones = []*testserver.Zone{tld}
+	ns1.Zones = []*testserver.Zone{sld}
+	ns3.Zones = []*testserver.Zone{sld}
+	ns3.Unresponsive.Store(true)
+	ns4.Zones = []*testserver.Zone{tld}
+	resolver.Zones = []*testserver.Zone{root, tld, sld}
+	require.NoError(t, network.Start())
+	t.Cleanup(network.Close)
+
+	rc := testresolver.Config(network, rootServer)
+	rc.ExternalNameServersV4 = []zdns.NameServer{{IP: resolver.IP, Port: uint16(network.Ports().DNS)}}
+	rc.Timeout = 10 * time.Second
+	rc.IterativeTimeout = time.Second
+	rc.NetworkTimeout = 300 * time.Millisecond
+	r := testresolver.New(t, rc)
+
+	mod := &LameCheckModule{}
+	require.NoError(t, mod.CLIInit(&cli.CLIConf{}, rc))
+	res, _, status, err := mod.Lookup(r, "example.test.", nil)
+	require.NoError(t, err)
+	require.Equal(t, zdns.StatusNoError, status)
+	result := res.(*Result)
+
+	servers := make(map[string]NameServer)
+	var names []string
+	for _, ns := range result.NameServers {
+		servers[ns.Name] = ns
+		names = append(names, ns.Name)
+	}
+	require.Equal(t, []string{"ns.missing.test", "ns1.example.test", "ns2.example.test", "ns3.example.test", "ns4.example.test", "ns5.example.test", "ns6.example.test"}, names)
+	require.Equal(t, 6, result.LameCount)
+
+	good := servers["ns1.example.test"]
+	require.False(t, good.Lame)
+	require.True(t, good.Authoritative)
+	require.True(t, good.InParent)
+	require.True(t, good.InChild)
+	require.True(t, good.Resolves)
+	require.Equal(t, []string{ns1.IP.String()}, good.IPv4Addresses)
+	require.Equal(t, []Response{{Address: ns1.IP.String(), Status: "NOERROR", Authoritative: true}}, good.Responses)
+
+	require.Equal(t, reasonRefused, servers["ns2.example.test"].Reason)
+	require.Equal(t, reasonNoResponse, servers["ns3.example.test"].Reason)
+	require.True(t, servers["ns3.example.test"].Resolves)
+
+	notAuth := servers["ns4.example.test"]
+	require.Equal(t, reasonNotAuthoritative, notAuth.Reason)
+	require.False(t, notAuth.InParent)
+	require.True(t, notAuth.InChild)
+
+	missing := servers["ns.missing.test"]
+	require.Equal(t, reasonUnresolvable, missing.Reason)
+	require.True(t, missing.InParent)
+	require.False(t, missing.InChild)
+	require.False(t, missing.Resolves)
+	require.Empty(t, missing.Responses)
+
+	// the walk only queries one address of each server, the other is queried afterwards
+	both := servers["ns5.example.test"]
+	require.Equal(t, []Response{
+		{Address: ns1.IP.String(), Status: "NOERROR", Authoritative: true},
+		{Address: ns2.IP.String(), Status: "REFUSED", Reason: reasonRefused},
+	}, both.Responses)
+	require.Equal(t, reasonRefused, both.Reason)
+	require.False(t, both.Authoritative)
+
+	// the resolver is IPv4 only, so the IPv6 address can't be queried even though the name resolves
+	ipv6Only := servers["ns6.example.test"]
+	require.True(t, ipv6Only.Resolves)
+	require.Equal(t, []string{"2001:db8::6"}, ipv6Only.IPv6Addresses)
+	require.Empty(t, ipv6Only.Responses)
+	require.Equal(t, reasonNotQueried, ipv6Only.Reason)
+}
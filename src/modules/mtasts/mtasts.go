@@ -0,0 +1,367 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+/*
+ * Discovery of SMTP MTA Strict Transport Security and SMTP TLS Reporting.
+ * RFC reference:
+ * - https://datatracker.ietf.org/doc/html/rfc8461 (MTA-STS)
+ * - https://datatracker.ietf.org/doc/html/rfc8460 (TLSRPT)
+ */
+
+package mtasts
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+
+	"github.com/zmap/zdns/src/cli"
+	"github.com/zmap/zdns/src/modules/mxlookup"
+	"github.com/zmap/zdns/src/zdns"
+)
+
+const (
+	stsLabel    = "_mta-sts"
+	tlsrptLabel = "_smtp._tls"
+	// policyPath is where the policy is served from, on the mta-sts host of the policy domain
+	policyPath = "/.well-known/mta-sts.txt"
+	// maxPolicySize bounds the policy file read, RFC 8461 suggests 64 KB
+	maxPolicySize = 64 * 1024
+	maxMaxAge     = 31557600
+)
+
+var (
+	stsRecordRegexp    = regexp.MustCompile(`^v=STSv1( *;|$)`)
+	tlsrptRecordRegexp = regexp.MustCompile(`^v=TLSRPTv1( *;|$)`)
+	stsIDRegexp        = regexp.MustCompile(`^[a-zA-Z0-9]{1,32}$`)
+)
+
+// STSRecord is the _mta-sts TXT record announcing that a domain has an MTA-STS policy
+type STSRecord struct {
+	Record     string            `json:"record" groups:"short,normal,long,trace"`
+	ID         string            `json:"id,omitempty" groups:"short,normal,long,trace"`
+	Extensions map[string]string `json:"extensions,omitempty" groups:"normal,long,trace"`
+}
+
+// TLSRPTRecord is the _smtp._tls TXT record listing where TLS failure reports for a domain are sent
+type TLSRPTRecord struct {
+	Record     string            `json:"record" groups:"short,normal,long,trace"`
+	RUA        []string          `json:"rua,omitempty" groups:"short,normal,long,trace"`
+	Extensions map[string]string `json:"extensions,omitempty" groups:"normal,long,trace"`
+}
+
+// Policy is the MTA-STS policy file served over HTTPS
+type Policy struct {
+	URL     string   `json:"url" groups:"short,normal,long,trace"`
+	Version string   `json:"version,omitempty" groups:"short,normal,long,trace"`
+	Mode    string   `json:"mode,omitempty" groups:"short,normal,long,trace"`
+	MX      []string `json:"mx,omitempty" groups:"short,normal,long,trace"`
+	MaxAge  int      `json:"max_age,omitempty" groups:"short,normal,long,trace"`
+	Error   string   `json:"error,omitempty" groups:"short,normal,long,trace"`
+}
+
+// MXHost is an exchange of the domain. PolicyMatch is whether a fetched policy lists it, the MTA-STS check a sending
+// MTA would make before delivering to it.
+type MXHost struct {
+	Name        string `json:"name" groups:"short,normal,long,trace"`
+	Preference  uint16 `json:"preference" groups:"short,normal,long,trace"`
+	PolicyMatch *bool  `json:"policy_match,omitempty" groups:"short,normal,long,trace"`
+}
+
+type Result struct {
+	MTASTS  *STSRecord    `json:"mta_sts,omitempty" groups:"short,normal,long,trace"`
+	TLSRPT  *TLSRPTRecord `json:"tls_rpt,omitempty" groups:"short,normal,long,trace"`
+	MXHosts []MXHost      `json:"mx_hosts,omitempty" groups:"short,normal,long,trace"`
+	Policy  *Policy       `json:"policy,omitempty" groups:"short,normal,long,trace"`
+	Errors  []string      `json:"errors,omitempty" groups:"short,normal,long,trace"`
+}
+
+func init() {
+	mod := new(MTASTSLookupModule)
+	cli.RegisterLookupModule("MTASTS", mod)
+}
+
+type MTASTSLookupModule struct {
+	cli.BasicLookupModule
+	FetchPolicy   bool          `long:"fetch-policy" description:"fetch the MTA-STS policy file over HTTPS and check the MX hosts against it"`
+	PolicyTimeout time.Duration `long:"policy-timeout" default:"10s" description:"timeout for fetching the MTA-STS policy file"`
+
+	client *http.Client
+	mx     mxlookup.MXLookupModule
+}
+
+// CLIInit initializes the MTASTS lookup module
+func (mod *MTASTSLookupModule) CLIInit(gc *cli.CLIConf, rc *zdns.ResolverConfig) error {
+	if gc.LookupAllNameServers {
+		return errors.New("MTASTS module does not support --all-nameservers")
+	}
+	mod.Init(mod.FetchPolicy, mod.PolicyTimeout)
+	if err := mod.BasicLookupModule.CLIInit(gc, rc); err != nil {
+		return err
+	}
+	mod.mx.IsIterative = mod.IsIterative
+	return nil
+}
+
+// Init initializes the MTASTS lookup module, used to call MTASTS programmatically
+func (mod *MTASTSLookupModule) Init(fetchPolicy bool, policyTimeout time.Duration) {
+	mod.FetchPolicy = fetchPolicy
+	mod.PolicyTimeout = policyTimeout
+	mod.DNSClass = dns.ClassINET
+	mod.client = &http.Client{
+		Timeout: policyTimeout,
+		// RFC 8461, Section 3.3: redirects must not be followed when fetching the policy
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// Lookup looks up the MTA-STS and TLSRPT records of lookupName along with its MX hosts, and with --fetch-policy,
+// checks the MX hosts against the MTA-STS policy
+func (mod *MTASTSLookupModule) Lookup(r *zdns.Resolver, lookupName string, nameServer *zdns.NameServer) (interface{}, zdns.Trace, zdns.Status, error) {
+	domain := strings.ToLower(strings.TrimSuffix(lookupName, "."))
+	res := &Result{}
+	var trace zdns.Trace
+
+	stsRecords, stsTrace, stsStatus, err := mod.lookupTXT(r, stsLabel+"."+domain, stsRecordRegexp, nameServer)
+	trace = append(trace, stsTrace...)
+	if !zdns.IsMissingStatus(stsStatus) && stsStatus != zdns.StatusNoError {
+		return res, trace, stsStatus, err
+	}
+	if len(stsRecords) > 1 {
+		// RFC 8461, Section 3.1: multiple records mean the domain has no usable policy
+		res.Errors = append(res.Errors, "more than one MTA-STS record")
+	} else if len(stsRecords) == 1 {
+		res.MTASTS = &STSRecord{Record: stsRecords[0]}
+		tags, tagErr := parseTags(stsRecords[0])
+		res.MTASTS.ID = tags["id"]
+		delete(tags, "v")
+		delete(tags, "id")
+		if len(tags) > 0 {
+			res.MTASTS.Extensions = tags
+		}
+		if tagErr != nil {
+			res.Errors = append(res.Errors, "invalid MTA-STS record: "+tagErr.Error())
+		} else if !stsIDRegexp.MatchString(res.MTASTS.ID) {
+			res.Errors = append(res.Errors, "invalid MTA-STS record: missing or invalid id")
+		}
+	}
+
+	tlsrptRecords, tlsrptTrace, tlsrptStatus, _ := mod.lookupTXT(r, tlsrptLabel+"."+domain, tlsrptRecordRegexp, nameServer)
+	trace = append(trace, tlsrptTrace...)
+	if !zdns.IsMissingStatus(tlsrptStatus) && tlsrptStatus != zdns.StatusNoError {
+		res.Errors = append(res.Errors, fmt.Sprintf("TLSRPT lookup failed with status %s", tlsrptStatus))
+	} else if len(tlsrptRecords) > 1 {
+		res.Errors = append(res.Errors, "more than one TLSRPT record")
+	} else if len(tlsrptRecords) == 1 {
+		res.TLSRPT = &TLSRPTRecord{Record: tlsrptRecords[0]}
+		tags, tagErr := parseTags(tlsrptRecords[0])
+		for _, uri := range strings.Split(tags["rua"], ",") {
+			if uri = strings.TrimSpace(uri); len(uri) > 0 {
+				res.TLSRPT.RUA = append(res.TLSRPT.RUA, uri)
+			}
+		}
+		delete(tags, "v")
+		delete(tags, "rua")
+		if len(tags) > 0 {
+			res.TLSRPT.Extensions = tags
+		}
+		if tagErr != nil {
+			res.Errors = append(res.Errors, "invalid TLSRPT record: "+tagErr.Error())
+		} else if len(res.TLSRPT.RUA) == 0 {
+			res.Errors = append(res.Errors, "invalid TLSRPT record: missing rua")
+		}
+	}
+
+	mxHosts, mxTrace, mxStatus := mod.lookupMX(r, domain, nameServer)
+	trace = append(trace, mxTrace...)
+	res.MXHosts = mxHosts
+	if !zdns.IsMissingStatus(mxStatus) && mxStatus != zdns.StatusNoError {
+		res.Errors = append(res.Errors, fmt.Sprintf("MX lookup failed with status %s", mxStatus))
+	}
+
+	if mod.FetchPolicy && res.MTASTS != nil {
+		res.Policy = mod.fetchPolicy(domain)
+		if len(res.Policy.Error) == 0 {
+			for i := range res.MXHosts {
+				matched := matchesPolicy(res.Policy.MX, res.MXHosts[i].Name)
+				res.MXHosts[i].PolicyMatch = &matched
+			}
+		}
+	}
+
+	if res.MTASTS == nil && res.TLSRPT == nil && len(res.Errors) == 0 {
+		return res, trace, zdns.StatusNoRecord, nil
+	}
+	return res, trace, zdns.StatusNoError, nil
+}
+
+// lookupTXT returns the TXT records at name that match re, with the strings of each record joined together
+func (mod *MTASTSLookupModule) lookupTXT(r *zdns.Resolver, name string, re *regexp.Regexp, nameServer *zdns.NameServer) ([]string, zdns.Trace, zdns.Status, error) {
+	res, trace, status, err := mod.LookupType(r, name, dns.TypeTXT, nameServer)
+	if status != zdns.StatusNoError || res == nil {
+		return nil, trace, status, err
+	}
+	var records []string
+	for _, a := range res.Answers {
+		ans, ok := a.(zdns.Answer)
+		if !ok || ans.RrType != dns.TypeTXT {
+			continue
+		}
+		if record := strings.ReplaceAll(ans.Answer, "\n", ""); re.MatchString(record) {
+			records = append(records, record)
+		}
+	}
+	return records, trace, status, nil
+}
+
+// lookupMX returns the MX hosts of domain, sorted by preference
+func (mod *MTASTSLookupModule) lookupMX(r *zdns.Resolver, domain string, nameServer *zdns.NameServer) ([]MXHost, zdns.Trace, zdns.Status) {
+	exchanges, _, trace, status, _ := mod.mx.LookupExchanges(r, domain, nameServer)
+	if status != zdns.StatusNoError {
+		return nil, trace, status
+	}
+	var hosts []MXHost
+	for _, exchange := range exchanges {
+		hosts = append(hosts, MXHost{Name: strings.ToLower(exchange.Name), Preference: exchange.Preference})
+	}
+	sort.SliceStable(hosts, func(i, j int) bool { return hosts[i].Preference < hosts[j].Preference })
+	return hosts, trace, status
+}
+
+// parseTags parses the semicolon separated key=value pairs of an MTA-STS or TLSRPT record
+func parseTags(record string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(record, ";") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+		key, value, found := strings.Cut(part, "=")
+		if !found || len(key) == 0 {
+			return tags, fmt.Errorf("invalid field: %s", part)
+		}
+		if _, ok := tags[key]; ok {
+			return tags, fmt.Errorf("duplicate field: %s", key)
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
+
+// fetchPolicy fetches and parses the MTA-STS policy of domain (RFC 8461, Section 3.2)
+func (mod *MTASTSLookupModule) fetchPolicy(domain string) *Policy {
+	policy := &Policy{URL: "https://mta-sts." + domain + policyPath}
+	resp, err := mod.client.Get(policy.URL)
+	if err != nil {
+		policy.Error = err.Error()
+		return policy
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		policy.Error = "unexpected HTTP status " + resp.Status
+		return policy
+	}
+	if mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";"); !strings.EqualFold(strings.TrimSpace(mediaType), "text/plain") {
+		policy.Error = "unexpected content type " + resp.Header.Get("Content-Type")
+		return policy
+	}
+	if err = parsePolicy(io.LimitReader(resp.Body, maxPolicySize), policy); err != nil {
+		policy.Error = err.Error()
+	}
+	return policy
+}
+
+// parsePolicy parses the key: value lines of a policy file into policy
+func parsePolicy(body io.Reader, policy *Policy) error {
+	s := bufio.NewScanner(body)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 {
+			continue
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			return fmt.Errorf("invalid policy line: %s", line)
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "version":
+			policy.Version = value
+		case "mode":
+			policy.Mode = value
+		case "mx":
+			policy.MX = append(policy.MX, strings.ToLower(value))
+		case "max_age":
+			maxAge, err := strconv.Atoi(value)
+			if err != nil || maxAge < 0 || maxAge > maxMaxAge {
+				return fmt.Errorf("invalid max_age: %s", value)
+			}
+			policy.MaxAge = maxAge
+		}
+	}
+	if err := s.Err(); err != nil {
+		return errors.Wrap(err, "unable to read policy")
+	}
+	if policy.Version != "STSv1" {
+		return errors.New("missing or invalid version")
+	}
+	if policy.Mode != "enforce" && policy.Mode != "testing" && policy.Mode != "none" {
+		return errors.New("missing or invalid mode")
+	}
+	if policy.Mode != "none" && len(policy.MX) == 0 {
+		return errors.New("missing mx")
+	}
+	return nil
+}
+
+// matchesPolicy returns true if host matches one of the mx patterns of a policy. A leading wildcard matches exactly
+// one label (RFC 8461, Section 4.1).
+func matchesPolicy(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if pattern == host {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if label, rest, found := strings.Cut(host, "."); found && len(label) > 0 && rest == suffix {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (mod *MTASTSLookupModule) Help() string {
+	return ""
+}
+
+func (mod *MTASTSLookupModule) Validate(args []string) error {
+	return nil
+}
+
+func (mod *MTASTSLookupModule) GetDescription() string {
+	return "MTASTS returns the MTA-STS and SMTP TLS Reporting records of a domain along with its MX hosts, optionally checking them against the MTA-STS policy file."
+}
+
+func (mod *MTASTSLookupModule) NewFlags() interface{} {
+	return mod
+}
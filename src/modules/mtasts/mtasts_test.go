@@ -0,0 +1,164 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package mtasts
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/zmap/zdns/src/cli"
+	"github.com/zmap/zdns/src/zdns"
+	"github.com/zmap/zdns/src/zdns/testserver"
+	"github.com/zmap/zdns/src/zdns/testserver/testresolver"
+)
+
+const testZone = `
+@ SOA ns1 hostmaster 1 7200 900 1209600 300
+@ MX 20 mx2.example.test.
+@ MX 10 mx1.example.test.
+@ MX 30 backup.other.test.
+_mta-sts TXT "v=STSv1; id=20240101T000000;"
+_smtp._tls TXT "v=TLSRPTv1; " "rua=mailto:tlsrpt@example.test,https://reports.example.test/v1"
+_mta-sts.invalid TXT "v=STSv1; id=not-alphanumeric"
+_mta-sts.invalid TXT "unrelated record"
+invalid MX 10 mx.invalid.example.test.
+_mta-sts.duplicate TXT "v=STSv1; id=1"
+_mta-sts.duplicate TXT "v=STSv1; id=2"
+_smtp._tls.duplicate TXT "v=TLSRPTv1; rua=mailto:a@example.test"
+_smtp._tls.duplicate TXT "v=TLSRPTv1; rua=mailto:b@example.test"
+none MX 10 mx.none.example.test.
+`
+
+// newTestResolver serves testZone on loopback and returns a resolver using it as its external name server
+func newTestResolver(t *testing.T) *zdns.Resolver {
+	network := testserver.NewNetwork()
+	server := network.AddServer(testserver.MustParseZone("example.test.", testZone))
+	require.NoError(t, network.Start())
+	t.Cleanup(network.Close)
+
+	return testresolver.New(t, testresolver.Config(network, server))
+}
+
+func initModule(t *testing.T, fetchPolicy bool) *MTASTSLookupModule {
+	mod := &MTASTSLookupModule{FetchPolicy: fetchPolicy, PolicyTimeout: time.Second}
+	require.NoError(t, mod.CLIInit(&cli.CLIConf{}, &zdns.ResolverConfig{}))
+	return mod
+}
+
+func TestMTASTSLookup(t *testing.T) {
+	r := newTestResolver(t)
+	mod := initModule(t, false)
+
+	res, _, status, err := mod.Lookup(r, "example.test", nil)
+	require.NoError(t, err)
+	require.Equal(t, zdns.StatusNoError, status)
+	result := res.(*Result)
+	require.Empty(t, result.Errors)
+	require.Equal(t, "20240101T000000", result.MTASTS.ID)
+	require.Equal(t, "v=TLSRPTv1; rua=mailto:tlsrpt@example.test,https://reports.example.test/v1", result.TLSRPT.Record)
+	require.Equal(t, []string{"mailto:tlsrpt@example.test", "https://reports.example.test/v1"}, result.TLSRPT.RUA)
+	require.Equal(t, []MXHost{
+		{Name: "mx1.example.test", Preference: 10},
+		{Name: "mx2.example.test", Preference: 20},
+		{Name: "backup.other.test", Preference: 30},
+	}, result.MXHosts)
+	require.Nil(t, result.Policy)
+}
+
+func TestMTASTSLookupErrors(t *testing.T) {
+	r := newTestResolver(t)
+	mod := initModule(t, false)
+
+	res, _, status, _ := mod.Lookup(r, "invalid.example.test", nil)
+	require.Equal(t, zdns.StatusNoError, status)
+	result := res.(*Result)
+	require.Equal(t, "v=STSv1; id=not-alphanumeric", result.MTASTS.Record)
+	require.Equal(t, []string{"invalid MTA-STS record: missing or invalid id"}, result.Errors)
+	require.Nil(t, result.TLSRPT)
+
+	res, _, status, _ = mod.Lookup(r, "duplicate.example.test", nil)
+	require.Equal(t, zdns.StatusNoError, status)
+	result = res.(*Result)
+	require.Nil(t, result.MTASTS)
+	require.Nil(t, result.TLSRPT)
+	require.Equal(t, []string{"more than one MTA-STS record", "more than one TLSRPT record"}, result.Errors)
+
+	res, _, status, _ = mod.Lookup(r, "none.example.test", nil)
+	require.Equal(t, zdns.StatusNoRecord, status)
+	require.Equal(t, []MXHost{{Name: "mx.none.example.test", Preference: 10}}, res.(*Result).MXHosts)
+}
+
+func TestMTASTSLookupPolicy(t *testing.T) {
+	r := newTestResolver(t)
+	policy := "version: STSv1\r\nmode: enforce\r\nmx: mx1.example.test\r\nmx: *.example.test\r\nmax_age: 604800\r\n"
+	var requested string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requested = req.Host + req.URL.Path
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, policy)
+	}))
+	defer server.Close()
+
+	mod := initModule(t, true)
+	mod.client = server.Client()
+	transport := mod.client.Transport.(*http.Transport)
+	// the test certificate is only valid for example.com, and every policy host dials the test server
+	transport.TLSClientConfig.ServerName = "example.com"
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+
+	res, _, status, err := mod.Lookup(r, "example.test", nil)
+	require.NoError(t, err)
+	require.Equal(t, zdns.StatusNoError, status)
+	result := res.(*Result)
+	require.Equal(t, "mta-sts.example.test/.well-known/mta-sts.txt", requested)
+	require.Equal(t, &Policy{
+		URL:     "https://mta-sts.example.test/.well-known/mta-sts.txt",
+		Version: "STSv1",
+		Mode:    "enforce",
+		MX:      []string{"mx1.example.test", "*.example.test"},
+		MaxAge:  604800,
+	}, result.Policy)
+	var matches []bool
+	for _, host := range result.MXHosts {
+		require.NotNil(t, host.PolicyMatch)
+		matches = append(matches, *host.PolicyMatch)
+	}
+	require.Equal(t, []bool{true, true, false}, matches)
+
+	policy = strings.Replace(policy, "mode: enforce", "mode: strict", 1)
+	res, _, _, _ = mod.Lookup(r, "example.test", nil)
+	result = res.(*Result)
+	require.Equal(t, "missing or invalid mode", result.Policy.Error)
+	require.Nil(t, result.MXHosts[0].PolicyMatch)
+}
+
+func TestMatchesPolicy(t *testing.T) {
+	patterns := []string{"mail.example.com", "*.mx.example.com"}
+	require.True(t, matchesPolicy(patterns, "mail.example.com"))
+	require.True(t, matchesPolicy(patterns, "a.mx.example.com"))
+	require.False(t, matchesPolicy(patterns, "mx.example.com"))
+	require.False(t, matchesPolicy(patterns, "a.b.mx.example.com"))
+	require.False(t, matchesPolicy(patterns, "other.example.com"))
+}
@@ -88,9 +88,9 @@ func (mxMod *MXLookupModule) lookupIPs(r *zdns.Resolver, name string, nameServer
 	return retv, trace
 }
 
-func (mxMod *MXLookupModule) Lookup(r *zdns.Resolver, lookupName string, nameServer *zdns.NameServer) (interface{}, zdns.Trace, zdns.Status, error) {
-	ipMode := zdns.GetIPVersionMode(mxMod.IPv4Lookup, mxMod.IPv6Lookup)
-	retv := MXResult{Servers: []MXRecord{}}
+// LookupExchanges looks up the MX records of lookupName, without resolving their addresses. The result of the MX lookup
+// is returned as well, for callers that need its DNSSEC validation result.
+func (mxMod *MXLookupModule) LookupExchanges(r *zdns.Resolver, lookupName string, nameServer *zdns.NameServer) ([]MXRecord, *zdns.SingleQueryResult, zdns.Trace, zdns.Status, error) {
 	var res *zdns.SingleQueryResult
 	var trace zdns.Trace
 	var status zdns.Status
@@ -101,20 +101,33 @@ func (mxMod *MXLookupModule) Lookup(r *zdns.Resolver, lookupName string, nameSer
 		res, trace, status, err = r.ExternalLookup(context.Background(), &zdns.Question{Name: lookupName, Type: dns.TypeMX, Class: dns.ClassINET}, nameServer)
 	}
 	if status != zdns.StatusNoError || err != nil {
-		return nil, trace, status, err
+		return nil, res, trace, status, err
 	}
 
+	records := []MXRecord{}
 	for _, ans := range res.Answers {
 		if mxAns, ok := ans.(zdns.PrefAnswer); ok {
-			lookupName = strings.TrimSuffix(mxAns.Answer.Answer, ".")
-			rec := MXRecord{TTL: mxAns.TTL, Type: mxAns.Type, Class: mxAns.Class, Name: lookupName, Preference: mxAns.Preference}
-			ips, secondTrace := mxMod.lookupIPs(r, lookupName, nameServer, ipMode)
-			rec.IPv4Addresses = ips.IPv4Addresses
-			rec.IPv6Addresses = ips.IPv6Addresses
-			retv.Servers = append(retv.Servers, rec)
-			trace = append(trace, secondTrace...)
+			name := strings.TrimSuffix(mxAns.Answer.Answer, ".")
+			records = append(records, MXRecord{TTL: mxAns.TTL, Type: mxAns.Type, Class: mxAns.Class, Name: name, Preference: mxAns.Preference})
 		}
 	}
+	return records, res, trace, zdns.StatusNoError, nil
+}
+
+func (mxMod *MXLookupModule) Lookup(r *zdns.Resolver, lookupName string, nameServer *zdns.NameServer) (interface{}, zdns.Trace, zdns.Status, error) {
+	ipMode := zdns.GetIPVersionMode(mxMod.IPv4Lookup, mxMod.IPv6Lookup)
+	records, _, trace, status, err := mxMod.LookupExchanges(r, lookupName, nameServer)
+	if status != zdns.StatusNoError || err != nil {
+		return nil, trace, status, err
+	}
+
+	retv := MXResult{Servers: records}
+	for i := range retv.Servers {
+		ips, secondTrace := mxMod.lookupIPs(r, retv.Servers[i].Name, nameServer, ipMode)
+		retv.Servers[i].IPv4Addresses = ips.IPv4Addresses
+		retv.Servers[i].IPv6Addresses = ips.IPv6Addresses
+		trace = append(trace, secondTrace...)
+	}
 	return &retv, trace, zdns.StatusNoError, nil
 }
 
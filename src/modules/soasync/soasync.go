@@ -0,0 +1,238 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+/*
+ * SOA serials are compared with serial number arithmetic, since they wrap around.
+ * RFC reference: https://www.rfc-editor.org/rfc/rfc1982
+ */
+
+package soasync
+
+import (
+	"net"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+
+	"github.com/zmap/zdns/src/cli"
+	"github.com/zmap/zdns/src/zdns"
+)
+
+// Reasons a name server isn't queried
+const (
+	reasonUnresolvable = "unresolvable"
+	reasonNotQueried   = "not queried"
+)
+
+// Server is the response of one address of a name server of the zone to the SOA query. A name server that has no
+// address to query has a single entry without an address, whose Reason is why.
+type Server struct {
+	Name          string `json:"name" groups:"short,normal,long,trace"`
+	Address       string `json:"address,omitempty" groups:"short,normal,long,trace"`
+	Status        string `json:"status,omitempty" groups:"short,normal,long,trace"`
+	Reason        string `json:"reason,omitempty" groups:"short,normal,long,trace"`
+	Authoritative bool   `json:"authoritative" groups:"short,normal,long,trace"`
+	// Serial is only set if the server answered authoritatively with the SOA of the zone
+	Serial *uint32 `json:"serial,omitempty" groups:"short,normal,long,trace"`
+	// Behind is set if Serial is less than the latest serial of the zone
+	Behind bool `json:"behind" groups:"short,normal,long,trace"`
+}
+
+type Result struct {
+	Servers []Server `json:"servers" groups:"short,normal,long,trace"`
+	// Serials are the distinct serials the servers answered with, from the latest if they're comparable and in numeric
+	// order otherwise
+	Serials []uint32 `json:"serials,omitempty" groups:"short,normal,long,trace"`
+	// Comparable is set if the serials span less than 2^31, so that the latest is well defined
+	Comparable   bool    `json:"comparable" groups:"short,normal,long,trace"`
+	LatestSerial *uint32 `json:"latest_serial,omitempty" groups:"short,normal,long,trace"`
+	BehindCount  int     `json:"behind_count" groups:"short,normal,long,trace"`
+	// InSync is set if every server that answered with a serial answered with the same one
+	InSync bool `json:"in_sync" groups:"short,normal,long,trace"`
+	// UnqueriedCount is the number of name servers without an address to query
+	UnqueriedCount int `json:"unqueried_count" groups:"short,normal,long,trace"`
+}
+
+func init() {
+	mod := new(SOASyncModule)
+	cli.RegisterLookupModule("SOASYNC", mod)
+}
+
+type SOASyncModule struct {
+	cli.BasicLookupModule
+	queryIPv4 bool
+	queryIPv6 bool
+}
+
+// CLIInit initializes the SOASync module
+func (mod *SOASyncModule) CLIInit(gc *cli.CLIConf, rc *zdns.ResolverConfig) error {
+	if gc.LookupAllNameServers {
+		return errors.New("SOASYNC module always queries all name servers, --all-nameservers is not needed")
+	}
+	mod.DNSType = dns.TypeSOA
+	mod.DNSClass = dns.ClassINET
+	mod.Init(rc.IPVersionMode != zdns.IPv6Only, rc.IPVersionMode != zdns.IPv4Only)
+	return mod.BasicLookupModule.CLIInit(gc, rc)
+}
+
+// Init sets which addresses of the name servers are queried, only those the resolver can reach should be
+func (mod *SOASyncModule) Init(queryIPv4, queryIPv6 bool) {
+	mod.queryIPv4 = queryIPv4
+	mod.queryIPv6 = queryIPv6
+}
+
+// Lookup looks up the name servers of the zone lookupName and their addresses, and queries each address for the SOA
+// of the zone. Servers whose serial is less than the latest serial of any server are behind. Servers whose name doesn't
+// resolve, or only resolves to addresses of an IP version the resolver doesn't use, are reported as not queried.
+func (mod *SOASyncModule) Lookup(r *zdns.Resolver, lookupName string, nameServer *zdns.NameServer) (interface{}, zdns.Trace, zdns.Status, error) {
+	zone := strings.ToLower(strings.TrimSuffix(lookupName, "."))
+	nsRes, trace, status, err := r.DoNSLookup(zone, nameServer, mod.IsIterative, true, true)
+	if status != zdns.StatusNoError {
+		return nil, trace, status, err
+	}
+	if len(nsRes.Servers) == 0 {
+		return nil, trace, zdns.StatusNoRecord, errors.New("no name servers found for zone " + zone)
+	}
+
+	res := &Result{}
+	var targets []zdns.NameServer
+	for _, ns := range nsRes.Servers {
+		var addrs []string
+		if mod.queryIPv4 {
+			addrs = append(addrs, ns.IPv4Addresses...)
+		}
+		if mod.queryIPv6 {
+			addrs = append(addrs, ns.IPv6Addresses...)
+		}
+		queried := false
+		for _, addr := range zdns.Unique(addrs) {
+			if ip := net.ParseIP(addr); ip != nil {
+				targets = append(targets, zdns.NameServer{IP: ip, DomainName: strings.ToLower(ns.Name)})
+				queried = true
+			}
+		}
+		if !queried {
+			reason := reasonNotQueried
+			if len(ns.IPv4Addresses) == 0 && len(ns.IPv6Addresses) == 0 {
+				reason = reasonUnresolvable
+			}
+			res.Servers = append(res.Servers, Server{Name: strings.ToLower(ns.Name), Reason: reason})
+			res.UnqueriedCount++
+		}
+	}
+	q := &zdns.Question{Name: zone, Type: mod.DNSType, Class: mod.DNSClass}
+	nsResults, soaTrace, err := r.LookupEachNameServer(q, targets)
+	trace = append(trace, soaTrace...)
+	if err != nil {
+		return nil, trace, zdns.StatusTimeout, err
+	}
+
+	for _, nsRes := range nsResults {
+		server := Server{
+			Name:          nsRes.NameServer.DomainName,
+			Address:       nsRes.NameServer.IP.String(),
+			Status:        string(nsRes.Status),
+			Authoritative: nsRes.Status == zdns.StatusNoError && nsRes.Res.Flags.Authoritative,
+		}
+		if server.Authoritative {
+			server.Serial = soaSerial(nsRes.Res.Answers, zone)
+		}
+		res.Servers = append(res.Servers, server)
+	}
+
+	for _, server := range res.Servers {
+		if server.Serial != nil && !slices.Contains(res.Serials, *server.Serial) {
+			res.Serials = append(res.Serials, *server.Serial)
+		}
+	}
+	if latest, ok := latestSerial(res.Serials); ok {
+		res.Comparable = true
+		res.LatestSerial = &latest
+		sort.SliceStable(res.Serials, func(i, j int) bool { return serialLess(res.Serials[j], res.Serials[i]) })
+		for i := range res.Servers {
+			if res.Servers[i].Serial != nil && serialLess(*res.Servers[i].Serial, latest) {
+				res.Servers[i].Behind = true
+				res.BehindCount++
+			}
+		}
+	} else {
+		slices.Sort(res.Serials)
+	}
+	res.InSync = len(res.Serials) == 1
+	sort.SliceStable(res.Servers, func(i, j int) bool {
+		if res.Servers[i].Name != res.Servers[j].Name {
+			return res.Servers[i].Name < res.Servers[j].Name
+		}
+		return res.Servers[i].Address < res.Servers[j].Address
+	})
+	return res, trace, zdns.StatusNoError, nil
+}
+
+// soaSerial returns the serial of the SOA record of zone in answers, or nil if there isn't one
+func soaSerial(answers []interface{}, zone string) *uint32 {
+	for _, a := range answers {
+		if soa, ok := a.(zdns.SOAAnswer); ok && strings.EqualFold(strings.TrimSuffix(soa.Name, "."), zone) {
+			serial := soa.Serial
+			return &serial
+		}
+	}
+	return nil
+}
+
+// latestSerial returns the latest of the distinct serials, or false if there are none or they can't be ordered. Serial
+// number arithmetic is only transitive for serials that all lie within less than 2^31 of each other, which is the case
+// if going around the serial space from the smallest to the largest, there's a gap of more than 2^31 between two
+// neighbouring serials. The serial before that gap is the latest.
+func latestSerial(serials []uint32) (uint32, bool) {
+	const space = 1 << 32
+	sorted := slices.Clone(serials)
+	slices.Sort(sorted)
+	for i, serial := range sorted {
+		// the gap to the next serial, wrapping around to the smallest one after the largest
+		gap := uint64(space)
+		if len(sorted) > 1 {
+			gap = (uint64(sorted[(i+1)%len(sorted)]) + space - uint64(serial)) % space
+		}
+		if gap > space/2 {
+			return serial, true
+		}
+	}
+	return 0, false
+}
+
+// serialLess returns whether serial s1 is less than s2 in serial number arithmetic with SERIAL_BITS of 32 (RFC 1982,
+// Section 3.2). Serials that are exactly 2^31 apart can't be compared, and neither is less than the other.
+func serialLess(s1, s2 uint32) bool {
+	const half = 1 << 31
+	return (s1 < s2 && s2-s1 < half) || (s1 > s2 && s1-s2 > half)
+}
+
+func (mod *SOASyncModule) Help() string {
+	return ""
+}
+
+func (mod *SOASyncModule) Validate(args []string) error {
+	return nil
+}
+
+func (mod *SOASyncModule) GetDescription() string {
+	return "SOASYNC queries every IPv4 and IPv6 address of every name server of a zone for its SOA, reporting the serials and the servers that are behind."
+}
+
+func (mod *SOASyncModule) NewFlags() interface{} {
+	return mod
+}
@@ -0,0 +1,181 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package soasync
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/zmap/zdns/src/cli"
+	"github.com/zmap/zdns/src/zdns"
+	"github.com/zmap/zdns/src/zdns/testserver"
+	"github.com/zmap/zdns/src/zdns/testserver/testresolver"
+)
+
+// zones returns example.test., wrap.test. and split.test. with the given serials, all served by ns1, ns2 and ns3, with
+// ns1 also on ::1. ns4 has no addresses and ns5 only has an IPv6 address, ::1.
+func zones(ns1, ns2, ns3 net.IP, exampleSerial, wrapSerial, splitSerial string) []*testserver.Zone {
+	records := `
+@ NS ns1.example.test.
+@ NS ns2.example.test.
+@ NS ns3.example.test.
+@ NS ns4.example.test.
+@ NS ns5.example.test.
+`
+	glue := `
+ns1 A ` + ns1.String() + `
+ns1 AAAA ::1
+ns2 A ` + ns2.String() + `
+ns3 A ` + ns3.String() + `
+ns5 AAAA ::1`
+	return []*testserver.Zone{
+		testserver.MustParseZone("example.test.", `@ SOA ns1 hostmaster `+exampleSerial+` 7200 900 1209600 300`+records+glue),
+		testserver.MustParseZone("wrap.test.", `@ SOA ns1.example.test. hostmaster.example.test. `+wrapSerial+` 7200 900 1209600 300`+records),
+		testserver.MustParseZone("split.test.", `@ SOA ns1.example.test. hostmaster.example.test. `+splitSerial+` 7200 900 1209600 300`+records),
+	}
+}
+
+// initTest serves the zones from ns1 and ns2 with different serials, while ns3 refuses queries for them
+func initTest(t *testing.T) (*zdns.Resolver, *zdns.ResolverConfig, []*testserver.Server) {
+	network := testserver.NewNetwork()
+	servers := []*testserver.Server{network.AddServer(), network.AddServer(), network.AddServer()}
+	servers[0].Zones = zones(servers[0].IP, servers[1].IP, servers[2].IP, "5", "1", "0")
+	servers[1].Zones = zones(servers[0].IP, servers[1].IP, servers[2].IP, "4", "4294967295", "2147483648")
+	require.NoError(t, network.Start())
+	t.Cleanup(network.Close)
+
+	rc := testresolver.Config(network, servers[0])
+	r := testresolver.New(t, rc)
+	return r, rc, servers
+}
+
+func TestSOASync(t *testing.T) {
+	r, rc, servers := initTest(t)
+	mod := &SOASyncModule{}
+	require.NoError(t, mod.CLIInit(&cli.CLIConf{}, rc))
+
+	res, _, status, err := mod.Lookup(r, "example.test", nil)
+	require.NoError(t, err)
+	require.Equal(t, zdns.StatusNoError, status)
+	result := res.(*Result)
+	five, four := uint32(5), uint32(4)
+	// the IPv6 addresses of ns1 and ns5 aren't queried, since the resolver is IPv4 only
+	require.Equal(t, []Server{
+		{Name: "ns1.example.test", Address: servers[0].IP.String(), Status: "NOERROR", Authoritative: true, Serial: &five},
+		{Name: "ns2.example.test", Address: servers[1].IP.String(), Status: "NOERROR", Authoritative: true, Serial: &four, Behind: true},
+		{Name: "ns3.example.test", Address: servers[2].IP.String(), Status: "REFUSED"},
+		{Name: "ns4.example.test", Reason: reasonUnresolvable},
+		{Name: "ns5.example.test", Reason: reasonNotQueried},
+	}, result.Servers)
+	require.Equal(t, 2, result.UnqueriedCount)
+	require.Equal(t, []uint32{5, 4}, result.Serials)
+	require.True(t, result.Comparable)
+	require.Equal(t, uint32(5), *result.LatestSerial)
+	require.Equal(t, 1, result.BehindCount)
+	require.False(t, result.InSync)
+
+	// serial 1 is after 4294967295, since serials wrap around
+	res, _, status, _ = mod.Lookup(r, "wrap.test", nil)
+	require.Equal(t, zdns.StatusNoError, status)
+	result = res.(*Result)
+	require.Equal(t, []uint32{1, 4294967295}, result.Serials)
+	require.True(t, result.Comparable)
+	require.Equal(t, uint32(1), *result.LatestSerial)
+	require.True(t, result.Servers[1].Behind)
+
+	// serials 2^31 apart can't be ordered, so neither server is behind
+	res, _, status, _ = mod.Lookup(r, "split.test", nil)
+	require.Equal(t, zdns.StatusNoError, status)
+	result = res.(*Result)
+	require.Equal(t, []uint32{0, 2147483648}, result.Serials)
+	require.False(t, result.Comparable)
+	require.Nil(t, result.LatestSerial)
+	require.Zero(t, result.BehindCount)
+	require.False(t, result.InSync)
+
+	_, _, status, _ = mod.Lookup(r, "ns1.example.test", nil)
+	require.Equal(t, zdns.StatusNoRecord, status)
+}
+
+func TestSOASyncIPv6(t *testing.T) {
+	_, rc, servers := initTest(t)
+	// the server on ::1, which is both ns1 and ns5, is behind the IPv4 servers
+	ipv6Server := testserver.NewServer(net.IPv6loopback, zones(servers[0].IP, servers[1].IP, servers[2].IP, "3", "1", "0")...)
+	if err := ipv6Server.Start(testserver.Ports{DNS: servers[0].Ports().DNS}, tls.Certificate{}); err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	t.Cleanup(ipv6Server.Close)
+	rc.IPVersionMode = zdns.IPv4OrIPv6
+	rc.LocalAddrsV6 = []net.IP{net.IPv6loopback}
+	rc.RootNameServersV6 = []zdns.NameServer{{IP: ipv6Server.IP, Port: rc.RootNameServersV4[0].Port}}
+	rc.ExternalNameServersV6 = rc.RootNameServersV6
+	r := testresolver.New(t, rc)
+	mod := &SOASyncModule{}
+	require.NoError(t, mod.CLIInit(&cli.CLIConf{}, rc))
+
+	res, _, status, err := mod.Lookup(r, "example.test", nil)
+	require.NoError(t, err)
+	require.Equal(t, zdns.StatusNoError, status)
+	result := res.(*Result)
+	five, four, three := uint32(5), uint32(4), uint32(3)
+	require.Equal(t, []Server{
+		{Name: "ns1.example.test", Address: servers[0].IP.String(), Status: "NOERROR", Authoritative: true, Serial: &five},
+		{Name: "ns1.example.test", Address: "::1", Status: "NOERROR", Authoritative: true, Serial: &three, Behind: true},
+		{Name: "ns2.example.test", Address: servers[1].IP.String(), Status: "NOERROR", Authoritative: true, Serial: &four, Behind: true},
+		{Name: "ns3.example.test", Address: servers[2].IP.String(), Status: "REFUSED"},
+		{Name: "ns4.example.test", Reason: reasonUnresolvable},
+		{Name: "ns5.example.test", Address: "::1", Status: "NOERROR", Authoritative: true, Serial: &three, Behind: true},
+	}, result.Servers)
+	require.Equal(t, []uint32{5, 4, 3}, result.Serials)
+	require.Equal(t, 3, result.BehindCount)
+	require.Equal(t, 1, result.UnqueriedCount)
+}
+
+func TestSerialLess(t *testing.T) {
+	require.True(t, serialLess(1, 2))
+	require.False(t, serialLess(2, 1))
+	require.False(t, serialLess(7, 7))
+	require.True(t, serialLess(4294967295, 0))
+	require.True(t, serialLess(4294967000, 100))
+	require.False(t, serialLess(100, 4294967000))
+	// serials 2^31 apart are undefined, neither is less than the other
+	require.False(t, serialLess(0, 1<<31))
+	require.False(t, serialLess(1<<31, 0))
+}
+
+func TestLatestSerial(t *testing.T) {
+	for _, test := range []struct {
+		serials    []uint32
+		latest     uint32
+		comparable bool
+	}{
+		{serials: []uint32{7}, latest: 7, comparable: true},
+		{serials: []uint32{4, 5, 1}, latest: 5, comparable: true},
+		{serials: []uint32{4294967295, 1, 4294967000}, latest: 1, comparable: true},
+		{serials: []uint32{0, 1<<31 - 1}, latest: 1<<31 - 1, comparable: true},
+		// serials 2^31 apart, or spanning more, can't be ordered whichever server answered first
+		{serials: []uint32{0, 1 << 31}},
+		{serials: []uint32{0, 1 << 30, 3 << 30}},
+		{serials: []uint32{3 << 30, 1 << 30, 0}},
+		{},
+	} {
+		latest, ok := latestSerial(test.serials)
+		require.Equal(t, test.comparable, ok, test.serials)
+		require.Equal(t, test.latest, latest, test.serials)
+	}
+}
@@ -0,0 +1,487 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+/*
+ * Evaluation of SPF policies.
+ * RFC reference: https://datatracker.ietf.org/doc/html/rfc7208#section-4
+ */
+
+package spf
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/zmap/zdns/src/zdns"
+)
+
+const (
+	// RFC 7208, Section 4.6.4
+	maxDNSLookups  = 10 // terms that cause DNS queries: include, a, mx, ptr, exists and redirect
+	maxVoidLookups = 2  // DNS queries answered with NXDOMAIN or no records
+	maxMXHosts     = 10 // exchanges looked up for a single mx mechanism
+	maxPTRNames    = 10 // host names validated for a single ptr mechanism
+
+	resultNone      = "none"
+	resultNeutral   = "neutral"
+	resultPass      = "pass"
+	resultPermError = "permerror"
+	resultTempError = "temperror"
+)
+
+// evaluation holds the state of evaluating the SPF policy of a single domain. Every term is resolved, not only those
+// up to the first match, so that the policy tree and the authorized ranges are complete. The DNS lookup limits apply
+// in the order check_host() would reach the terms.
+type evaluation struct {
+	mod         *SpfLookupModule
+	r           *zdns.Resolver
+	nameServer  *zdns.NameServer
+	sender      string
+	dnsLookups  int
+	voidLookups int
+	// aborted is set once a lookup limit is exceeded, after which nothing else is resolved
+	aborted  bool
+	findings []Finding
+	trace    zdns.Trace
+}
+
+// spfRecords returns the SPF records among TXT answers. A TXT record split into several strings is joined back
+// together before matching.
+func spfRecords(answers []interface{}) []string {
+	var records []string
+	for _, a := range answers {
+		ans, ok := a.(zdns.Answer)
+		if !ok {
+			continue
+		}
+		record := strings.ReplaceAll(ans.Answer, "\n", "")
+		if spfRecordRegexp.MatchString(record) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// lookup resolves name through the same resolver as the module, returning the answers of type qType. A name that
+// doesn't exist or has no records of qType counts as a void lookup. Any other failure is returned as an error.
+func (e *evaluation) lookup(name string, qType uint16) ([]interface{}, error) {
+	res, trace, status, err := e.mod.LookupType(e.r, name, qType, e.nameServer)
+	e.trace = append(e.trace, trace...)
+	if status != zdns.StatusNoError && !zdns.IsMissingStatus(status) {
+		if err == nil {
+			err = fmt.Errorf("%s lookup of %s failed with status %s", dns.TypeToString[qType], name, status)
+		}
+		return nil, err
+	}
+	var answers []interface{}
+	if res != nil && status == zdns.StatusNoError {
+		for _, a := range res.Answers {
+			switch ans := a.(type) {
+			case zdns.Answer:
+				if ans.RrType == qType {
+					answers = append(answers, ans)
+				}
+			case zdns.PrefAnswer:
+				if ans.RrType == qType {
+					answers = append(answers, ans)
+				}
+			}
+		}
+	}
+	return answers, nil
+}
+
+// addresses looks up the A and AAAA records of name
+func (e *evaluation) addresses(name string) (ipv4, ipv6 []net.IP, err error) {
+	for _, qType := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		answers, err := e.lookup(name, qType)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, a := range answers {
+			ip := net.ParseIP(a.(zdns.Answer).Answer)
+			if ip == nil {
+				continue
+			}
+			if qType == dns.TypeA {
+				ipv4 = append(ipv4, ip)
+			} else {
+				ipv6 = append(ipv6, ip)
+			}
+		}
+	}
+	return ipv4, ipv6, nil
+}
+
+// fail records a permerror or temperror for a term of the policy of domain. A term of "" is an error of the policy as a
+// whole.
+func (e *evaluation) fail(errType, domain, term, message string) {
+	e.findings = append(e.findings, Finding{Type: errType, Domain: domain, Term: term, Message: message})
+}
+
+// countLookup counts a term that causes DNS queries against the limit, returning false if it's exceeded
+func (e *evaluation) countLookup(p *Policy, t *Term) bool {
+	e.dnsLookups++
+	if e.dnsLookups > maxDNSLookups {
+		e.aborted = true
+		t.setError(resultPermError, fmt.Sprintf("more than %d DNS lookups", maxDNSLookups))
+		e.fail(resultPermError, p.Domain, t.Term, t.Error)
+		return false
+	}
+	return true
+}
+
+// countVoid counts a void lookup against the limit, returning false if it's exceeded
+func (e *evaluation) countVoid(p *Policy, t *Term) bool {
+	e.voidLookups++
+	if e.voidLookups > maxVoidLookups {
+		e.aborted = true
+		t.setError(resultPermError, fmt.Sprintf("more than %d void lookups", maxVoidLookups))
+		e.fail(resultPermError, p.Domain, t.Term, t.Error)
+		return false
+	}
+	return true
+}
+
+// fetchPolicy looks up and evaluates the SPF record of domain, for an include mechanism or redirect modifier. A
+// domain without an SPF record is a permerror for both.
+func (e *evaluation) fetchPolicy(domain string) *Policy {
+	p := &Policy{Domain: domain}
+	answers, err := e.lookup(domain, dns.TypeTXT)
+	if err != nil {
+		p.setError(resultTempError, err.Error())
+		e.fail(resultTempError, domain, "", p.Error)
+		return p
+	}
+	records := spfRecords(answers)
+	switch len(records) {
+	case 0:
+		p.setError(resultPermError, "no SPF record")
+		e.fail(resultPermError, domain, "", p.Error)
+	case 1:
+		p.Record = records[0]
+		e.evaluatePolicy(p)
+	default:
+		p.setError(resultPermError, "more than one SPF record")
+		e.fail(resultPermError, domain, "", p.Error)
+	}
+	return p
+}
+
+// evaluatePolicy parses the record of p and resolves each of its terms. Terms after an all mechanism are never reached
+// by check_host(), so they're listed without being resolved, and a redirect modifier is ignored.
+func (e *evaluation) evaluatePolicy(p *Policy) {
+	terms, err := parseRecord(p.Record)
+	if err != nil {
+		p.setError(resultPermError, err.Error())
+		e.fail(resultPermError, p.Domain, "", p.Error)
+		return
+	}
+	mc := &macroContext{sender: e.sender, domain: p.Domain, ip: e.mod.senderIP}
+	redirect := -1
+	reachedAll := false
+	for _, t := range terms {
+		p.Terms = append(p.Terms, Term{Term: t.raw, Qualifier: t.qualifier, Mechanism: t.mechanism, Modifier: t.modifier})
+		if t.modifier == "redirect" {
+			redirect = len(p.Terms) - 1
+		}
+		if e.aborted || reachedAll || len(t.mechanism) == 0 {
+			continue
+		}
+		e.resolveMechanism(p, &p.Terms[len(p.Terms)-1], &t, mc)
+		reachedAll = t.mechanism == "all"
+	}
+	if redirect < 0 || reachedAll || e.aborted {
+		return
+	}
+	// the redirect is only followed once every mechanism has failed to match
+	rt := &p.Terms[redirect]
+	if !e.countLookup(p, rt) {
+		return
+	}
+	domain, ok := e.expand(p, rt, terms[redirect].domainSpec, mc)
+	if !ok {
+		return
+	}
+	rt.Domain = domain
+	rt.Policy = e.fetchPolicy(domain)
+}
+
+// expand expands a domain-spec for a term, recording a permerror for invalid macros. Macros that need the sender's
+// address are skipped without an error when --sender-ip isn't set.
+func (e *evaluation) expand(p *Policy, t *Term, spec string, mc *macroContext) (string, bool) {
+	domain, err := expandDomainSpec(spec, mc)
+	if err == errSenderIPRequired {
+		t.Skipped = err.Error()
+		return "", false
+	} else if err != nil {
+		t.setError(resultPermError, err.Error())
+		e.fail(resultPermError, p.Domain, t.Term, t.Error)
+		return "", false
+	}
+	return domain, true
+}
+
+// resolveMechanism fills in what a single mechanism matches
+func (e *evaluation) resolveMechanism(p *Policy, t *Term, parsed *term, mc *macroContext) {
+	switch parsed.mechanism {
+	case "all":
+		return
+	case "ip4", "ip6":
+		t.addNetwork(parsed.network)
+		return
+	}
+	if !e.countLookup(p, t) {
+		return
+	}
+	if parsed.mechanism == "ptr" && e.mod.senderIP == nil {
+		t.Skipped = errSenderIPRequired.Error()
+		return
+	}
+	domain, ok := e.expand(p, t, parsed.domainSpec, mc)
+	if !ok {
+		return
+	}
+	t.Domain = domain
+	tempError := func(err error) {
+		t.setError(resultTempError, err.Error())
+		e.fail(resultTempError, p.Domain, t.Term, t.Error)
+	}
+
+	switch parsed.mechanism {
+	case "include":
+		t.Policy = e.fetchPolicy(domain)
+	case "a":
+		ipv4, ipv6, err := e.addresses(domain)
+		if err != nil {
+			tempError(err)
+			return
+		}
+		if len(ipv4) == 0 && len(ipv6) == 0 && !e.countVoid(p, t) {
+			return
+		}
+		t.addAddresses(ipv4, ipv6, parsed.cidr4, parsed.cidr6)
+	case "mx":
+		answers, err := e.lookup(domain, dns.TypeMX)
+		if err != nil {
+			tempError(err)
+			return
+		}
+		if len(answers) == 0 && !e.countVoid(p, t) {
+			return
+		}
+		if len(answers) > maxMXHosts {
+			t.setError(resultPermError, fmt.Sprintf("more than %d MX records", maxMXHosts))
+			e.fail(resultPermError, p.Domain, t.Term, t.Error)
+			return
+		}
+		for _, a := range answers {
+			host := strings.TrimSuffix(a.(zdns.PrefAnswer).Answer.Answer, ".")
+			t.Hosts = append(t.Hosts, host)
+			ipv4, ipv6, err := e.addresses(host)
+			if err != nil {
+				tempError(err)
+				return
+			}
+			t.addAddresses(ipv4, ipv6, parsed.cidr4, parsed.cidr6)
+		}
+	case "exists":
+		answers, err := e.lookup(domain, dns.TypeA)
+		if err != nil {
+			tempError(err)
+			return
+		}
+		exists := len(answers) > 0
+		t.Exists = &exists
+		if !exists {
+			e.countVoid(p, t)
+		}
+	case "ptr":
+		e.resolvePTR(p, t, domain)
+	}
+}
+
+// resolvePTR validates the host names of the sender's address, those whose addresses include it, and matches the ptr
+// mechanism if any of them is at or below domain
+func (e *evaluation) resolvePTR(p *Policy, t *Term, domain string) {
+	reverse, err := dns.ReverseAddr(e.mod.senderIP.String())
+	if err != nil {
+		t.setError(resultPermError, err.Error())
+		return
+	}
+	answers, err := e.lookup(strings.TrimSuffix(reverse, "."), dns.TypePTR)
+	if err != nil {
+		// errors looking up the PTR records don't make the policy fail, the mechanism just doesn't match
+		t.Skipped = err.Error()
+		return
+	}
+	if len(answers) == 0 && !e.countVoid(p, t) {
+		return
+	}
+	for i, a := range answers {
+		if i == maxPTRNames {
+			break
+		}
+		host := strings.TrimSuffix(a.(zdns.Answer).Answer, ".")
+		ipv4, ipv6, err := e.addresses(host)
+		if err != nil {
+			continue
+		}
+		for _, ip := range append(ipv4, ipv6...) {
+			if ip.Equal(e.mod.senderIP) {
+				t.Hosts = append(t.Hosts, host)
+				if dns.IsSubDomain(dns.Fqdn(domain), dns.Fqdn(host)) {
+					t.matched = true
+				}
+				break
+			}
+		}
+	}
+}
+
+// setError marks the term as failing with a permerror or temperror
+func (t *Term) setError(errType, message string) {
+	t.errType = errType
+	t.Error = message
+}
+
+func (p *Policy) setError(errType, message string) {
+	p.errType = errType
+	p.Error = message
+}
+
+func (t *Term) addNetwork(network *net.IPNet) {
+	t.networks = append(t.networks, network)
+	t.Ranges = append(t.Ranges, network.String())
+}
+
+// addAddresses adds the networks of the given prefix lengths around each address, for the a and mx mechanisms
+func (t *Term) addAddresses(ipv4, ipv6 []net.IP, cidr4, cidr6 int) {
+	for _, ip := range ipv4 {
+		mask := net.CIDRMask(cidr4, 32)
+		t.addNetwork(&net.IPNet{IP: ip.To4().Mask(mask), Mask: mask})
+	}
+	for _, ip := range ipv6 {
+		mask := net.CIDRMask(cidr6, 128)
+		t.addNetwork(&net.IPNet{IP: ip.To16().Mask(mask), Mask: mask})
+	}
+}
+
+// authorizedRanges collects the networks the policy passes: those of mechanisms qualified with pass, along with those
+// of included and redirected policies. Networks of mechanisms that fail are not subtracted.
+func authorizedRanges(p *Policy, ranges map[string]struct{}) {
+	if p == nil {
+		return
+	}
+	for _, t := range p.Terms {
+		if t.Qualifier != resultPass || len(t.errType) > 0 {
+			if t.Modifier == "redirect" {
+				authorizedRanges(t.Policy, ranges)
+			}
+			continue
+		}
+		switch t.Mechanism {
+		case "all":
+			ranges["0.0.0.0/0"] = struct{}{}
+			ranges["::/0"] = struct{}{}
+		case "include":
+			authorizedRanges(t.Policy, ranges)
+		default:
+			for _, r := range t.Ranges {
+				ranges[r] = struct{}{}
+			}
+		}
+	}
+}
+
+// splitRanges sorts the authorized networks into IPv4 and IPv6
+func splitRanges(ranges map[string]struct{}) (ipv4, ipv6 []string) {
+	for r := range ranges {
+		if strings.Contains(r, ":") {
+			ipv6 = append(ipv6, r)
+		} else {
+			ipv4 = append(ipv4, r)
+		}
+	}
+	sort.Strings(ipv4)
+	sort.Strings(ipv6)
+	return ipv4, ipv6
+}
+
+// checkHost returns the result of check_host() for ip against an evaluated policy (RFC 7208, Section 4): the
+// qualifier of the first mechanism that matches, the result of the redirect if none do, or neutral.
+func checkHost(p *Policy, ip net.IP) string {
+	if len(p.errType) > 0 {
+		return p.errType
+	}
+	for _, t := range p.Terms {
+		if len(t.Mechanism) == 0 {
+			continue
+		}
+		if len(t.errType) > 0 {
+			return t.errType
+		}
+		matched := false
+		switch t.Mechanism {
+		case "all":
+			return t.Qualifier
+		case "include":
+			if t.Policy == nil {
+				// not resolved, as its domain depends on a macro that couldn't be expanded
+				continue
+			}
+			switch result := checkHost(t.Policy, ip); result {
+			case resultPass:
+				matched = true
+			case resultTempError:
+				return resultTempError
+			case resultPermError, resultNone:
+				return resultPermError
+			}
+		case "ptr":
+			matched = t.matched
+		case "exists":
+			matched = t.Exists != nil && *t.Exists
+		default:
+			for _, network := range t.networks {
+				if network.Contains(ip) {
+					matched = true
+					break
+				}
+			}
+		}
+		if matched {
+			return t.Qualifier
+		}
+	}
+	for _, t := range p.Terms {
+		if t.Modifier != "redirect" {
+			continue
+		}
+		if len(t.errType) > 0 {
+			return t.errType
+		}
+		if t.Policy != nil {
+			if result := checkHost(t.Policy, ip); result != resultNone {
+				return result
+			}
+			return resultPermError
+		}
+	}
+	return resultNeutral
+}
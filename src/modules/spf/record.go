@@ -0,0 +1,338 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+/*
+ * Parsing of SPF records and macro expansion.
+ * RFC reference: https://datatracker.ietf.org/doc/html/rfc7208#section-4.6 and section-7
+ */
+
+package spf
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	spfVersion = "v=spf1"
+	// maxDomainLength is the length expanded domain-specs are truncated to, by dropping labels from the left
+	maxDomainLength = 253
+)
+
+var (
+	spfRecordRegexp = regexp.MustCompile("(?i)^v=spf1( |$)")
+	modifierRegexp  = regexp.MustCompile("^([a-zA-Z][a-zA-Z0-9._-]*)=(.*)$")
+	// dualCIDRRegexp splits the optional domain-spec of an a or mx mechanism from its IPv4 and IPv6 prefix lengths
+	dualCIDRRegexp = regexp.MustCompile("^(.*?)(?:/([0-9]+))?(?://([0-9]+))?$")
+
+	qualifiers = map[byte]string{'+': "pass", '-': "fail", '~': "softfail", '?': "neutral"}
+
+	// errSenderIPRequired is returned when a macro or the ptr mechanism depends on the address of the sending host
+	errSenderIPRequired = errors.New("depends on the sender IP address, set --sender-ip to resolve it")
+)
+
+// term is a mechanism or modifier of an SPF record
+type term struct {
+	raw        string
+	qualifier  string // pass, fail, softfail or neutral, empty for modifiers
+	mechanism  string // lower case mechanism name, empty for modifiers
+	modifier   string // lower case modifier name, empty for mechanisms
+	domainSpec string // target domain before macro expansion, empty if the mechanism uses the current domain
+	network    *net.IPNet
+	cidr4      int
+	cidr6      int
+}
+
+// parseRecord splits an SPF record into its terms, returning an error for any syntax error, which makes the whole
+// record a permerror
+func parseRecord(record string) ([]term, error) {
+	fields := strings.Fields(record)
+	if len(fields) == 0 || !strings.EqualFold(fields[0], spfVersion) {
+		return nil, errors.New("record doesn't start with " + spfVersion)
+	}
+	var terms []term
+	seenModifiers := make(map[string]bool)
+	for _, field := range fields[1:] {
+		if m := modifierRegexp.FindStringSubmatch(field); m != nil {
+			t := term{raw: field, modifier: strings.ToLower(m[1]), domainSpec: m[2]}
+			if t.modifier == "redirect" || t.modifier == "exp" {
+				if seenModifiers[t.modifier] {
+					return nil, fmt.Errorf("more than one %s modifier", t.modifier)
+				}
+				if len(t.domainSpec) == 0 {
+					return nil, fmt.Errorf("%s modifier without a domain", t.modifier)
+				}
+				seenModifiers[t.modifier] = true
+			}
+			terms = append(terms, t)
+			continue
+		}
+		t, err := parseMechanism(field)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, t)
+	}
+	return terms, nil
+}
+
+func parseMechanism(field string) (term, error) {
+	t := term{raw: field, qualifier: qualifiers['+'], cidr4: 32, cidr6: 128}
+	if q, ok := qualifiers[field[0]]; ok {
+		t.qualifier = q
+		field = field[1:]
+	}
+	name, arg := field, ""
+	if i := strings.IndexAny(field, ":/"); i >= 0 {
+		name, arg = field[:i], field[i:]
+	}
+	t.mechanism = strings.ToLower(name)
+	invalid := fmt.Errorf("invalid %s mechanism: %s", t.mechanism, t.raw)
+	switch t.mechanism {
+	case "all":
+		if len(arg) > 0 {
+			return t, invalid
+		}
+	case "include", "exists":
+		if !strings.HasPrefix(arg, ":") || len(arg) == 1 {
+			return t, invalid
+		}
+		t.domainSpec = arg[1:]
+	case "ptr":
+		if len(arg) > 0 {
+			if !strings.HasPrefix(arg, ":") || len(arg) == 1 {
+				return t, invalid
+			}
+			t.domainSpec = arg[1:]
+		}
+	case "a", "mx":
+		m := dualCIDRRegexp.FindStringSubmatch(arg)
+		if len(m[1]) > 0 {
+			if !strings.HasPrefix(m[1], ":") || len(m[1]) == 1 {
+				return t, invalid
+			}
+			t.domainSpec = m[1][1:]
+		}
+		var err error
+		if len(m[2]) > 0 {
+			if t.cidr4, err = strconv.Atoi(m[2]); err != nil || t.cidr4 > 32 {
+				return t, invalid
+			}
+		}
+		if len(m[3]) > 0 {
+			if t.cidr6, err = strconv.Atoi(m[3]); err != nil || t.cidr6 > 128 {
+				return t, invalid
+			}
+		}
+	case "ip4", "ip6":
+		if !strings.HasPrefix(arg, ":") {
+			return t, invalid
+		}
+		addr := arg[1:]
+		// ip4 addresses can't be written as IPv4-mapped IPv6 addresses, or the other way around
+		if strings.Contains(addr, ":") != (t.mechanism == "ip6") {
+			return t, invalid
+		}
+		if !strings.Contains(addr, "/") {
+			if t.mechanism == "ip4" {
+				addr += "/32"
+			} else {
+				addr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(addr)
+		if err != nil {
+			return t, invalid
+		}
+		t.network = network
+	default:
+		return t, fmt.Errorf("unknown mechanism: %s", t.raw)
+	}
+	return t, nil
+}
+
+// macroContext holds the values macros in a domain-spec expand to
+type macroContext struct {
+	sender string // the MAIL FROM address, local-part@domain
+	domain string // the domain whose record is being evaluated
+	ip     net.IP // the address of the sending host, nil if unknown
+}
+
+// expandDomainSpec expands the macros of spec (RFC 7208, Section 7), returning the domain it names without a trailing
+// dot. An empty spec is the current domain.
+func expandDomainSpec(spec string, mc *macroContext) (string, error) {
+	if len(spec) == 0 {
+		return mc.domain, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(spec); i++ {
+		if spec[i] != '%' {
+			b.WriteByte(spec[i])
+			continue
+		}
+		if i+1 == len(spec) {
+			return "", errors.New("incomplete macro in " + spec)
+		}
+		i++
+		switch spec[i] {
+		case '%':
+			b.WriteByte('%')
+		case '_':
+			b.WriteByte(' ')
+		case '-':
+			b.WriteString("%20")
+		case '{':
+			end := strings.IndexByte(spec[i:], '}')
+			if end < 0 {
+				return "", errors.New("unterminated macro in " + spec)
+			}
+			expanded, err := expandMacro(spec[i+1:i+end], mc)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(expanded)
+			i += end
+		default:
+			return "", errors.New("invalid macro in " + spec)
+		}
+	}
+	domain := strings.TrimSuffix(b.String(), ".")
+	for len(domain) > maxDomainLength {
+		_, rest, found := strings.Cut(domain, ".")
+		if !found {
+			break
+		}
+		domain = rest
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if len(label) == 0 || len(label) > 63 || strings.ContainsAny(label, " \t") {
+			return "", fmt.Errorf("invalid domain %q expanded from %s", domain, spec)
+		}
+	}
+	return domain, nil
+}
+
+// expandMacro expands the body of a %{...} macro: a letter, an optional number of parts to keep, an optional r to
+// reverse them, and the delimiters to split on
+func expandMacro(body string, mc *macroContext) (string, error) {
+	if len(body) == 0 {
+		return "", errors.New("empty macro")
+	}
+	letter := body[0]
+	var value string
+	switch letter | 0x20 {
+	case 's':
+		value = mc.sender
+	case 'l':
+		value, _, _ = strings.Cut(mc.sender, "@")
+	case 'o':
+		_, value, _ = strings.Cut(mc.sender, "@")
+	case 'd':
+		value = mc.domain
+	case 'h':
+		// without a HELO identity, the domain of the sender stands in for it
+		_, value, _ = strings.Cut(mc.sender, "@")
+	case 'i':
+		if mc.ip == nil {
+			return "", errSenderIPRequired
+		}
+		value = dottedIP(mc.ip)
+	case 'v':
+		if mc.ip == nil {
+			return "", errSenderIPRequired
+		}
+		value = "ip6"
+		if mc.ip.To4() != nil {
+			value = "in-addr"
+		}
+	case 'p':
+		// the validated domain of the sender is expensive and unreliable, RFC 7208 allows "unknown" in its place
+		value = "unknown"
+	case 'c', 'r', 't':
+		return "", fmt.Errorf("macro %%{%c} is only allowed in explanations", letter)
+	default:
+		return "", fmt.Errorf("unknown macro %%{%c}", letter)
+	}
+
+	transformers := body[1:]
+	digits := 0
+	for digits < len(transformers) && transformers[digits] >= '0' && transformers[digits] <= '9' {
+		digits++
+	}
+	keep := 0
+	if digits > 0 {
+		var err error
+		if keep, err = strconv.Atoi(transformers[:digits]); err != nil || keep == 0 {
+			return "", fmt.Errorf("invalid macro %%{%s}", body)
+		}
+	}
+	transformers = transformers[digits:]
+	reverse := false
+	if len(transformers) > 0 && (transformers[0] == 'r' || transformers[0] == 'R') {
+		reverse = true
+		transformers = transformers[1:]
+	}
+	if strings.Trim(transformers, ".-+,/_=") != "" {
+		return "", fmt.Errorf("invalid macro %%{%s}", body)
+	}
+	delimiters := transformers
+	if len(delimiters) == 0 {
+		delimiters = "."
+	}
+	parts := strings.FieldsFunc(value, func(r rune) bool { return strings.ContainsRune(delimiters, r) })
+	if reverse {
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+	}
+	if keep > 0 && keep < len(parts) {
+		parts = parts[len(parts)-keep:]
+	}
+	value = strings.Join(parts, ".")
+	if letter >= 'A' && letter <= 'Z' {
+		value = urlEscape(value)
+	}
+	return value, nil
+}
+
+// dottedIP formats an address for the i macro, as dotted decimal for IPv4 or dotted nibbles for IPv6
+func dottedIP(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+	nibbles := make([]string, 0, 32)
+	for _, b := range ip.To16() {
+		nibbles = append(nibbles, strconv.FormatUint(uint64(b>>4), 16), strconv.FormatUint(uint64(b&0xf), 16))
+	}
+	return strings.Join(nibbles, ".")
+}
+
+// urlEscape escapes every character outside the unreserved set of RFC 3986, for upper case macro letters
+func urlEscape(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || strings.IndexByte("-._~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
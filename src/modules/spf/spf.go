@@ -15,7 +15,9 @@ package spf
 
 import (
 	"errors"
+	"net"
 	"regexp"
+	"strings"
 
 	"github.com/miekg/dns"
 
@@ -28,6 +30,53 @@ const spfPrefixRegexp = "(?i)^v=spf1"
 // result to be returned by scan of host
 type Result struct {
 	Spf string `json:"spf,omitempty" groups:"short,normal,long,trace"`
+	// Result is the outcome of check_host() for --sender-ip: pass, fail, softfail, neutral, permerror or temperror
+	Result string `json:"result,omitempty" groups:"short,normal,long,trace"`
+	// IPv4Ranges and IPv6Ranges are the networks the policy passes, flattened across includes and redirects
+	IPv4Ranges  []string  `json:"ipv4_ranges,omitempty" groups:"short,normal,long,trace"`
+	IPv6Ranges  []string  `json:"ipv6_ranges,omitempty" groups:"short,normal,long,trace"`
+	DNSLookups  int       `json:"dns_lookups,omitempty" groups:"normal,long,trace"`
+	VoidLookups int       `json:"void_lookups,omitempty" groups:"normal,long,trace"`
+	Errors      []Finding `json:"errors,omitempty" groups:"short,normal,long,trace"`
+	Policy      *Policy   `json:"policy,omitempty" groups:"normal,long,trace"`
+}
+
+// Policy is an SPF record along with what each of its terms resolved to
+type Policy struct {
+	Domain string `json:"domain" groups:"short,normal,long,trace"`
+	Record string `json:"record,omitempty" groups:"short,normal,long,trace"`
+	Terms  []Term `json:"terms,omitempty" groups:"short,normal,long,trace"`
+	Error  string `json:"error,omitempty" groups:"short,normal,long,trace"`
+
+	errType string
+}
+
+// Term is a mechanism or modifier of an SPF record. Domain is the target domain after macro expansion, Ranges the
+// networks an ip4, ip6, a or mx mechanism matches, and Policy the record an include or redirect refers to.
+type Term struct {
+	Term      string   `json:"term" groups:"short,normal,long,trace"`
+	Qualifier string   `json:"qualifier,omitempty" groups:"short,normal,long,trace"`
+	Mechanism string   `json:"mechanism,omitempty" groups:"short,normal,long,trace"`
+	Modifier  string   `json:"modifier,omitempty" groups:"short,normal,long,trace"`
+	Domain    string   `json:"domain,omitempty" groups:"short,normal,long,trace"`
+	Ranges    []string `json:"ranges,omitempty" groups:"short,normal,long,trace"`
+	Hosts     []string `json:"hosts,omitempty" groups:"short,normal,long,trace"` // exchanges for mx, validated host names for ptr
+	Exists    *bool    `json:"exists,omitempty" groups:"short,normal,long,trace"`
+	Policy    *Policy  `json:"policy,omitempty" groups:"short,normal,long,trace"`
+	Error     string   `json:"error,omitempty" groups:"short,normal,long,trace"`
+	Skipped   string   `json:"skipped,omitempty" groups:"short,normal,long,trace"` // why the term couldn't be resolved without a sender
+
+	errType  string
+	networks []*net.IPNet
+	matched  bool // for ptr, whether a validated host name is within the target domain
+}
+
+// Finding is a permerror or temperror hit while evaluating the policy, in the policy of Domain
+type Finding struct {
+	Type    string `json:"type" groups:"short,normal,long,trace"`
+	Domain  string `json:"domain" groups:"short,normal,long,trace"`
+	Term    string `json:"term,omitempty" groups:"short,normal,long,trace"`
+	Message string `json:"message" groups:"short,normal,long,trace"`
 }
 
 func init() {
@@ -37,7 +86,11 @@ func init() {
 
 type SpfLookupModule struct {
 	cli.BasicLookupModule
-	re *regexp.Regexp
+	SenderIP string `long:"sender-ip" description:"evaluate the policy for mail from this address, resolving ptr mechanisms and sender macros, and output the result"`
+	Sender   string `long:"sender" description:"MAIL FROM address for sender macros, defaults to postmaster@ the input domain"`
+
+	re       *regexp.Regexp
+	senderIP net.IP
 }
 
 // CLIInit initializes the SPF lookup module
@@ -45,12 +98,23 @@ func (spfMod *SpfLookupModule) CLIInit(gc *cli.CLIConf, rc *zdns.ResolverConfig)
 	if gc.LookupAllNameServers {
 		return errors.New("SPF module does not support --all-nameservers")
 	}
+	spfMod.senderIP = nil
+	if len(spfMod.SenderIP) > 0 {
+		if spfMod.senderIP = net.ParseIP(spfMod.SenderIP); spfMod.senderIP == nil {
+			return errors.New("--sender-ip is not a valid IP address")
+		}
+	}
+	if len(spfMod.Sender) > 0 && !strings.Contains(spfMod.Sender, "@") {
+		return errors.New("--sender must be an email address")
+	}
 	spfMod.re = regexp.MustCompile(spfPrefixRegexp)
 	spfMod.DNSType = dns.TypeTXT
 	spfMod.DNSClass = dns.ClassINET
 	return spfMod.BasicLookupModule.CLIInit(gc, rc)
 }
 
+// Lookup returns the SPF record of name, and evaluates it: includes, redirects and the a, mx, ptr and exists
+// mechanisms are resolved through the same resolver, up to the DNS lookup limits of RFC 7208.
 func (spfMod *SpfLookupModule) Lookup(r *zdns.Resolver, name string, nameServer *zdns.NameServer) (interface{}, zdns.Trace, zdns.Status, error) {
 	innerRes, trace, status, err := spfMod.BasicLookupModule.Lookup(r, name, nameServer)
 	castedInnerRes, ok := innerRes.(*zdns.SingleQueryResult)
@@ -59,7 +123,34 @@ func (spfMod *SpfLookupModule) Lookup(r *zdns.Resolver, name string, nameServer
 	}
 	resString, resStatus, err := zdns.CheckTxtRecords(castedInnerRes, status, spfMod.re, err)
 	res := Result{Spf: resString}
-	return res, trace, resStatus, err
+	records := spfRecords(castedInnerRes.Answers)
+	if resStatus != zdns.StatusNoError || len(records) == 0 {
+		return res, trace, resStatus, err
+	}
+
+	domain := strings.ToLower(strings.TrimSuffix(name, "."))
+	e := &evaluation{mod: spfMod, r: r, nameServer: nameServer, sender: spfMod.Sender}
+	if len(e.sender) == 0 {
+		e.sender = "postmaster@" + domain
+	}
+	res.Policy = &Policy{Domain: domain}
+	if len(records) > 1 {
+		res.Policy.setError(resultPermError, "more than one SPF record")
+		e.fail(resultPermError, domain, "", res.Policy.Error)
+	} else {
+		res.Policy.Record = records[0]
+		e.evaluatePolicy(res.Policy)
+	}
+	ranges := make(map[string]struct{})
+	authorizedRanges(res.Policy, ranges)
+	res.IPv4Ranges, res.IPv6Ranges = splitRanges(ranges)
+	res.DNSLookups = e.dnsLookups
+	res.VoidLookups = e.voidLookups
+	res.Errors = e.findings
+	if spfMod.senderIP != nil {
+		res.Result = checkHost(res.Policy, spfMod.senderIP)
+	}
+	return res, append(trace, e.trace...), resStatus, err
 }
 
 // Help
@@ -74,7 +165,7 @@ func (spfMod *SpfLookupModule) Validate(args []string) error {
 
 // Description
 func (spfMod *SpfLookupModule) GetDescription() string {
-	return ""
+	return "SPF returns the SPF record of a domain, along with its policy tree, the IP ranges it authorizes and any errors found evaluating it."
 }
 
 func (spfMod *SpfLookupModule) NewFlags() interface{} {
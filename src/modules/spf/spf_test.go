@@ -16,7 +16,9 @@ package spf
 
 import (
 	"context"
+	"fmt"
 	"net"
+	"strings"
 	"testing"
 
 	"github.com/miekg/dns"
@@ -32,12 +34,25 @@ type QueryRecord struct {
 }
 
 var mockResults = make(map[string]*zdns.SingleQueryResult)
+
+// mockRecords answers by name and type, taking precedence over mockResults. Names in mockFailures fail with SERVFAIL.
+var mockRecords = make(map[string]map[uint16][]interface{})
+var mockFailures = make(map[string]bool)
 var queries []QueryRecord
 
 type MockLookup struct{}
 
 func (ml MockLookup) DoDstServersLookup(ctx context.Context, r *zdns.Resolver, question zdns.Question, nameServers []zdns.NameServer, isIterative bool) (*zdns.SingleQueryResult, zdns.Trace, zdns.Status, error) {
 	queries = append(queries, QueryRecord{question, &nameServers[0]})
+	if mockFailures[question.Name] {
+		return &zdns.SingleQueryResult{}, nil, zdns.StatusServFail, nil
+	}
+	if types, ok := mockRecords[question.Name]; ok {
+		if len(types[question.Type]) == 0 {
+			return &zdns.SingleQueryResult{}, nil, zdns.StatusNoAnswer, nil
+		}
+		return &zdns.SingleQueryResult{Answers: types[question.Type]}, nil, zdns.StatusNoError, nil
+	}
 	if res, ok := mockResults[question.Name]; ok {
 		return res, nil, zdns.StatusNoError, nil
 	} else {
@@ -47,6 +62,8 @@ func (ml MockLookup) DoDstServersLookup(ctx context.Context, r *zdns.Resolver, q
 
 func InitTest(t *testing.T) *zdns.Resolver {
 	mockResults = make(map[string]*zdns.SingleQueryResult)
+	mockRecords = make(map[string]map[uint16][]interface{})
+	mockFailures = make(map[string]bool)
 	queries = make([]QueryRecord, 0)
 	rc := zdns.ResolverConfig{
 		RootNameServersV4:     []zdns.NameServer{{IP: net.ParseIP("127.0.0.53"), Port: 53}},
@@ -154,3 +171,169 @@ func TestLookup_DoTxtLookup_NoTXT(t *testing.T) {
 	assert.Equal(t, zdns.StatusNoAnswer, status)
 	assert.Equal(t, res.(Result).Spf, "")
 }
+
+// addRecord adds a TXT, A, AAAA, MX or PTR record to the mock
+func addRecord(name string, rrType uint16, value string) {
+	if _, ok := mockRecords[name]; !ok {
+		mockRecords[name] = make(map[uint16][]interface{})
+	}
+	ans := zdns.Answer{Name: name, Type: dns.TypeToString[rrType], RrType: rrType, Answer: value}
+	if rrType == dns.TypeMX {
+		mockRecords[name][rrType] = append(mockRecords[name][rrType], zdns.PrefAnswer{Answer: ans, Preference: 10})
+	} else {
+		mockRecords[name][rrType] = append(mockRecords[name][rrType], ans)
+	}
+}
+
+func initSpfModule(t *testing.T, senderIP string) *SpfLookupModule {
+	spfModule := &SpfLookupModule{SenderIP: senderIP}
+	assert.NilError(t, spfModule.CLIInit(&cli.CLIConf{}, &zdns.ResolverConfig{LookupClient: MockLookup{}}))
+	return spfModule
+}
+
+func TestEvaluatePolicy(t *testing.T) {
+	resolver := InitTest(t)
+	addRecord("example.com", dns.TypeTXT, "v=spf1 ip4:192.0.2.0/24 a mx/30 include:_spf.example.net ~all")
+	addRecord("example.com", dns.TypeA, "198.51.100.1")
+	addRecord("example.com", dns.TypeMX, "mail.example.com.")
+	addRecord("mail.example.com", dns.TypeA, "198.51.100.9")
+	// split into two strings, which are joined back together
+	addRecord("_spf.example.net", dns.TypeTXT, "v=spf1 ip6:2001:db8::/32 -ip4:203.0.113.5\n redirect=_spf2.example.net")
+	addRecord("_spf2.example.net", dns.TypeTXT, "v=spf1 ip4:203.0.113.0/24 -all")
+
+	res, _, status, err := initSpfModule(t, "").Lookup(resolver, "example.com", nil)
+	assert.NilError(t, err)
+	assert.Equal(t, zdns.StatusNoError, status)
+	result := res.(Result)
+	assert.DeepEqual(t, result.IPv4Ranges, []string{"192.0.2.0/24", "198.51.100.1/32", "198.51.100.8/30", "203.0.113.0/24"})
+	assert.DeepEqual(t, result.IPv6Ranges, []string{"2001:db8::/32"})
+	assert.Equal(t, result.DNSLookups, 4) // a, mx, include and redirect
+	assert.Equal(t, result.VoidLookups, 0)
+	assert.Equal(t, len(result.Errors), 0)
+	assert.Equal(t, result.Result, "")
+
+	terms := result.Policy.Terms
+	assert.Equal(t, len(terms), 5)
+	assert.DeepEqual(t, terms[2].Hosts, []string{"mail.example.com"})
+	assert.Equal(t, terms[4].Qualifier, "softfail")
+	included := terms[3].Policy
+	assert.Equal(t, included.Domain, "_spf.example.net")
+	assert.Equal(t, included.Record, "v=spf1 ip6:2001:db8::/32 -ip4:203.0.113.5 redirect=_spf2.example.net")
+	assert.Equal(t, included.Terms[2].Modifier, "redirect")
+	assert.Equal(t, included.Terms[2].Policy.Domain, "_spf2.example.net")
+
+	for ip, expected := range map[string]string{
+		"192.0.2.77":    "pass",
+		"198.51.100.10": "pass",
+		"2001:db8::1":   "pass",
+		"203.0.113.6":   "pass",     // passed by the redirect of the include
+		"203.0.113.5":   "softfail", // fails the include, so it doesn't match
+		"10.0.0.1":      "softfail",
+	} {
+		res, _, _, _ = initSpfModule(t, ip).Lookup(resolver, "example.com", nil)
+		assert.Equal(t, res.(Result).Result, expected, ip)
+	}
+}
+
+func TestEvaluatePolicyErrors(t *testing.T) {
+	resolver := InitTest(t)
+	addRecord("multiple.example.com", dns.TypeTXT, "v=spf1 -all")
+	addRecord("multiple.example.com", dns.TypeTXT, "v=spf1 +all")
+	addRecord("syntax.example.com", dns.TypeTXT, "v=spf1 ip4:192.0.2.0/24 foo:bar -all")
+	addRecord("void.example.com", dns.TypeTXT, "v=spf1 a:n1.example.com mx:n2.example.com exists:n3.example.com ip4:192.0.2.1 -all")
+	addRecord("temp.example.com", dns.TypeTXT, "v=spf1 include:broken.example.com ip4:192.0.2.1 -all")
+	mockFailures["broken.example.com"] = true
+	addRecord("missing.example.com", dns.TypeTXT, "v=spf1 include:nospf.example.com -all")
+	records := "v=spf1"
+	for i := 0; i < 11; i++ {
+		name := fmt.Sprintf("h%d.example.com", i)
+		addRecord(name, dns.TypeA, "192.0.2.1")
+		records += " a:" + name
+	}
+	addRecord("limit.example.com", dns.TypeTXT, records+" -all")
+
+	for _, tc := range []struct {
+		name    string
+		message string
+		result  string
+	}{
+		{"multiple.example.com", "more than one SPF record", "permerror"},
+		{"syntax.example.com", "unknown mechanism: foo:bar", "permerror"},
+		{"void.example.com", "more than 2 void lookups", "permerror"},
+		{"temp.example.com", "SERVFAIL", "temperror"},
+		{"missing.example.com", "no SPF record", "permerror"},
+		{"limit.example.com", "more than 10 DNS lookups", "pass"}, // the first mechanism matches before the limit
+	} {
+		res, _, status, _ := initSpfModule(t, "192.0.2.1").Lookup(resolver, tc.name, nil)
+		assert.Equal(t, zdns.StatusNoError, status)
+		result := res.(Result)
+		assert.Equal(t, len(result.Errors), 1, tc.name)
+		assert.Assert(t, strings.Contains(result.Errors[0].Message, tc.message), result.Errors[0].Message)
+		assert.Equal(t, result.Result, tc.result, tc.name)
+	}
+	res, _, _, _ := initSpfModule(t, "192.0.2.2").Lookup(resolver, "limit.example.com", nil)
+	assert.Equal(t, res.(Result).Result, "permerror")
+	assert.Equal(t, res.(Result).DNSLookups, 11)
+}
+
+func TestEvaluatePolicySenderMacros(t *testing.T) {
+	resolver := InitTest(t)
+	addRecord("example.com", dns.TypeTXT, "v=spf1 exists:%{ir}.%{v}._spf.%{d} ptr -all")
+	addRecord("3.2.0.192.in-addr._spf.example.com", dns.TypeA, "127.0.0.2")
+	addRecord("3.2.0.192.in-addr.arpa", dns.TypePTR, "mail.example.com.")
+	addRecord("mail.example.com", dns.TypeA, "192.0.2.3")
+
+	// without a sender address, the terms that depend on it can't be resolved
+	res, _, _, _ := initSpfModule(t, "").Lookup(resolver, "example.com", nil)
+	terms := res.(Result).Policy.Terms
+	assert.Assert(t, len(terms[0].Skipped) > 0)
+	assert.Assert(t, len(terms[1].Skipped) > 0)
+	assert.Equal(t, len(res.(Result).Errors), 0)
+
+	res, _, _, _ = initSpfModule(t, "192.0.2.3").Lookup(resolver, "example.com", nil)
+	terms = res.(Result).Policy.Terms
+	assert.Equal(t, terms[0].Domain, "3.2.0.192.in-addr._spf.example.com")
+	assert.Equal(t, *terms[0].Exists, true)
+	assert.DeepEqual(t, terms[1].Hosts, []string{"mail.example.com"})
+	assert.Equal(t, res.(Result).Result, "pass")
+
+	// the host name validates, but the exists mechanism doesn't match and ptr only matches within example.com
+	res, _, _, _ = initSpfModule(t, "192.0.2.4").Lookup(resolver, "example.com", nil)
+	assert.Equal(t, res.(Result).Result, "fail")
+}
+
+func TestExpandDomainSpec(t *testing.T) {
+	// the examples of RFC 7208, Section 7.4
+	mc := &macroContext{sender: "strong-bad@email.example.com", domain: "email.example.com", ip: net.ParseIP("192.0.2.3")}
+	for spec, expected := range map[string]string{
+		"%{s}":                    "strong-bad@email.example.com",
+		"%{o}":                    "email.example.com",
+		"%{d4}":                   "email.example.com",
+		"%{d2}":                   "example.com",
+		"%{d1}":                   "com",
+		"%{dr}":                   "com.example.email",
+		"%{d2r}":                  "example.email",
+		"%{l-}":                   "strong.bad",
+		"%{lr-}":                  "bad.strong",
+		"%{l1r-}":                 "strong",
+		"%{ir}.%{v}._spf.%{d2}":   "3.2.0.192.in-addr._spf.example.com",
+		"%{lr-}.lp._spf.%{d2}":    "bad.strong.lp._spf.example.com",
+		"%{d2}.trusted-domains.x": "example.com.trusted-domains.x",
+	} {
+		domain, err := expandDomainSpec(spec, mc)
+		assert.NilError(t, err, spec)
+		assert.Equal(t, domain, expected, spec)
+	}
+	mc.ip = net.ParseIP("2001:db8::cb01")
+	domain, err := expandDomainSpec("%{ir}.%{v}._spf.%{d2}", mc)
+	assert.NilError(t, err)
+	assert.Equal(t, domain, "1.0.b.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6._spf.example.com")
+
+	for _, spec := range []string{"%{x}", "%{d0}", "%{c}.example.com", "%{d", "%z.example.com"} {
+		_, err = expandDomainSpec(spec, mc)
+		assert.Assert(t, err != nil, spec)
+	}
+	mc.ip = nil
+	_, err = expandDomainSpec("%{i}.example.com", mc)
+	assert.Equal(t, err, errSenderIPRequired)
+}
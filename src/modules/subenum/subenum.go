@@ -0,0 +1,330 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package subenum
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+
+	"github.com/zmap/zdns/src/cli"
+	"github.com/zmap/zdns/src/zdns"
+)
+
+const (
+	// wildcardLabelLength is the length of the random labels probed for wildcards, long enough not to exist by chance
+	wildcardLabelLength = 16
+	wildcardLabelChars  = "abcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// Subdomain is a name that exists, along with the records it resolves to. For a wildcard, Name is the wildcard owner.
+type Subdomain struct {
+	Name          string   `json:"name" groups:"short,normal,long,trace"`
+	CNAMEs        []string `json:"cnames,omitempty" groups:"short,normal,long,trace"`
+	IPv4Addresses []string `json:"ipv4_addresses,omitempty" groups:"short,normal,long,trace"`
+	IPv6Addresses []string `json:"ipv6_addresses,omitempty" groups:"short,normal,long,trace"`
+}
+
+// Result lists the subdomains of an input domain found from the wordlist. Candidates whose records all match a
+// wildcard are counted in WildcardMatches instead. Unprobed lists the parent names where every wildcard probe failed,
+// whose subdomains may include wildcard matches.
+type Result struct {
+	Subdomains      []Subdomain `json:"subdomains" groups:"short,normal,long,trace"`
+	Wildcards       []Subdomain `json:"wildcards,omitempty" groups:"short,normal,long,trace"`
+	Unprobed        []string    `json:"unprobed,omitempty" groups:"short,normal,long,trace"`
+	Candidates      int         `json:"candidates" groups:"normal,long,trace"`
+	WildcardMatches int         `json:"wildcard_matches" groups:"normal,long,trace"`
+	Errors          []string    `json:"errors,omitempty" groups:"short,normal,long,trace"`
+}
+
+type SubEnumLookupModule struct {
+	cli.BasicLookupModule
+	IPv4Lookup     bool   `long:"ipv4-lookup" description:"perform A lookups for each candidate, defaults to both A and AAAA if neither is set"`
+	IPv6Lookup     bool   `long:"ipv6-lookup" description:"perform AAAA lookups for each candidate, defaults to both A and AAAA if neither is set"`
+	WildcardProbes int    `long:"wildcard-probes" default:"3" description:"number of random labels looked up in each zone to detect wildcard records"`
+	Wordlist       string `long:"wordlist" description:"file with one subdomain label per line, prepended to each input domain"`
+
+	words []string
+}
+
+func init() {
+	se := new(SubEnumLookupModule)
+	cli.RegisterLookupModule("SUBENUM", se)
+}
+
+// CLIInit initializes the SubEnum lookup module, reading the wordlist
+func (seMod *SubEnumLookupModule) CLIInit(gc *cli.CLIConf, rc *zdns.ResolverConfig) error {
+	if gc.LookupAllNameServers {
+		return errors.New("SUBENUM module does not support --all-nameservers")
+	}
+	if len(seMod.Wordlist) == 0 {
+		return errors.New("SUBENUM module requires --wordlist")
+	}
+	if seMod.WildcardProbes < 0 {
+		return errors.New("--wildcard-probes must not be negative")
+	}
+	words, err := readWordlist(seMod.Wordlist)
+	if err != nil {
+		return err
+	}
+	seMod.Init(words, seMod.IPv4Lookup, seMod.IPv6Lookup)
+	return seMod.BasicLookupModule.CLIInit(gc, rc)
+}
+
+// Init initializes the SubEnum lookup module with the given labels, used to call SUBENUM programmatically. Blank
+// labels and duplicates are skipped.
+func (seMod *SubEnumLookupModule) Init(words []string, ipv4Lookup, ipv6Lookup bool) {
+	seMod.DNSClass = dns.ClassINET
+	seMod.IPv4Lookup = ipv4Lookup || !ipv6Lookup
+	seMod.IPv6Lookup = ipv6Lookup || !ipv4Lookup
+	seMod.words = nil
+	seen := make(map[string]struct{}, len(words))
+	for _, word := range words {
+		word = strings.Trim(strings.ToLower(strings.TrimSpace(word)), ".")
+		if _, ok := seen[word]; ok || len(word) == 0 {
+			continue
+		}
+		seen[word] = struct{}{}
+		seMod.words = append(seMod.words, word)
+	}
+}
+
+// readWordlist reads a wordlist with one label per line, ignoring blank lines and comments starting with #
+func readWordlist(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open wordlist")
+	}
+	defer f.Close()
+	var words []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	if err = s.Err(); err != nil {
+		return nil, errors.Wrap(err, "unable to read wordlist")
+	}
+	return words, nil
+}
+
+// enumeration holds the state of expanding a single input domain
+type enumeration struct {
+	mod        *SubEnumLookupModule
+	r          *zdns.Resolver
+	nameServer *zdns.NameServer
+	// wildcards holds the records of any wildcard under each parent name probed so far, keyed by record value
+	wildcards map[string]map[string]struct{}
+	res       Result
+	trace     zdns.Trace
+}
+
+// Lookup looks up every word in the wordlist as a subdomain of lookupName, returning those that exist with their
+// CNAME and address records. Before the first candidate under a parent name is looked up, random labels under it
+// are probed to detect a wildcard, and candidates that resolve to nothing but the wildcard's records are suppressed.
+func (seMod *SubEnumLookupModule) Lookup(r *zdns.Resolver, lookupName string, nameServer *zdns.NameServer) (interface{}, zdns.Trace, zdns.Status, error) {
+	domain := strings.ToLower(strings.TrimSuffix(lookupName, "."))
+	e := &enumeration{
+		mod:        seMod,
+		r:          r,
+		nameServer: nameServer,
+		wildcards:  make(map[string]map[string]struct{}),
+		res:        Result{Subdomains: []Subdomain{}},
+	}
+	failures := 0
+	var lastStatus zdns.Status
+	var lastErr error
+	for _, word := range seMod.words {
+		name := word + "." + domain
+		e.res.Candidates++
+		wildcard := e.wildcard(parentName(name))
+		sub, status, err := e.resolve(name)
+		if status != zdns.StatusNoError {
+			if status != zdns.StatusNXDomain && status != zdns.StatusNoRecord {
+				failures++
+				lastStatus, lastErr = status, err
+			}
+			continue
+		}
+		if len(wildcard) > 0 && matchesWildcard(sub, wildcard) {
+			e.res.WildcardMatches++
+			continue
+		}
+		e.res.Subdomains = append(e.res.Subdomains, sub)
+	}
+	if failures > 0 && failures == e.res.Candidates {
+		// nothing could be looked up, ex. the name server is unreachable
+		return &e.res, e.trace, lastStatus, lastErr
+	}
+	return &e.res, e.trace, zdns.StatusNoError, nil
+}
+
+// wildcard returns the records of the wildcard under parent, probing for it the first time parent is seen. Setting
+// WildcardProbes to 0 disables wildcard detection. If every probe fails, parent is added to Unprobed and probed again
+// for the next candidate under it.
+func (e *enumeration) wildcard(parent string) map[string]struct{} {
+	if records, ok := e.wildcards[parent]; ok {
+		return records
+	}
+	records := make(map[string]struct{})
+	found := Subdomain{Name: "*." + parent}
+	answered := 0
+	for i := 0; i < e.mod.WildcardProbes; i++ {
+		name := randomLabel() + "." + parent
+		sub, status, err := e.resolve(name)
+		if status != zdns.StatusNoError && !zdns.IsMissingStatus(status) {
+			msg := fmt.Sprintf("wildcard probe of %s failed with status %s", name, status)
+			if err != nil {
+				msg += ": " + err.Error()
+			}
+			e.res.Errors = append(e.res.Errors, msg)
+			continue
+		}
+		answered++
+		if status != zdns.StatusNoError {
+			continue
+		}
+		// round-robin wildcards may answer each probe differently, so collect everything they resolve to
+		found.CNAMEs = appendUnique(found.CNAMEs, sub.CNAMEs...)
+		found.IPv4Addresses = appendUnique(found.IPv4Addresses, sub.IPv4Addresses...)
+		found.IPv6Addresses = appendUnique(found.IPv6Addresses, sub.IPv6Addresses...)
+	}
+	if answered == 0 && e.mod.WildcardProbes > 0 {
+		e.res.Unprobed = appendUnique(e.res.Unprobed, parent)
+		return records
+	}
+	for _, values := range [][]string{found.CNAMEs, found.IPv4Addresses, found.IPv6Addresses} {
+		for _, value := range values {
+			records[value] = struct{}{}
+		}
+	}
+	if len(records) > 0 {
+		e.res.Wildcards = append(e.res.Wildcards, found)
+	}
+	e.wildcards[parent] = records
+	return records
+}
+
+// resolve looks up the address records of name, returning StatusNoError only if it has CNAME or address records
+func (e *enumeration) resolve(name string) (Subdomain, zdns.Status, error) {
+	sub := Subdomain{Name: name}
+	var qTypes []uint16
+	if e.mod.IPv4Lookup {
+		qTypes = append(qTypes, dns.TypeA)
+	}
+	if e.mod.IPv6Lookup {
+		qTypes = append(qTypes, dns.TypeAAAA)
+	}
+	status := zdns.StatusNoRecord
+	var err error
+	for _, qType := range qTypes {
+		res, trace, qStatus, qErr := e.mod.LookupType(e.r, name, qType, e.nameServer)
+		e.trace = append(e.trace, trace...)
+		if qStatus == zdns.StatusNXDomain {
+			// the name doesn't exist, so it won't have any other type either
+			return sub, qStatus, nil
+		}
+		if qStatus != zdns.StatusNoError || res == nil {
+			status, err = qStatus, qErr
+			continue
+		}
+		for _, ans := range res.Answers {
+			a, ok := ans.(zdns.Answer)
+			if !ok {
+				continue
+			}
+			switch a.RrType {
+			case dns.TypeCNAME:
+				sub.CNAMEs = appendUnique(sub.CNAMEs, strings.TrimSuffix(a.Answer, "."))
+			case dns.TypeA:
+				sub.IPv4Addresses = appendUnique(sub.IPv4Addresses, a.Answer)
+			case dns.TypeAAAA:
+				sub.IPv6Addresses = appendUnique(sub.IPv6Addresses, a.Answer)
+			}
+		}
+	}
+	if len(sub.CNAMEs) > 0 || len(sub.IPv4Addresses) > 0 || len(sub.IPv6Addresses) > 0 {
+		sort.Strings(sub.IPv4Addresses)
+		sort.Strings(sub.IPv6Addresses)
+		return sub, zdns.StatusNoError, nil
+	}
+	return sub, status, err
+}
+
+// matchesWildcard returns true if everything sub resolves to is also a record of the wildcard
+func matchesWildcard(sub Subdomain, wildcard map[string]struct{}) bool {
+	for _, values := range [][]string{sub.CNAMEs, sub.IPv4Addresses, sub.IPv6Addresses} {
+		for _, value := range values {
+			if _, ok := wildcard[value]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func parentName(name string) string {
+	_, parent, _ := strings.Cut(name, ".")
+	return parent
+}
+
+func randomLabel() string {
+	label := make([]byte, wildcardLabelLength)
+	for i := range label {
+		label[i] = wildcardLabelChars[rand.Intn(len(wildcardLabelChars))]
+	}
+	return string(label)
+}
+
+func appendUnique(values []string, newValues ...string) []string {
+	for _, value := range newValues {
+		found := false
+		for _, existing := range values {
+			if existing == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func (seMod *SubEnumLookupModule) Help() string {
+	return ""
+}
+
+func (seMod *SubEnumLookupModule) Validate(args []string) error {
+	return nil
+}
+
+func (seMod *SubEnumLookupModule) GetDescription() string {
+	return "SUBENUM looks up each label in a wordlist as a subdomain of the input domain, returning the subdomains that exist while suppressing wildcard matches."
+}
+
+func (seMod *SubEnumLookupModule) NewFlags() interface{} {
+	return seMod
+}
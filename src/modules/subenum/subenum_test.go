@@ -0,0 +1,162 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package subenum
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zmap/zdns/src/cli"
+	"github.com/zmap/zdns/src/zdns"
+)
+
+// mockLookup answers from records, keyed by name and then type. Names that aren't found are answered by the wildcard
+// of the closest parent with one, or else NXDOMAIN.
+type mockLookup struct {
+	records     map[string]map[uint16][]interface{}
+	status      zdns.Status // if set, every query fails with this status
+	probeStatus zdns.Status // if set, queries for names that aren't in records fail with this status
+}
+
+func (ml *mockLookup) DoDstServersLookup(ctx context.Context, r *zdns.Resolver, question zdns.Question, nameServers []zdns.NameServer, isIterative bool) (*zdns.SingleQueryResult, zdns.Trace, zdns.Status, error) {
+	if ml.status != "" {
+		return nil, nil, ml.status, nil
+	}
+	name := question.Name
+	types, ok := ml.records[name]
+	for !ok && strings.Contains(name, ".") {
+		_, name, _ = strings.Cut(name, ".")
+		types, ok = ml.records["*."+name]
+	}
+	if !ok && ml.probeStatus != "" {
+		return nil, nil, ml.probeStatus, nil
+	}
+	if !ok {
+		return &zdns.SingleQueryResult{}, nil, zdns.StatusNXDomain, nil
+	}
+	return &zdns.SingleQueryResult{Answers: types[question.Type]}, nil, zdns.StatusNoError, nil
+}
+
+func answer(name string, rrType uint16, value string) zdns.Answer {
+	return zdns.Answer{Name: name, Type: dns.TypeToString[rrType], RrType: rrType, Answer: value}
+}
+
+func initTest(t *testing.T, ml *mockLookup) *zdns.Resolver {
+	rc := zdns.ResolverConfig{
+		ExternalNameServersV4: []zdns.NameServer{{IP: net.ParseIP("1.1.1.1"), Port: 53}},
+		RootNameServersV4:     []zdns.NameServer{{IP: net.ParseIP("1.1.1.1"), Port: 53}},
+		LocalAddrsV4:          []net.IP{net.ParseIP("192.168.1.1")},
+		IPVersionMode:         zdns.IPv4Only,
+		LookupClient:          ml}
+	r, err := zdns.InitResolver(&rc)
+	require.NoError(t, err)
+	return r
+}
+
+func TestSubEnumWildcard(t *testing.T) {
+	ml := &mockLookup{records: map[string]map[uint16][]interface{}{
+		"*.example.com": {dns.TypeA: {answer("*.example.com", dns.TypeA, "192.0.2.99")}},
+		"www.example.com": {
+			dns.TypeA:    {answer("www.example.com", dns.TypeA, "192.0.2.1")},
+			dns.TypeAAAA: {answer("www.example.com", dns.TypeAAAA, "2001:db8::1")},
+		},
+		"mail.example.com": {dns.TypeA: {
+			answer("mail.example.com", dns.TypeCNAME, "mail.provider.net."),
+			answer("mail.provider.net", dns.TypeA, "198.51.100.1"),
+		}},
+		// the same as the wildcard, so it can't be told apart from a name that doesn't exist
+		"blog.example.com": {dns.TypeA: {answer("blog.example.com", dns.TypeA, "192.0.2.99")}},
+	}}
+	seMod := SubEnumLookupModule{WildcardProbes: 2}
+	seMod.Init([]string{"www", "mail", "blog", "missing", "WWW", ""}, false, false)
+	res, _, status, err := seMod.Lookup(initTest(t, ml), "example.com", nil)
+	require.NoError(t, err)
+	require.Equal(t, zdns.StatusNoError, status)
+	result := res.(*Result)
+	require.Equal(t, []Subdomain{
+		{Name: "www.example.com", IPv4Addresses: []string{"192.0.2.1"}, IPv6Addresses: []string{"2001:db8::1"}},
+		{Name: "mail.example.com", CNAMEs: []string{"mail.provider.net"}, IPv4Addresses: []string{"198.51.100.1"}},
+	}, result.Subdomains)
+	require.Equal(t, []Subdomain{{Name: "*.example.com", IPv4Addresses: []string{"192.0.2.99"}}}, result.Wildcards)
+	require.Equal(t, 4, result.Candidates)
+	require.Equal(t, 2, result.WildcardMatches) // blog and missing
+}
+
+func TestSubEnumNoWildcard(t *testing.T) {
+	ml := &mockLookup{records: map[string]map[uint16][]interface{}{
+		"www.example.com":     {dns.TypeA: {answer("www.example.com", dns.TypeA, "192.0.2.1")}},
+		"dev.api.example.com": {dns.TypeA: {answer("dev.api.example.com", dns.TypeA, "192.0.2.2")}},
+		// exists, but without any address records
+		"mx.example.com": {},
+	}}
+	seMod := SubEnumLookupModule{WildcardProbes: 1}
+	seMod.Init([]string{"www", "dev.api", "mx", "missing"}, true, false)
+	res, _, status, err := seMod.Lookup(initTest(t, ml), "example.com.", nil)
+	require.NoError(t, err)
+	require.Equal(t, zdns.StatusNoError, status)
+	result := res.(*Result)
+	require.Equal(t, []Subdomain{
+		{Name: "www.example.com", IPv4Addresses: []string{"192.0.2.1"}},
+		{Name: "dev.api.example.com", IPv4Addresses: []string{"192.0.2.2"}},
+	}, result.Subdomains)
+	require.Empty(t, result.Wildcards)
+	require.Zero(t, result.WildcardMatches)
+}
+
+func TestSubEnumFailure(t *testing.T) {
+	seMod := SubEnumLookupModule{WildcardProbes: 1}
+	seMod.Init([]string{"www", "mail"}, false, false)
+	res, _, status, _ := seMod.Lookup(initTest(t, &mockLookup{status: zdns.StatusTimeout}), "example.com", nil)
+	require.Equal(t, zdns.StatusTimeout, status)
+	require.Empty(t, res.(*Result).Subdomains)
+}
+
+func TestSubEnumWildcardProbeFailure(t *testing.T) {
+	ml := &mockLookup{records: map[string]map[uint16][]interface{}{
+		"www.example.com":  {dns.TypeA: {answer("www.example.com", dns.TypeA, "192.0.2.1")}},
+		"mail.example.com": {dns.TypeA: {answer("mail.example.com", dns.TypeA, "192.0.2.2")}},
+	}, probeStatus: zdns.StatusServFail}
+	seMod := SubEnumLookupModule{WildcardProbes: 2}
+	seMod.Init([]string{"www", "mail"}, true, false)
+	res, _, status, err := seMod.Lookup(initTest(t, ml), "example.com", nil)
+	require.NoError(t, err)
+	require.Equal(t, zdns.StatusNoError, status)
+	result := res.(*Result)
+	require.Len(t, result.Subdomains, 2)
+	require.Equal(t, []string{"example.com"}, result.Unprobed)
+	// the parent isn't cached, so it's probed again for the second candidate
+	require.Len(t, result.Errors, 4)
+	require.Contains(t, result.Errors[0], "failed with status SERVFAIL")
+}
+
+func TestSubEnumCLIInit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# common names\nwww\n\n  mail  \nwww\n"), 0o600))
+	seMod := SubEnumLookupModule{Wordlist: path, WildcardProbes: 3}
+	require.NoError(t, seMod.CLIInit(&cli.CLIConf{}, &zdns.ResolverConfig{}))
+	require.Equal(t, []string{"www", "mail"}, seMod.words)
+	require.True(t, seMod.IPv4Lookup)
+	require.True(t, seMod.IPv6Lookup)
+
+	seMod = SubEnumLookupModule{}
+	require.Error(t, seMod.CLIInit(&cli.CLIConf{}, &zdns.ResolverConfig{}))
+}
@@ -0,0 +1,408 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zonewalk
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+
+	"github.com/zmap/zdns/src/cli"
+	"github.com/zmap/zdns/src/zdns"
+)
+
+const (
+	DefaultMaxQueries = 10000
+	// maxHashAttempts is the number of candidate names hashed while looking for one that falls in a gap of an NSEC3
+	// chain before giving up on the walk
+	maxHashAttempts = 1 << 20
+)
+
+// NameRecord is an owner name found by following a zone's NSEC chain
+type NameRecord struct {
+	Name       string `json:"name" groups:"short,normal,long,trace"`
+	TypeBitMap string `json:"type_bit_map,omitempty" groups:"short,normal,long,trace"`
+}
+
+// HashRecord is an NSEC3 record collected from a zone, whose hashed owner name can be cracked offline
+type HashRecord struct {
+	Hash       string `json:"hash" groups:"short,normal,long,trace"`
+	NextHash   string `json:"next_hash" groups:"short,normal,long,trace"`
+	OptOut     bool   `json:"opt_out" groups:"normal,long,trace"`
+	TypeBitMap string `json:"type_bit_map" groups:"short,normal,long,trace"`
+}
+
+// NSEC3Parameters are the parameters needed to hash names the same way as the zone
+type NSEC3Parameters struct {
+	HashAlgorithm uint8  `json:"hash_algorithm" groups:"short,normal,long,trace"`
+	Iterations    uint16 `json:"iterations" groups:"short,normal,long,trace"`
+	Salt          string `json:"salt" groups:"short,normal,long,trace"`
+}
+
+// Result is the outcome of walking a single zone. Complete is false if the walk stopped before the whole chain was
+// seen, ex. because the query limit was reached.
+type Result struct {
+	Zone            string           `json:"zone" groups:"short,normal,long,trace"`
+	Type            string           `json:"type" groups:"short,normal,long,trace"`
+	Names           []NameRecord     `json:"names,omitempty" groups:"short,normal,long,trace"`
+	NSEC3Parameters *NSEC3Parameters `json:"nsec3_parameters,omitempty" groups:"short,normal,long,trace"`
+	Hashes          []HashRecord     `json:"hashes,omitempty" groups:"short,normal,long,trace"`
+	Queries         int              `json:"queries" groups:"normal,long,trace"`
+	Complete        bool             `json:"complete" groups:"short,normal,long,trace"`
+}
+
+type ZoneWalkLookupModule struct {
+	cli.BasicLookupModule
+	MaxQueries int `long:"max-queries" default:"10000" description:"maximum number of queries sent while walking a single zone"`
+}
+
+func init() {
+	zw := new(ZoneWalkLookupModule)
+	cli.RegisterLookupModule("ZONEWALK", zw)
+}
+
+// CLIInit initializes the ZoneWalk lookup module. NSEC and NSEC3 records are only included in negative responses when
+// the DNSSEC OK bit is set, so it's always set for this module.
+func (zwMod *ZoneWalkLookupModule) CLIInit(gc *cli.CLIConf, rc *zdns.ResolverConfig) error {
+	if gc.LookupAllNameServers {
+		return errors.New("ZONEWALK module does not support --all-nameservers")
+	}
+	if zwMod.MaxQueries <= 0 {
+		return errors.New("--max-queries must be positive")
+	}
+	rc.DNSSecEnabled = true
+	// probes for names that don't exist are answered with the NSEC or NSEC3 records proving it
+	rc.NegativeResponseAuthorities = true
+	zwMod.DNSClass = dns.ClassINET
+	return zwMod.BasicLookupModule.CLIInit(gc, rc)
+}
+
+// walk holds the state of a single zone walk
+type walk struct {
+	mod        *ZoneWalkLookupModule
+	r          *zdns.Resolver
+	nameServer *zdns.NameServer
+	zone       string
+	res        Result
+	trace      zdns.Trace
+}
+
+// Lookup enumerates the zone lookupName. For zones signed with NSEC, the names in the zone are listed by following
+// the chain of next domain names. For zones signed with NSEC3, the hashed names are collected along with the
+// parameters needed to crack them. Either way, at most MaxQueries queries are sent.
+func (zwMod *ZoneWalkLookupModule) Lookup(r *zdns.Resolver, lookupName string, nameServer *zdns.NameServer) (interface{}, zdns.Trace, zdns.Status, error) {
+	zone := strings.ToLower(strings.TrimSuffix(lookupName, "."))
+	w := &walk{mod: zwMod, r: r, nameServer: nameServer, zone: zone, res: Result{Zone: zone}}
+	// the apex always owns an NSEC or NSEC3 record, which tells us how the zone is signed
+	res, status, err := w.lookup(zone, dns.TypeNSEC, false)
+	if status != zdns.StatusNoError {
+		return nil, w.trace, status, err
+	}
+	if nsec, ok := w.ownNSEC(res, zone); ok {
+		status, err = w.walkNSEC(nsec)
+	} else if params := w.nsec3Parameters(res); params != nil {
+		status, err = w.walkNSEC3(res, params)
+	} else {
+		return nil, w.trace, zdns.StatusNoRecord, errors.Errorf("zone %s does not appear to be signed", zone)
+	}
+	return &w.res, w.trace, status, err
+}
+
+// lookup sends a single query, counting it towards the query limit. Probes for names that don't exist are expected to
+// get a NXDOMAIN, so that's returned as a successful lookup if allowNXDomain is set.
+func (w *walk) lookup(name string, qType uint16, allowNXDomain bool) (*zdns.SingleQueryResult, zdns.Status, error) {
+	res, trace, status, err := w.mod.LookupType(w.r, name, qType, w.nameServer)
+	w.res.Queries++
+	w.trace = append(w.trace, trace...)
+	if allowNXDomain && status == zdns.StatusNXDomain && res != nil {
+		return res, zdns.StatusNoError, nil
+	}
+	return res, status, err
+}
+
+// queryLimitReached returns true once no more queries may be sent
+func (w *walk) queryLimitReached() bool {
+	maxQueries := w.mod.MaxQueries
+	if maxQueries <= 0 {
+		maxQueries = DefaultMaxQueries
+	}
+	return w.res.Queries >= maxQueries
+}
+
+// walkNSEC follows the NSEC chain starting at the apex record until it wraps around to the apex
+func (w *walk) walkNSEC(apex zdns.NSECAnswer) (zdns.Status, error) {
+	w.res.Type = "NSEC"
+	w.res.Names = append(w.res.Names, NameRecord{Name: w.zone, TypeBitMap: apex.TypeBitMap})
+	seen := map[string]struct{}{w.zone: {}}
+	next := strings.ToLower(apex.NextDomain)
+	for {
+		if next == w.zone {
+			w.res.Complete = true
+			return zdns.StatusNoError, nil
+		}
+		if _, ok := seen[next]; ok || !dns.IsSubDomain(w.zone, next) {
+			// the chain is broken, ex. a server synthesizing NSEC records on the fly
+			return zdns.StatusNoError, nil
+		}
+		if w.queryLimitReached() {
+			return zdns.StatusNoError, nil
+		}
+		seen[next] = struct{}{}
+		res, status, err := w.lookup(next, dns.TypeNSEC, false)
+		if status != zdns.StatusNoError {
+			return status, err
+		}
+		nsec, ok := w.ownNSEC(res, next)
+		if !ok {
+			// next is a delegation point, whose NSEC record lives in this zone but whose queries are answered by the
+			// child. Ask for a name that sorts right after it without being below it, the NSEC covering that name is
+			// the one owned by the delegation point.
+			if w.queryLimitReached() {
+				w.res.Names = append(w.res.Names, NameRecord{Name: next})
+				return zdns.StatusNoError, nil
+			}
+			if res, status, err = w.lookup(successor(next), dns.TypeNSEC, true); status != zdns.StatusNoError {
+				return status, err
+			}
+			if nsec, ok = w.coveringNSEC(res, next); !ok {
+				w.res.Names = append(w.res.Names, NameRecord{Name: next})
+				return zdns.StatusNoError, nil
+			}
+			if owner := strings.ToLower(nsec.Name); owner != next {
+				// next has descendants in this zone that we'd otherwise skip over
+				w.res.Names = append(w.res.Names, NameRecord{Name: next})
+				seen[owner] = struct{}{}
+			}
+		}
+		w.res.Names = append(w.res.Names, NameRecord{Name: strings.ToLower(nsec.Name), TypeBitMap: nsec.TypeBitMap})
+		next = strings.ToLower(nsec.NextDomain)
+	}
+}
+
+// ownNSEC returns the NSEC record owned by name in the answers of res, if it was signed by the zone being walked
+func (w *walk) ownNSEC(res *zdns.SingleQueryResult, name string) (zdns.NSECAnswer, bool) {
+	for _, ans := range res.Answers {
+		if sig, ok := ans.(zdns.RRSIGAnswer); ok && sig.TypeCovered == dns.TypeNSEC && canonicalName(sig.SignerName) != w.zone {
+			// answered from a child zone
+			return zdns.NSECAnswer{}, false
+		}
+	}
+	for _, ans := range res.Answers {
+		if nsec, ok := ans.(zdns.NSECAnswer); ok && strings.EqualFold(nsec.Name, name) {
+			return nsec, true
+		}
+	}
+	return zdns.NSECAnswer{}, false
+}
+
+// coveringNSEC returns the NSEC record in the authorities of res owned by the first name at or after name in
+// canonical order. Negative responses may also include the NSEC record denying a wildcard, which is owned by an
+// earlier name.
+func (w *walk) coveringNSEC(res *zdns.SingleQueryResult, name string) (zdns.NSECAnswer, bool) {
+	var best zdns.NSECAnswer
+	found := false
+	for _, ans := range res.Authorities {
+		nsec, ok := ans.(zdns.NSECAnswer)
+		if !ok {
+			continue
+		}
+		owner := strings.ToLower(nsec.Name)
+		if !dns.IsSubDomain(w.zone, owner) || canonicalLess(owner, name) {
+			continue
+		}
+		if !found || canonicalLess(owner, strings.ToLower(best.Name)) {
+			best, found = nsec, true
+		}
+	}
+	return best, found
+}
+
+// nsec3Parameters returns the parameters of the zone's NSEC3 chain from the records in the authorities of res
+func (w *walk) nsec3Parameters(res *zdns.SingleQueryResult) *NSEC3Parameters {
+	for _, ans := range res.Authorities {
+		if nsec3, ok := w.zoneNSEC3(ans); ok {
+			return &NSEC3Parameters{HashAlgorithm: nsec3.HashAlgorithm, Iterations: nsec3.Iterations, Salt: nsec3.Salt}
+		}
+	}
+	return nil
+}
+
+// zoneNSEC3 returns ans as an NSEC3 record if it belongs to the chain of the zone being walked
+func (w *walk) zoneNSEC3(ans interface{}) (zdns.NSEC3Answer, bool) {
+	nsec3, ok := ans.(zdns.NSEC3Answer)
+	if !ok || nsec3.RrType != dns.TypeNSEC3 {
+		return zdns.NSEC3Answer{}, false
+	}
+	hash, parent, _ := strings.Cut(strings.ToLower(nsec3.Name), ".")
+	if hash == "" || parent != w.zone {
+		return zdns.NSEC3Answer{}, false
+	}
+	return nsec3, true
+}
+
+// walkNSEC3 collects the zone's NSEC3 records by querying names whose hashes fall in the gaps between the records
+// seen so far, until the records link up into a complete chain
+func (w *walk) walkNSEC3(res *zdns.SingleQueryResult, params *NSEC3Parameters) (zdns.Status, error) {
+	w.res.Type = "NSEC3"
+	w.res.NSEC3Parameters = params
+	records := make(map[string]HashRecord)
+	addRecords := func(res *zdns.SingleQueryResult) {
+		for _, ans := range res.Authorities {
+			nsec3, ok := w.zoneNSEC3(ans)
+			if !ok || nsec3.HashAlgorithm != params.HashAlgorithm || nsec3.Iterations != params.Iterations || !strings.EqualFold(nsec3.Salt, params.Salt) {
+				continue
+			}
+			hash, _, _ := strings.Cut(strings.ToUpper(nsec3.Name), ".")
+			records[hash] = HashRecord{
+				Hash:       hash,
+				NextHash:   strings.ToUpper(nsec3.NextDomain),
+				OptOut:     nsec3.Flags&1 == 1,
+				TypeBitMap: nsec3.TypeBitMap,
+			}
+		}
+	}
+	defer func() {
+		w.res.Hashes = make([]HashRecord, 0, len(records))
+		for _, record := range records {
+			w.res.Hashes = append(w.res.Hashes, record)
+		}
+		sort.Slice(w.res.Hashes, func(i, j int) bool { return w.res.Hashes[i].Hash < w.res.Hashes[j].Hash })
+	}()
+
+	addRecords(res)
+	candidate := 0
+	for !chainComplete(records) {
+		if w.queryLimitReached() {
+			return zdns.StatusNoError, nil
+		}
+		name, ok := w.uncoveredName(records, params, &candidate)
+		if !ok {
+			return zdns.StatusNoError, nil
+		}
+		res, status, err := w.lookup(name, dns.TypeA, true)
+		if status != zdns.StatusNoError {
+			return status, err
+		}
+		addRecords(res)
+	}
+	w.res.Complete = true
+	return zdns.StatusNoError, nil
+}
+
+// uncoveredName returns a name in the zone whose hash isn't covered by any of records. Candidates are numbered
+// labels, starting from candidate, which is advanced past the returned name.
+func (w *walk) uncoveredName(records map[string]HashRecord, params *NSEC3Parameters, candidate *int) (string, bool) {
+	for attempts := 0; attempts < maxHashAttempts; attempts++ {
+		name := strconv.Itoa(*candidate) + "." + w.zone
+		*candidate++
+		hash := dns.HashName(dns.Fqdn(name), params.HashAlgorithm, params.Iterations, params.Salt)
+		if hash == "" {
+			// unknown hash algorithm
+			return "", false
+		}
+		if !hashCovered(records, hash) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// hashCovered returns true if hash is the owner of one of records or falls between its owner and next hash
+func hashCovered(records map[string]HashRecord, hash string) bool {
+	for owner, record := range records {
+		next := record.NextHash
+		switch {
+		case hash == owner:
+			return true
+		case owner < next && owner < hash && hash < next:
+			return true
+		case owner >= next && (hash > owner || hash < next):
+			// the last record in the chain wraps around to the first
+			return true
+		}
+	}
+	return false
+}
+
+// chainComplete returns true if the next hash of every record is the owner of another, so together they cover the
+// whole hash space
+func chainComplete(records map[string]HashRecord) bool {
+	if len(records) == 0 {
+		return false
+	}
+	for _, record := range records {
+		if _, ok := records[record.NextHash]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// successor returns the name immediately after name in canonical order that isn't below it, made by appending a zero
+// octet to its first label
+func successor(name string) string {
+	label, rest, _ := strings.Cut(name, ".")
+	if rest == "" {
+		return label + `\000`
+	}
+	return label + `\000.` + rest
+}
+
+func canonicalName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// canonicalLess orders names as described in RFC 4034, Section 6.1
+func canonicalLess(a, b string) bool {
+	aLabels := dns.SplitDomainName(a)
+	bLabels := dns.SplitDomainName(b)
+	for i := 1; i <= len(aLabels) && i <= len(bLabels); i++ {
+		aLabel, bLabel := unescapeLabel(aLabels[len(aLabels)-i]), unescapeLabel(bLabels[len(bLabels)-i])
+		if aLabel != bLabel {
+			return aLabel < bLabel
+		}
+	}
+	return len(aLabels) < len(bLabels)
+}
+
+// unescapeLabel converts a label in presentation format to its wire format octets, so labels compare correctly
+func unescapeLabel(label string) string {
+	// large enough for any name in wire format, at most 255 octets
+	buf := make([]byte, 256)
+	n, err := dns.PackDomainName(dns.Fqdn(label), buf, 0, nil, false)
+	if err != nil || n < 2 {
+		return strings.ToLower(label)
+	}
+	return strings.ToLower(string(buf[1 : 1+buf[0]]))
+}
+
+func (zwMod *ZoneWalkLookupModule) Help() string {
+	return ""
+}
+
+func (zwMod *ZoneWalkLookupModule) Validate(args []string) error {
+	return nil
+}
+
+func (zwMod *ZoneWalkLookupModule) GetDescription() string {
+	return "ZONEWALK enumerates a DNSSEC signed zone by following its NSEC chain, or collects its NSEC3 hashes for offline cracking."
+}
+
+func (zwMod *ZoneWalkLookupModule) NewFlags() interface{} {
+	return zwMod
+}
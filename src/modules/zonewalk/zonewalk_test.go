@@ -0,0 +1,239 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zonewalk
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zmap/zdns/src/zdns"
+)
+
+// mockZone answers queries for a signed zone, either from its NSEC chain or, if nsec3 is set, an NSEC3 chain
+type mockZone struct {
+	origin      string
+	names       []string            // names in the zone in canonical order, the first being the origin
+	types       map[string]string   // name -> type bitmap
+	delegations map[string]struct{} // names that are delegated to a child zone
+	nsec3       bool
+	queries     []zdns.Question
+}
+
+const (
+	testSalt       = "AABBCCDD"
+	testIterations = 2
+)
+
+func (z *mockZone) DoDstServersLookup(ctx context.Context, r *zdns.Resolver, question zdns.Question, nameServers []zdns.NameServer, isIterative bool) (*zdns.SingleQueryResult, zdns.Trace, zdns.Status, error) {
+	z.queries = append(z.queries, question)
+	name := strings.ToLower(question.Name)
+	if _, ok := z.types[name]; ok {
+		res := &zdns.SingleQueryResult{}
+		if _, ok = z.delegations[name]; ok {
+			// answered by the signed child zone, from its own apex NSEC record
+			res.Answers = []interface{}{
+				zdns.NSECAnswer{Answer: zdns.Answer{Name: name, Type: "NSEC", RrType: dns.TypeNSEC}, NextDomain: "www." + name, TypeBitMap: "NS SOA"},
+				zdns.RRSIGAnswer{Answer: zdns.Answer{Name: name, Type: "RRSIG", RrType: dns.TypeRRSIG}, TypeCovered: dns.TypeNSEC, SignerName: name + "."},
+			}
+			return res, nil, zdns.StatusNoError, nil
+		}
+		if z.nsec3 {
+			res.Authorities = []interface{}{z.nsec3Record(z.hashIndex(dns.HashName(dns.Fqdn(name), dns.SHA1, testIterations, testSalt)))}
+		} else if question.Type == dns.TypeNSEC {
+			res.Answers = []interface{}{
+				z.nsecRecord(z.index(name)),
+				zdns.RRSIGAnswer{Answer: zdns.Answer{Name: name, Type: "RRSIG", RrType: dns.TypeRRSIG}, TypeCovered: dns.TypeNSEC, SignerName: z.origin + "."},
+			}
+		}
+		return res, nil, zdns.StatusNoError, nil
+	}
+	res := &zdns.SingleQueryResult{}
+	if z.nsec3 {
+		res.Authorities = []interface{}{z.nsec3Record(z.hashIndex(dns.HashName(dns.Fqdn(name), dns.SHA1, testIterations, testSalt)))}
+	} else {
+		// the record covering the name, and the one denying the wildcard
+		res.Authorities = []interface{}{z.nsecRecord(z.coveringIndex(name)), z.nsecRecord(0)}
+	}
+	return res, nil, zdns.StatusNXDomain, nil
+}
+
+func (z *mockZone) index(name string) int {
+	for i, n := range z.names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (z *mockZone) coveringIndex(name string) int {
+	covering := 0
+	for i, n := range z.names {
+		if !canonicalLess(name, n) {
+			covering = i
+		}
+	}
+	return covering
+}
+
+func (z *mockZone) nsecRecord(i int) zdns.NSECAnswer {
+	return zdns.NSECAnswer{
+		Answer:     zdns.Answer{Name: z.names[i], Type: "NSEC", RrType: dns.TypeNSEC},
+		NextDomain: z.names[(i+1)%len(z.names)],
+		TypeBitMap: z.types[z.names[i]],
+	}
+}
+
+func (z *mockZone) hashes() []string {
+	hashes := make([]string, len(z.names))
+	for i, name := range z.names {
+		hashes[i] = dns.HashName(dns.Fqdn(name), dns.SHA1, testIterations, testSalt)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// hashIndex returns the index of the hash matching or covering hash
+func (z *mockZone) hashIndex(hash string) int {
+	hashes := z.hashes()
+	covering := len(hashes) - 1
+	for i, h := range hashes {
+		if h <= hash {
+			covering = i
+		}
+	}
+	return covering
+}
+
+func (z *mockZone) nsec3Record(i int) zdns.NSEC3Answer {
+	hashes := z.hashes()
+	return zdns.NSEC3Answer{
+		Answer:        zdns.Answer{Name: strings.ToLower(hashes[i]) + "." + z.origin, Type: "NSEC3", RrType: dns.TypeNSEC3},
+		HashAlgorithm: dns.SHA1,
+		Iterations:    testIterations,
+		Salt:          testSalt,
+		NextDomain:    hashes[(i+1)%len(hashes)],
+		TypeBitMap:    "A RRSIG",
+	}
+}
+
+func newMockZone(nsec3 bool) *mockZone {
+	return &mockZone{
+		origin: "example.com",
+		names:  []string{"example.com", "a.example.com", "deleg.example.com", "www.example.com", "x.www.example.com"},
+		types: map[string]string{
+			"example.com":       "NS SOA RRSIG NSEC DNSKEY",
+			"a.example.com":     "A RRSIG NSEC",
+			"deleg.example.com": "NS DS RRSIG NSEC",
+			"www.example.com":   "A RRSIG NSEC",
+			"x.www.example.com": "TXT RRSIG NSEC",
+		},
+		delegations: map[string]struct{}{"deleg.example.com": {}},
+		nsec3:       nsec3,
+	}
+}
+
+// unsignedZone answers every query with an empty response
+type unsignedZone struct{}
+
+func (unsignedZone) DoDstServersLookup(ctx context.Context, r *zdns.Resolver, question zdns.Question, nameServers []zdns.NameServer, isIterative bool) (*zdns.SingleQueryResult, zdns.Trace, zdns.Status, error) {
+	return &zdns.SingleQueryResult{}, nil, zdns.StatusNoError, nil
+}
+
+func initTest(t *testing.T, lookupClient zdns.Lookuper) *zdns.Resolver {
+	rc := zdns.ResolverConfig{
+		ExternalNameServersV4: []zdns.NameServer{{IP: net.ParseIP("1.1.1.1"), Port: 53}},
+		RootNameServersV4:     []zdns.NameServer{{IP: net.ParseIP("1.1.1.1"), Port: 53}},
+		LocalAddrsV4:          []net.IP{net.ParseIP("192.168.1.1")},
+		IPVersionMode:         zdns.IPv4Only,
+		LookupClient:          lookupClient}
+	r, err := zdns.InitResolver(&rc)
+	require.NoError(t, err)
+	return r
+}
+
+func TestWalkNSEC(t *testing.T) {
+	z := newMockZone(false)
+	zw := ZoneWalkLookupModule{MaxQueries: 100}
+	res, _, status, err := zw.Lookup(initTest(t, z), "example.com", &zdns.NameServer{IP: net.ParseIP("1.2.3.4"), Port: 53})
+	require.NoError(t, err)
+	require.Equal(t, zdns.StatusNoError, status)
+	result := res.(*Result)
+	require.Equal(t, "NSEC", result.Type)
+	require.True(t, result.Complete)
+	require.Equal(t, []NameRecord{
+		{Name: "example.com", TypeBitMap: "NS SOA RRSIG NSEC DNSKEY"},
+		{Name: "a.example.com", TypeBitMap: "A RRSIG NSEC"},
+		// the delegation point's record is found from the parent side
+		{Name: "deleg.example.com", TypeBitMap: "NS DS RRSIG NSEC"},
+		{Name: "www.example.com", TypeBitMap: "A RRSIG NSEC"},
+		{Name: "x.www.example.com", TypeBitMap: "TXT RRSIG NSEC"},
+	}, result.Names)
+	require.Equal(t, len(z.queries), result.Queries)
+	require.Equal(t, `deleg\000.example.com`, z.queries[3].Name)
+}
+
+func TestWalkNSECQueryLimit(t *testing.T) {
+	z := newMockZone(false)
+	zw := ZoneWalkLookupModule{MaxQueries: 2}
+	res, _, status, err := zw.Lookup(initTest(t, z), "example.com.", nil)
+	require.NoError(t, err)
+	require.Equal(t, zdns.StatusNoError, status)
+	result := res.(*Result)
+	require.False(t, result.Complete)
+	require.Equal(t, 2, result.Queries)
+	require.Len(t, z.queries, 2)
+	require.Len(t, result.Names, 2)
+}
+
+func TestWalkNSEC3(t *testing.T) {
+	z := newMockZone(true)
+	zw := ZoneWalkLookupModule{MaxQueries: 100}
+	res, _, status, err := zw.Lookup(initTest(t, z), "example.com", nil)
+	require.NoError(t, err)
+	require.Equal(t, zdns.StatusNoError, status)
+	result := res.(*Result)
+	require.Equal(t, "NSEC3", result.Type)
+	require.True(t, result.Complete)
+	require.Equal(t, &NSEC3Parameters{HashAlgorithm: dns.SHA1, Iterations: testIterations, Salt: testSalt}, result.NSEC3Parameters)
+	hashes := make([]string, len(result.Hashes))
+	for i, record := range result.Hashes {
+		hashes[i] = record.Hash
+	}
+	require.Equal(t, z.hashes(), hashes)
+	// every query after the first should have uncovered a new record
+	require.Equal(t, len(z.names), result.Queries)
+}
+
+func TestWalkUnsignedZone(t *testing.T) {
+	zw := ZoneWalkLookupModule{MaxQueries: 100}
+	_, _, status, err := zw.Lookup(initTest(t, unsignedZone{}), "example.com", nil)
+	require.Error(t, err)
+	require.Equal(t, zdns.StatusNoRecord, status)
+}
+
+func TestCanonicalLessLongLabels(t *testing.T) {
+	// labels of the maximum 63 octets are compared by their unescaped octets, so \126 (~) sorts after b
+	tilde := `\126` + strings.Repeat("a", 62) + ".example.com"
+	b := "b" + strings.Repeat("a", 62) + ".example.com"
+	require.True(t, canonicalLess(b, tilde))
+	require.False(t, canonicalLess(tilde, b))
+}
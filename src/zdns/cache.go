@@ -27,10 +27,14 @@ import (
 
 type IsCached bool
 
+// maxNegativeCacheTTL caps how long a negative response is cached, regardless of the zone's SOA (RFC 2308, Section 5)
+const maxNegativeCacheTTL = 3 * time.Hour
+
 type CachedKey struct {
 	Question    Question
 	NameServer  string // optional
 	IsAuthority bool
+	IsNegative  bool // an NXDOMAIN or NODATA response, see SafeAddCachedNegativeAnswer
 }
 
 type CachedResult struct {
@@ -39,6 +43,7 @@ type CachedResult struct {
 	Additionals  []TimedAnswer
 	Flags        DNSFlags
 	DNSSECResult *DNSSECResult
+	Status       Status // status of the cached response, only set for negative entries
 }
 
 type TimedAnswer struct {
@@ -49,11 +54,15 @@ type TimedAnswer struct {
 type Cache struct {
 	IterativeCache cachehash.ShardedCacheHash
 	Stats          CacheStatistics
+
+	denials denialCache // validated NSEC and NSEC3 records, see SynthesizeNegativeResult
 }
 
-// Init initializes the cache with a maximum cacheSize.
+// Init initializes the cache with a maximum cacheSize. Up to cacheSize validated NSEC and NSEC3 records are also
+// kept to synthesize negative answers from.
 func (s *Cache) Init(cacheSize int) {
 	s.IterativeCache.Init(cacheSize, 4096)
+	s.denials.maxSize = cacheSize
 }
 
 func (s *Cache) VerboseLog(depth int, args ...interface{}) {
@@ -63,8 +72,8 @@ func (s *Cache) VerboseLog(depth int, args ...interface{}) {
 	}
 }
 
-func (s *Cache) addCachedAnswer(q Question, nameServer string, isAuthority bool, result *CachedResult, depth int) {
-	cacheKey := CachedKey{q, nameServer, isAuthority}
+func (s *Cache) addCachedAnswer(q Question, nameServer string, isAuthority, isNegative bool, result *CachedResult, depth int) {
+	cacheKey := CachedKey{q, nameServer, isAuthority, isNegative}
 	s.IterativeCache.Lock(cacheKey)
 	// this record will replace any existing record with the exact same cache key
 	didExist, didEject := s.IterativeCache.Add(cacheKey, *result)
@@ -85,7 +94,7 @@ func (s *Cache) addCachedAnswer(q Question, nameServer string, isAuthority bool,
 }
 
 func (s *Cache) GetCachedAuthority(authorityName string, ns *NameServer, depth int) (retv *SingleQueryResult, isFound bool) {
-	retv, isFound, partiallyExpired := s.getCachedResult(Question{Name: authorityName, Type: dns.TypeNS, Class: dns.ClassINET}, ns, true, depth)
+	retv, _, isFound, partiallyExpired := s.getCachedResult(Question{Name: authorityName, Type: dns.TypeNS, Class: dns.ClassINET}, ns, true, false, depth)
 	if partiallyExpired {
 		// if the authority is partially expired, we'll re-query it and update the cache. This prevents a cache with only part of a non-expired answer
 		return nil, false
@@ -94,7 +103,7 @@ func (s *Cache) GetCachedAuthority(authorityName string, ns *NameServer, depth i
 }
 
 func (s *Cache) GetCachedResults(q Question, ns *NameServer, depth int) (retv *SingleQueryResult, isFound bool) {
-	retv, isFound, partiallyExpired := s.getCachedResult(q, ns, false, depth)
+	retv, _, isFound, partiallyExpired := s.getCachedResult(q, ns, false, false, depth)
 	if partiallyExpired {
 		// if the authority is partially expired, we'll re-query it and update the cache. This prevents a cache with only part of a non-expired answer
 		return nil, false
@@ -102,11 +111,34 @@ func (s *Cache) GetCachedResults(q Question, ns *NameServer, depth int) (retv *S
 	return retv, isFound
 }
 
-func (s *Cache) getCachedResult(q Question, ns *NameServer, isAuthority bool, depth int) (retv *SingleQueryResult, isFound, partiallyExpired bool) {
+// GetCachedNegativeResult returns a cached NODATA response for q, or a cached NXDOMAIN response for q's name, along
+// with the status of the cached response
+func (s *Cache) GetCachedNegativeResult(q Question, ns *NameServer, depth int) (retv *SingleQueryResult, status Status, isFound bool) {
+	retv, status, isFound, partiallyExpired := s.getCachedResult(q, ns, false, true, depth)
+	if !isFound {
+		retv, status, isFound, partiallyExpired = s.getCachedResult(nxDomainQuestion(q), ns, false, true, depth)
+	}
+	if !isFound || partiallyExpired {
+		s.Stats.IncrementNegativeMisses()
+		return nil, "", false
+	}
+	s.Stats.IncrementNegativeHits()
+	return retv, status, true
+}
+
+// nxDomainQuestion is the key NXDOMAIN responses are cached under, since they apply to every type of the name
+func nxDomainQuestion(q Question) Question {
+	return Question{Name: q.Name, Type: dns.TypeNone, Class: q.Class}
+}
+
+// getCachedResult looks up an entry in the cache. Hits and misses on negative entries are counted by the caller,
+// since a negative lookup checks multiple entries.
+func (s *Cache) getCachedResult(q Question, ns *NameServer, isAuthority, isNegative bool, depth int) (retv *SingleQueryResult, status Status, isFound, partiallyExpired bool) {
 	retv = &SingleQueryResult{}
+	status = StatusNoError
 	isFound = false
 	partiallyExpired = false
-	cacheKey := CachedKey{q, "", isAuthority}
+	cacheKey := CachedKey{q, "", isAuthority, isNegative}
 	if ns != nil {
 		cacheKey.NameServer = ns.String()
 		retv.Resolver = ns.String()
@@ -125,14 +157,21 @@ func (s *Cache) getCachedResult(q Question, ns *NameServer, isAuthority bool, de
 	unres, ok := s.IterativeCache.Get(cacheKey)
 	if !ok { // nothing found
 		s.VerboseLog(depth+2, "-> no entry found in cache for ", q.Name)
-		s.Stats.IncrementMisses()
-		return retv, false, false
+		if !isNegative {
+			s.Stats.IncrementMisses()
+		}
+		return retv, status, false, false
+	}
+	if !isNegative {
+		s.Stats.IncrementHits()
 	}
-	s.Stats.IncrementHits()
 	cachedRes, ok := unres.(CachedResult)
 	if !ok {
 		log.Panic("unable to cast cached result for ", q.Name)
 	}
+	if len(cachedRes.Status) > 0 {
+		status = cachedRes.Status
+	}
 	retv = new(SingleQueryResult)
 	retv.Answers = make([]interface{}, 0, len(cachedRes.Answers))
 	retv.Authorities = make([]interface{}, 0, len(cachedRes.Authorities))
@@ -171,11 +210,11 @@ func (s *Cache) getCachedResult(q Question, ns *NameServer, isAuthority bool, de
 		// remove from cache since it's completely expired
 		s.IterativeCache.Delete(cacheKey)
 		s.VerboseLog(depth+2, "-> no entry found in cache, after expiration for ", cacheKey, ", removing from cache")
-		return nil, false, false
+		return nil, status, false, false
 	}
 
 	s.VerboseLog(depth+2, "Cache hit for ", q.Name, ": ", *retv)
-	return retv, true, partiallyExpired
+	return retv, status, true, partiallyExpired
 }
 
 func isCacheableType(ans WithBaseAnswer) bool {
@@ -246,6 +285,10 @@ func (s *Cache) SafeAddCachedAnswer(q Question, res *SingleQueryResult, ns *Name
 	if res.DNSSECResult != nil && res.DNSSECResult.Status == DNSSECBogus {
 		panic("attempting to cache a bogus result")
 	}
+	if _, isNoData := findNegativeSOA(res); isNoData {
+		s.SafeAddCachedNegativeAnswer(q, res, StatusNoError, ns, layer, depth, cacheNonAuthoritative)
+		return
+	}
 
 	nsString := ""
 	if ns != nil {
@@ -283,7 +326,80 @@ func (s *Cache) SafeAddCachedAnswer(q Question, res *SingleQueryResult, ns *Name
 		s.VerboseLog(depth+1, "SafeAddCachedAnswer: no cacheable records found, aborting")
 		return
 	}
-	s.addCachedAnswer(q, nsString, false, cachedRes, depth)
+	s.addCachedAnswer(q, nsString, false, false, cachedRes, depth)
+}
+
+// findNegativeSOA returns the SOA record of a negative response, one without answers and with the SOA of the zone in
+// the authority section (RFC 2308, Section 2)
+func findNegativeSOA(res *SingleQueryResult) (SOAAnswer, bool) {
+	if len(res.Answers) > 0 {
+		return SOAAnswer{}, false
+	}
+	for _, a := range res.Authorities {
+		if soa, ok := a.(SOAAnswer); ok {
+			return soa, true
+		}
+	}
+	return SOAAnswer{}, false
+}
+
+// SafeAddCachedNegativeAnswer caches an NXDOMAIN (status StatusNXDomain) or NODATA (status StatusNoError) response to
+// q as described in RFC 2308. NXDOMAIN responses are cached for every type of q.Name, NODATA responses only for q's
+// type. The SOA and any NSEC or NSEC3 records in the authority section are cached for the lesser of the SOA's TTL and
+// MINIMUM field. Responses without an SOA record can't be cached.
+func (s *Cache) SafeAddCachedNegativeAnswer(q Question, res *SingleQueryResult, status Status, ns *NameServer, layer string, depth int, cacheNonAuthoritative bool) {
+	if res.DNSSECResult != nil && res.DNSSECResult.Status == DNSSECBogus {
+		panic("attempting to cache a bogus result")
+	}
+	if status != StatusNXDomain && status != StatusNoError {
+		return
+	}
+	if !res.Flags.Authoritative && !cacheNonAuthoritative {
+		s.VerboseLog(depth+1, "SafeAddCachedNegativeAnswer: aborting since response is non-authoritative: ", q)
+		return
+	}
+	soa, ok := findNegativeSOA(res)
+	if !ok {
+		s.VerboseLog(depth+1, "SafeAddCachedNegativeAnswer: no SOA record found, aborting: ", q)
+		return
+	}
+	// check for poison, the SOA must be for a zone containing the name, and for iterative lookups, the zone queried
+	if ok, _ = nameIsBeneath(q.Name, soa.Name); !ok {
+		s.VerboseLog(depth+1, "SafeAddCachedNegativeAnswer: detected poison: ", soa.Name, "(SOA): ", q.Name, " , aborting")
+		return
+	}
+	if ok, _ = nameIsBeneath(soa.Name, layer); !ok && !cacheNonAuthoritative {
+		s.VerboseLog(depth+1, "SafeAddCachedNegativeAnswer: detected poison: ", soa.Name, "(SOA): ", layer, " , aborting")
+		return
+	}
+	ttl := time.Duration(min(soa.TTL, soa.Minttl)) * time.Second
+	ttl = min(ttl, maxNegativeCacheTTL)
+	if ttl == 0 {
+		s.VerboseLog(depth+1, "SafeAddCachedNegativeAnswer: negative TTL is 0, aborting: ", q)
+		return
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	cachedRes := CachedResult{
+		Flags:        res.Flags,
+		DNSSECResult: res.DNSSECResult,
+		Status:       status,
+	}
+	for _, a := range res.Authorities {
+		switch a.(type) {
+		case SOAAnswer, NSECAnswer, NSEC3Answer:
+			cachedRes.Authorities = append(cachedRes.Authorities, TimedAnswer{Answer: a.(WithBaseAnswer), ExpiresAt: expiresAt})
+		}
+	}
+	nsString := ""
+	if ns != nil {
+		nsString = ns.String()
+	}
+	key := q
+	if status == StatusNXDomain {
+		key = nxDomainQuestion(q)
+	}
+	s.addCachedAnswer(key, nsString, false, true, &cachedRes, depth)
 }
 
 // SafeAddCachedAuthority Writes an authority to the cache. This is a special case where the result should only have
@@ -357,7 +473,7 @@ func (s *Cache) SafeAddCachedAuthority(res *SingleQueryResult, ns *NameServer, d
 			}
 			dsRes.Flags.Authoritative = true
 			dsCachedRes := s.buildCachedResult(dsRes, depth, layer)
-			s.addCachedAnswer(Question{Name: delegateName, Type: dns.TypeDS, Class: dns.ClassINET}, nsString, false, dsCachedRes, depth)
+			s.addCachedAnswer(Question{Name: delegateName, Type: dns.TypeDS, Class: dns.ClassINET}, nsString, false, false, dsCachedRes, depth)
 		}
 	}
 
@@ -368,5 +484,5 @@ func (s *Cache) SafeAddCachedAuthority(res *SingleQueryResult, ns *NameServer, d
 		s.VerboseLog(depth+1, "SafeAddCachedAnswer: no cacheable records found, aborting")
 		return
 	}
-	s.addCachedAnswer(Question{Name: authName, Type: dns.TypeNS, Class: dns.ClassINET}, nsString, true, cachedRes, depth)
+	s.addCachedAnswer(Question{Name: authName, Type: dns.TypeNS, Class: dns.ClassINET}, nsString, true, false, cachedRes, depth)
 }
@@ -0,0 +1,424 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+/*
+ * Aggressive use of the DNSSEC validated cache.
+ * RFC reference:
+ * - https://datatracker.ietf.org/doc/html/rfc8198
+ * - https://datatracker.ietf.org/doc/html/rfc4035#section-5.4 (NSEC denial of existence)
+ * - https://datatracker.ietf.org/doc/html/rfc5155#section-8 (NSEC3 denial of existence)
+ *
+ * Validated NSEC and NSEC3 records from negative responses are kept by zone. A later query for a name inside one of
+ * the proven gaps is answered with a synthesized NXDOMAIN or NODATA response instead of going to the wire.
+ */
+
+package zdns
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// maxNSEC3Iterations is the highest NSEC3 iteration count answers are synthesized for. RFC 9276 recommends 0, and
+// hashing names with a high count for every lookup would cost more than the queries it saves.
+const maxNSEC3Iterations = 150
+
+// denialRecord is a validated NSEC or NSEC3 record
+type denialRecord struct {
+	rr dns.RR // *dns.NSEC or *dns.NSEC3
+	// key orders the records of a zone. For NSEC, it's the canonical key of the owner name, for NSEC3, the owner hash.
+	key       string
+	nextKey   string
+	expiresAt time.Time
+}
+
+// zoneDenials holds the validated NSEC or NSEC3 records of a single zone
+type zoneDenials struct {
+	soa          *dns.SOA
+	soaExpiresAt time.Time
+	nsec         []denialRecord // sorted by key
+	nsec3        []denialRecord // sorted by key, all with the same hash parameters
+}
+
+// denialCache holds the validated NSEC and NSEC3 records of each zone, keyed by canonical zone name
+type denialCache struct {
+	mu      sync.RWMutex
+	zones   map[string]*zoneDenials
+	size    int
+	maxSize int // maximum number of records across all zones, 0 for no limit
+}
+
+// addValidatedDenials caches the NSEC and NSEC3 records of a negative response that passed DNSSEC validation. They're
+// kept for the lesser of their TTL and the negative TTL of the zone's SOA (RFC 8198, Section 5.4).
+func (s *Cache) addValidatedDenials(msg *dns.Msg, depth int) {
+	if len(msg.Answer) > 0 || (msg.Rcode != dns.RcodeSuccess && msg.Rcode != dns.RcodeNameError) {
+		return
+	}
+	var soa *dns.SOA
+	for _, rr := range msg.Ns {
+		if typedSOA, ok := rr.(*dns.SOA); ok {
+			soa = typedSOA
+			break
+		}
+	}
+	if soa == nil {
+		return
+	}
+	zone := dns.CanonicalName(soa.Hdr.Name)
+	negativeTTL := min(time.Duration(min(soa.Hdr.Ttl, soa.Minttl))*time.Second, maxNegativeCacheTTL)
+	now := time.Now()
+
+	d := &s.denials
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.zones == nil {
+		d.zones = make(map[string]*zoneDenials)
+	}
+	z, ok := d.zones[zone]
+	if !ok {
+		z = new(zoneDenials)
+		d.zones[zone] = z
+	}
+	z.soa = soa
+	z.soaExpiresAt = now.Add(negativeTTL)
+	added := 0
+	for _, rr := range msg.Ns {
+		if !dns.IsSubDomain(zone, dns.CanonicalName(rr.Header().Name)) {
+			continue
+		}
+		if d.maxSize > 0 && d.size >= d.maxSize && !d.removeExpired(now) {
+			s.VerboseLog(depth+1, "addValidatedDenials: denial cache is full, not adding records for ", zone)
+			break
+		}
+		expiresAt := now.Add(min(time.Duration(rr.Header().Ttl)*time.Second, negativeTTL))
+		switch typedRR := rr.(type) {
+		case *dns.NSEC:
+			rec := denialRecord{rr: typedRR, key: canonicalKey(typedRR.Hdr.Name), nextKey: canonicalKey(typedRR.NextDomain), expiresAt: expiresAt}
+			z.nsec, ok = insertDenial(z.nsec, rec)
+		case *dns.NSEC3:
+			if len(z.nsec3) > 0 && !sameNSEC3Parameters(z.nsec3[0].rr.(*dns.NSEC3), typedRR) {
+				// the zone's NSEC3 parameters changed, so the records cached for the old ones are stale
+				d.size -= len(z.nsec3)
+				z.nsec3 = nil
+			}
+			ownerHash, _, _ := strings.Cut(typedRR.Hdr.Name, ".")
+			rec := denialRecord{rr: typedRR, key: strings.ToUpper(ownerHash), nextKey: strings.ToUpper(typedRR.NextDomain), expiresAt: expiresAt}
+			z.nsec3, ok = insertDenial(z.nsec3, rec)
+		default:
+			continue
+		}
+		if ok {
+			d.size++
+			added++
+		}
+	}
+	s.VerboseLog(depth+1, "addValidatedDenials: cached ", added, " NSEC/NSEC3 records for ", zone)
+}
+
+// insertDenial inserts rec into records sorted by key, replacing any record with the same key. Returns true if the
+// number of records grew.
+func insertDenial(records []denialRecord, rec denialRecord) ([]denialRecord, bool) {
+	i := sort.Search(len(records), func(i int) bool { return records[i].key >= rec.key })
+	if i < len(records) && records[i].key == rec.key {
+		records[i] = rec
+		return records, false
+	}
+	return slices.Insert(records, i, rec), true
+}
+
+// removeExpired drops every expired record, returning true if any were dropped. The caller must hold the write lock.
+func (d *denialCache) removeExpired(now time.Time) bool {
+	before := d.size
+	isExpired := func(rec denialRecord) bool { return rec.expiresAt.Before(now) }
+	for zone, z := range d.zones {
+		z.nsec = slices.DeleteFunc(z.nsec, isExpired)
+		z.nsec3 = slices.DeleteFunc(z.nsec3, isExpired)
+		if len(z.nsec) == 0 && len(z.nsec3) == 0 {
+			delete(d.zones, zone)
+		}
+	}
+	d.size = 0
+	for _, z := range d.zones {
+		d.size += len(z.nsec) + len(z.nsec3)
+	}
+	return d.size < before
+}
+
+func sameNSEC3Parameters(a, b *dns.NSEC3) bool {
+	return a.Hash == b.Hash && a.Iterations == b.Iterations && strings.EqualFold(a.Salt, b.Salt)
+}
+
+// SynthesizeNegativeResult answers q from the validated NSEC and NSEC3 records of the closest enclosing zone, as
+// described in RFC 8198. It returns an NXDOMAIN (StatusNXDomain) or NODATA (StatusNoError without answers) response
+// if the cached records prove one, along with the records that prove it.
+func (s *Cache) SynthesizeNegativeResult(q Question, depth int) (*SingleQueryResult, Status, bool) {
+	name := dns.CanonicalName(q.Name)
+	d := &s.denials
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	// only the closest enclosing zone is authoritative for the name, a parent zone can't prove anything below a cut.
+	// DS records live on the parent side of a zone cut, so the zone at the name itself is skipped for them.
+	var zone string
+	var z *zoneDenials
+	for candidate := name; ; {
+		if found, ok := d.zones[candidate]; ok && (q.Type != dns.TypeDS || candidate != name || candidate == rootZone) {
+			zone, z = candidate, found
+			break
+		}
+		if candidate == rootZone {
+			return nil, "", false
+		}
+		candidate = parentZone(candidate)
+	}
+	now := time.Now()
+	if z.soaExpiresAt.Before(now) {
+		return nil, "", false
+	}
+	var proof []dns.RR
+	var status Status
+	var ok bool
+	if len(z.nsec) > 0 {
+		proof, status, ok = z.nsecProof(name, q.Type, zone, now)
+	} else if len(z.nsec3) > 0 {
+		proof, status, ok = z.nsec3Proof(name, q.Type, zone, now)
+	}
+	if !ok {
+		return nil, "", false
+	}
+
+	res := &SingleQueryResult{
+		Answers:     []interface{}{},
+		Additionals: []interface{}{},
+		Authorities: []interface{}{ParseAnswer(z.soa)},
+		Flags:       DNSFlags{Response: true, Authoritative: true, ErrorCode: dns.RcodeSuccess},
+	}
+	if status == StatusNXDomain {
+		res.Flags.ErrorCode = dns.RcodeNameError
+	}
+	for _, rr := range proof {
+		res.Authorities = append(res.Authorities, ParseAnswer(rr))
+	}
+	res.DNSSECResult = makeDNSSECResult()
+	res.DNSSECResult.Status = DNSSECSecure
+	res.synthesizedFrom = removeTrailingDotIfNotRoot(zone)
+	s.Stats.IncrementSynthesized()
+	s.VerboseLog(depth+1, "Synthesized ", status, " for ", q.Name, " (", dns.TypeToString[q.Type], ") from cached denials of ", zone)
+	return res, status, true
+}
+
+// nsecProof proves name doesn't exist, or has no records of qType, with the zone's NSEC records (RFC 4035, Section
+// 5.4). An NXDOMAIN also requires proof that no wildcard could have matched name.
+func (z *zoneDenials) nsecProof(name string, qType uint16, zone string, now time.Time) ([]dns.RR, Status, bool) {
+	match, cover := z.findNSEC(name, now)
+	if match != nil {
+		nsec := match.rr.(*dns.NSEC)
+		if !deniesType(nsec.TypeBitMap, qType) {
+			return nil, "", false
+		}
+		return []dns.RR{nsec}, StatusNoError, true
+	}
+	if cover == nil {
+		return nil, "", false
+	}
+	nsec := cover.rr.(*dns.NSEC)
+	if isBelowCut(nsec.Hdr.Name, nsec.TypeBitMap, name, zone) {
+		return nil, "", false
+	}
+	// the closest encloser is the longest ancestor of name that exists, which is shared with either end of the gap
+	closestEncloser := commonAncestor(name, nsec.Hdr.Name)
+	if other := commonAncestor(name, nsec.NextDomain); dns.CountLabel(other) > dns.CountLabel(closestEncloser) {
+		closestEncloser = other
+	}
+	if closestEncloser == name {
+		// the next name is below name, so name is an empty non-terminal without any records
+		return []dns.RR{nsec}, StatusNoError, true
+	}
+	if !dns.IsSubDomain(zone, closestEncloser) {
+		return nil, "", false
+	}
+	wildcardMatch, wildcardCover := z.findNSEC("*."+closestEncloser, now)
+	if wildcardMatch != nil || wildcardCover == nil {
+		// a wildcard may exist, in which case the name would have been synthesized from it
+		return nil, "", false
+	}
+	proof := []dns.RR{nsec}
+	if wildcardCover != cover {
+		proof = append(proof, wildcardCover.rr)
+	}
+	return proof, StatusNXDomain, true
+}
+
+// findNSEC returns the unexpired NSEC record owned by name or covering it, if there is one
+func (z *zoneDenials) findNSEC(name string, now time.Time) (match, cover *denialRecord) {
+	return findDenial(z.nsec, canonicalKey(name), now)
+}
+
+// nsec3Proof proves name doesn't exist, or has no records of qType, with the zone's NSEC3 records (RFC 5155, Section
+// 8). An NXDOMAIN requires the closest encloser proof, along with proof that no wildcard at the closest encloser exists.
+func (z *zoneDenials) nsec3Proof(name string, qType uint16, zone string, now time.Time) ([]dns.RR, Status, bool) {
+	params := z.nsec3[0].rr.(*dns.NSEC3)
+	if params.Iterations > maxNSEC3Iterations {
+		return nil, "", false
+	}
+	find := func(name string) (match, cover *denialRecord) {
+		return findDenial(z.nsec3, dns.HashName(name, params.Hash, params.Iterations, params.Salt), now)
+	}
+
+	if match, _ := find(name); match != nil {
+		nsec3 := match.rr.(*dns.NSEC3)
+		if !deniesType(nsec3.TypeBitMap, qType) {
+			return nil, "", false
+		}
+		return []dns.RR{nsec3}, StatusNoError, true
+	}
+	// find the closest encloser, the longest existing ancestor, and prove the next closer name below it doesn't exist
+	nextCloser := name
+	for closestEncloser := parentZone(name); dns.IsSubDomain(zone, closestEncloser); closestEncloser = parentZone(closestEncloser) {
+		match, _ := find(closestEncloser)
+		if match == nil {
+			nextCloser = closestEncloser
+			if closestEncloser == rootZone {
+				break
+			}
+			continue
+		}
+		encloser := match.rr.(*dns.NSEC3)
+		if slices.Contains(encloser.TypeBitMap, dns.TypeDNAME) || (slices.Contains(encloser.TypeBitMap, dns.TypeNS) && !slices.Contains(encloser.TypeBitMap, dns.TypeSOA)) {
+			// the name is below a delegation or DNAME, so this zone can't prove anything about it
+			return nil, "", false
+		}
+		_, nextCloserCover := find(nextCloser)
+		if nextCloserCover == nil || nextCloserCover.rr.(*dns.NSEC3).Flags&NSEC3OptOutFlag != 0 {
+			// an opt-out span may hide unsigned delegations, so it doesn't prove the name doesn't exist
+			return nil, "", false
+		}
+		wildcardMatch, wildcardCover := find("*." + closestEncloser)
+		if wildcardMatch != nil || wildcardCover == nil {
+			return nil, "", false
+		}
+		proof := []dns.RR{encloser, nextCloserCover.rr}
+		if wildcardCover != nextCloserCover {
+			proof = append(proof, wildcardCover.rr)
+		}
+		return proof, StatusNXDomain, true
+	}
+	return nil, "", false
+}
+
+// findDenial returns the unexpired record of records whose key is key, or whose gap covers key
+func findDenial(records []denialRecord, key string, now time.Time) (match, cover *denialRecord) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+	// the record with the greatest key at or before key, or the last record, whose gap wraps around to the first name
+	i := sort.Search(len(records), func(i int) bool { return records[i].key > key }) - 1
+	if i < 0 {
+		i = len(records) - 1
+	}
+	rec := &records[i]
+	if rec.expiresAt.Before(now) {
+		return nil, nil
+	}
+	if rec.key == key {
+		return rec, nil
+	}
+	if rec.key < rec.nextKey {
+		if rec.key < key && key < rec.nextKey {
+			return nil, rec
+		}
+	} else if key > rec.key || key < rec.nextKey {
+		// the last record in the zone, whose next name is the first
+		return nil, rec
+	}
+	return nil, nil
+}
+
+// deniesType returns true if a type bitmap proves there are no records of qType, or a CNAME, at its owner. A bitmap of
+// a delegation point only proves the absence of DS records, since everything else is in the child zone.
+func deniesType(typeBitMap []uint16, qType uint16) bool {
+	if slices.Contains(typeBitMap, qType) || slices.Contains(typeBitMap, dns.TypeCNAME) {
+		return false
+	}
+	if slices.Contains(typeBitMap, dns.TypeNS) && !slices.Contains(typeBitMap, dns.TypeSOA) {
+		return qType == dns.TypeDS
+	}
+	return true
+}
+
+// isBelowCut returns true if name is below the delegation or DNAME at owner, an NSEC owner name within zone
+func isBelowCut(owner string, typeBitMap []uint16, name, zone string) bool {
+	owner = dns.CanonicalName(owner)
+	if owner == zone || !dns.IsSubDomain(owner, name) {
+		return false
+	}
+	return slices.Contains(typeBitMap, dns.TypeDNAME) || (slices.Contains(typeBitMap, dns.TypeNS) && !slices.Contains(typeBitMap, dns.TypeSOA))
+}
+
+// commonAncestor returns the longest name that both a and b are at or below
+func commonAncestor(a, b string) string {
+	aLabels := dns.SplitDomainName(dns.CanonicalName(a))
+	bLabels := dns.SplitDomainName(dns.CanonicalName(b))
+	shared := 0
+	for shared < len(aLabels) && shared < len(bLabels) && aLabels[len(aLabels)-1-shared] == bLabels[len(bLabels)-1-shared] {
+		shared++
+	}
+	if shared == 0 {
+		return rootZone
+	}
+	return dns.Fqdn(strings.Join(aLabels[len(aLabels)-shared:], "."))
+}
+
+// parentZone returns the name one label above name, a canonical name other than the root
+func parentZone(name string) string {
+	_, parent, _ := strings.Cut(name, ".")
+	if len(parent) == 0 {
+		return rootZone
+	}
+	return parent
+}
+
+// canonicalKey returns a string that sorts in the canonical DNS name order of RFC 4034, Section 6.1. Labels are
+// compared from the rightmost, as case-insensitive octet strings where a shorter label sorts first.
+func canonicalKey(name string) string {
+	buf := make([]byte, 256)
+	off, err := dns.PackDomainName(dns.CanonicalName(name), buf, 0, nil, false)
+	if err != nil {
+		return strings.ToLower(name)
+	}
+	var labels [][]byte
+	for i := 0; i < off && buf[i] != 0; i += int(buf[i]) + 1 {
+		labels = append(labels, buf[i+1:i+1+int(buf[i])])
+	}
+	var b strings.Builder
+	for i := len(labels) - 1; i >= 0; i-- {
+		// each label starts with a 0 byte, so 0 and 1 within labels are escaped to sort after it
+		b.WriteByte(0)
+		for _, c := range labels[i] {
+			switch c {
+			case 0:
+				b.Write([]byte{1, 1})
+			case 1:
+				b.Write([]byte{1, 2})
+			default:
+				b.WriteByte(c)
+			}
+		}
+	}
+	return b.String()
+}
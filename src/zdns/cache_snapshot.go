@@ -0,0 +1,175 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/zmap/zdns/src/internal/util"
+)
+
+// cacheSnapshotVersion must be incremented whenever the encoding of CachedKey or CachedResult changes
+const cacheSnapshotVersion = 2
+
+type cacheSnapshotHeader struct {
+	Version int
+	SavedAt time.Time
+}
+
+type cacheSnapshotEntry struct {
+	Key    CachedKey
+	Result CachedResult
+}
+
+func init() {
+	// gob needs the concrete types that can be stored in a TimedAnswer. These are the types produced by ParseAnswer
+	// for the record types allowed by isCacheableType, and the SOA records of negative entries.
+	gob.Register(Answer{})
+	gob.Register(DNSKEYAnswer{})
+	gob.Register(DSAnswer{})
+	gob.Register(NSECAnswer{})
+	gob.Register(NSEC3Answer{})
+	gob.Register(SOAAnswer{})
+}
+
+// isSnapshotAnswer returns true if the answer's type is registered with gob above
+func isSnapshotAnswer(ans WithBaseAnswer) bool {
+	switch ans.(type) {
+	case Answer, DNSKEYAnswer, DSAnswer, NSECAnswer, NSEC3Answer, SOAAnswer:
+		return true
+	default:
+		return false
+	}
+}
+
+// isSnapshotEntryUsable returns true if every record in the result can be encoded and is unexpired. getCachedResult
+// treats a partially expired entry as a miss, so there is no point in persisting one.
+func isSnapshotEntryUsable(res *CachedResult, now time.Time) bool {
+	for _, section := range [][]TimedAnswer{res.Answers, res.Authorities, res.Additionals} {
+		for _, ans := range section {
+			if ans.ExpiresAt.Before(now) || !isSnapshotAnswer(ans.Answer) {
+				return false
+			}
+		}
+	}
+	return len(res.Answers) > 0 || len(res.Authorities) > 0 || len(res.Additionals) > 0
+}
+
+// WriteSnapshot writes all unexpired cache entries to w so they can be restored with ReadSnapshot.
+// Each cache shard is locked while its entries are written. Returns the number of entries written.
+func (s *Cache) WriteSnapshot(w io.Writer) (int, error) {
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(cacheSnapshotHeader{Version: cacheSnapshotVersion, SavedAt: time.Now()}); err != nil {
+		return 0, errors.Wrap(err, "unable to write cache snapshot header")
+	}
+	now := time.Now()
+	written := 0
+	var encErr error
+	s.IterativeCache.Range(func(k, v interface{}) bool {
+		key, ok := k.(CachedKey)
+		if !ok {
+			return true
+		}
+		res, ok := v.(CachedResult)
+		if !ok || !isSnapshotEntryUsable(&res, now) {
+			return true
+		}
+		if encErr = enc.Encode(cacheSnapshotEntry{Key: key, Result: res}); encErr != nil {
+			return false
+		}
+		written++
+		return true
+	})
+	if encErr != nil {
+		return written, errors.Wrap(encErr, "unable to write cache snapshot entry")
+	}
+	return written, nil
+}
+
+// ReadSnapshot adds the entries of a snapshot written by WriteSnapshot to the cache, skipping any that have expired
+// since the snapshot was taken. Returns the number of entries added.
+func (s *Cache) ReadSnapshot(r io.Reader) (int, error) {
+	dec := gob.NewDecoder(r)
+	var header cacheSnapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return 0, errors.Wrap(err, "unable to read cache snapshot header")
+	}
+	if header.Version != cacheSnapshotVersion {
+		return 0, fmt.Errorf("unsupported cache snapshot version %d, expected %d", header.Version, cacheSnapshotVersion)
+	}
+	now := time.Now()
+	added := 0
+	for {
+		var entry cacheSnapshotEntry
+		if err := dec.Decode(&entry); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return added, errors.Wrap(err, "unable to read cache snapshot entry")
+		}
+		if !isSnapshotEntryUsable(&entry.Result, now) {
+			continue
+		}
+		s.IterativeCache.Lock(entry.Key)
+		s.IterativeCache.Add(entry.Key, entry.Result)
+		s.IterativeCache.Unlock(entry.Key)
+		added++
+	}
+	return added, nil
+}
+
+// SaveToFile writes a snapshot of the cache to path. The snapshot is written to a temporary file first so an existing
+// snapshot is only replaced once the new one is complete. Returns the number of entries written.
+func (s *Cache) SaveToFile(path string) (int, error) {
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, util.DefaultFilePermissions)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to open cache file")
+	}
+	w := bufio.NewWriter(f)
+	written, err := s.WriteSnapshot(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return 0, errors.Wrap(err, "unable to write cache file")
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return 0, errors.Wrap(err, "unable to replace cache file")
+	}
+	return written, nil
+}
+
+// LoadFromFile adds the entries of a snapshot written by SaveToFile to the cache. The cache must already be
+// initialized with Init. Returns the number of entries added. If path does not exist, the returned error wraps
+// os.ErrNotExist.
+func (s *Cache) LoadFromFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to open cache file")
+	}
+	defer f.Close()
+	return s.ReadSnapshot(bufio.NewReader(f))
+}
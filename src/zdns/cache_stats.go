@@ -24,6 +24,9 @@ type CacheStatistics struct {
 	misses                  atomic.Uint64 // number of reads to the cache that result in a miss
 	writes                  atomic.Uint64 // number of writes to the cache
 	ejects                  atomic.Uint64 // number of cache entries that are ejected due to insertions
+	negativeHits            atomic.Uint64 // number of reads for NXDOMAIN/NODATA entries that result in a hit
+	negativeMisses          atomic.Uint64 // number of reads for NXDOMAIN/NODATA entries that result in a miss
+	synthesized             atomic.Uint64 // number of negative answers synthesized from validated NSEC/NSEC3 records
 }
 
 type CacheStatisticsMetadata struct {
@@ -33,6 +36,12 @@ type CacheStatisticsMetadata struct {
 	Ejects   uint64  `json:"ejects"`
 	HitRate  float64 `json:"hit_rate"`
 	MissRate float64 `json:"miss_rate"`
+	// Negative entries cache NXDOMAIN and NODATA responses, and are only read after a miss on a positive entry
+	NegativeHits    uint64  `json:"negative_hits"`
+	NegativeMisses  uint64  `json:"negative_misses"`
+	NegativeHitRate float64 `json:"negative_hit_rate"`
+	// Synthesized counts NXDOMAIN and NODATA answers synthesized from cached NSEC and NSEC3 records (RFC 8198)
+	Synthesized uint64 `json:"synthesized"`
 }
 
 func (s *CacheStatistics) IncrementHits() {
@@ -67,16 +76,40 @@ func (s *CacheStatistics) IncrementEjects() {
 	}
 }
 
+func (s *CacheStatistics) IncrementNegativeHits() {
+	if s.shouldCaptureStatistics {
+		s.negativeHits.Add(1)
+	}
+}
+
+func (s *CacheStatistics) IncrementNegativeMisses() {
+	if s.shouldCaptureStatistics {
+		s.negativeMisses.Add(1)
+	}
+}
+
+func (s *CacheStatistics) IncrementSynthesized() {
+	if s.shouldCaptureStatistics {
+		s.synthesized.Add(1)
+	}
+}
+
 func (s *CacheStatistics) GetStatistics() *CacheStatisticsMetadata {
 	hits := s.hits.Load()
 	misses := s.misses.Load()
 	writes := s.writes.Load()
 	ejects := s.ejects.Load()
 	metadata := CacheStatisticsMetadata{
-		Hits:   hits,
-		Misses: misses,
-		Writes: writes,
-		Ejects: ejects,
+		Hits:           hits,
+		Misses:         misses,
+		Writes:         writes,
+		Ejects:         ejects,
+		NegativeHits:   s.negativeHits.Load(),
+		NegativeMisses: s.negativeMisses.Load(),
+		Synthesized:    s.synthesized.Load(),
+	}
+	if negativeTotal := metadata.NegativeHits + metadata.NegativeMisses; negativeTotal > 0 {
+		metadata.NegativeHitRate = float64(metadata.NegativeHits) / float64(negativeTotal)
 	}
 	total := hits + misses
 	if total == 0 {
@@ -14,12 +14,17 @@
 package zdns
 
 import (
+	"fmt"
 	"net"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/miekg/dns"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCheckForNonExistentKey(t *testing.T) {
@@ -148,3 +153,226 @@ func TestNoNameServerLookupNotAuthoritative(t *testing.T) {
 	_, found = cache.GetCachedResults(Question{1, 1, "google.com"}, nil, 0)
 	assert.True(t, found, "should cache non-authoritative answers")
 }
+
+func TestNegativeCaching(t *testing.T) {
+	cache := Cache{}
+	cache.Init(4096)
+	cache.Stats.CaptureStatistics()
+	soa := SOAAnswer{Answer: Answer{TTL: 3600, RrType: dns.TypeSOA, RrClass: dns.ClassINET, Name: "example.com"}, Minttl: 300}
+	negative := func(authorities ...interface{}) *SingleQueryResult {
+		return &SingleQueryResult{Authorities: authorities, Flags: DNSFlags{Authoritative: true}}
+	}
+
+	// NXDOMAIN applies to every type of the name
+	cache.SafeAddCachedNegativeAnswer(Question{Type: dns.TypeA, Name: "missing.example.com", Class: dns.ClassINET}, negative(soa), StatusNXDomain, nil, "example.com", 0, false)
+	res, status, found := cache.GetCachedNegativeResult(Question{Type: dns.TypeMX, Name: "missing.example.com", Class: dns.ClassINET}, nil, 0)
+	require.True(t, found)
+	assert.Equal(t, StatusNXDomain, status)
+	assert.Equal(t, soa, res.Authorities[0])
+
+	// NODATA only applies to the type queried, and is cached from SafeAddCachedAnswer
+	cache.SafeAddCachedAnswer(Question{Type: dns.TypeAAAA, Name: "www.example.com", Class: dns.ClassINET}, negative(soa), nil, "example.com", 0, false)
+	_, found = cache.GetCachedResults(Question{Type: dns.TypeAAAA, Name: "www.example.com", Class: dns.ClassINET}, nil, 0)
+	assert.False(t, found, "NODATA shouldn't be cached as a positive answer")
+	_, status, found = cache.GetCachedNegativeResult(Question{Type: dns.TypeAAAA, Name: "www.example.com", Class: dns.ClassINET}, nil, 0)
+	require.True(t, found)
+	assert.Equal(t, StatusNoError, status)
+	_, _, found = cache.GetCachedNegativeResult(Question{Type: dns.TypeA, Name: "www.example.com", Class: dns.ClassINET}, nil, 0)
+	assert.False(t, found)
+
+	// responses without an SOA, or with the SOA of an unrelated zone, can't be cached
+	cache.SafeAddCachedNegativeAnswer(Question{Type: dns.TypeA, Name: "nosoa.example.com", Class: dns.ClassINET}, negative(), StatusNXDomain, nil, "example.com", 0, false)
+	_, _, found = cache.GetCachedNegativeResult(Question{Type: dns.TypeA, Name: "nosoa.example.com", Class: dns.ClassINET}, nil, 0)
+	assert.False(t, found)
+	cache.SafeAddCachedNegativeAnswer(Question{Type: dns.TypeA, Name: "google.com", Class: dns.ClassINET}, negative(soa), StatusNXDomain, nil, "example.com", 0, false)
+	_, _, found = cache.GetCachedNegativeResult(Question{Type: dns.TypeA, Name: "google.com", Class: dns.ClassINET}, nil, 0)
+	assert.False(t, found)
+
+	stats := cache.Stats.GetStatistics()
+	assert.Equal(t, uint64(2), stats.NegativeHits)
+	assert.Equal(t, uint64(3), stats.NegativeMisses)
+}
+
+func TestNegativeCachingTTL(t *testing.T) {
+	cache := Cache{}
+	cache.Init(4096)
+	q := Question{Type: dns.TypeA, Name: "missing.example.com", Class: dns.ClassINET}
+	// the lesser of the SOA's TTL and MINIMUM field is used, capped at maxNegativeCacheTTL
+	for _, tc := range []struct {
+		ttl, minTTL uint32
+		expected    time.Duration
+	}{
+		{3600, 300, 300 * time.Second},
+		{60, 300, 60 * time.Second},
+		{86400, 86400, maxNegativeCacheTTL},
+	} {
+		soa := SOAAnswer{Answer: Answer{TTL: tc.ttl, RrType: dns.TypeSOA, RrClass: dns.ClassINET, Name: "example.com"}, Minttl: tc.minTTL}
+		before := time.Now()
+		cache.SafeAddCachedNegativeAnswer(q, &SingleQueryResult{Authorities: []interface{}{soa}, Flags: DNSFlags{Authoritative: true}}, StatusNXDomain, nil, "example.com", 0, false)
+		entry, ok := cache.IterativeCache.Get(CachedKey{Question: nxDomainQuestion(q), IsNegative: true})
+		require.True(t, ok)
+		expiresAt := entry.(CachedResult).Authorities[0].ExpiresAt
+		assert.WithinDuration(t, before.Add(tc.expected), expiresAt, time.Second)
+	}
+}
+
+// denialMsg returns a validated negative response from example.com. carrying records
+func denialMsg(records ...string) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.Rcode = dns.RcodeNameError
+	for _, record := range append([]string{"example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 900 1209600 300"}, records...) {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			panic(err)
+		}
+		msg.Ns = append(msg.Ns, rr)
+	}
+	return msg
+}
+
+func TestCanonicalKeyOrder(t *testing.T) {
+	// the example of RFC 4034, Section 6.1
+	names := []string{"example.", "a.example.", "yljkjljk.a.example.", "Z.a.example.", "zABC.a.EXAMPLE.", "z.example.", "\\001.z.example.", "*.z.example.", "\\200.z.example."}
+	for i := 1; i < len(names); i++ {
+		assert.Less(t, canonicalKey(names[i-1]), canonicalKey(names[i]), "%s should sort before %s", names[i-1], names[i])
+	}
+	assert.Equal(t, canonicalKey("Example.COM"), canonicalKey("example.com."))
+}
+
+func TestSynthesizeNegativeResultNSEC(t *testing.T) {
+	cache := Cache{}
+	cache.Init(4096)
+	cache.Stats.CaptureStatistics()
+	cache.addValidatedDenials(denialMsg(
+		"example.com. 300 IN NSEC a.example.com. A NS SOA RRSIG NSEC DNSKEY",
+		"a.example.com. 300 IN NSEC d.example.com. A RRSIG NSEC",
+		"d.example.com. 300 IN NSEC z.example.com. NS RRSIG NSEC",
+		"z.example.com. 300 IN NSEC example.com. MX RRSIG NSEC",
+	), 0)
+
+	for _, tc := range []struct {
+		name     string
+		qType    uint16
+		status   Status
+		found    bool
+		proofLen int
+	}{
+		// covered by a -> d, and the wildcard at example.com by example.com -> a
+		{"b.example.com", dns.TypeA, StatusNXDomain, true, 2},
+		// covered by the last record, whose gap wraps around to the apex
+		{"zz.example.com", dns.TypeA, StatusNXDomain, true, 2},
+		{"a.example.com", dns.TypeAAAA, StatusNoError, true, 1},
+		{"a.example.com", dns.TypeA, "", false, 0},
+		// d.example.com is a delegation, the parent only knows it has no DS records
+		{"d.example.com", dns.TypeDS, StatusNoError, true, 1},
+		{"d.example.com", dns.TypeA, "", false, 0},
+		{"www.d.example.com", dns.TypeA, "", false, 0},
+		{"example.org", dns.TypeA, "", false, 0},
+	} {
+		res, status, found := cache.SynthesizeNegativeResult(Question{Type: tc.qType, Name: tc.name, Class: dns.ClassINET}, 0)
+		require.Equal(t, tc.found, found, "%s %s", tc.name, dns.TypeToString[tc.qType])
+		if !found {
+			continue
+		}
+		assert.Equal(t, tc.status, status, tc.name)
+		assert.Empty(t, res.Answers)
+		assert.Len(t, res.Authorities, tc.proofLen+1, tc.name) // the SOA and the proof
+		assert.Equal(t, "example.com", res.synthesizedFrom)
+		assert.Equal(t, DNSSECSecure, res.DNSSECResult.Status)
+	}
+	assert.Equal(t, uint64(4), cache.Stats.GetStatistics().Synthesized)
+}
+
+func TestSynthesizeNegativeResultNSEC3(t *testing.T) {
+	nsec3 := func(owner, next string, optOut bool, types string) string {
+		flags := 0
+		if optOut {
+			flags = NSEC3OptOutFlag
+		}
+		return fmt.Sprintf("%s.example.com. 300 IN NSEC3 1 %d 0 - %s %s", dns.HashName(owner, dns.SHA1, 0, ""), flags, dns.HashName(next, dns.SHA1, 0, ""), types)
+	}
+	cache := Cache{}
+	cache.Init(4096)
+	// with only two names in the zone, each record covers every hash other than the two
+	cache.addValidatedDenials(denialMsg(
+		nsec3("example.com.", "www.example.com.", false, "A NS SOA RRSIG DNSKEY NSEC3PARAM"),
+		nsec3("www.example.com.", "example.com.", false, "A RRSIG"),
+	), 0)
+
+	_, status, found := cache.SynthesizeNegativeResult(Question{Type: dns.TypeA, Name: "missing.example.com", Class: dns.ClassINET}, 0)
+	require.True(t, found)
+	assert.Equal(t, StatusNXDomain, status)
+	_, status, found = cache.SynthesizeNegativeResult(Question{Type: dns.TypeMX, Name: "www.example.com", Class: dns.ClassINET}, 0)
+	require.True(t, found)
+	assert.Equal(t, StatusNoError, status)
+	_, _, found = cache.SynthesizeNegativeResult(Question{Type: dns.TypeA, Name: "www.example.com", Class: dns.ClassINET}, 0)
+	assert.False(t, found)
+
+	// an opt-out span may hide unsigned delegations
+	cache = Cache{}
+	cache.Init(4096)
+	cache.addValidatedDenials(denialMsg(
+		nsec3("example.com.", "www.example.com.", true, "A NS SOA RRSIG DNSKEY NSEC3PARAM"),
+		nsec3("www.example.com.", "example.com.", true, "A RRSIG"),
+	), 0)
+	_, _, found = cache.SynthesizeNegativeResult(Question{Type: dns.TypeA, Name: "missing.example.com", Class: dns.ClassINET}, 0)
+	assert.False(t, found)
+}
+
+func TestCacheSnapshotRoundTrip(t *testing.T) {
+	cache := Cache{}
+	cache.Init(4096)
+	ns := &NameServer{IP: net.ParseIP("192.0.2.53"), Port: 53}
+	cache.SafeAddCachedAnswer(Question{Type: dns.TypeA, Name: "google.com", Class: dns.ClassINET}, &SingleQueryResult{
+		Answers: []interface{}{Answer{TTL: 3600, RrType: dns.TypeA, RrClass: dns.ClassINET, Name: "google.com", Answer: "192.0.2.1"}},
+		Flags:   DNSFlags{Authoritative: true},
+	}, nil, "google.com", 0, false)
+	cache.SafeAddCachedAuthority(&SingleQueryResult{
+		Authorities: []interface{}{
+			Answer{TTL: 3600, RrType: dns.TypeNS, RrClass: dns.ClassINET, Name: "com", Answer: "a.gtld-servers.net"},
+			DSAnswer{Answer: Answer{TTL: 3600, RrType: dns.TypeDS, RrClass: dns.ClassINET, Name: "com"}, KeyTag: 1234, Digest: "abcd"},
+		},
+		Additionals: []interface{}{Answer{TTL: 3600, RrType: dns.TypeA, RrClass: dns.ClassINET, Name: "a.gtld-servers.net", Answer: "192.0.2.2"}},
+	}, ns, 0, ".")
+	cache.SafeAddCachedNegativeAnswer(Question{Type: dns.TypeA, Name: "missing.com", Class: dns.ClassINET}, &SingleQueryResult{
+		Authorities: []interface{}{SOAAnswer{Answer: Answer{TTL: 3600, RrType: dns.TypeSOA, RrClass: dns.ClassINET, Name: "com"}, Minttl: 900}},
+		Flags:       DNSFlags{Authoritative: true},
+	}, StatusNXDomain, nil, "com", 0, false)
+	// expired entries should not be persisted
+	cache.addCachedAnswer(Question{Type: dns.TypeA, Name: "expired.com", Class: dns.ClassINET}, "", false, false, &CachedResult{
+		Answers: []TimedAnswer{{Answer: Answer{RrType: dns.TypeA, Name: "expired.com"}, ExpiresAt: time.Now().Add(-time.Second)}},
+	}, 0)
+
+	path := filepath.Join(t.TempDir(), "cache")
+	written, err := cache.SaveToFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 4, written)
+
+	restored := Cache{}
+	restored.Init(4096)
+	added, err := restored.LoadFromFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 4, added)
+
+	res, found := restored.GetCachedResults(Question{Type: dns.TypeA, Name: "google.com", Class: dns.ClassINET}, nil, 0)
+	require.True(t, found)
+	assert.Equal(t, "192.0.2.1", res.Answers[0].(Answer).Answer)
+	res, found = restored.GetCachedAuthority("com", ns, 0)
+	require.True(t, found)
+	assert.Equal(t, "192.0.2.2", res.Additionals[0].(Answer).Answer)
+	res, found = restored.GetCachedResults(Question{Type: dns.TypeDS, Name: "com", Class: dns.ClassINET}, ns, 0)
+	require.True(t, found)
+	assert.Equal(t, uint16(1234), res.Authorities[0].(DSAnswer).KeyTag)
+	_, status, found := restored.GetCachedNegativeResult(Question{Type: dns.TypeMX, Name: "missing.com", Class: dns.ClassINET}, nil, 0)
+	require.True(t, found)
+	assert.Equal(t, StatusNXDomain, status)
+	_, found = restored.GetCachedResults(Question{Type: dns.TypeA, Name: "expired.com", Class: dns.ClassINET}, nil, 0)
+	assert.False(t, found)
+}
+
+func TestCacheSnapshotMissingFile(t *testing.T) {
+	cache := Cache{}
+	cache.Init(4096)
+	_, err := cache.LoadFromFile(filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -20,6 +20,7 @@ const (
 	GoogleDoHDomainName     = "dns.google"
 	cloudflareDNSDomainName = "one.one.one.one"
 	CloudflareDoHDomainName = "cloudflare-dns.com"
+	AdGuardDoQDomainName    = "dns.adguard-dns.com"
 )
 
 type TargetedDomain struct {
@@ -118,3 +119,14 @@ var DefaultExternalDoTResolversV6 = []NameServer{
 	{IP: net.ParseIP("2606:4700:4700::1111"), Port: DefaultDoTPort, DomainName: cloudflareDNSDomainName},
 	{IP: net.ParseIP("2606:4700:4700::1001"), Port: DefaultDoTPort, DomainName: cloudflareDNSDomainName},
 }
+
+// DefaultExternalDoQResolversV4 are public resolvers that support DNS over QUIC
+var DefaultExternalDoQResolversV4 = []NameServer{
+	{IP: net.ParseIP("94.140.14.14"), Port: DefaultDoQPort, DomainName: AdGuardDoQDomainName},
+	{IP: net.ParseIP("94.140.15.15"), Port: DefaultDoQPort, DomainName: AdGuardDoQDomainName},
+}
+
+var DefaultExternalDoQResolversV6 = []NameServer{
+	{IP: net.ParseIP("2a10:50c0::ad1:ff"), Port: DefaultDoQPort, DomainName: AdGuardDoQDomainName},
+	{IP: net.ParseIP("2a10:50c0::ad2:ff"), Port: DefaultDoQPort, DomainName: AdGuardDoQDomainName},
+}
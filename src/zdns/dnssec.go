@@ -74,6 +74,10 @@ func (v *dNSSECValidator) validate(layer string, msg *dns.Msg, nameServer *NameS
 		return result, trace
 	}
 
+	if !v.isIterative {
+		layer, trace = v.externalZone(msg, depth, trace)
+	}
+
 	dsRecords, hasNSECProof, newTrace, err := v.fetchDSRecords(dns.CanonicalName(layer), trace, depth)
 	trace = newTrace
 	if err != nil {
@@ -112,6 +116,10 @@ func (v *dNSSECValidator) validate(layer string, msg *dns.Msg, nameServer *NameS
 			// Validate the authoritative section
 			sectionRes, trace = v.validateSection(v.msg.Ns, depth, trace)
 			result.Authorities = sectionRes
+		} else if len(v.msg.Answer) == 0 {
+			// Negative responses are proven by the NSEC or NSEC3 records in the authority section
+			sectionRes, trace = v.validateSection(v.msg.Ns, depth, trace)
+			result.Authorities = sectionRes
 		}
 
 		for ds := range v.ds {
@@ -143,6 +151,52 @@ func (v *dNSSECValidator) validate(layer string, msg *dns.Msg, nameServer *NameS
 	return result, trace
 }
 
+// externalZone returns the zone a response from an external resolver belongs to. Unlike iterative lookups, there are
+// no referrals to learn zone cuts from, so the zone is the signer of the first RRSIG or the owner of an SOA record in
+// the response. Failing both, ex. for an unsigned answer, the zone is found by looking up the SOA of the queried name.
+func (v *dNSSECValidator) externalZone(msg *dns.Msg, depth int, trace Trace) (string, Trace) {
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns} {
+		for _, rr := range section {
+			switch typedRR := rr.(type) {
+			case *dns.RRSIG:
+				return typedRR.SignerName, trace
+			case *dns.SOA:
+				return typedRR.Hdr.Name, trace
+			}
+		}
+	}
+	if len(msg.Question) == 0 {
+		return rootZone, trace
+	}
+	qName := msg.Question[0].Name
+	if msg.Question[0].Qtype == dns.TypeSOA {
+		// the name is the zone apex if it has an SOA at all, and looking it up again would be circular
+		return qName, trace
+	}
+
+	soaQuestion := QuestionWithMetadata{
+		Q: Question{
+			Name:  removeTrailingDotIfNotRoot(qName),
+			Type:  dns.TypeSOA,
+			Class: dns.ClassINET,
+		},
+		RetriesRemaining: &v.r.retriesRemaining,
+	}
+	res, trace, status, err := v.r.lookup(v.ctx, &soaQuestion, v.nameServers, v.isIterative, trace)
+	if status != StatusNoError || err != nil {
+		v.r.verboseLog(depth, fmt.Sprintf("DNSSEC: Failed to find the zone of %s, query status: %s, err: %v", qName, status, err))
+		return qName, trace
+	}
+	for _, section := range [][]interface{}{res.Answers, res.Authorities} {
+		for _, rr := range section {
+			if soa, ok := rr.(SOAAnswer); ok {
+				return soa.Name, trace
+			}
+		}
+	}
+	return qName, trace
+}
+
 // validateSection validates DNSSEC records for a given DNS message section.
 //
 // Parameters:
@@ -318,7 +372,7 @@ func (v *dNSSECValidator) getDNSKEYs(signerDomain string, trace Trace, depth int
 		RetriesRemaining: &v.r.retriesRemaining,
 	}
 
-	res, trace, status, err := v.r.lookup(v.ctx, &dnskeyQuestion, v.r.rootNameServers, v.isIterative, trace)
+	res, trace, status, err := v.r.lookup(v.ctx, &dnskeyQuestion, v.nameServers, v.isIterative, trace)
 	if status != StatusNoError {
 		v.r.verboseLog(depth, fmt.Sprintf("DNSSEC: Failed to get DNSKEYs for signer domain %s, query status: %s", signerDomain, status))
 		return nil, nil, trace, fmt.Errorf("DNSKEY fetch failed, query status: %s", status)
@@ -377,6 +431,9 @@ func (v *dNSSECValidator) fetchDSRecords(signerDomain string, trace Trace, depth
 
 	if signerDomain == rootZone {
 		// Root zone, use the root anchors
+		if v.r.rootTrustAnchors != nil {
+			return v.r.rootTrustAnchors, false, trace, nil
+		}
 		return rootanchors.GetValidDSRecords(), false, trace, nil
 	}
 
@@ -389,7 +446,7 @@ func (v *dNSSECValidator) fetchDSRecords(signerDomain string, trace Trace, depth
 		RetriesRemaining: &v.r.retriesRemaining,
 	}
 
-	res, newTrace, status, err := v.r.lookup(v.ctx, &dsQuestion, v.r.rootNameServers, v.isIterative, trace)
+	res, newTrace, status, err := v.r.lookup(v.ctx, &dsQuestion, v.nameServers, v.isIterative, trace)
 	trace = newTrace
 	// Empirically, DS records may present in the answer section in some cases
 	res.Authorities = append(res.Authorities, res.Answers...)
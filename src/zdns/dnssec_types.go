@@ -45,13 +45,16 @@ type DNSSECPerSetResult struct {
 
 // DNSSECResult captures all information generated during a DNSSEC validation
 type DNSSECResult struct {
-	Status      DNSSECStatus         `json:"status" groups:"dnssec,dnssec,normal,long,trace"`
-	Reason      string               `json:"reason" groups:"dnssec,dnssec,normal,long,trace"`
+	Status      DNSSECStatus         `json:"status" groups:"dnssec,normal,long,trace"`
+	Reason      string               `json:"reason" groups:"dnssec,normal,long,trace"`
 	DSes        []*DSAnswer          `json:"dses" groups:"dnssec,long,trace"`
 	DNSKEYs     []*DNSKEYAnswer      `json:"dnskeys" groups:"dnssec,long,trace"`
 	Answers     []DNSSECPerSetResult `json:"answers" groups:"dnssec,long,trace"`
 	Additionals []DNSSECPerSetResult `json:"additionals" groups:"dnssec,long,trace"`
 	Authorities []DNSSECPerSetResult `json:"authorities" groups:"dnssec,long,trace"`
+	// ResolverAuthenticated is the AD bit set by an external resolver, so it can be compared against Status to spot
+	// resolvers that claim to validate but don't. Nil for iterative lookups.
+	ResolverAuthenticated *bool `json:"resolver_authenticated,omitempty" groups:"dnssec,normal,long,trace"`
 }
 
 func getResultForRRset(rrsetKey RRsetKey, results []DNSSECPerSetResult) *DNSSECPerSetResult {
@@ -68,6 +71,7 @@ type dNSSECValidator struct {
 	r           *Resolver
 	ctx         context.Context
 	isIterative bool
+	nameServers []NameServer // name servers DNSKEY and DS records are looked up with
 	status      DNSSECStatus
 	reason      string
 
@@ -79,11 +83,12 @@ type dNSSECValidator struct {
 }
 
 // makeDNSSECValidator creates a new DNSSECValidator instance
-func makeDNSSECValidator(r *Resolver, ctx context.Context, isIterative bool) *dNSSECValidator {
+func makeDNSSECValidator(r *Resolver, ctx context.Context, nameServers []NameServer, isIterative bool) *dNSSECValidator {
 	return &dNSSECValidator{
 		r:           r,
 		ctx:         ctx,
 		isIterative: isIterative,
+		nameServers: nameServers,
 		status:      DNSSECSecure,
 		reason:      "",
 	}
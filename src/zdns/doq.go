@@ -0,0 +1,236 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"context"
+	stdtls "crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zcrypto/tls"
+	"github.com/zmap/zcrypto/x509"
+	"github.com/zmap/zgrab2/lib/output"
+)
+
+const (
+	doqALPN = "doq" // RFC 9250, Section 4.1.1
+	// doqNoError is the DOQ_NO_ERROR application error code used to close connections, RFC 9250, Section 4.3
+	doqNoError = 0x0
+)
+
+// doDoQLookup performs a DNS over QUIC (RFC 9250) lookup. Each query is sent on a new stream of the connection info's
+// QUIC connection, which is (re-)established if it's to a different name server or has been closed.
+func doDoQLookup(ctx context.Context, connInfo *ConnectionInfo, q Question, nameServer *NameServer, rootCAs *x509.CertPool, shouldVerifyServerCert, recursive bool, ednsOptions []dns.EDNS0, dnssec bool, checkingDisabled bool) (*SingleQueryResult, *dns.Msg, Status, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dotName(q.Name), q.Type)
+	m.Question[0].Qclass = q.Class
+	m.RecursionDesired = recursive
+	m.CheckingDisabled = checkingDisabled
+	// the message ID must be 0, since the stream identifies the query, RFC 9250, Section 4.2.1
+	m.Id = 0
+
+	m.SetEdns0(1232, dnssec)
+	if ednsOpt := m.IsEdns0(); ednsOpt != nil {
+		ednsOpt.Option = append(ednsOpt.Option, ednsOptions...)
+	}
+	packed, err := m.Pack()
+	if err != nil {
+		return nil, nil, StatusError, errors.Wrap(err, "could not pack DNS message")
+	}
+
+	stream, err := newDoQStream(ctx, connInfo, nameServer, rootCAs, shouldVerifyServerCert)
+	if err != nil {
+		return nil, nil, StatusError, err
+	}
+	defer stream.CancelRead(doqNoError)
+	// streams don't take a context, so unblock any read or write once ctx is done
+	stop := context.AfterFunc(ctx, func() { _ = stream.SetDeadline(time.Now()) })
+	defer stop()
+	// messages are prefixed with their length as in DNS over TCP, and the client closes its side of the stream once
+	// the query is sent, RFC 9250, Section 4.2
+	query := make([]byte, 2+len(packed))
+	binary.BigEndian.PutUint16(query, uint16(len(packed)))
+	copy(query[2:], packed)
+	if _, err = stream.Write(query); err != nil {
+		return nil, nil, StatusError, errors.Wrap(err, "could not write query over DoQ to server")
+	}
+	// closing a quic-go stream only closes its write direction
+	_ = stream.Close()
+
+	var length [2]byte
+	if _, err = io.ReadFull(stream, length[:]); err != nil {
+		return nil, nil, statusForDoQReadError(ctx), errors.Wrap(err, "could not read DoQ response length")
+	}
+	response := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err = io.ReadFull(stream, response); err != nil {
+		return nil, nil, statusForDoQReadError(ctx), errors.Wrap(err, "could not read DoQ response")
+	}
+	responseMsg := new(dns.Msg)
+	if err = responseMsg.Unpack(response); err != nil {
+		return nil, nil, StatusError, errors.Wrap(err, "could not unpack DNS message from DoQ server")
+	}
+	res := SingleQueryResult{
+		Resolver:    nameServer.String(),
+		Protocol:    DoQProtocol,
+		Answers:     []interface{}{},
+		Authorities: []interface{}{},
+		Additionals: []interface{}{},
+	}
+	// if we have it, add the TLS handshake info
+	if connInfo.tlsHandshake != nil {
+		processor := output.Processor{Verbose: false}
+		strippedOutput, stripErr := processor.Process(connInfo.tlsHandshake)
+		if stripErr != nil {
+			log.Warnf("Error stripping TLS log: %v", stripErr)
+		} else {
+			res.TLSServerHandshake = strippedOutput
+		}
+	}
+	return constructSingleQueryResultFromDNSMsg(&res, responseMsg)
+}
+
+// newDoQStream opens a stream for a query to nameServer, re-using the connection info's QUIC connection if possible
+func newDoQStream(ctx context.Context, connInfo *ConnectionInfo, nameServer *NameServer, rootCAs *x509.CertPool, shouldVerifyServerCert bool) (*quic.Stream, error) {
+	if connInfo.quicConn != nil {
+		if connInfo.quicConn.RemoteAddr().String() == nameServer.String() {
+			stream, err := connInfo.quicConn.OpenStreamSync(ctx)
+			if err == nil {
+				return stream, nil
+			}
+			// the connection may have been closed by the server or timed out while idle, reconnect
+			log.Debugf("could not open stream on existing QUIC connection to %s, reconnecting: %v", nameServer.String(), err)
+		}
+		_ = connInfo.quicConn.CloseWithError(doqNoError, "")
+		connInfo.quicConn = nil
+		connInfo.tlsHandshake = nil
+	}
+	var handshake *tls.ServerHandshake
+	tlsConfig := &stdtls.Config{
+		NextProtos: []string{doqALPN},
+		MinVersion: stdtls.VersionTLS13,
+		ServerName: nameServer.DomainName,
+		// root CAs are a zcrypto pool which crypto/tls can't use, so the certificate is verified in VerifyConnection
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state stdtls.ConnectionState) error {
+			handshake = serverHandshakeFromConnectionState(state)
+			if shouldVerifyServerCert {
+				return verifyDoQServerCert(state, rootCAs, nameServer.DomainName)
+			}
+			return nil
+		},
+	}
+	addr := &net.UDPAddr{IP: nameServer.IP, Port: int(nameServer.Port)}
+	conn, err := connInfo.quicTransport.Dial(ctx, addr, tlsConfig, &quic.Config{})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not perform QUIC handshake")
+	}
+	connInfo.quicConn = conn
+	connInfo.tlsHandshake = handshake
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open QUIC stream")
+	}
+	return stream, nil
+}
+
+// statusForDoQReadError returns StatusTimeout if reading a response failed because the lookup timed out
+func statusForDoQReadError(ctx context.Context) Status {
+	if ctx.Err() != nil {
+		return StatusTimeout
+	}
+	return StatusError
+}
+
+// serverHandshakeFromConnectionState converts the state of a crypto/tls connection to the zcrypto handshake log used
+// for DoT and DoH, so DoQ handshakes are reported in the same format. Only the fields crypto/tls exposes are set.
+func serverHandshakeFromConnectionState(state stdtls.ConnectionState) *tls.ServerHandshake {
+	handshake := &tls.ServerHandshake{
+		ServerHello: &tls.ServerHello{
+			Version:      tls.TLSVersion(state.Version),
+			CipherSuite:  tls.CipherSuite(state.CipherSuite),
+			OcspStapling: len(state.OCSPResponse) > 0,
+			AlpnProtocol: state.NegotiatedProtocol,
+		},
+	}
+	if len(state.PeerCertificates) == 0 {
+		return handshake
+	}
+	handshake.ServerCertificates = new(tls.Certificates)
+	for i, cert := range state.PeerCertificates {
+		simpleCert := tls.SimpleCertificate{Raw: cert.Raw}
+		if parsed, err := x509.ParseCertificate(cert.Raw); err == nil {
+			simpleCert.Parsed = parsed
+		}
+		if i == 0 {
+			handshake.ServerCertificates.Certificate = simpleCert
+		} else {
+			handshake.ServerCertificates.Chain = append(handshake.ServerCertificates.Chain, simpleCert)
+		}
+	}
+	return handshake
+}
+
+// verifyDoQServerCert verifies the certificate chain presented by a DoQ server against rootCAs and domainName
+func verifyDoQServerCert(state stdtls.ConnectionState, rootCAs *x509.CertPool, domainName string) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("server did not present a certificate")
+	}
+	certs := make([]*x509.Certificate, 0, len(state.PeerCertificates))
+	for _, cert := range state.PeerCertificates {
+		parsed, err := x509.ParseCertificate(cert.Raw)
+		if err != nil {
+			return errors.Wrap(err, "could not parse server certificate")
+		}
+		certs = append(certs, parsed)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, _, _, err := certs[0].Verify(x509.VerifyOptions{
+		// zcrypto doesn't default to the current time when checking validity periods
+		CurrentTime:   time.Now(),
+		DNSName:       domainName,
+		Intermediates: intermediates,
+		Roots:         rootCAs,
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not verify server certificate")
+	}
+	return nil
+}
+
+// closeQUICTransport closes the connection info's QUIC connection, if any, and its transport and UDP socket
+func closeQUICTransport(connInfo *ConnectionInfo) {
+	if connInfo.quicConn != nil {
+		_ = connInfo.quicConn.CloseWithError(doqNoError, "")
+		connInfo.quicConn = nil
+	}
+	if err := connInfo.quicTransport.Close(); err != nil {
+		log.Errorf("error closing QUIC transport: %v", err)
+	}
+	// the transport doesn't close a socket it was given
+	if err := connInfo.quicTransport.Conn.Close(); err != nil {
+		log.Errorf("error closing QUIC socket: %v", err)
+	}
+	connInfo.quicTransport = nil
+}
@@ -129,7 +129,12 @@ func (r *Resolver) doDstServersLookup(ctx context.Context, q Question, nameServe
 		}
 	}
 	if r.shouldValidateDNSSEC {
-		r.validator = makeDNSSECValidator(r, ctx, isIterative)
+		// external lookups validate the chain of trust through the same resolvers, iterative ones from the root
+		validationNameServers := nameServers
+		if isIterative {
+			validationNameServers = r.rootNameServers
+		}
+		r.validator = makeDNSSECValidator(r, ctx, validationNameServers, isIterative)
 	}
 	r.retriesRemaining = r.retries
 
@@ -175,6 +180,9 @@ func (r *Resolver) lookup(ctx context.Context, qWithMeta *QuestionWithMetadata,
 		if res != nil {
 			t.Result = *res
 			t.NameServer = res.Resolver
+			if !isCached {
+				t.Protocol = res.Protocol
+			}
 		} else {
 			t.Result = SingleQueryResult{}
 		}
@@ -333,6 +341,36 @@ func (r *Resolver) LookupAllNameserversExternal(q *Question, nameServers []NameS
 	return retv, trace, StatusNoError, nil
 }
 
+// LookupEachNameServer queries each of nameServers for q, retrying failures once, the same way
+// LookupAllNameserversIterative queries the name servers of each layer. Name servers without an IP are resolved first,
+// and those without a port are queried on the iteration port, since they're usually authoritative name servers.
+// Returns the response of each name server in order along with the name server it's from, skipping those whose IP
+// couldn't be found.
+// Unlike ExternalLookup, the name servers don't become the default name server of later external lookups.
+func (r *Resolver) LookupEachNameServer(q *Question, nameServers []NameServer) ([]NameServerResult, Trace, error) {
+	perNameServerRetriesLimit := 2
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	lastUsedExternalNameServer := r.lastUsedExternalNameServer
+	defer func() { r.lastUsedExternalNameServer = lastUsedExternalNameServer }()
+	results := make([]NameServerResult, 0, len(nameServers))
+	var trace Trace
+	for _, nameServer := range nameServers {
+		if nameServer.Port == 0 {
+			nameServer.Port = r.iterationPort
+		}
+		extResult, nsTrace, err := r.queryNameServer(ctx, perNameServerRetriesLimit, q, &nameServer)
+		trace = append(trace, nsTrace...)
+		if err != nil {
+			return results, trace, err
+		}
+		if extResult != nil {
+			results = append(results, NameServerResult{NameServer: nameServer, ExtendedResult: *extResult})
+		}
+	}
+	return results, trace, nil
+}
+
 // filterNameServersForUniqueNames will filter out duplicate nameservers based on the name.
 // Usually we'll have duplicates if a nameserver has both an IPv4 and IPv6 address. We'll use r.ipVersionMode and r.iterationIPPreference to determine which to keep.
 func (r *Resolver) filterNameServersForUniqueNames(nameServers []NameServer) []NameServer {
@@ -399,6 +437,10 @@ func (r *Resolver) LookupAllNameserversIterative(q *Question, rootNameServers []
 	perNameServerRetriesLimit := 2
 	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
 	defer cancel()
+	// every name server in the walk is queried as an external name server, which mustn't become the default for later
+	// external lookups
+	lastUsedExternalNameServer := r.lastUsedExternalNameServer
+	defer func() { r.lastUsedExternalNameServer = lastUsedExternalNameServer }()
 	retv := AllNameServersResult{
 		LayeredResponses: make(map[string][]ExtendedResult),
 	}
@@ -524,8 +566,8 @@ func (r *Resolver) extractNameServersFromLayerResults(layerResults []ExtendedRes
 	v6NameServers := make(map[string]NameServer)
 	for _, authorities := range uniqueAuthorities {
 		if authorities.RrType == dns.TypeNS {
-			v4NameServers[strings.TrimSuffix(authorities.Answer, ".")] = NameServer{DomainName: strings.TrimSuffix(authorities.Answer, ".")}
-			v6NameServers[strings.TrimSuffix(authorities.Answer, ".")] = NameServer{DomainName: strings.TrimSuffix(authorities.Answer, ".")}
+			v4NameServers[strings.TrimSuffix(authorities.Answer, ".")] = NameServer{DomainName: strings.TrimSuffix(authorities.Answer, "."), Port: r.iterationPort}
+			v6NameServers[strings.TrimSuffix(authorities.Answer, ".")] = NameServer{DomainName: strings.TrimSuffix(authorities.Answer, "."), Port: r.iterationPort}
 		}
 	}
 	for _, additionals := range uniqueAdditionals {
@@ -565,6 +607,7 @@ func (r *Resolver) extractNameServersFromLayerResults(layerResults []ExtendedRes
 	for _, answer := range uniqueAnswers {
 		ns := NameServer{
 			DomainName: strings.TrimSuffix(answer.Answer, "."),
+			Port:       r.iterationPort,
 		}
 		key := ns.DomainName
 		if _, ok := uniqNameServersSet[key]; !ok {
@@ -643,48 +686,60 @@ func (r *Resolver) queryAllNameServersInLayer(ctx context.Context, perNameServer
 	currentLayerResults := make([]ExtendedResult, 0, len(currentNameServers))
 	isAuthoritative := false
 	for _, nameServer := range currentNameServers {
-		var extResult *ExtendedResult
-		for retry := 0; retry < perNameServerRetriesLimit; retry++ {
-			if util.HasCtxExpired(ctx) {
-				return currentLayerResults, trace, false, ErrorContextExpired
-			}
-			if nameServer.IP == nil {
-				nsTrace, err := r.populateNameServerIP(ctx, &nameServer)
-				if err != nil {
-					log.Debugf("LookupAllNameserversIterative of name %s errored for %s: %v", q.Name, nameServer.DomainName, err)
-					continue
-				}
-				trace = append(trace, nsTrace...)
-				// we've populated NS IP, we can proceed
-			}
-			result, currTrace, status, err := r.ExternalLookup(ctx, q, &nameServer)
-			trace = append(trace, currTrace...)
-			extResult = &ExtendedResult{Status: status, Nameserver: nameServer.DomainName, Type: dns.TypeToString[q.Type]}
-			if result != nil {
-				extResult.Res = *result
-			}
-			if err == nil && status == StatusNoError && result != nil {
-				if result.Flags.Authoritative {
-					isAuthoritative = true
-				}
-				// successful result, continue to next nameserver
-				break
-			}
-			if err != nil {
-				log.Debugf("LookupAllNameserversIterative of name %s errored for %s: %v", q.Name, nameServer.IP.String(), err)
-			} else {
-				log.Debugf("LookupAllNameserversIterative of name %s failed for %s: %v", q.Name, nameServer.IP.String(), status)
-			}
+		extResult, nsTrace, err := r.queryNameServer(ctx, perNameServerRetriesLimit, q, &nameServer)
+		trace = append(trace, nsTrace...)
+		if err != nil {
+			return currentLayerResults, trace, false, err
 		}
 		if extResult == nil {
 			log.Debugf("LookupAllNameserversIterative of name %s against nameserver %s ran out of retries, continueing to next nameserver", q.Name, nameServer.IP.String())
-		} else {
-			currentLayerResults = append(currentLayerResults, *extResult)
+			continue
+		}
+		if extResult.Status == StatusNoError && extResult.Res.Flags.Authoritative {
+			isAuthoritative = true
 		}
+		currentLayerResults = append(currentLayerResults, *extResult)
 	}
 	return currentLayerResults, trace, isAuthoritative, nil
 }
 
+// queryNameServer queries nameServer for q, making up to retries attempts, and resolves its IP first if it doesn't have
+// one. Returns nil if the IP couldn't be found, and ErrorContextExpired if ctx expires before the query is made.
+func (r *Resolver) queryNameServer(ctx context.Context, retries int, q *Question, nameServer *NameServer) (*ExtendedResult, Trace, error) {
+	var extResult *ExtendedResult
+	var trace Trace
+	for retry := 0; retry < retries; retry++ {
+		if util.HasCtxExpired(ctx) {
+			return nil, trace, ErrorContextExpired
+		}
+		if nameServer.IP == nil {
+			nsTrace, err := r.populateNameServerIP(ctx, nameServer)
+			if err != nil {
+				log.Debugf("LookupAllNameserversIterative of name %s errored for %s: %v", q.Name, nameServer.DomainName, err)
+				continue
+			}
+			trace = append(trace, nsTrace...)
+			// we've populated NS IP, we can proceed
+		}
+		result, currTrace, status, err := r.ExternalLookup(ctx, q, nameServer)
+		trace = append(trace, currTrace...)
+		extResult = &ExtendedResult{Status: status, Nameserver: nameServer.DomainName, Type: dns.TypeToString[q.Type]}
+		if result != nil {
+			extResult.Res = *result
+		}
+		if err == nil && status == StatusNoError && result != nil {
+			// successful result, continue to next nameserver
+			break
+		}
+		if err != nil {
+			log.Debugf("LookupAllNameserversIterative of name %s errored for %s: %v", q.Name, nameServer.IP.String(), err)
+		} else {
+			log.Debugf("LookupAllNameserversIterative of name %s failed for %s: %v", q.Name, nameServer.IP.String(), status)
+		}
+	}
+	return extResult, trace, nil
+}
+
 func (r *Resolver) iterativeLookup(ctx context.Context, qWithMeta *QuestionWithMetadata, nameServers []NameServer,
 	depth int, layer string, trace Trace) (*SingleQueryResult, Trace, Status, error) {
 	if depth > r.maxDepth {
@@ -700,7 +755,7 @@ func (r *Resolver) iterativeLookup(ctx context.Context, qWithMeta *QuestionWithM
 	iterationStepCtx, cancel := context.WithTimeout(ctx, r.iterativeTimeout)
 	defer cancel()
 	result, isCached, status, trace, err := r.cyclingLookup(iterationStepCtx, qWithMeta, nameServers, layer, depth, false, trace)
-	if status == StatusNoError && result != nil {
+	if result != nil && (status == StatusNoError || len(result.synthesizedFrom) > 0) {
 		var t TraceStep
 		t.Result = *result
 		t.NameServer = result.Resolver
@@ -710,7 +765,11 @@ func (r *Resolver) iterativeLookup(ctx context.Context, qWithMeta *QuestionWithM
 		t.Layer = layer
 		t.Depth = depth
 		t.Cached = isCached
+		t.SynthesizedFrom = result.synthesizedFrom
 		t.Try = getTryNumber(r.retries, *qWithMeta.RetriesRemaining)
+		if !isCached {
+			t.Protocol = result.Protocol
+		}
 		trace = append(trace, t)
 	}
 	if status == StatusTimeout && util.HasCtxExpired(iterationStepCtx) && !util.HasCtxExpired(ctx) {
@@ -791,6 +850,7 @@ func (r *Resolver) cyclingLookup(ctx context.Context, qWithMeta *QuestionWithMet
 
 		r.verboseLog(depth+1, "Cycling lookup failed with status:", status, "err: ", err, ", using a retry. Retries remaining: ", *qWithMeta.RetriesRemaining, " , Name: ", qWithMeta.Q.Name, ", Layer: ", layer, ", Nameserver: ", nameServer)
 		*qWithMeta.RetriesRemaining--
+		r.queryStats.IncrementRetries()
 	}
 	return &SingleQueryResult{}, false, StatusError, trace, errors.New("cycling lookup function did not exit properly")
 }
@@ -834,10 +894,6 @@ func (r *Resolver) cachedLookup(ctx context.Context, q Question, nameServer *Nam
 	if isValid, reason := nameServer.IsValid(); !isValid {
 		return &SingleQueryResult{}, false, StatusIllegalInput, trace, fmt.Errorf("invalid nameserver (%s): %s", nameServer.String(), reason)
 	}
-	// create a context for this network lookup
-	lookupCtx, cancel := context.WithTimeout(ctx, r.networkTimeout)
-	defer cancel()
-
 	// For some lookups, we want them to be nameserver specific, ie. if cacheBasedOnNameServer is true
 	// Else, we don't care which nameserver returned it
 	cacheNameServer := nameServer
@@ -846,6 +902,14 @@ func (r *Resolver) cachedLookup(ctx context.Context, q Question, nameServer *Nam
 	}
 	// First, we check the cache
 	cachedResult, ok := r.cache.GetCachedResults(q, cacheNameServer, depth+1)
+	cachedStatus := StatusNoError
+	if !ok {
+		cachedResult, cachedStatus, ok = r.cache.GetCachedNegativeResult(q, cacheNameServer, depth+1)
+	}
+	if !ok && r.shouldValidateDNSSEC && !requestIteration {
+		// the name may fall in a gap proven by validated NSEC/NSEC3 records of a previous negative answer
+		cachedResult, cachedStatus, ok = r.cache.SynthesizeNegativeResult(q, depth+1)
+	}
 	if ok {
 		isCached = true
 		// set protocol on the result
@@ -853,13 +917,16 @@ func (r *Resolver) cachedLookup(ctx context.Context, q Question, nameServer *Nam
 			cachedResult.Protocol = DoHProtocol
 		} else if r.dnsOverTLSEnabled {
 			cachedResult.Protocol = DoTProtocol
+		} else if r.dnsOverQUICEnabled {
+			cachedResult.Protocol = DoQProtocol
 		} else if r.transportMode == TCPOnly {
 			cachedResult.Protocol = TCPProtocol
 		} else {
 			// default to UDP
 			cachedResult.Protocol = UDPProtocol
 		}
-		return cachedResult, isCached, StatusNoError, trace, nil
+		r.stripNegativeResponse(cachedResult)
+		return cachedResult, isCached, cachedStatus, trace, nil
 	}
 
 	// Stop if we hit a nameserver we don't want to hit
@@ -918,22 +985,52 @@ func (r *Resolver) cachedLookup(ctx context.Context, q Question, nameServer *Nam
 	var result *SingleQueryResult
 	var rawResp *dns.Msg
 	var status Status
-	if r.dnsOverHTTPSEnabled {
+	answeredOverTLS := false
+	if r.opportunisticTLS && !requestIteration {
+		result, rawResp, status, answeredOverTLS = r.opportunisticDoTLookup(ctx, connInfo, q, nameServer, depth)
+	}
+	if !answeredOverTLS {
+		if err = r.waitForRateLimit(ctx, nameServer); err != nil {
+			return &SingleQueryResult{}, isCached, StatusTimeout, trace, err
+		}
+	}
+	// create a context for this network lookup, after any rate limiting so the wait doesn't count against the network timeout
+	lookupCtx, cancel := context.WithTimeout(ctx, r.networkTimeout)
+	defer cancel()
+	if answeredOverTLS {
+		r.verboseLog(depth+2, "Answered over opportunistic DoT by ", nameServer)
+	} else if r.dnsOverHTTPSEnabled {
 		r.verboseLog(depth, "****WIRE LOOKUP*** ", DoHProtocol, " ", dns.TypeToString[q.Type], " ", q.Name, " ", nameServer)
+		r.queryStats.IncrementQueries(DoHProtocol)
 		result, rawResp, status, err = doDoHLookup(lookupCtx, connInfo.httpsClient, q, nameServer, requestIteration, r.ednsOptions, r.dnsSecEnabled, r.checkingDisabledBit)
 	} else if r.dnsOverTLSEnabled {
 		r.verboseLog(depth, "****WIRE LOOKUP*** ", DoTProtocol, " ", dns.TypeToString[q.Type], " ", q.Name, " ", nameServer)
+		r.queryStats.IncrementQueries(DoTProtocol)
 		result, rawResp, status, err = doDoTLookup(lookupCtx, connInfo, q, nameServer, r.rootCAs, r.verifyServerCert, requestIteration, r.ednsOptions, r.dnsSecEnabled, r.checkingDisabledBit)
+	} else if r.dnsOverQUICEnabled {
+		r.verboseLog(depth, "****WIRE LOOKUP*** ", DoQProtocol, " ", dns.TypeToString[q.Type], " ", q.Name, " ", nameServer)
+		r.queryStats.IncrementQueries(DoQProtocol)
+		result, rawResp, status, err = doDoQLookup(lookupCtx, connInfo, q, nameServer, r.rootCAs, r.verifyServerCert, requestIteration, r.ednsOptions, r.dnsSecEnabled, r.checkingDisabledBit)
 	} else if connInfo.udpClient != nil {
 		r.verboseLog(depth, "****WIRE LOOKUP*** ", UDPProtocol, " ", dns.TypeToString[q.Type], " ", q.Name, " ", nameServer)
+		r.queryStats.IncrementQueries(UDPProtocol)
 		result, rawResp, status, err = wireLookupUDP(lookupCtx, connInfo, q, nameServer, r.ednsOptions, requestIteration, r.dnsSecEnabled, r.checkingDisabledBit)
 		if status == StatusTruncated && connInfo.tcpClient != nil {
 			// result truncated, try again with TCP
+			if err = r.waitForRateLimit(ctx, nameServer); err != nil {
+				return &SingleQueryResult{}, isCached, StatusTimeout, trace, err
+			}
+			// the retry gets its own network timeout, which like the first one doesn't include the rate limiting
+			tcpCtx, tcpCancel := context.WithTimeout(ctx, r.networkTimeout)
+			defer tcpCancel()
 			r.verboseLog(depth, "****WIRE LOOKUP*** ", TCPProtocol, " ", dns.TypeToString[q.Type], " ", q.Name, " ", nameServer)
-			result, rawResp, status, err = wireLookupTCP(lookupCtx, connInfo, q, nameServer, r.ednsOptions, requestIteration, r.dnsSecEnabled, r.checkingDisabledBit)
+			r.queryStats.IncrementTruncationFallbacks()
+			r.queryStats.IncrementQueries(TCPProtocol)
+			result, rawResp, status, err = wireLookupTCP(tcpCtx, connInfo, q, nameServer, r.ednsOptions, requestIteration, r.dnsSecEnabled, r.checkingDisabledBit)
 		}
 	} else if connInfo.tcpClient != nil {
 		r.verboseLog(depth, "****WIRE LOOKUP*** ", TCPProtocol, " ", dns.TypeToString[q.Type], " ", q.Name, " ", nameServer)
+		r.queryStats.IncrementQueries(TCPProtocol)
 		result, rawResp, status, err = wireLookupTCP(lookupCtx, connInfo, q, nameServer, r.ednsOptions, requestIteration, r.dnsSecEnabled, r.checkingDisabledBit)
 	} else {
 		return &SingleQueryResult{}, false, StatusError, trace, errors.New("no connection info for nameserver")
@@ -946,12 +1043,18 @@ func (r *Resolver) cachedLookup(ctx context.Context, q Question, nameServer *Nam
 		r.verboseLog(depth+2, "Results from wire for name: ", q, ", Layer: ", layer, ", Nameserver: ", nameServer, " status: ", status, " , err: ", err, " result: ", *result)
 	}
 
-	if status == StatusNoError && result != nil {
-		if r.shouldValidateDNSSEC {
-			result.DNSSECResult, trace = r.validator.validate(layer, rawResp, nameServer, depth+2, trace)
-			r.verboseLog(depth+2, "DNSSEC validation status:", result.DNSSECResult.Status)
+	if (status == StatusNoError || status == StatusNXDomain) && result != nil && r.shouldValidateDNSSEC {
+		result.DNSSECResult, trace = r.validator.validate(layer, rawResp, nameServer, depth+2, trace)
+		if requestIteration {
+			// an external resolver's claim to have validated the answer, reported next to our own validation
+			authenticated := result.Flags.Authenticated
+			result.DNSSECResult.ResolverAuthenticated = &authenticated
+		} else if result.DNSSECResult.Status == DNSSECSecure {
+			r.cache.addValidatedDenials(rawResp, depth+2)
 		}
-
+		r.verboseLog(depth+2, "DNSSEC validation status:", result.DNSSECResult.Status)
+	}
+	if status == StatusNoError && result != nil {
 		// only cache answers that don't have errors and pass DNSSEC validation
 		if !r.shouldValidateDNSSEC || result.DNSSECResult.Status != DNSSECBogus {
 			if !requestIteration && strings.ToLower(q.Name) != layer && authName != layer && !result.Flags.Authoritative { // TODO - how to detect if we've retrieved an authority record or a answer record? maybe add q.Name != authName
@@ -963,13 +1066,80 @@ func (r *Resolver) cachedLookup(ctx context.Context, q Question, nameServer *Nam
 		} else {
 			r.verboseLog(depth+2, "skipping cache for domain", q.Name, "and type", dns.TypeToString[q.Type], "due to DNSSEC bogus status")
 		}
-	} else if r.shouldValidateDNSSEC {
+	} else if status == StatusNXDomain && result != nil {
+		if !r.shouldValidateDNSSEC || result.DNSSECResult.Status != DNSSECBogus {
+			r.cache.SafeAddCachedNegativeAnswer(q, result, status, cacheNameServer, layer, depth+2, cacheNonAuthoritative)
+		}
+	} else if r.shouldValidateDNSSEC && result != nil {
 		result.DNSSECResult = makeDNSSECResult()
 	}
+	r.stripNegativeResponse(result)
 
 	return result, isCached, status, trace, err
 }
 
+// stripNegativeResponse removes what a result with an error code, ex. NXDOMAIN, only carries so that it can be cached:
+// its flags, whose AA bit decides whether it's cached, and its authorities, unless the resolver is configured to keep
+// them. Their SOA, NSEC and NSEC3 records are only parsed so that negative answers can be cached.
+func (r *Resolver) stripNegativeResponse(result *SingleQueryResult) {
+	if result == nil || result.Flags.ErrorCode == dns.RcodeSuccess {
+		return
+	}
+	result.Flags = DNSFlags{}
+	if !r.negativeResponseAuthorities {
+		result.Authorities = []interface{}{}
+	}
+}
+
+// waitForRateLimit blocks until the rate limiter, if any, allows another query to nameServer. Each query sent on the
+// wire takes its own token, including opportunistic DNS over TLS attempts and TCP retries of truncated responses.
+func (r *Resolver) waitForRateLimit(ctx context.Context, nameServer *NameServer) error {
+	if r.rateLimiter == nil {
+		return nil
+	}
+	if err := r.rateLimiter.Wait(ctx, nameServer.IP); err != nil {
+		return errors.Wrap(err, "timed out waiting for rate limiter")
+	}
+	return nil
+}
+
+// opportunisticDoTLookup tries to send q to nameServer over unauthenticated DNS over TLS, as described in RFC 9539.
+// Returns false if the name server couldn't be queried over TLS, in which case the caller should fall back to UDP/TCP.
+// Name servers that fail are remembered so they aren't tried over TLS again by any resolver sharing the set.
+func (r *Resolver) opportunisticDoTLookup(ctx context.Context, connInfo *ConnectionInfo, q Question, nameServer *NameServer, depth int) (*SingleQueryResult, *dns.Msg, Status, bool) {
+	if r.noTLSNameServers.Contains(nameServer.IP) {
+		return nil, nil, "", false
+	}
+	if err := r.waitForRateLimit(ctx, nameServer); err != nil {
+		return nil, nil, "", false
+	}
+	tlsNameServer := &NameServer{IP: nameServer.IP, Port: DefaultDoTPort}
+	if r.iterationTLSPort != 0 {
+		tlsNameServer.Port = r.iterationTLSPort
+	}
+	r.verboseLog(depth, "****WIRE LOOKUP*** ", DoTProtocol, " ", dns.TypeToString[q.Type], " ", q.Name, " ", tlsNameServer)
+	r.queryStats.IncrementQueries(DoTProtocol)
+	tlsCtx, cancel := context.WithTimeout(ctx, r.networkTimeout)
+	defer cancel()
+	// consecutive steps of an iterative lookup rarely query the same name server, so the connection isn't kept
+	tlsConnInfo := &ConnectionInfo{localAddr: connInfo.localAddr}
+	result, rawResp, status, err := doDoTLookup(tlsCtx, tlsConnInfo, q, tlsNameServer, nil, false, false, r.ednsOptions, r.dnsSecEnabled, r.checkingDisabledBit)
+	if tlsConnInfo.tlsConn != nil {
+		if closeErr := tlsConnInfo.tlsConn.Close(); closeErr != nil {
+			log.Errorf("error closing TLS connection: %v", closeErr)
+		}
+	}
+	if err != nil {
+		r.verboseLog(depth+2, "Opportunistic DoT to ", tlsNameServer, " failed, falling back to UDP/TCP: ", err)
+		if ctx.Err() == nil {
+			// only the name server is to blame if the lookup itself hasn't run out of time
+			r.noTLSNameServers.Add(nameServer.IP)
+		}
+		return nil, nil, "", false
+	}
+	return result, rawResp, status, true
+}
+
 func doDoTLookup(ctx context.Context, connInfo *ConnectionInfo, q Question, nameServer *NameServer, rootCAs *x509.CertPool, shouldVerifyServerCert, recursive bool, ednsOptions []dns.EDNS0, dnssec bool, checkingDisabled bool) (*SingleQueryResult, *dns.Msg, Status, error) {
 	m := new(dns.Msg)
 	m.SetQuestion(dotName(q.Name), q.Type)
@@ -1005,6 +1175,12 @@ func doDoTLookup(ctx context.Context, connInfo *ConnectionInfo, q Question, name
 		if err != nil {
 			return nil, nil, StatusError, errors.Wrap(err, "could not connect to server")
 		}
+		if deadline, ok := ctx.Deadline(); ok {
+			// bound the handshake, a server that accepts the connection may never complete it
+			if err = tcpConn.SetDeadline(deadline); err != nil {
+				log.Errorf("error setting deadline on TLS connection: %v", err)
+			}
+		}
 		// Now wrap the connection with TLS
 		tlsConn := tls.Client(tcpConn, &tls.Config{
 			InsecureSkipVerify: true,
@@ -1028,6 +1204,11 @@ func doDoTLookup(ctx context.Context, connInfo *ConnectionInfo, q Question, name
 		connInfo.tlsHandshake = tlsConn.GetHandshakeLog()
 		connInfo.tlsConn = &dns.Conn{Conn: tlsConn}
 	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := connInfo.tlsConn.SetDeadline(deadline); err != nil {
+			log.Errorf("error setting deadline on TLS connection: %v", err)
+		}
+	}
 	err := connInfo.tlsConn.WriteMsg(m)
 	if err != nil {
 		return nil, nil, "", errors.Wrap(err, "could not write query over DoT to server")
@@ -1229,16 +1410,8 @@ func wireLookupUDP(ctx context.Context, connInfo *ConnectionInfo, q Question, na
 
 // fills out all the fields in a SingleQueryResult from a dns.Msg directly.
 func constructSingleQueryResultFromDNSMsg(res *SingleQueryResult, r *dns.Msg) (*SingleQueryResult, *dns.Msg, Status, error) {
-	if r.Rcode != dns.RcodeSuccess {
-		for _, ans := range r.Extra {
-			inner := ParseAnswer(ans)
-			if inner != nil {
-				res.Additionals = append(res.Additionals, inner)
-			}
-		}
-		return res, r, TranslateDNSErrorCode(r.Rcode), nil
-	}
-
+	// flags are set for negative responses too, since the AA bit decides whether they can be cached. They're removed by
+	// stripNegativeResponse once the response has been cached.
 	res.Flags.Response = r.Response
 	res.Flags.Opcode = r.Opcode
 	res.Flags.Authoritative = r.Authoritative
@@ -1249,6 +1422,23 @@ func constructSingleQueryResultFromDNSMsg(res *SingleQueryResult, r *dns.Msg) (*
 	res.Flags.CheckingDisabled = r.CheckingDisabled
 	res.Flags.ErrorCode = r.Rcode
 
+	if r.Rcode != dns.RcodeSuccess {
+		for _, ans := range r.Extra {
+			inner := ParseAnswer(ans)
+			if inner != nil {
+				res.Additionals = append(res.Additionals, inner)
+			}
+		}
+		// negative responses prove the name doesn't exist with the SOA and, if signed, NSEC or NSEC3 records
+		for _, ans := range r.Ns {
+			inner := ParseAnswer(ans)
+			if inner != nil {
+				res.Authorities = append(res.Authorities, inner)
+			}
+		}
+		return res, r, TranslateDNSErrorCode(r.Rcode), nil
+	}
+
 	for _, ans := range r.Answer {
 		inner := ParseAnswer(ans)
 		if inner != nil {
@@ -1396,7 +1586,8 @@ func (r *Resolver) extractAuthority(ctx context.Context, authority interface{},
 				ns := new(NameServer)
 				parsedIPString := strings.TrimSuffix(innerAns.Answer, ".")
 				ns.IP = net.ParseIP(parsedIPString)
-				ns.PopulateDefaultPort(r.dnsOverTLSEnabled, r.dnsOverHTTPSEnabled)
+				ns.Port = r.iterationPort
+				ns.PopulateDefaultPort(r.dnsOverTLSEnabled || r.dnsOverQUICEnabled, r.dnsOverHTTPSEnabled)
 				ns.DomainName = server
 				return ns, StatusNoError, layer, trace
 			}
@@ -1443,6 +1634,11 @@ func populateResults(records []interface{}, dnsType uint16, candidateSet map[str
 	for _, a := range records {
 		// filter only valid answers of requested type or CNAME (#163)
 		if ans, ok = a.(Answer); !ok {
+			// complex answers, such as CAA or SRV, only count as candidates of their own type
+			if withBase, isBase := a.(WithBaseAnswer); isBase && withBase.BaseAns().RrType == dnsType {
+				lowerCaseName := strings.ToLower(strings.TrimSuffix(withBase.BaseAns().Name, "."))
+				candidateSet[lowerCaseName] = append(candidateSet[lowerCaseName], *withBase.BaseAns())
+			}
 			continue
 		}
 		lowerCaseName := strings.ToLower(strings.TrimSuffix(ans.Name, "."))
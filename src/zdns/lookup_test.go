@@ -33,6 +33,7 @@ import (
 	"github.com/miekg/dns"
 
 	"github.com/zmap/zdns/src/internal/util"
+	"github.com/zmap/zdns/src/zdns/testserver"
 )
 
 type nameAndIP struct {
@@ -1973,6 +1974,67 @@ func TestInvalidInputsLookup(t *testing.T) {
 	})
 }
 
+// Test that complex answers count as candidates of their own type only, like the simple answers they embed
+func TestPopulateResultsComplexAnswers(t *testing.T) {
+	caa := CAAAnswer{
+		Answer: Answer{TTL: 3600, Type: "CAA", RrType: dns.TypeCAA, Class: "IN", Name: "Target.example.com.", Answer: ""},
+		Tag:    "issue",
+		Value:  "ca.example.net",
+	}
+	srv := SRVAnswer{
+		Answer: Answer{TTL: 3600, Type: "SRV", RrType: dns.TypeSRV, Class: "IN", Name: "target.example.com.", Answer: ""},
+		Target: "host.example.com.",
+	}
+	cname := Answer{TTL: 3600, Type: "CNAME", RrType: dns.TypeCNAME, Class: "IN", Name: "alias.example.com", Answer: "target.example.com."}
+
+	candidateSet := make(map[string][]Answer)
+	cnameSet := make(map[string][]Answer)
+	dnameSet := make(map[string][]Answer)
+	garbage := make(map[string][]Answer)
+	populateResults([]interface{}{caa, srv, cname}, dns.TypeCAA, candidateSet, cnameSet, dnameSet, garbage)
+	require.Equal(t, map[string][]Answer{"target.example.com": {caa.Answer}}, candidateSet)
+	require.Equal(t, map[string][]Answer{"alias.example.com": {cname}}, cnameSet)
+	require.Empty(t, dnameSet)
+	require.Empty(t, garbage)
+	require.True(t, isLookupComplete("alias.example.com", candidateSet, cnameSet, dnameSet))
+}
+
+// Test that following a CNAME to a complex answer returns both the CNAME and the record it points to
+func TestFollowingLookupComplexAnswer(t *testing.T) {
+	network := testserver.NewNetwork()
+	server := network.AddServer(testserver.MustParseZone("example.test.", `
+@ SOA ns1 hostmaster 1 7200 900 1209600 300
+alias CNAME target
+target CAA 0 issue "ca.example.net"
+`))
+	require.NoError(t, network.Start())
+	t.Cleanup(network.Close)
+	rc := NewResolverConfig()
+	rc.LogLevel = 1
+	rc.IPVersionMode = IPv4Only
+	rc.LocalAddrsV4 = []net.IP{net.ParseIP(DefaultLoopbackIPv4Addr)}
+	rc.ExternalNameServersV4 = []NameServer{{IP: server.IP, Port: uint16(network.Ports().DNS)}}
+	rc.RootNameServersV4 = rc.ExternalNameServersV4
+	rc.Timeout = 5 * time.Second
+	rc.NetworkTimeout = 500 * time.Millisecond
+	r := initTestResolver(t, rc)
+
+	retries := 1
+	res, _, status, err := r.followingLookup(context.Background(), &QuestionWithMetadata{
+		Q:                Question{Name: "alias.example.test", Type: dns.TypeCAA, Class: dns.ClassINET},
+		RetriesRemaining: &retries,
+	}, rc.ExternalNameServersV4, false)
+	require.NoError(t, err)
+	require.Equal(t, StatusNoError, status)
+	require.Len(t, res.Answers, 2)
+	cname, ok := res.Answers[0].(Answer)
+	require.True(t, ok)
+	require.Equal(t, "target.example.test.", cname.Answer)
+	caa, ok := res.Answers[1].(CAAAnswer)
+	require.True(t, ok)
+	require.Equal(t, "ca.example.net", caa.Value)
+}
+
 func TestGetDNSServersFromReader(t *testing.T) {
 	tests := []struct {
 		name     string
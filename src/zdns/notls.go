@@ -0,0 +1,61 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"net"
+
+	"github.com/zmap/zdns/src/internal/cachehash"
+)
+
+// defaultNoTLSNameServersSize is the number of name servers a NoTLSNameServers remembers if none is configured
+const defaultNoTLSNameServersSize = 10000
+
+// NoTLSNameServers is the set of IPs of name servers that failed opportunistic DNS over TLS, which aren't tried over
+// TLS again. A single NoTLSNameServers should be shared between all Resolvers, which is done by setting
+// ResolverConfig.NoTLSNameServers. Once full, the name server that was least recently skipped is forgotten first.
+// It is safe for concurrent use.
+type NoTLSNameServers struct {
+	ips cachehash.CacheHash
+}
+
+// NewNoTLSNameServers creates a NoTLSNameServers remembering at most maxSize name servers
+func NewNoTLSNameServers(maxSize int) *NoTLSNameServers {
+	s := new(NoTLSNameServers)
+	s.ips.Init(maxSize)
+	return s
+}
+
+// Add records that the name server with the given IP doesn't support DNS over TLS
+func (s *NoTLSNameServers) Add(ip net.IP) {
+	s.ips.Lock()
+	defer s.ips.Unlock()
+	s.ips.Upsert(ip.String(), struct{}{})
+}
+
+// Contains returns true if the name server with the given IP is known not to support DNS over TLS
+func (s *NoTLSNameServers) Contains(ip net.IP) bool {
+	s.ips.Lock()
+	defer s.ips.Unlock()
+	_, found := s.ips.Get(ip.String())
+	return found
+}
+
+// Len returns the number of name servers in the set
+func (s *NoTLSNameServers) Len() int {
+	s.ips.Lock()
+	defer s.ips.Unlock()
+	return s.ips.Len()
+}
@@ -0,0 +1,36 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoTLSNameServersBounded(t *testing.T) {
+	s := NewNoTLSNameServers(2)
+	a, b, c := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"), net.ParseIP("192.0.2.3")
+	s.Add(a)
+	s.Add(b)
+	// skipping a keeps it, so b is forgotten to make room for c
+	require.True(t, s.Contains(a))
+	s.Add(c)
+	require.Equal(t, 2, s.Len())
+	require.True(t, s.Contains(a))
+	require.False(t, s.Contains(b))
+	require.True(t, s.Contains(c))
+}
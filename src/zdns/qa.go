@@ -50,6 +50,10 @@ type TraceStep struct {
 	Layer      string            `json:"layer" groups:"trace"`
 	Cached     IsCached          `json:"cached" groups:"trace"`
 	Try        int               `json:"try" groups:"trace"`
+	Protocol   string            `json:"protocol,omitempty" groups:"trace"` // protocol the name server was queried over, empty if cached
+	// SynthesizedFrom is the zone whose cached, validated NSEC or NSEC3 records proved a negative answer without
+	// querying a name server (RFC 8198), empty otherwise
+	SynthesizedFrom string `json:"synthesized_from,omitempty" groups:"trace"`
 }
 
 // Result contains all the metadata from a complete lookup(s) for a name. Results is keyed with the ModuleName.
@@ -82,7 +86,9 @@ type SingleQueryResult struct {
 	Resolver           string        `json:"resolver" groups:"resolver,normal,long,trace"` // IP address
 	Flags              DNSFlags      `json:"flags" groups:"flags,long,trace"`
 	DNSSECResult       *DNSSECResult `json:"dnssec,omitempty" groups:"dnssec,normal,long,trace"`
-	TLSServerHandshake interface{}   `json:"tls_handshake,omitempty" groups:"normal,long,trace"` // used for --tls and --https, JSON string of the TLS handshake
+	TLSServerHandshake interface{}   `json:"tls_handshake,omitempty" groups:"normal,long,trace"` // used for --tls, --https and --quic, JSON string of the TLS handshake
+
+	synthesizedFrom string // zone whose cached NSEC/NSEC3 records the result was synthesized from, see TraceStep
 }
 
 type ExtendedResult struct {
@@ -92,6 +98,13 @@ type ExtendedResult struct {
 	Nameserver string            `json:"nameserver" groups:"short,normal,long,trace"` // NS name queried for this result
 }
 
+// NameServerResult is the response of a name server queried by LookupEachNameServer, along with the name server
+// itself, whose IP is filled in if it had to be resolved
+type NameServerResult struct {
+	ExtendedResult
+	NameServer NameServer
+}
+
 type AllNameServersResult struct {
 	LayeredResponses map[string][]ExtendedResult `json:"per_layer_responses" groups:"short,normal,long,trace"`
 }
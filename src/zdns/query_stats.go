@@ -0,0 +1,86 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"sync/atomic"
+)
+
+// QueryStatistics counts the on-the-wire queries sent by Resolvers. A single QueryStatistics should be shared between
+// all Resolvers, which is done by setting ResolverConfig.QueryStatistics. It is safe for concurrent use, and a nil
+// QueryStatistics ignores all updates.
+type QueryStatistics struct {
+	udp                 atomic.Uint64 // number of queries sent over UDP
+	tcp                 atomic.Uint64 // number of queries sent over TCP
+	dot                 atomic.Uint64 // number of queries sent over DNS over TLS
+	doh                 atomic.Uint64 // number of queries sent over DNS over HTTPS
+	doq                 atomic.Uint64 // number of queries sent over DNS over QUIC
+	truncationFallbacks atomic.Uint64 // number of truncated UDP responses that were retried over TCP
+	retries             atomic.Uint64 // number of queries retried after a failure
+}
+
+type QueryStatisticsMetadata struct {
+	UDP                 uint64 `json:"udp"`
+	TCP                 uint64 `json:"tcp"`
+	DoT                 uint64 `json:"dot"`
+	DoH                 uint64 `json:"doh"`
+	DoQ                 uint64 `json:"doq"`
+	TruncationFallbacks uint64 `json:"truncation_fallbacks"`
+	Retries             uint64 `json:"retries"`
+}
+
+// IncrementQueries records a query sent with the given protocol, one of UDPProtocol, TCPProtocol, DoTProtocol,
+// DoHProtocol or DoQProtocol
+func (s *QueryStatistics) IncrementQueries(protocol string) {
+	if s == nil {
+		return
+	}
+	switch protocol {
+	case UDPProtocol:
+		s.udp.Add(1)
+	case TCPProtocol:
+		s.tcp.Add(1)
+	case DoTProtocol:
+		s.dot.Add(1)
+	case DoHProtocol:
+		s.doh.Add(1)
+	case DoQProtocol:
+		s.doq.Add(1)
+	}
+}
+
+func (s *QueryStatistics) IncrementTruncationFallbacks() {
+	if s != nil {
+		s.truncationFallbacks.Add(1)
+	}
+}
+
+func (s *QueryStatistics) IncrementRetries() {
+	if s != nil {
+		s.retries.Add(1)
+	}
+}
+
+func (s *QueryStatistics) GetStatistics() *QueryStatisticsMetadata {
+	return &QueryStatisticsMetadata{
+		UDP:                 s.udp.Load(),
+		TCP:                 s.tcp.Load(),
+		DoT:                 s.dot.Load(),
+		DoH:                 s.doh.Load(),
+		DoQ:                 s.doq.Load(),
+		TruncationFallbacks: s.truncationFallbacks.Load(),
+		Retries:             s.retries.Load(),
+	}
+}
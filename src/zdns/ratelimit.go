@@ -0,0 +1,159 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"context"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	rateLimiterBurst       = 1    // queries that may be sent back-to-back before the limiter starts spacing them out
+	minRateLimiterPruneLen = 4096 // number of per-name-server buckets before idle buckets are pruned
+)
+
+// tokenBucket is a token bucket that hands out reservations. Callers take a token immediately and are told how long
+// to wait until that token is valid, so concurrent callers are spaced evenly without needing to retry.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token from the bucket and returns how long the caller must wait before using it
+func (b *tokenBucket) reserve(now time.Time, rate float64) time.Duration {
+	b.tokens = math.Min(rateLimiterBurst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// isFull returns true if the bucket would have refilled completely by now, and so holds no state worth keeping
+func (b *tokenBucket) isFull(now time.Time, rate float64) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*rate >= rateLimiterBurst
+}
+
+// RateLimiter limits the rate of on-the-wire queries, both globally and per destination name server IP.
+// A single RateLimiter should be shared between all Resolvers, which is done by setting ResolverConfig.RateLimiter.
+// It is safe for concurrent use.
+type RateLimiter struct {
+	globalRate        float64 // queries per second across all name servers, 0 for unlimited
+	perNameServerRate float64 // queries per second to any single name server IP, 0 for unlimited
+
+	mu            sync.Mutex
+	global        tokenBucket
+	perNameServer map[string]*tokenBucket
+	nextPruneLen  int
+
+	queries   atomic.Uint64 // number of queries that passed through the limiter
+	throttled atomic.Uint64 // number of queries that were delayed by the limiter
+	waited    atomic.Int64  // total time queries were delayed, in nanoseconds
+	waiting   atomic.Int64  // number of queries currently being delayed
+}
+
+// RateLimiterStatistics is a snapshot of the cumulative activity of a RateLimiter
+type RateLimiterStatistics struct {
+	Queries   uint64        `json:"queries"`
+	Throttled uint64        `json:"throttled"`
+	Waited    time.Duration `json:"waited"`
+	Waiting   int64         `json:"waiting"`
+}
+
+// NewRateLimiter creates a RateLimiter allowing at most queriesPerSecond queries in total and at most
+// perNameServerQueriesPerSecond queries to any single name server IP. A rate of 0 disables that limit.
+func NewRateLimiter(queriesPerSecond, perNameServerQueriesPerSecond float64) *RateLimiter {
+	now := time.Now()
+	return &RateLimiter{
+		globalRate:        math.Max(queriesPerSecond, 0),
+		perNameServerRate: math.Max(perNameServerQueriesPerSecond, 0),
+		global:            tokenBucket{tokens: rateLimiterBurst, last: now},
+		perNameServer:     make(map[string]*tokenBucket),
+		nextPruneLen:      minRateLimiterPruneLen,
+	}
+}
+
+// Wait blocks until a query may be sent to the name server with the given IP, or until ctx is done.
+func (rl *RateLimiter) Wait(ctx context.Context, ip net.IP) error {
+	rl.queries.Add(1)
+	delay := rl.reserve(ip)
+	if delay <= 0 {
+		return nil
+	}
+	rl.throttled.Add(1)
+	rl.waited.Add(int64(delay))
+	rl.waiting.Add(1)
+	defer rl.waiting.Add(-1)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reserve takes a token from both the global and the name server's bucket and returns the longer of the two waits
+func (rl *RateLimiter) reserve(ip net.IP) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	var delay time.Duration
+	if rl.globalRate > 0 {
+		delay = rl.global.reserve(now, rl.globalRate)
+	}
+	if rl.perNameServerRate > 0 {
+		key := ip.String()
+		bucket, ok := rl.perNameServer[key]
+		if !ok {
+			if len(rl.perNameServer) >= rl.nextPruneLen {
+				rl.pruneIdleBuckets(now)
+			}
+			bucket = &tokenBucket{tokens: rateLimiterBurst, last: now}
+			rl.perNameServer[key] = bucket
+		}
+		if nsDelay := bucket.reserve(now, rl.perNameServerRate); nsDelay > delay {
+			delay = nsDelay
+		}
+	}
+	return delay
+}
+
+// pruneIdleBuckets removes per-name-server buckets that have fully refilled. Iterative scans contact many distinct
+// name servers, so without pruning the map would grow without bound. Caller must hold rl.mu.
+func (rl *RateLimiter) pruneIdleBuckets(now time.Time) {
+	for key, bucket := range rl.perNameServer {
+		if bucket.isFull(now, rl.perNameServerRate) {
+			delete(rl.perNameServer, key)
+		}
+	}
+	rl.nextPruneLen = max(minRateLimiterPruneLen, 2*len(rl.perNameServer))
+}
+
+// GetStatistics returns the cumulative statistics of the RateLimiter
+func (rl *RateLimiter) GetStatistics() RateLimiterStatistics {
+	return RateLimiterStatistics{
+		Queries:   rl.queries.Load(),
+		Throttled: rl.throttled.Load(),
+		Waited:    time.Duration(rl.waited.Load()),
+		Waiting:   rl.waiting.Load(),
+	}
+}
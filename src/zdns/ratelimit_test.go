@@ -0,0 +1,72 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiterGlobal(t *testing.T) {
+	rl := NewRateLimiter(100, 0)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 21; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			require.NoError(t, rl.Wait(context.Background(), net.IPv4(10, 0, 0, byte(i))))
+		}(i)
+	}
+	wg.Wait()
+	// the first query is sent immediately, the remaining 20 are spaced 10ms apart
+	require.GreaterOrEqual(t, time.Since(start), 190*time.Millisecond)
+	stats := rl.GetStatistics()
+	require.Equal(t, uint64(21), stats.Queries)
+	require.Equal(t, uint64(20), stats.Throttled)
+}
+
+func TestRateLimiterPerNameServer(t *testing.T) {
+	rl := NewRateLimiter(0, 10)
+	ns1, ns2 := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")
+	require.Equal(t, time.Duration(0), rl.reserve(ns1))
+	require.Equal(t, time.Duration(0), rl.reserve(ns2), "name servers should not share a bucket")
+	require.InDelta(t, 100*time.Millisecond, rl.reserve(ns1), float64(5*time.Millisecond))
+}
+
+func TestRateLimiterWaitCancelled(t *testing.T) {
+	rl := NewRateLimiter(1, 0)
+	ip := net.ParseIP("192.0.2.1")
+	require.NoError(t, rl.Wait(context.Background(), ip))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, rl.Wait(ctx, ip), context.DeadlineExceeded)
+}
+
+func TestRateLimiterPrunesIdleBuckets(t *testing.T) {
+	rl := NewRateLimiter(0, 1000)
+	for i := 0; i < minRateLimiterPruneLen; i++ {
+		rl.reserve(net.IPv4(10, 0, byte(i>>8), byte(i)))
+	}
+	require.Len(t, rl.perNameServer, minRateLimiterPruneLen)
+	time.Sleep(5 * time.Millisecond)
+	rl.reserve(net.ParseIP("192.0.2.1"))
+	require.Len(t, rl.perNameServer, 1)
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
 	log "github.com/sirupsen/logrus"
 	"github.com/zmap/zcrypto/tls"
 	"github.com/zmap/zgrab2/lib/http"
@@ -65,7 +66,12 @@ type ResolverConfig struct {
 	CacheSize    int      // don't use both cache and cacheSize
 	LookupClient Lookuper // either a functional or mock Lookuper client for testing
 
-	Blacklist *blacklist.SafeBlacklist
+	Blacklist       *blacklist.SafeBlacklist
+	RateLimiter     *RateLimiter     // limits the rate of on-the-wire queries across all resolvers created from this config, nil for no limit
+	QueryStatistics *QueryStatistics // counts on-the-wire queries across all resolvers created from this config, nil to disable
+	// NoTLSNameServers are the name servers that failed opportunistic DNS over TLS, shared across all resolvers created
+	// from this config. If nil, each resolver keeps its own set.
+	NoTLSNameServers *NoTLSNameServers
 
 	LocalAddrsV4 []net.IP // ipv4 local addresses to use for connections, one will be selected at random for the resolver
 	LocalAddrsV6 []net.IP // ipv6 local addresses to use for connections, one will be selected at random for the resolver
@@ -86,20 +92,28 @@ type ResolverConfig struct {
 	ExternalNameServersV6 []NameServer // v6 name servers used for external lookups
 	RootNameServersV4     []NameServer // v4 root servers used for iterative lookups
 	RootNameServersV6     []NameServer // v6 root servers used for iterative lookups
+	IterationPort         uint16       // port of name servers learned from referrals in iterative lookups, 0 for the default port
+	IterationTLSPort      uint16       // port used for opportunistic DNS over TLS in iterative lookups, 0 for the default DoT port
 	LookupAllNameServers  bool         // perform the lookup via all the nameservers for the name
 	FollowCNAMEs          bool         // whether iterative lookups should follow CNAMEs/DNAMEs
 	DNSConfigFilePath     string       // path to the DNS config file, ex: /etc/resolv.conf
 
 	DNSSecEnabled        bool
 	ShouldValidateDNSSEC bool           // whether to validate DNSSEC
+	RootTrustAnchors     []dns.DS       // DS records of the root zone's keys to validate DNSSEC against, nil for the IANA root anchors
 	DNSOverHTTPS         bool           // whether to use DNS over HTTPS for External Lookups, n/a to Iterative Lookups
 	DNSOverTLS           bool           // whether to use DNS over TLS for External Lookups, n/a to Iterative Lookups
-	RootCAs              *x509.CertPool // Root CAs for DoT/DoH Server Verification
-	VerifyServerCert     bool           // Verify server certificates for DoT/DoH
+	DNSOverQUIC          bool           // whether to use DNS over QUIC for External Lookups, n/a to Iterative Lookups
+	OpportunisticTLS     bool           // whether Iterative Lookups try unauthenticated DNS over TLS to each name server before UDP/TCP
+	RootCAs              *x509.CertPool // Root CAs for DoT/DoH/DoQ Server Verification
+	VerifyServerCert     bool           // Verify server certificates for DoT/DoH/DoQ
 	HTTPSClientIPv4      *http.Client   // for DoH, per docs should be shared amongst requests
 	HTTPSClientIPv6      *http.Client   // for DoH, per docs should be shared amongst requests
 	EdnsOptions          []dns.EDNS0
 	CheckingDisabledBit  bool
+	// NegativeResponseAuthorities keeps the SOA, NSEC and NSEC3 records in the authorities of results with an error
+	// code, ex. NXDOMAIN. They're always used for caching, but left out of results unless a module needs them.
+	NegativeResponseAuthorities bool
 }
 
 // Validate checks if the ResolverConfig is valid, returns an error describing the issue if it is not.
@@ -123,6 +137,22 @@ func (rc *ResolverConfig) Validate() error {
 		return errors.New("cannot use both DNS over TLS and DNS over HTTPS")
 	}
 
+	if rc.DNSOverQUIC && (rc.DNSOverTLS || rc.DNSOverHTTPS) {
+		return errors.New("cannot use DNS over QUIC with DNS over TLS or DNS over HTTPS")
+	}
+
+	if rc.TransportMode == TCPOnly && rc.DNSOverQUIC {
+		return errors.New("cannot use DNS over QUIC with TCP only transport mode")
+	}
+
+	if rc.OpportunisticTLS && (rc.DNSOverTLS || rc.DNSOverHTTPS || rc.DNSOverQUIC) {
+		return errors.New("cannot use opportunistic DNS over TLS with DNS over TLS, HTTPS or QUIC")
+	}
+
+	if rc.OpportunisticTLS && rc.TransportMode == UDPOnly {
+		return errors.New("cannot use opportunistic DNS over TLS with UDP only transport mode")
+	}
+
 	if rc.VerifyServerCert && (rc.RootCAs == nil || rc.RootCAs.Size() == 0) {
 		return errors.New("cannot verify server certificates without root CAs")
 	}
@@ -250,14 +280,16 @@ func NewResolverConfig() *ResolverConfig {
 }
 
 type ConnectionInfo struct {
-	udpClient    *dns.Client
-	tcpClient    *dns.Client
-	udpConn      *dns.Conn            // for socket re-use with UDP
-	tcpConn      *dns.Conn            // for socket re-use with TCP
-	httpsClient  *http.Client         // for DoH
-	tlsConn      *dns.Conn            // for DoT
-	tlsHandshake *tls.ServerHandshake // for DoT, used to print TLS handshake to user
-	localAddr    net.IP
+	udpClient     *dns.Client
+	tcpClient     *dns.Client
+	udpConn       *dns.Conn            // for socket re-use with UDP
+	tcpConn       *dns.Conn            // for socket re-use with TCP
+	httpsClient   *http.Client         // for DoH
+	tlsConn       *dns.Conn            // for DoT
+	tlsHandshake  *tls.ServerHandshake // for DoT and DoQ, used to print TLS handshake to user
+	quicTransport *quic.Transport      // for DoQ, on a UDP socket bound to localAddr
+	quicConn      *quic.Conn           // for DoQ, each query is sent on a new stream of this connection
+	localAddr     net.IP
 }
 
 // Resolver is a struct that holds the state of a DNS resolver. It is used to perform DNS lookups.
@@ -266,16 +298,19 @@ type Resolver struct {
 	lookupClient Lookuper // either a functional or mock Lookuper client for testing
 
 	blacklist                   *blacklist.SafeBlacklist
-	userPreferredIPv4LocalAddrs []net.IP        // user-supplied local IPv4 addresses, we'll prefer to use these
-	userPreferredIPv6LocalAddrs []net.IP        // user-supplied local IPv6 addresses, we'll prefer to use these
-	connInfoIPv4Internet        *ConnectionInfo // used for IPv4 lookups to Internet-facing nameservers
-	connInfoIPv6Internet        *ConnectionInfo // used for IPv6 lookups to Internet-facing nameservers
-	connInfoIPv4Loopback        *ConnectionInfo // used for IPv4 lookups to loopback nameservers
-	connInfoIPv6Loopback        *ConnectionInfo // used for IPv6 lookups to loopback nameservers
+	rateLimiter                 *RateLimiter     // shared between all resolvers created from the same config
+	queryStats                  *QueryStatistics // shared between all resolvers created from the same config
+	userPreferredIPv4LocalAddrs []net.IP         // user-supplied local IPv4 addresses, we'll prefer to use these
+	userPreferredIPv6LocalAddrs []net.IP         // user-supplied local IPv6 addresses, we'll prefer to use these
+	connInfoIPv4Internet        *ConnectionInfo  // used for IPv4 lookups to Internet-facing nameservers
+	connInfoIPv6Internet        *ConnectionInfo  // used for IPv6 lookups to Internet-facing nameservers
+	connInfoIPv4Loopback        *ConnectionInfo  // used for IPv4 lookups to loopback nameservers
+	connInfoIPv6Loopback        *ConnectionInfo  // used for IPv6 lookups to loopback nameservers
 
 	retries          int               // constant, configured max number of retries
 	retriesRemaining int               // number of retries left in the current lookup
 	pendingQueries   map[Question]bool // map of pending queries, to prevent cyclic queries
+	noTLSNameServers *NoTLSNameServers // name servers that failed opportunistic DNS over TLS, not tried again
 	logLevel         log.Level
 
 	transportMode         transportMode
@@ -289,21 +324,28 @@ type Resolver struct {
 	maxDepth                   int
 	externalNameServers        []NameServer // name servers used by external lookups (either OS or user specified)
 	rootNameServers            []NameServer // root servers used for iterative lookups
+	iterationPort              uint16       // port of name servers learned from referrals, 0 for the default port
+	iterationTLSPort           uint16       // port used for opportunistic DNS over TLS, 0 for the default DoT port
 	lastUsedExternalNameServer *NameServer  // the last external name server used for an external lookup
 	lookupAllNameServers       bool
 	followCNAMEs               bool // whether iterative lookups should follow CNAMEs/DNAMEs
 
 	dnsSecEnabled        bool
-	shouldValidateDNSSEC bool             // whether to validate DNSSEC
-	validator            *dNSSECValidator // DNSSEC validator for the current lookup
+	shouldValidateDNSSEC bool              // whether to validate DNSSEC
+	rootTrustAnchors     map[uint16]dns.DS // root DS records by key tag, nil to use the IANA root anchors
+	validator            *dNSSECValidator  // DNSSEC validator for the current lookup
 
 	dnsOverHTTPSEnabled bool           // whether to use DNS over HTTPS for External Lookups, n/a to Iterative Lookups
 	dnsOverTLSEnabled   bool           // whether to use DNS over TLS for External Lookups, n/a to Iterative Lookups
-	rootCAs             *x509.CertPool // Root CAs for DoT/DoH Server Verification
-	verifyServerCert    bool           // Verify server certificates for DoT/DoH
+	dnsOverQUICEnabled  bool           // whether to use DNS over QUIC for External Lookups, n/a to Iterative Lookups
+	opportunisticTLS    bool           // whether Iterative Lookups try DNS over TLS to each name server before UDP/TCP
+	rootCAs             *x509.CertPool // Root CAs for DoT/DoH/DoQ Server Verification
+	verifyServerCert    bool           // Verify server certificates for DoT/DoH/DoQ
 	ednsOptions         []dns.EDNS0
 	checkingDisabledBit bool
 	isClosed            bool // true if the resolver has been closed, lookup will panic if called after Close
+
+	negativeResponseAuthorities bool // whether results with an error code keep their authorities
 }
 
 // InitResolver creates a new Resolver struct using the ResolverConfig. The Resolver is used to perform DNS lookups.
@@ -328,11 +370,14 @@ func InitResolver(config *ResolverConfig) (*Resolver, error) {
 		cache:        c,
 		lookupClient: config.LookupClient,
 
-		blacklist: config.Blacklist,
+		blacklist:   config.Blacklist,
+		rateLimiter: config.RateLimiter,
+		queryStats:  config.QueryStatistics,
 
 		retries:              config.Retries,
 		logLevel:             config.LogLevel,
 		pendingQueries:       make(map[Question]bool),
+		noTLSNameServers:     config.NoTLSNameServers,
 		lookupAllNameServers: config.LookupAllNameServers,
 
 		transportMode:         config.TransportMode,
@@ -340,19 +385,34 @@ func InitResolver(config *ResolverConfig) (*Resolver, error) {
 		iterationIPPreference: config.IterationIPPreference,
 		shouldRecycleSockets:  config.ShouldRecycleSockets,
 		followCNAMEs:          config.FollowCNAMEs,
+		iterationPort:         config.IterationPort,
+		iterationTLSPort:      config.IterationTLSPort,
 
 		timeout: config.Timeout,
 
 		dnsOverHTTPSEnabled:  config.DNSOverHTTPS,
 		dnsOverTLSEnabled:    config.DNSOverTLS,
+		dnsOverQUICEnabled:   config.DNSOverQUIC,
+		opportunisticTLS:     config.OpportunisticTLS,
 		rootCAs:              config.RootCAs,
 		verifyServerCert:     config.VerifyServerCert,
 		dnsSecEnabled:        config.DNSSecEnabled,
 		shouldValidateDNSSEC: config.ShouldValidateDNSSEC,
 		ednsOptions:          config.EdnsOptions,
 		checkingDisabledBit:  config.CheckingDisabledBit,
+
+		negativeResponseAuthorities: config.NegativeResponseAuthorities,
 	}
 	log.SetLevel(r.logLevel)
+	if r.noTLSNameServers == nil {
+		r.noTLSNameServers = NewNoTLSNameServers(defaultNoTLSNameServersSize)
+	}
+	if config.RootTrustAnchors != nil {
+		r.rootTrustAnchors = make(map[uint16]dns.DS, len(config.RootTrustAnchors))
+		for _, ds := range config.RootTrustAnchors {
+			r.rootTrustAnchors[ds.KeyTag] = ds
+		}
+	}
 	// Deep copy local address so Resolver is independent of the config
 	r.userPreferredIPv4LocalAddrs = DeepCopyIPs(config.LocalAddrsV4)
 	r.userPreferredIPv6LocalAddrs = DeepCopyIPs(config.LocalAddrsV6)
@@ -421,6 +481,8 @@ func (r *Resolver) getConnectionInfo(nameServer *NameServer) (*ConnectionInfo, e
 			return existingConnInfo, nil
 		} else if r.dnsOverTLSEnabled && existingConnInfo.tlsConn != nil {
 			return existingConnInfo, nil
+		} else if r.dnsOverQUICEnabled && existingConnInfo.quicTransport != nil {
+			return existingConnInfo, nil
 		} else if (r.transportMode == UDPOnly || r.transportMode == UDPOrTCP) && r.shouldRecycleSockets && existingConnInfo.udpConn != nil {
 			return existingConnInfo, nil
 		} else if r.transportMode == TCPOnly && r.shouldRecycleSockets && existingConnInfo.tcpConn != nil {
@@ -529,6 +591,14 @@ func (r *Resolver) getConnectionInfo(nameServer *NameServer) (*ConnectionInfo, e
 			}
 		}
 	}
+	if r.dnsOverQUICEnabled {
+		// QUIC connections to the name server are dialed from this transport as needed
+		udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: connInfo.localAddr})
+		if err != nil {
+			return nil, fmt.Errorf("unable to create QUIC socket: %w", err)
+		}
+		connInfo.quicTransport = &quic.Transport{Conn: udpConn}
+	}
 	if r.dnsOverHTTPSEnabled {
 		// Create a http.Client with the custom transport
 		connInfo.httpsClient = &http.Client{
@@ -625,7 +695,7 @@ func (r *Resolver) ExternalLookup(ctx context.Context, q *Question, dstServer *N
 		dstServer = r.lastUsedExternalNameServer
 		log.Info("no name server provided for external lookup, using last external name server: ", dstServer)
 	}
-	dstServer.PopulateDefaultPort(r.dnsOverTLSEnabled, r.dnsOverHTTPSEnabled)
+	dstServer.PopulateDefaultPort(r.dnsOverTLSEnabled || r.dnsOverQUICEnabled, r.dnsOverHTTPSEnabled)
 	if isValid, reason := dstServer.IsValid(); !isValid {
 		return nil, nil, StatusIllegalInput, fmt.Errorf("destination server %s is invalid: %s", dstServer.String(), reason)
 	}
@@ -699,6 +769,11 @@ func (r *Resolver) Close() {
 			}
 		}
 	}
+	for _, connInfo := range []*ConnectionInfo{r.connInfoIPv4Internet, r.connInfoIPv6Internet, r.connInfoIPv4Loopback, r.connInfoIPv6Loopback} {
+		if connInfo != nil && connInfo.quicTransport != nil {
+			closeQUICTransport(connInfo)
+		}
+	}
 }
 
 func (r *Resolver) randomExternalNameServer() *NameServer {
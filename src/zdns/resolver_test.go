@@ -57,6 +57,17 @@ func TestResolverConfig_Validate(t *testing.T) {
 		err := rc.Validate()
 		require.NotNil(t, err)
 	})
+	t.Run("Opportunistic TLS with an incompatible transport", func(t *testing.T) {
+		for _, rc := range []*ResolverConfig{
+			{OpportunisticTLS: true, TransportMode: UDPOnly},
+			{OpportunisticTLS: true, DNSOverTLS: true},
+		} {
+			rc.ExternalNameServersV4 = []NameServer{{IP: net.ParseIP("127.0.0.53"), Port: 53}}
+			rc.RootNameServersV4 = []NameServer{{IP: net.ParseIP("127.0.0.53"), Port: 53}}
+			rc.LocalAddrsV4 = []net.IP{net.ParseIP("127.0.0.1")}
+			require.NotNil(t, rc.Validate())
+		}
+	})
 	t.Run("Missing root nameserver", func(t *testing.T) {
 		rc := &ResolverConfig{
 			ExternalNameServersV4: []NameServer{{IP: net.ParseIP("127.0.0.53"), Port: 53}},
@@ -0,0 +1,166 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LookupFunc performs the lookup for a single question using a Resolver owned by the calling Scanner worker
+type LookupFunc func(ctx context.Context, r *Resolver, q *Question) (*SingleQueryResult, Trace, Status, error)
+
+// IterativeLookupFunc is a LookupFunc that performs an iterative lookup, starting at the root name servers
+func IterativeLookupFunc(ctx context.Context, r *Resolver, q *Question) (*SingleQueryResult, Trace, Status, error) {
+	return r.IterativeLookup(ctx, q)
+}
+
+// ExternalLookupFunc is a LookupFunc that performs a lookup using the configured external name servers
+func ExternalLookupFunc(ctx context.Context, r *Resolver, q *Question) (*SingleQueryResult, Trace, Status, error) {
+	return r.ExternalLookup(ctx, q, nil)
+}
+
+// ScanResult is the outcome of looking up a single question with a Scanner
+type ScanResult struct {
+	Question  Question
+	Result    *SingleQueryResult
+	Trace     Trace
+	Status    Status
+	Err       error
+	Timestamp time.Time     // when the lookup started
+	Duration  time.Duration // how long the lookup took
+}
+
+// Scanner performs lookups concurrently using a pool of workers, each with its own Resolver. It is the library
+// equivalent of the zdns command line tool's worker pool.
+type Scanner struct {
+	config  ResolverConfig
+	workers int
+	lookup  LookupFunc
+}
+
+// NewScanner creates a Scanner that performs lookups with workers concurrent Resolvers created from config. If config
+// does not have a Cache, or a NoTLSNameServers with opportunistic TLS, one is created so that all workers share it.
+// config must not be modified afterward.
+func NewScanner(config *ResolverConfig, workers int, lookup LookupFunc) (*Scanner, error) {
+	if config == nil {
+		return nil, errors.New("resolver config must not be nil")
+	}
+	if workers <= 0 {
+		return nil, fmt.Errorf("number of workers must be positive, got %d", workers)
+	}
+	if lookup == nil {
+		return nil, errors.New("lookup function must not be nil")
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid resolver config: %w", err)
+	}
+	s := &Scanner{config: *config, workers: workers, lookup: lookup}
+	if s.config.Cache == nil {
+		// resolvers only share a cache if it is set on the config, otherwise each would create its own
+		cacheSize := s.config.CacheSize
+		if cacheSize == 0 {
+			cacheSize = defaultCacheSize
+		}
+		s.config.Cache = new(Cache)
+		s.config.Cache.Init(cacheSize)
+		s.config.CacheSize = 0
+	}
+	if s.config.OpportunisticTLS && s.config.NoTLSNameServers == nil {
+		// like the cache, name servers that don't support TLS are only skipped by every worker if the set is shared
+		s.config.NoTLSNameServers = NewNoTLSNameServers(defaultNoTLSNameServersSize)
+	}
+	return s, nil
+}
+
+// Scan looks up every question received on questions and sends one ScanResult per question on the returned channel,
+// in the order the lookups complete. The returned channel is closed once questions is closed and all lookups have
+// finished, or once ctx is done, in which case questions that have not been looked up are dropped. Lookups in progress
+// when ctx is done stop once their current network operation completes or reaches the network timeout. The caller must
+// keep receiving from the returned channel until it is closed. A nil question gets a result with StatusIllegalInput.
+// All Resolvers are created before Scan returns, so any error creating them is returned immediately.
+func (s *Scanner) Scan(ctx context.Context, questions <-chan *Question) (<-chan ScanResult, error) {
+	resolvers := make([]*Resolver, 0, s.workers)
+	for i := 0; i < s.workers; i++ {
+		r, err := InitResolver(&s.config)
+		if err != nil {
+			for _, initialized := range resolvers {
+				initialized.Close()
+			}
+			return nil, fmt.Errorf("could not init resolver: %w", err)
+		}
+		resolvers = append(resolvers, r)
+	}
+	results := make(chan ScanResult)
+	var wg sync.WaitGroup
+	wg.Add(len(resolvers))
+	for _, r := range resolvers {
+		go func(r *Resolver) {
+			defer wg.Done()
+			defer r.Close()
+			s.work(ctx, r, questions, results)
+		}(r)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results, nil
+}
+
+// work looks up questions with r until questions is closed or ctx is done
+func (s *Scanner) work(ctx context.Context, r *Resolver, questions <-chan *Question, results chan<- ScanResult) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		var q *Question
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return
+		case q, ok = <-questions:
+			if !ok {
+				return
+			}
+		}
+		start := time.Now()
+		var result ScanResult
+		if q == nil {
+			// there's nothing to look up, but every question still gets a result
+			result = ScanResult{Status: StatusIllegalInput, Err: errors.New("question must not be nil"), Timestamp: start}
+		} else {
+			res, trace, status, err := s.lookup(ctx, r, q)
+			result = ScanResult{
+				Question:  *q,
+				Result:    res,
+				Trace:     trace,
+				Status:    status,
+				Err:       err,
+				Timestamp: start,
+				Duration:  time.Since(start),
+			}
+		}
+		select {
+		case results <- result:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
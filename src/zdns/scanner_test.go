@@ -0,0 +1,124 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScannerScan(t *testing.T) {
+	h := newHermeticNetwork(t)
+	scanner, err := NewScanner(h.resolverConfig(), 4, IterativeLookupFunc)
+	require.NoError(t, err)
+
+	expected := map[string]Status{
+		"example.test":         StatusNoError,
+		"www.example.test":     StatusNoError,
+		"missing.example.test": StatusNXDomain,
+		"lame.test":            StatusServFail,
+	}
+	questions := make(chan *Question)
+	results, err := scanner.Scan(context.Background(), questions)
+	require.NoError(t, err)
+	go func() {
+		for name := range expected {
+			questions <- &Question{Name: name, Type: dns.TypeA, Class: dns.ClassINET}
+		}
+		close(questions)
+	}()
+
+	statuses := make(map[string]Status)
+	for res := range results {
+		statuses[res.Question.Name] = res.Status
+		require.False(t, res.Timestamp.IsZero())
+		if res.Status == StatusNoError {
+			requireSingleA(t, res.Result, "192.0.2.1")
+		}
+	}
+	require.Equal(t, expected, statuses)
+}
+
+func TestScannerNilQuestion(t *testing.T) {
+	h := newHermeticNetwork(t)
+	scanner, err := NewScanner(h.resolverConfig(), 1, IterativeLookupFunc)
+	require.NoError(t, err)
+
+	questions := make(chan *Question, 2)
+	questions <- nil
+	questions <- &Question{Name: "example.test", Type: dns.TypeA, Class: dns.ClassINET}
+	close(questions)
+	results, err := scanner.Scan(context.Background(), questions)
+	require.NoError(t, err)
+
+	var statuses []Status
+	for res := range results {
+		statuses = append(statuses, res.Status)
+		if res.Status == StatusIllegalInput {
+			require.Error(t, res.Err)
+		}
+	}
+	require.Equal(t, []Status{StatusIllegalInput, StatusNoError}, statuses)
+}
+
+func TestScannerCancel(t *testing.T) {
+	h := newHermeticNetwork(t)
+	rc := h.resolverConfig()
+	rc.Timeout = time.Minute
+	rc.IterativeTimeout = time.Minute
+	scanner, err := NewScanner(rc, 2, IterativeLookupFunc)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	questions := make(chan *Question, 10)
+	for i := 0; i < cap(questions); i++ {
+		questions <- &Question{Name: "slow.test", Type: dns.TypeA, Class: dns.ClassINET}
+	}
+	results, err := scanner.Scan(ctx, questions)
+	require.NoError(t, err)
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	// in-flight lookups stop once their current network operation times out
+	closed := make(chan int)
+	go func() {
+		count := 0
+		for range results {
+			count++
+		}
+		closed <- count
+	}()
+	select {
+	case count := <-closed:
+		require.Less(t, count, cap(questions))
+	case <-time.After(4 * rc.NetworkTimeout):
+		t.Fatal("results channel was not closed after the context was cancelled")
+	}
+}
+
+func TestNewScannerInvalid(t *testing.T) {
+	rc := &ResolverConfig{}
+	_, err := NewScanner(rc, 1, IterativeLookupFunc)
+	require.Error(t, err)
+
+	rc = NewResolverConfig()
+	_, err = NewScanner(rc, 0, IterativeLookupFunc)
+	require.Error(t, err)
+	_, err = NewScanner(rc, 1, nil)
+	require.Error(t, err)
+}
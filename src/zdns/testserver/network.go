@@ -0,0 +1,154 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package testserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+const (
+	// maxStartAttempts is how many times Network.Start retries when a port picked for the first server is already in
+	// use on another server's address
+	maxStartAttempts = 10
+	certLifeTime     = 24 * time.Hour
+)
+
+// Network is a set of Servers on consecutive loopback addresses, starting at 127.0.0.2, that all listen on the same
+// ports. Resolvers only let callers choose the port of the root servers, so every server a resolver is referred to
+// must share it. Linux routes all of 127.0.0.0/8 to the loopback interface, so no setup is needed there.
+type Network struct {
+	Servers []*Server
+
+	ports   Ports
+	cert    tls.Certificate
+	certPEM []byte
+}
+
+// NewNetwork creates an empty network
+func NewNetwork() *Network {
+	return &Network{}
+}
+
+// AddServer adds a server on the next free loopback address that is authoritative for zones. Servers must be added
+// before the network is started.
+func (n *Network) AddServer(zones ...*Zone) *Server {
+	ip := net.IPv4(127, 0, 0, byte(len(n.Servers)+2))
+	s := NewServer(ip, zones...)
+	n.Servers = append(n.Servers, s)
+	return s
+}
+
+// Ports returns the ports shared by all servers in the network. Only valid after Start.
+func (n *Network) Ports() Ports {
+	return n.ports
+}
+
+// CertificatePEM returns the PEM encoded self-signed certificate used by every server for DNS over TLS and HTTPS.
+// It is valid for all of the servers' IP addresses and for "localhost". Only valid after Start.
+func (n *Network) CertificatePEM() []byte {
+	return n.certPEM
+}
+
+// CertPool returns a pool containing the network's certificate, for use as the root CAs of a TLS client
+func (n *Network) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(n.certPEM)
+	return pool
+}
+
+// Start starts every server in the network on a common set of ports
+func (n *Network) Start() error {
+	if len(n.Servers) == 0 {
+		return errors.New("network has no servers")
+	}
+	if err := n.generateCertificate(); err != nil {
+		return err
+	}
+	var err error
+	for attempt := 0; attempt < maxStartAttempts; attempt++ {
+		if err = n.listen(); err == nil {
+			for _, s := range n.Servers {
+				s.serve()
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unable to start network after %d attempts: %w", maxStartAttempts, err)
+}
+
+// listen binds the first server to free ports and every other server to the same ports
+func (n *Network) listen() error {
+	first := n.Servers[0]
+	if err := first.listen(Ports{}, n.cert); err != nil {
+		first.Close()
+		return err
+	}
+	n.ports = first.Ports()
+	for i, s := range n.Servers[1:] {
+		if err := s.listen(n.ports, n.cert); err != nil {
+			for _, started := range n.Servers[:i+2] {
+				started.Close()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops every server in the network
+func (n *Network) Close() {
+	for _, s := range n.Servers {
+		s.Close()
+	}
+}
+
+func (n *Network) generateCertificate() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("unable to generate certificate key: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "zdns test server"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(certLifeTime),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+	}
+	for _, s := range n.Servers {
+		template.IPAddresses = append(template.IPAddresses, s.IP)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("unable to create certificate: %w", err)
+	}
+	n.cert = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	n.certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return nil
+}
@@ -0,0 +1,405 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package testserver
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// Transports a Server answers queries over, as recorded in Query.Transport
+const (
+	TransportUDP   = "udp"
+	TransportTCP   = "tcp"
+	TransportTLS   = "tls"
+	TransportHTTPS = "https"
+	TransportQUIC  = "quic"
+)
+
+// Ports are the ports a Server listens on. UDP and TCP share the DNS port.
+type Ports struct {
+	DNS   int
+	TLS   int
+	HTTPS int
+	QUIC  int
+}
+
+// Query is a query received by a Server
+type Query struct {
+	Question  dns.Question
+	Transport string
+}
+
+// Server is an authoritative name server for a set of zones, listening on a single IP address over UDP, TCP, DNS over
+// TLS, DNS over HTTPS and DNS over QUIC. Queries for names outside its zones are REFUSED, so a server that is delegated a zone it
+// does not have emulates a lame delegation.
+type Server struct {
+	IP    net.IP
+	Zones []*Zone
+
+	Unresponsive atomic.Bool // if set, queries are never answered, causing the client to time out
+	TruncateUDP  atomic.Bool // if set, every UDP query is answered with an empty, truncated response
+	RefuseTLS    atomic.Bool // if set, DNS over TLS handshakes fail, as if the server didn't support DNS over TLS
+	SetAD        atomic.Bool // if set, responses have the AD bit set, as if a resolver had validated them
+
+	mu      sync.Mutex
+	queries []Query
+
+	ports         Ports
+	dnsServers    []*dns.Server
+	httpsServer   *http.Server
+	httpsListener net.Listener
+	quicListener  *quic.Listener
+	listeners     []io.Closer
+}
+
+// NewServer creates a server that will listen on ip and be authoritative for zones
+func NewServer(ip net.IP, zones ...*Zone) *Server {
+	return &Server{IP: ip, Zones: zones}
+}
+
+// Ports returns the ports the server is listening on. Only valid after Start.
+func (s *Server) Ports() Ports {
+	return s.ports
+}
+
+// Addr returns the address of the server's UDP and TCP listeners
+func (s *Server) Addr() string {
+	return net.JoinHostPort(s.IP.String(), strconv.Itoa(s.ports.DNS))
+}
+
+// TLSAddr returns the address of the server's DNS over TLS listener
+func (s *Server) TLSAddr() string {
+	return net.JoinHostPort(s.IP.String(), strconv.Itoa(s.ports.TLS))
+}
+
+// HTTPSAddr returns the address of the server's DNS over HTTPS listener, which serves /dns-query
+func (s *Server) HTTPSAddr() string {
+	return net.JoinHostPort(s.IP.String(), strconv.Itoa(s.ports.HTTPS))
+}
+
+// QUICAddr returns the address of the server's DNS over QUIC listener
+func (s *Server) QUICAddr() string {
+	return net.JoinHostPort(s.IP.String(), strconv.Itoa(s.ports.QUIC))
+}
+
+// Queries returns every query the server has received, in order
+func (s *Server) Queries() []Query {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Query(nil), s.queries...)
+}
+
+// ResetQueries clears the list of received queries
+func (s *Server) ResetQueries() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries = nil
+}
+
+// Start starts the server on the given ports using cert for TLS, HTTPS and QUIC. A port of 0 picks a free port.
+func (s *Server) Start(ports Ports, cert tls.Certificate) error {
+	if err := s.listen(ports, cert); err != nil {
+		s.Close()
+		return err
+	}
+	s.serve()
+	return nil
+}
+
+// listen binds all of the server's sockets without answering queries yet
+func (s *Server) listen(ports Ports, cert tls.Certificate) error {
+	ip := s.IP.String()
+	pc, err := net.ListenPacket("udp", net.JoinHostPort(ip, strconv.Itoa(ports.DNS)))
+	if err != nil {
+		return fmt.Errorf("unable to listen on UDP: %w", err)
+	}
+	s.listeners = append(s.listeners, pc)
+	ports.DNS = pc.LocalAddr().(*net.UDPAddr).Port
+	tcp, err := net.Listen("tcp", net.JoinHostPort(ip, strconv.Itoa(ports.DNS)))
+	if err != nil {
+		return fmt.Errorf("unable to listen on TCP: %w", err)
+	}
+	s.listeners = append(s.listeners, tcp)
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	dotConfig := tlsConfig.Clone()
+	dotConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		if s.RefuseTLS.Load() {
+			return nil, errors.New("DNS over TLS refused")
+		}
+		return nil, nil
+	}
+	dot, err := tls.Listen("tcp", net.JoinHostPort(ip, strconv.Itoa(ports.TLS)), dotConfig)
+	if err != nil {
+		return fmt.Errorf("unable to listen for DNS over TLS: %w", err)
+	}
+	s.listeners = append(s.listeners, dot)
+	ports.TLS = dot.Addr().(*net.TCPAddr).Port
+	doh, err := tls.Listen("tcp", net.JoinHostPort(ip, strconv.Itoa(ports.HTTPS)), tlsConfig)
+	if err != nil {
+		return fmt.Errorf("unable to listen for DNS over HTTPS: %w", err)
+	}
+	s.listeners = append(s.listeners, doh)
+	ports.HTTPS = doh.Addr().(*net.TCPAddr).Port
+	doqConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS13, NextProtos: []string{"doq"}}
+	doq, err := quic.ListenAddr(net.JoinHostPort(ip, strconv.Itoa(ports.QUIC)), doqConfig, &quic.Config{})
+	if err != nil {
+		return fmt.Errorf("unable to listen for DNS over QUIC: %w", err)
+	}
+	s.listeners = append(s.listeners, doq)
+	ports.QUIC = doq.Addr().(*net.UDPAddr).Port
+	s.quicListener = doq
+	s.ports = ports
+
+	s.dnsServers = []*dns.Server{
+		{PacketConn: pc, Handler: s.dnsHandler(TransportUDP)},
+		{Listener: tcp, Handler: s.dnsHandler(TransportTCP)},
+		{Listener: dot, Handler: s.dnsHandler(TransportTLS)},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dns-query", s.handleHTTPS)
+	s.httpsServer = &http.Server{Handler: mux}
+	s.httpsListener = doh
+	s.listeners = append(s.listeners, s.httpsServer)
+	return nil
+}
+
+// serve starts answering queries on the bound sockets, returning once all listeners are active
+func (s *Server) serve() {
+	var started sync.WaitGroup
+	for _, srv := range s.dnsServers {
+		started.Add(1)
+		srv.NotifyStartedFunc = started.Done
+		go func(srv *dns.Server) {
+			_ = srv.ActivateAndServe()
+		}(srv)
+	}
+	started.Wait()
+	go func() {
+		_ = s.httpsServer.Serve(s.httpsListener)
+	}()
+	go s.serveQUIC(s.quicListener)
+}
+
+// Close stops the server
+func (s *Server) Close() {
+	for _, srv := range s.dnsServers {
+		_ = srv.Shutdown()
+	}
+	for _, l := range s.listeners {
+		_ = l.Close()
+	}
+	s.dnsServers = nil
+	s.listeners = nil
+}
+
+func (s *Server) dnsHandler(transport string) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		if resp := s.respond(req, transport); resp != nil {
+			_ = w.WriteMsg(resp)
+		}
+	})
+}
+
+func (s *Server) handleHTTPS(w http.ResponseWriter, r *http.Request) {
+	var body []byte
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		body, err = io.ReadAll(r.Body)
+	case http.MethodGet:
+		body, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	req := new(dns.Msg)
+	if err == nil {
+		err = req.Unpack(body)
+	}
+	if err != nil {
+		http.Error(w, "invalid DNS message", http.StatusBadRequest)
+		return
+	}
+	resp := s.respond(req, TransportHTTPS)
+	if resp == nil {
+		<-r.Context().Done()
+		return
+	}
+	packed, err := resp.Pack()
+	if err != nil {
+		http.Error(w, "unable to pack response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/dns-message")
+	_, _ = w.Write(packed)
+}
+
+// serveQUIC answers DNS over QUIC (RFC 9250) queries, each of which is sent on its own stream
+func (s *Server) serveQUIC(listener *quic.Listener) {
+	for {
+		conn, err := listener.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		go func() {
+			for {
+				stream, err := conn.AcceptStream(context.Background())
+				if err != nil {
+					return
+				}
+				go s.handleQUICStream(stream)
+			}
+		}()
+	}
+}
+
+func (s *Server) handleQUICStream(stream *quic.Stream) {
+	// messages are prefixed with their length, like over TCP
+	var length [2]byte
+	if _, err := io.ReadFull(stream, length[:]); err != nil {
+		resetQUICStream(stream)
+		return
+	}
+	packed := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(stream, packed); err != nil {
+		resetQUICStream(stream)
+		return
+	}
+	req := new(dns.Msg)
+	if err := req.Unpack(packed); err != nil {
+		resetQUICStream(stream)
+		return
+	}
+	resp := s.respond(req, TransportQUIC)
+	if resp == nil {
+		// leave the stream open so the client times out
+		return
+	}
+	packed, err := resp.Pack()
+	if err != nil {
+		resetQUICStream(stream)
+		return
+	}
+	binary.BigEndian.PutUint16(length[:], uint16(len(packed)))
+	_, _ = stream.Write(append(length[:], packed...))
+	_ = stream.Close()
+}
+
+// resetQUICStream aborts both directions of a stream whose query couldn't be answered
+func resetQUICStream(stream *quic.Stream) {
+	stream.CancelRead(0)
+	stream.CancelWrite(0)
+}
+
+// respond builds the response to req, or returns nil if the query should go unanswered
+func (s *Server) respond(req *dns.Msg, transport string) *dns.Msg {
+	if len(req.Question) > 0 {
+		s.mu.Lock()
+		s.queries = append(s.queries, Query{Question: req.Question[0], Transport: transport})
+		s.mu.Unlock()
+	}
+	if s.Unresponsive.Load() {
+		return nil
+	}
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.RecursionAvailable = false
+	resp.AuthenticatedData = s.SetAD.Load()
+	if len(req.Question) != 1 {
+		resp.Rcode = dns.RcodeFormatError
+		return resp
+	}
+	if req.Opcode != dns.OpcodeQuery {
+		resp.Rcode = dns.RcodeNotImplemented
+		return resp
+	}
+	udpSize := dns.MinMsgSize
+	do := false
+	if opt := req.IsEdns0(); opt != nil {
+		udpSize = max(int(opt.UDPSize()), dns.MinMsgSize)
+		do = opt.Do()
+	}
+	zone := s.zoneFor(req.Question[0])
+	if zone == nil {
+		resp.Rcode = dns.RcodeRefused
+	} else {
+		zone.answer(req.Question[0], do, resp)
+	}
+	if req.IsEdns0() != nil {
+		resp.SetEdns0(dns.DefaultMsgSize, do)
+	}
+	if transport == TransportUDP {
+		if s.TruncateUDP.Load() {
+			resp.Answer, resp.Ns = nil, nil
+			resp.Extra = filterOPT(resp.Extra)
+			resp.Truncated = true
+		} else {
+			resp.Truncate(udpSize)
+		}
+	}
+	return resp
+}
+
+// zoneFor returns the zone the server should answer q from, or nil if it is not authoritative for q.
+// DS records live in the parent zone, so a DS query for the apex of one zone is answered from its parent if the
+// server has it.
+func (s *Server) zoneFor(q dns.Question) *Zone {
+	qname := dns.CanonicalName(q.Name)
+	var best *Zone
+	for _, z := range s.Zones {
+		if !dns.IsSubDomain(z.Origin, qname) {
+			continue
+		}
+		if q.Qtype == dns.TypeDS && z.Origin == qname && qname != "." {
+			continue
+		}
+		if best == nil || dns.CountLabel(z.Origin) > dns.CountLabel(best.Origin) {
+			best = z
+		}
+	}
+	if best == nil && q.Qtype == dns.TypeDS {
+		// we don't have the parent, so answer from the zone itself like a real server would
+		for _, z := range s.Zones {
+			if z.Origin == qname {
+				return z
+			}
+		}
+	}
+	return best
+}
+
+func filterOPT(rrs []dns.RR) []dns.RR {
+	var out []dns.RR
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			out = append(out, rr)
+		}
+	}
+	return out
+}
@@ -0,0 +1,236 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package testserver
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+type testHierarchy struct {
+	network *Network
+	root    *Server
+	tld     *Server
+	sld     *Server
+	lame    *Server
+}
+
+// newTestHierarchy serves a signed root -> test. -> example.test. chain, plus a lame delegation for lame.test.
+func newTestHierarchy(t *testing.T) *testHierarchy {
+	h := &testHierarchy{network: NewNetwork()}
+	h.root = h.network.AddServer()
+	h.tld = h.network.AddServer()
+	h.sld = h.network.AddServer()
+	h.lame = h.network.AddServer()
+
+	root := MustParseZone(".", `
+. SOA a.root-servers.test. hostmaster.test. 1 7200 900 1209600 86400
+. NS a.root-servers.test.
+a.root-servers.test. A `+h.root.IP.String())
+	tld := MustParseZone("test.", `@ SOA ns1.nic.test. hostmaster.test. 1 7200 900 1209600 300`)
+	sld := MustParseZone("example.test.", `
+@ SOA ns1 hostmaster 1 7200 900 1209600 300
+@ A 192.0.2.1
+www CNAME @
+*.wild A 192.0.2.2
+big TXT "`+strings.Repeat("a", 200)+`" "`+strings.Repeat("b", 200)+`" "`+strings.Repeat("c", 200)+`"
+`)
+	lame := NewZone("lame.test.")
+	root.Delegate(tld, "ns1.nic.test.", h.tld.IP)
+	tld.Delegate(sld, "ns1.example.test.", h.sld.IP)
+	tld.Delegate(lame, "ns.lame.test.", h.lame.IP)
+	require.NoError(t, sld.Sign())
+	require.NoError(t, tld.Sign())
+	require.NoError(t, root.Sign())
+	h.root.Zones = []*Zone{root}
+	h.tld.Zones = []*Zone{tld}
+	h.sld.Zones = []*Zone{sld}
+
+	require.NoError(t, h.network.Start())
+	t.Cleanup(h.network.Close)
+	return h
+}
+
+func query(t *testing.T, client *dns.Client, addr, name string, qtype uint16, do bool) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	if do {
+		m.SetEdns0(dns.DefaultMsgSize, true)
+	}
+	resp, _, err := client.Exchange(m, addr)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestReferralChain(t *testing.T) {
+	h := newTestHierarchy(t)
+	client := &dns.Client{Net: "udp"}
+
+	resp := query(t, client, h.root.Addr(), "www.example.test.", dns.TypeA, false)
+	require.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	require.False(t, resp.Authoritative)
+	require.Empty(t, resp.Answer)
+	require.Len(t, resp.Ns, 1)
+	require.Equal(t, "ns1.nic.test.", resp.Ns[0].(*dns.NS).Ns)
+	require.Len(t, resp.Extra, 1)
+	require.Equal(t, h.tld.IP.String(), resp.Extra[0].(*dns.A).A.String())
+
+	resp = query(t, client, h.tld.Addr(), "www.example.test.", dns.TypeA, false)
+	require.Equal(t, "ns1.example.test.", resp.Ns[0].(*dns.NS).Ns)
+
+	resp = query(t, client, h.sld.Addr(), "www.example.test.", dns.TypeA, false)
+	require.True(t, resp.Authoritative)
+	require.Len(t, resp.Answer, 2)
+	require.Equal(t, dns.TypeCNAME, resp.Answer[0].Header().Rrtype)
+	require.Equal(t, "192.0.2.1", resp.Answer[1].(*dns.A).A.String())
+}
+
+func TestNegativeAnswers(t *testing.T) {
+	h := newTestHierarchy(t)
+	client := &dns.Client{Net: "udp"}
+
+	resp := query(t, client, h.sld.Addr(), "missing.example.test.", dns.TypeA, true)
+	require.Equal(t, dns.RcodeNameError, resp.Rcode)
+	require.Equal(t, dns.TypeSOA, resp.Ns[0].Header().Rrtype)
+	require.Equal(t, uint32(300), resp.Ns[0].Header().Ttl)
+	var nsecs int
+	for _, rr := range resp.Ns {
+		if rr.Header().Rrtype == dns.TypeNSEC {
+			nsecs++
+		}
+	}
+	require.NotZero(t, nsecs)
+
+	resp = query(t, client, h.sld.Addr(), "example.test.", dns.TypeMX, false)
+	require.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	require.Empty(t, resp.Answer)
+	require.Len(t, resp.Ns, 1)
+
+	resp = query(t, client, h.sld.Addr(), "host.wild.example.test.", dns.TypeA, false)
+	require.Len(t, resp.Answer, 1)
+	require.Equal(t, "host.wild.example.test.", resp.Answer[0].Header().Name)
+}
+
+func TestSignedResponsesVerify(t *testing.T) {
+	h := newTestHierarchy(t)
+	client := &dns.Client{Net: "tcp"}
+
+	keys := query(t, client, h.sld.Addr(), "example.test.", dns.TypeDNSKEY, true)
+	dnskeys := make(map[uint16]*dns.DNSKEY)
+	for _, rr := range keys.Answer {
+		if key, ok := rr.(*dns.DNSKEY); ok {
+			dnskeys[key.KeyTag()] = key
+		}
+	}
+	require.Len(t, dnskeys, 2)
+
+	resp := query(t, client, h.sld.Addr(), "example.test.", dns.TypeA, true)
+	var rrset []dns.RR
+	var sig *dns.RRSIG
+	for _, rr := range resp.Answer {
+		if s, ok := rr.(*dns.RRSIG); ok {
+			sig = s
+		} else {
+			rrset = append(rrset, rr)
+		}
+	}
+	require.NotNil(t, sig)
+	require.NoError(t, sig.Verify(dnskeys[sig.KeyTag], rrset))
+
+	// the parent's DS must match the child's KSK
+	ds := query(t, client, h.tld.Addr(), "example.test.", dns.TypeDS, true)
+	require.True(t, ds.Authoritative)
+	found := false
+	for _, rr := range ds.Answer {
+		if d, ok := rr.(*dns.DS); ok {
+			key := dnskeys[d.KeyTag]
+			require.NotNil(t, key)
+			require.Equal(t, strings.ToLower(key.ToDS(d.DigestType).Digest), strings.ToLower(d.Digest))
+			found = true
+		}
+	}
+	require.True(t, found)
+}
+
+func TestTruncation(t *testing.T) {
+	h := newTestHierarchy(t)
+	udp := &dns.Client{Net: "udp"}
+
+	resp := query(t, udp, h.sld.Addr(), "big.example.test.", dns.TypeTXT, false)
+	require.True(t, resp.Truncated)
+	resp = query(t, udp, h.sld.Addr(), "big.example.test.", dns.TypeTXT, true)
+	require.False(t, resp.Truncated)
+
+	h.sld.TruncateUDP.Store(true)
+	resp = query(t, udp, h.sld.Addr(), "example.test.", dns.TypeA, false)
+	require.True(t, resp.Truncated)
+	require.Empty(t, resp.Answer)
+	resp = query(t, &dns.Client{Net: "tcp"}, h.sld.Addr(), "example.test.", dns.TypeA, false)
+	require.False(t, resp.Truncated)
+	require.Len(t, resp.Answer, 1)
+}
+
+func TestLameAndUnresponsive(t *testing.T) {
+	h := newTestHierarchy(t)
+	client := &dns.Client{Net: "udp", Timeout: 200 * time.Millisecond}
+
+	resp := query(t, client, h.lame.Addr(), "lame.test.", dns.TypeSOA, false)
+	require.Equal(t, dns.RcodeRefused, resp.Rcode)
+
+	h.sld.Unresponsive.Store(true)
+	m := new(dns.Msg)
+	m.SetQuestion("example.test.", dns.TypeA)
+	_, _, err := client.Exchange(m, h.sld.Addr())
+	require.Error(t, err)
+	require.Len(t, h.sld.Queries(), 1)
+}
+
+func TestEncryptedTransports(t *testing.T) {
+	h := newTestHierarchy(t)
+	tlsConfig := &tls.Config{RootCAs: h.network.CertPool(), MinVersion: tls.VersionTLS12}
+
+	dot := &dns.Client{Net: "tcp-tls", TLSConfig: tlsConfig}
+	resp := query(t, dot, h.sld.TLSAddr(), "example.test.", dns.TypeA, false)
+	require.Len(t, resp.Answer, 1)
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.test.", dns.TypeA)
+	packed, err := m.Pack()
+	require.NoError(t, err)
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	httpResp, err := httpClient.Post("https://"+h.sld.HTTPSAddr()+"/dns-query", "application/dns-message", bytes.NewReader(packed))
+	require.NoError(t, err)
+	defer httpResp.Body.Close()
+	require.Equal(t, http.StatusOK, httpResp.StatusCode)
+	body, err := io.ReadAll(httpResp.Body)
+	require.NoError(t, err)
+	resp = new(dns.Msg)
+	require.NoError(t, resp.Unpack(body))
+	require.Len(t, resp.Answer, 1)
+
+	var transports []string
+	for _, q := range h.sld.Queries() {
+		transports = append(transports, q.Transport)
+	}
+	require.Equal(t, []string{TransportTLS, TransportHTTPS}, transports)
+}
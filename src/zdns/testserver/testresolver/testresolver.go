@@ -0,0 +1,52 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+// Package testresolver builds resolvers that query a testserver.Network, for tests outside of the zdns package. It's
+// separate from testserver since the zdns package's own tests import testserver.
+package testresolver
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/zmap/zdns/src/zdns"
+	"github.com/zmap/zdns/src/zdns/testserver"
+)
+
+// Config returns a config for an IPv4 only resolver on loopback that uses server as both its root and external name
+// server, with timeouts short enough for tests. The network must have been started, so that its ports are known.
+func Config(network *testserver.Network, server *testserver.Server) *zdns.ResolverConfig {
+	ports := network.Ports()
+	rc := zdns.NewResolverConfig()
+	rc.LogLevel = 1
+	rc.IPVersionMode = zdns.IPv4Only
+	rc.LocalAddrsV4 = []net.IP{net.ParseIP(zdns.DefaultLoopbackIPv4Addr)}
+	rc.RootNameServersV4 = []zdns.NameServer{{IP: server.IP, Port: uint16(ports.DNS)}}
+	rc.ExternalNameServersV4 = rc.RootNameServersV4
+	rc.IterationPort = uint16(ports.DNS)
+	rc.Timeout = 5 * time.Second
+	rc.NetworkTimeout = 500 * time.Millisecond
+	return rc
+}
+
+// New initializes a resolver from rc, which is closed when the test ends
+func New(t *testing.T, rc *zdns.ResolverConfig) *zdns.Resolver {
+	r, err := zdns.InitResolver(rc)
+	require.NoError(t, err)
+	t.Cleanup(r.Close)
+	return r
+}
@@ -0,0 +1,489 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package testserver
+
+import (
+	"crypto"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultTTL        = 3600
+	maxCNAMEChain     = 8
+	signatureLeadIn   = time.Hour      // signatures are valid from this long before the zone was signed
+	signatureLifeTime = 24 * time.Hour // signatures expire this long after the zone was signed
+)
+
+// Zone is an authoritative DNS zone. Records are added with Add or parsed from zone file syntax with ParseZone.
+// A Zone must not be modified once the Server serving it has started.
+type Zone struct {
+	Origin string // fully-qualified, lower-case name of the zone apex
+
+	records map[string]map[uint16][]dns.RR // owner name -> type -> RRset
+	sigs    map[string]map[uint16][]dns.RR // owner name -> covered type -> RRSIGs, populated by Sign
+
+	children []*Zone // zones delegated with Delegate, whose DS records are added by Sign
+
+	ksk, zsk         *dns.DNSKEY
+	kskPriv, zskPriv crypto.Signer
+}
+
+// NewZone creates a zone for origin containing records
+func NewZone(origin string, records ...dns.RR) *Zone {
+	z := &Zone{
+		Origin:  dns.CanonicalName(origin),
+		records: make(map[string]map[uint16][]dns.RR),
+	}
+	z.Add(records...)
+	return z
+}
+
+// ParseZone creates a zone for origin from text in zone file syntax. Relative names are relative to origin and
+// records without a TTL default to 3600 seconds.
+func ParseZone(origin, text string) (*Zone, error) {
+	z := NewZone(origin)
+	zp := dns.NewZoneParser(strings.NewReader(text), z.Origin, "")
+	zp.SetDefaultTTL(defaultTTL)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		z.Add(rr)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, fmt.Errorf("unable to parse zone %s: %w", z.Origin, err)
+	}
+	return z, nil
+}
+
+// MustParseZone is like ParseZone but panics on error, for use in tests
+func MustParseZone(origin, text string) *Zone {
+	z, err := ParseZone(origin, text)
+	if err != nil {
+		panic(err)
+	}
+	return z
+}
+
+// MustParseRR parses a record in presentation format and panics on error, for use in tests
+func MustParseRR(s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		panic(err)
+	}
+	return rr
+}
+
+// Add adds records to the zone. Records must be at or below the zone's origin.
+func (z *Zone) Add(records ...dns.RR) {
+	for _, rr := range records {
+		rr = dns.Copy(rr)
+		hdr := rr.Header()
+		hdr.Name = dns.CanonicalName(hdr.Name)
+		if !dns.IsSubDomain(z.Origin, hdr.Name) {
+			panic(fmt.Sprintf("record %s is not within zone %s", hdr.Name, z.Origin))
+		}
+		if _, ok := z.records[hdr.Name]; !ok {
+			z.records[hdr.Name] = make(map[uint16][]dns.RR)
+		}
+		z.records[hdr.Name][hdr.Rrtype] = append(z.records[hdr.Name][hdr.Rrtype], rr)
+	}
+}
+
+// Delegate delegates child from z to the name server nsName at addrs. The NS record is added to both zones, and
+// address records for nsName are added to whichever of the zones contains it, making them glue in z. Delegate may be
+// called once for each of the child's name servers, and must be called before either zone is signed.
+func (z *Zone) Delegate(child *Zone, nsName string, addrs ...net.IP) {
+	nsName = dns.CanonicalName(nsName)
+	ns := &dns.NS{Hdr: dns.RR_Header{Name: child.Origin, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: defaultTTL}, Ns: nsName}
+	z.Add(ns)
+	child.Add(ns)
+	for _, addr := range addrs {
+		var rr dns.RR
+		hdr := dns.RR_Header{Name: nsName, Class: dns.ClassINET, Ttl: defaultTTL}
+		if ip4 := addr.To4(); ip4 != nil {
+			hdr.Rrtype = dns.TypeA
+			rr = &dns.A{Hdr: hdr, A: ip4}
+		} else {
+			hdr.Rrtype = dns.TypeAAAA
+			rr = &dns.AAAA{Hdr: hdr, AAAA: addr}
+		}
+		if dns.IsSubDomain(child.Origin, nsName) {
+			child.Add(rr)
+		}
+		if dns.IsSubDomain(z.Origin, nsName) {
+			z.Add(rr)
+		}
+	}
+	for _, c := range z.children {
+		if c == child {
+			return
+		}
+	}
+	z.children = append(z.children, child)
+}
+
+// Signed returns true if Sign has been called on the zone
+func (z *Zone) Signed() bool {
+	return z.ksk != nil
+}
+
+// DS returns the DS records for the zone's key signing key, to be added to the parent zone. Only valid after Sign.
+func (z *Zone) DS() []*dns.DS {
+	if !z.Signed() {
+		return nil
+	}
+	return []*dns.DS{z.ksk.ToDS(dns.SHA256)}
+}
+
+// Sign generates a key signing key and a zone signing key, adds the DNSKEY RRset and an NSEC chain to the zone,
+// and signs every authoritative RRset. The DS records of any signed child zones passed to Delegate are added first,
+// so children must be signed before their parents. It must be called after all other records have been added.
+func (z *Zone) Sign() error {
+	for _, child := range z.children {
+		for _, ds := range child.DS() {
+			z.Add(ds)
+		}
+	}
+	var err error
+	if z.ksk, z.kskPriv, err = generateKey(z.Origin, dns.ZONE|dns.SEP); err != nil {
+		return fmt.Errorf("unable to generate KSK for %s: %w", z.Origin, err)
+	}
+	if z.zsk, z.zskPriv, err = generateKey(z.Origin, dns.ZONE); err != nil {
+		return fmt.Errorf("unable to generate ZSK for %s: %w", z.Origin, err)
+	}
+	z.Add(z.ksk, z.zsk)
+	z.addNSECChain()
+
+	z.sigs = make(map[string]map[uint16][]dns.RR)
+	now := time.Now()
+	for name, types := range z.records {
+		cut := z.zoneCut(name)
+		if cut != "" && (cut != name) {
+			// glue below a delegation is not authoritative and is not signed
+			continue
+		}
+		for rrType, rrset := range types {
+			if cut == name && rrType != dns.TypeDS && rrType != dns.TypeNSEC {
+				// only the DS and NSEC RRsets at a delegation point are authoritative in the parent
+				continue
+			}
+			key, priv := z.zsk, z.zskPriv
+			if rrType == dns.TypeDNSKEY {
+				key, priv = z.ksk, z.kskPriv
+			}
+			sig := &dns.RRSIG{
+				Hdr:         dns.RR_Header{Name: name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: rrset[0].Header().Ttl},
+				TypeCovered: rrType,
+				Algorithm:   key.Algorithm,
+				OrigTtl:     rrset[0].Header().Ttl,
+				Expiration:  uint32(now.Add(signatureLifeTime).Unix()),
+				Inception:   uint32(now.Add(-signatureLeadIn).Unix()),
+				KeyTag:      key.KeyTag(),
+				SignerName:  z.Origin,
+			}
+			if err = sig.Sign(priv, rrset); err != nil {
+				return fmt.Errorf("unable to sign %s %s: %w", name, dns.TypeToString[rrType], err)
+			}
+			if _, ok := z.sigs[name]; !ok {
+				z.sigs[name] = make(map[uint16][]dns.RR)
+			}
+			z.sigs[name][rrType] = append(z.sigs[name][rrType], sig)
+		}
+	}
+	return nil
+}
+
+func generateKey(origin string, flags uint16) (*dns.DNSKEY, crypto.Signer, error) {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: origin, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: defaultTTL},
+		Flags:     flags,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("generated key of type %T is not a signer", priv)
+	}
+	return key, signer, nil
+}
+
+// addNSECChain links every authoritative name and delegation point in canonical order with NSEC records
+func (z *Zone) addNSECChain() {
+	names := make([]string, 0, len(z.records))
+	for name := range z.records {
+		if cut := z.zoneCut(name); cut == "" || cut == name {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return canonicalLess(names[i], names[j]) })
+	ttl := z.negativeTTL()
+	for i, name := range names {
+		types := []uint16{dns.TypeNSEC, dns.TypeRRSIG}
+		if z.zoneCut(name) == name {
+			// only the NS and DS RRsets at a delegation point belong to this zone, anything else is glue
+			types = append(types, dns.TypeNS)
+			if len(z.records[name][dns.TypeDS]) > 0 {
+				types = append(types, dns.TypeDS)
+			}
+		} else {
+			for rrType := range z.records[name] {
+				types = append(types, rrType)
+			}
+		}
+		sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+		z.Add(&dns.NSEC{
+			Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: ttl},
+			NextDomain: names[(i+1)%len(names)],
+			TypeBitMap: types,
+		})
+	}
+}
+
+// canonicalLess orders names as described in RFC 4034, Section 6.1
+func canonicalLess(a, b string) bool {
+	aLabels := dns.SplitDomainName(strings.ToLower(a))
+	bLabels := dns.SplitDomainName(strings.ToLower(b))
+	for i := 1; i <= len(aLabels) && i <= len(bLabels); i++ {
+		aLabel, bLabel := aLabels[len(aLabels)-i], bLabels[len(bLabels)-i]
+		if aLabel != bLabel {
+			return aLabel < bLabel
+		}
+	}
+	return len(aLabels) < len(bLabels)
+}
+
+// negativeTTL is the TTL for negative responses, the minimum of the SOA TTL and SOA MINIMUM field (RFC 2308)
+func (z *Zone) negativeTTL() uint32 {
+	soa := z.soa()
+	if soa == nil {
+		return defaultTTL
+	}
+	return min(soa.Hdr.Ttl, soa.Minttl)
+}
+
+func (z *Zone) soa() *dns.SOA {
+	if rrs := z.records[z.Origin][dns.TypeSOA]; len(rrs) > 0 {
+		soa, _ := rrs[0].(*dns.SOA)
+		return soa
+	}
+	return nil
+}
+
+// zoneCut returns the highest delegation point at or above name, or "" if name is not at or below a delegation
+func (z *Zone) zoneCut(name string) string {
+	labels := dns.SplitDomainName(name)
+	originLabels := dns.CountLabel(z.Origin)
+	for i := len(labels) - originLabels - 1; i >= 0; i-- {
+		candidate := dns.Fqdn(strings.Join(labels[i:], "."))
+		if len(z.records[candidate][dns.TypeNS]) > 0 {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// nameExists returns true if name owns records or is an empty non-terminal
+func (z *Zone) nameExists(name string) bool {
+	if _, ok := z.records[name]; ok {
+		return true
+	}
+	for owner := range z.records {
+		if dns.IsSubDomain(name, owner) {
+			return true
+		}
+	}
+	return false
+}
+
+// closestEncloser returns the longest existing ancestor of name
+func (z *Zone) closestEncloser(name string) string {
+	for ce := name; ; {
+		if z.nameExists(ce) || ce == z.Origin {
+			return ce
+		}
+		i, end := dns.NextLabel(ce, 0)
+		if end {
+			return z.Origin
+		}
+		ce = ce[i:]
+	}
+}
+
+// coveringNSEC returns the NSEC record whose owner name is the closest name before name in canonical order
+func (z *Zone) coveringNSEC(name string) dns.RR {
+	var best dns.RR
+	for owner, types := range z.records {
+		nsec := types[dns.TypeNSEC]
+		if len(nsec) == 0 || canonicalLess(name, owner) {
+			continue
+		}
+		if best == nil || canonicalLess(best.Header().Name, owner) {
+			best = nsec[0]
+		}
+	}
+	return best
+}
+
+// rrset returns the RRset of type rrType owned by name, along with its signatures if do is set
+func (z *Zone) rrset(name string, rrType uint16, do bool) []dns.RR {
+	rrs := z.records[name][rrType]
+	out := make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		out = append(out, dns.Copy(rr))
+	}
+	if do && len(out) > 0 {
+		for _, sig := range z.sigs[name][rrType] {
+			out = append(out, dns.Copy(sig))
+		}
+	}
+	return out
+}
+
+// negativeSOA returns the SOA record to include in a negative response, with the TTL lowered per RFC 2308
+func (z *Zone) negativeSOA(do bool) []dns.RR {
+	rrs := z.rrset(z.Origin, dns.TypeSOA, do)
+	ttl := z.negativeTTL()
+	for _, rr := range rrs {
+		rr.Header().Ttl = ttl
+	}
+	return rrs
+}
+
+// nsecProof returns the NSEC record (and its signatures) that either matches or covers name
+func (z *Zone) nsecProof(name string) []dns.RR {
+	if len(z.records[name][dns.TypeNSEC]) > 0 {
+		return z.rrset(name, dns.TypeNSEC, true)
+	}
+	nsec := z.coveringNSEC(name)
+	if nsec == nil {
+		return nil
+	}
+	return z.rrset(nsec.Header().Name, dns.TypeNSEC, true)
+}
+
+// answer fills in resp with the authoritative response to q
+func (z *Zone) answer(q dns.Question, do bool, resp *dns.Msg) {
+	qname := dns.CanonicalName(q.Name)
+	do = do && z.Signed()
+	for i := 0; i < maxCNAMEChain; i++ {
+		if cut := z.zoneCut(qname); cut != "" && !(cut == qname && q.Qtype == dns.TypeDS) {
+			if len(resp.Answer) == 0 {
+				z.referral(cut, do, resp)
+			}
+			return
+		}
+		resp.Authoritative = true
+		if !z.nameExists(qname) {
+			z.answerNonExistent(qname, q.Qtype, do, resp)
+			return
+		}
+		if cname := z.rrset(qname, dns.TypeCNAME, do); len(cname) > 0 && q.Qtype != dns.TypeCNAME {
+			resp.Answer = append(resp.Answer, cname...)
+			qname = dns.CanonicalName(cname[0].(*dns.CNAME).Target)
+			if !dns.IsSubDomain(z.Origin, qname) {
+				return
+			}
+			continue
+		}
+		if rrs := z.rrset(qname, q.Qtype, do); len(rrs) > 0 {
+			resp.Answer = append(resp.Answer, rrs...)
+			if q.Qtype == dns.TypeNS {
+				z.addGlue(rrs, resp)
+			}
+			return
+		}
+		// NODATA
+		resp.Ns = append(resp.Ns, z.negativeSOA(do)...)
+		if do {
+			resp.Ns = append(resp.Ns, z.nsecProof(qname)...)
+		}
+		return
+	}
+}
+
+// answerNonExistent answers a query for a name that does not exist, synthesizing from a wildcard if there is one
+func (z *Zone) answerNonExistent(qname string, qtype uint16, do bool, resp *dns.Msg) {
+	ce := z.closestEncloser(qname)
+	wildcard := "*." + ce
+	if _, ok := z.records[wildcard]; ok {
+		rrs := z.rrset(wildcard, qtype, do)
+		if len(rrs) == 0 {
+			rrs = z.rrset(wildcard, dns.TypeCNAME, do)
+		}
+		for _, rr := range rrs {
+			rr.Header().Name = qname
+		}
+		if len(rrs) > 0 {
+			resp.Answer = append(resp.Answer, rrs...)
+		} else {
+			resp.Ns = append(resp.Ns, z.negativeSOA(do)...)
+			if do {
+				resp.Ns = append(resp.Ns, z.nsecProof(wildcard)...)
+			}
+		}
+		if do {
+			// prove that qname itself does not exist
+			resp.Ns = append(resp.Ns, z.nsecProof(qname)...)
+		}
+		return
+	}
+	resp.Rcode = dns.RcodeNameError
+	resp.Ns = append(resp.Ns, z.negativeSOA(do)...)
+	if do {
+		resp.Ns = append(resp.Ns, z.nsecProof(qname)...)
+		if wildcardProof := z.nsecProof(wildcard); len(wildcardProof) > 0 && wildcardProof[0].Header().Name != resp.Ns[len(resp.Ns)-1].Header().Name {
+			resp.Ns = append(resp.Ns, wildcardProof...)
+		}
+	}
+}
+
+// referral fills in resp with a referral to the child zone delegated at cut
+func (z *Zone) referral(cut string, do bool, resp *dns.Msg) {
+	ns := z.rrset(cut, dns.TypeNS, false)
+	resp.Ns = append(resp.Ns, ns...)
+	if do {
+		if ds := z.rrset(cut, dns.TypeDS, true); len(ds) > 0 {
+			resp.Ns = append(resp.Ns, ds...)
+		} else {
+			// prove the delegation is insecure
+			resp.Ns = append(resp.Ns, z.rrset(cut, dns.TypeNSEC, true)...)
+		}
+	}
+	z.addGlue(ns, resp)
+}
+
+// addGlue adds address records for any name servers in nsRRs that are within the zone
+func (z *Zone) addGlue(nsRRs []dns.RR, resp *dns.Msg) {
+	for _, rr := range nsRRs {
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+		target := dns.CanonicalName(ns.Ns)
+		if !dns.IsSubDomain(z.Origin, target) {
+			continue
+		}
+		resp.Extra = append(resp.Extra, z.rrset(target, dns.TypeA, false)...)
+		resp.Extra = append(resp.Extra, z.rrset(target, dns.TypeAAAA, false)...)
+	}
+}
@@ -0,0 +1,483 @@
+/*
+ * ZDNS Copyright 2024 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+	"github.com/zmap/zcrypto/x509"
+
+	"github.com/zmap/zdns/src/zdns/testserver"
+)
+
+type hermeticNetwork struct {
+	network *testserver.Network
+	root    *testserver.Server
+	tld     *testserver.Server
+	sld     *testserver.Server
+	lame    *testserver.Server
+	slow    *testserver.Server
+	// resolver serves every zone, answering like a recursive resolver would for names within them
+	resolver *testserver.Server
+	anchors  []dns.DS
+}
+
+// newHermeticNetwork serves a signed root -> test. -> example.test. delegation chain on loopback, along with a lame
+// delegation for lame.test. and an unresponsive server for slow.test.
+func newHermeticNetwork(t *testing.T) *hermeticNetwork {
+	h := &hermeticNetwork{network: testserver.NewNetwork()}
+	h.root = h.network.AddServer()
+	h.tld = h.network.AddServer()
+	h.sld = h.network.AddServer()
+	h.lame = h.network.AddServer()
+	h.slow = h.network.AddServer()
+	h.resolver = h.network.AddServer()
+
+	root := testserver.MustParseZone(".", `
+. SOA a.root-servers.test. hostmaster.test. 1 7200 900 1209600 86400
+. NS a.root-servers.test.
+a.root-servers.test. A `+h.root.IP.String())
+	tld := testserver.MustParseZone("test.", `@ SOA ns1.nic.test. hostmaster.test. 1 7200 900 1209600 300`)
+	sld := testserver.MustParseZone("example.test.", `
+@ SOA ns1 hostmaster 1 7200 900 1209600 300
+@ A 192.0.2.1
+www CNAME @
+`)
+	slow := testserver.NewZone("slow.test.")
+	insecure := testserver.MustParseZone("insecure.test.", `
+@ SOA ns hostmaster 1 7200 900 1209600 300
+www A 192.0.2.2
+`)
+	root.Delegate(tld, "ns1.nic.test.", h.tld.IP)
+	tld.Delegate(sld, "ns1.example.test.", h.sld.IP)
+	tld.Delegate(testserver.NewZone("lame.test."), "ns.lame.test.", h.lame.IP)
+	tld.Delegate(slow, "ns.slow.test.", h.slow.IP)
+	tld.Delegate(insecure, "ns.insecure.test.", h.resolver.IP)
+	require.NoError(t, sld.Sign())
+	require.NoError(t, tld.Sign())
+	require.NoError(t, root.Sign())
+	for _, ds := range root.DS() {
+		h.anchors = append(h.anchors, *ds)
+	}
+	h.root.Zones = []*testserver.Zone{root}
+	h.tld.Zones = []*testserver.Zone{tld}
+	h.sld.Zones = []*testserver.Zone{sld}
+	h.slow.Zones = []*testserver.Zone{slow}
+	h.resolver.Zones = []*testserver.Zone{root, tld, sld, insecure}
+	h.slow.Unresponsive.Store(true)
+
+	require.NoError(t, h.network.Start())
+	t.Cleanup(h.network.Close)
+	return h
+}
+
+func (h *hermeticNetwork) resolverConfig() *ResolverConfig {
+	ports := h.network.Ports()
+	rc := NewResolverConfig()
+	rc.LogLevel = 1
+	rc.IPVersionMode = IPv4Only
+	rc.LocalAddrsV4 = []net.IP{net.ParseIP(DefaultLoopbackIPv4Addr)}
+	rc.RootNameServersV4 = []NameServer{{IP: h.root.IP, Port: uint16(ports.DNS)}}
+	rc.ExternalNameServersV4 = []NameServer{{IP: h.sld.IP, Port: uint16(ports.DNS)}}
+	rc.IterationPort = uint16(ports.DNS)
+	rc.RootTrustAnchors = h.anchors
+	rc.Timeout = 5 * time.Second
+	rc.IterativeTimeout = time.Second
+	rc.NetworkTimeout = 500 * time.Millisecond
+	return rc
+}
+
+func initTestResolver(t *testing.T, rc *ResolverConfig) *Resolver {
+	r, err := InitResolver(rc)
+	require.NoError(t, err)
+	t.Cleanup(r.Close)
+	return r
+}
+
+func requireSingleA(t *testing.T, res *SingleQueryResult, ip string) {
+	var found []string
+	for _, ans := range res.Answers {
+		if a, ok := ans.(Answer); ok && a.RrType == dns.TypeA {
+			found = append(found, a.Answer)
+		}
+	}
+	require.Equal(t, []string{ip}, found)
+}
+
+func TestHermeticIterativeLookup(t *testing.T) {
+	h := newHermeticNetwork(t)
+	r := initTestResolver(t, h.resolverConfig())
+
+	res, _, status, err := r.IterativeLookup(context.Background(), &Question{Name: "www.example.test", Type: dns.TypeA, Class: dns.ClassINET})
+	require.NoError(t, err)
+	require.Equal(t, StatusNoError, status)
+	requireSingleA(t, res, "192.0.2.1")
+
+	_, _, status, _ = r.IterativeLookup(context.Background(), &Question{Name: "missing.example.test", Type: dns.TypeA, Class: dns.ClassINET})
+	require.Equal(t, StatusNXDomain, status)
+}
+
+func TestHermeticNXDomainAuthorities(t *testing.T) {
+	h := newHermeticNetwork(t)
+	rc := h.resolverConfig()
+	rc.DNSSecEnabled = true
+	r := initTestResolver(t, rc)
+
+	// by default, the records proving the name doesn't exist are only used for caching
+	q := &Question{Name: "missing.example.test", Type: dns.TypeA, Class: dns.ClassINET}
+	res, _, status, _ := r.IterativeLookup(context.Background(), q)
+	require.Equal(t, StatusNXDomain, status)
+	require.Empty(t, res.Authorities)
+	// like the authorities, the flags of a negative response are only used to decide whether it's cached
+	require.Equal(t, DNSFlags{}, res.Flags)
+	res, _, status, _ = r.ExternalLookup(context.Background(), q, nil)
+	require.Equal(t, StatusNXDomain, status)
+	require.Empty(t, res.Authorities)
+	require.Equal(t, DNSFlags{}, res.Flags)
+
+	// modules that walk or reason about the zone keep them
+	rc.NegativeResponseAuthorities = true
+	r = initTestResolver(t, rc)
+	res, _, status, _ = r.IterativeLookup(context.Background(), q)
+	require.Equal(t, StatusNXDomain, status)
+	var types []string
+	for _, ans := range res.Authorities {
+		switch a := ans.(type) {
+		case SOAAnswer:
+			types = append(types, a.Type)
+		case NSECAnswer:
+			types = append(types, a.Type)
+		}
+	}
+	require.Contains(t, types, "SOA")
+	require.Contains(t, types, "NSEC")
+}
+
+func TestHermeticIterativeLookupDNSSEC(t *testing.T) {
+	h := newHermeticNetwork(t)
+	rc := h.resolverConfig()
+	rc.DNSSecEnabled = true
+	rc.ShouldValidateDNSSEC = true
+	r := initTestResolver(t, rc)
+
+	res, _, status, err := r.IterativeLookup(context.Background(), &Question{Name: "example.test", Type: dns.TypeA, Class: dns.ClassINET})
+	require.NoError(t, err)
+	require.Equal(t, StatusNoError, status)
+	require.NotNil(t, res.DNSSECResult)
+	require.Equal(t, DNSSECSecure, res.DNSSECResult.Status, res.DNSSECResult.Reason)
+}
+
+func TestHermeticExternalLookupDNSSEC(t *testing.T) {
+	h := newHermeticNetwork(t)
+	rc := h.resolverConfig()
+	rc.DNSSecEnabled = true
+	rc.ShouldValidateDNSSEC = true
+	rc.ExternalNameServersV4 = []NameServer{{IP: h.resolver.IP, Port: uint16(h.network.Ports().DNS)}}
+
+	t.Run("secure", func(t *testing.T) {
+		r := initTestResolver(t, rc)
+		res, _, status, err := r.ExternalLookup(context.Background(), &Question{Name: "www.example.test", Type: dns.TypeA, Class: dns.ClassINET}, nil)
+		require.NoError(t, err)
+		require.Equal(t, StatusNoError, status)
+		requireSingleA(t, res, "192.0.2.1")
+		require.NotNil(t, res.DNSSECResult)
+		require.Equal(t, DNSSECSecure, res.DNSSECResult.Status, res.DNSSECResult.Reason)
+		// the resolver didn't claim to have validated anything
+		require.NotNil(t, res.DNSSECResult.ResolverAuthenticated)
+		require.False(t, *res.DNSSECResult.ResolverAuthenticated)
+		// the chain of trust was fetched through the resolver, not the root
+		require.Empty(t, h.root.Queries())
+	})
+	t.Run("insecure with AD bit set", func(t *testing.T) {
+		h.resolver.SetAD.Store(true)
+		defer h.resolver.SetAD.Store(false)
+		r := initTestResolver(t, rc)
+		res, _, status, err := r.ExternalLookup(context.Background(), &Question{Name: "www.insecure.test", Type: dns.TypeA, Class: dns.ClassINET}, nil)
+		require.NoError(t, err)
+		require.Equal(t, StatusNoError, status)
+		requireSingleA(t, res, "192.0.2.2")
+		require.NotNil(t, res.DNSSECResult)
+		require.Equal(t, DNSSECInsecure, res.DNSSECResult.Status, res.DNSSECResult.Reason)
+		require.NotNil(t, res.DNSSECResult.ResolverAuthenticated)
+		require.True(t, *res.DNSSECResult.ResolverAuthenticated)
+	})
+}
+
+func TestHermeticIterativeLookupTruncated(t *testing.T) {
+	h := newHermeticNetwork(t)
+	h.sld.TruncateUDP.Store(true)
+	rc := h.resolverConfig()
+	rc.QueryStatistics = new(QueryStatistics)
+	rc.RateLimiter = NewRateLimiter(0, 0)
+	r := initTestResolver(t, rc)
+
+	res, _, status, err := r.IterativeLookup(context.Background(), &Question{Name: "example.test", Type: dns.TypeA, Class: dns.ClassINET})
+	require.NoError(t, err)
+	require.Equal(t, StatusNoError, status)
+	requireSingleA(t, res, "192.0.2.1")
+	transports := make(map[string]bool)
+	for _, q := range h.sld.Queries() {
+		transports[q.Transport] = true
+	}
+	require.True(t, transports[testserver.TransportTCP], "expected a TCP retry after truncation")
+	stats := rc.QueryStatistics.GetStatistics()
+	require.Equal(t, uint64(1), stats.TruncationFallbacks)
+	require.Equal(t, stats.TCP, stats.TruncationFallbacks)
+	require.Equal(t, uint64(3), stats.UDP) // root, TLD and SLD
+	// the TCP retry takes its own rate limit token
+	require.Equal(t, stats.UDP+stats.TCP, rc.RateLimiter.GetStatistics().Queries)
+}
+
+func TestHermeticNegativeCaching(t *testing.T) {
+	h := newHermeticNetwork(t)
+	rc := h.resolverConfig()
+	rc.Cache.Stats.CaptureStatistics()
+	r := initTestResolver(t, rc)
+
+	// a second lookup of any type for a name that doesn't exist, and of the same type for a name without records of
+	// that type, are answered from the cache
+	for _, qType := range []uint16{dns.TypeA, dns.TypeMX} {
+		_, _, status, _ := r.IterativeLookup(context.Background(), &Question{Name: "missing.example.test", Type: qType, Class: dns.ClassINET})
+		require.Equal(t, StatusNXDomain, status)
+	}
+	for i := 0; i < 2; i++ {
+		res, _, status, err := r.IterativeLookup(context.Background(), &Question{Name: "example.test", Type: dns.TypeAAAA, Class: dns.ClassINET})
+		require.NoError(t, err)
+		require.Equal(t, StatusNoError, status)
+		require.Empty(t, res.Answers)
+	}
+	require.Len(t, h.sld.Queries(), 2)
+	// NXDOMAIN is retried, and the retries are answered from the cache as well
+	require.GreaterOrEqual(t, rc.Cache.Stats.GetStatistics().NegativeHits, uint64(2))
+}
+
+func TestHermeticAggressiveNSEC(t *testing.T) {
+	h := newHermeticNetwork(t)
+	rc := h.resolverConfig()
+	rc.DNSSecEnabled = true
+	rc.ShouldValidateDNSSEC = true
+	rc.Cache.Stats.CaptureStatistics()
+	r := initTestResolver(t, rc)
+
+	res, _, status, _ := r.IterativeLookup(context.Background(), &Question{Name: "missing.example.test", Type: dns.TypeA, Class: dns.ClassINET})
+	require.Equal(t, StatusNXDomain, status)
+	require.Equal(t, DNSSECSecure, res.DNSSECResult.Status, res.DNSSECResult.Reason)
+	sldQueries := len(h.sld.Queries())
+
+	// other names in the gap between example.test. and ns1.example.test., and types the apex doesn't have, are
+	// answered from the NSEC record of the first response
+	for _, q := range []Question{
+		{Name: "mail.example.test", Type: dns.TypeA, Class: dns.ClassINET},
+		{Name: "example.test", Type: dns.TypeMX, Class: dns.ClassINET},
+	} {
+		res, trace, status, _ := r.IterativeLookup(context.Background(), &q)
+		require.Empty(t, res.Answers)
+		require.Equal(t, DNSSECSecure, res.DNSSECResult.Status)
+		require.NotEmpty(t, trace)
+		require.Equal(t, "example.test", trace[len(trace)-1].SynthesizedFrom)
+		if q.Type == dns.TypeA {
+			require.Equal(t, StatusNXDomain, status)
+		} else {
+			require.Equal(t, StatusNoError, status)
+		}
+	}
+	require.Len(t, h.sld.Queries(), sldQueries)
+	// NXDOMAIN is retried, and the retries are synthesized as well
+	require.GreaterOrEqual(t, rc.Cache.Stats.GetStatistics().Synthesized, uint64(2))
+}
+
+func TestHermeticIterativeLookupFailures(t *testing.T) {
+	h := newHermeticNetwork(t)
+	r := initTestResolver(t, h.resolverConfig())
+
+	// the only name server for each zone fails, so both lookups run out of authorities to try
+	_, _, status, _ := r.IterativeLookup(context.Background(), &Question{Name: "lame.test", Type: dns.TypeA, Class: dns.ClassINET})
+	require.Equal(t, StatusServFail, status)
+	require.NotEmpty(t, h.lame.Queries())
+
+	_, _, status, _ = r.IterativeLookup(context.Background(), &Question{Name: "slow.test", Type: dns.TypeA, Class: dns.ClassINET})
+	require.Equal(t, StatusServFail, status)
+	require.Len(t, h.slow.Queries(), defaultRetries+1)
+}
+
+func TestHermeticIterativeLookupOpportunisticTLS(t *testing.T) {
+	h := newHermeticNetwork(t)
+	h.sld.RefuseTLS.Store(true)
+	rc := h.resolverConfig()
+	rc.OpportunisticTLS = true
+	rc.IterationTLSPort = uint16(h.network.Ports().TLS)
+	rc.QueryStatistics = new(QueryStatistics)
+	rc.RateLimiter = NewRateLimiter(0, 0)
+	r := initTestResolver(t, rc)
+
+	res, trace, status, err := r.IterativeLookup(context.Background(), &Question{Name: "www.example.test", Type: dns.TypeA, Class: dns.ClassINET})
+	require.NoError(t, err)
+	require.Equal(t, StatusNoError, status)
+	requireSingleA(t, res, "192.0.2.1")
+	// the root and TLD servers answer over TLS, the SLD server doesn't support it so the lookup falls back to UDP
+	protocols := make(map[string]string)
+	for _, step := range trace {
+		protocols[step.Layer] = step.Protocol
+	}
+	require.Equal(t, map[string]string{".": DoTProtocol, "test": DoTProtocol, "example.test": UDPProtocol}, protocols)
+	require.Equal(t, testserver.TransportTLS, h.root.Queries()[0].Transport)
+	stats := rc.QueryStatistics.GetStatistics()
+	require.Equal(t, uint64(3), stats.DoT)
+	require.Equal(t, uint64(1), stats.UDP)
+	// the failed TLS attempt and the UDP fallback each take a rate limit token
+	require.Equal(t, stats.DoT+stats.UDP, rc.RateLimiter.GetStatistics().Queries)
+
+	// the SLD server isn't tried over TLS again
+	_, trace, status, err = r.IterativeLookup(context.Background(), &Question{Name: "example.test", Type: dns.TypeA, Class: dns.ClassINET})
+	require.NoError(t, err)
+	require.Equal(t, StatusNoError, status)
+	require.Equal(t, UDPProtocol, trace[len(trace)-1].Protocol)
+	require.Equal(t, uint64(3), rc.QueryStatistics.GetStatistics().DoT)
+
+	// nor by another resolver sharing the set of name servers without TLS
+	rc.NoTLSNameServers = NewNoTLSNameServers(10)
+	rc.NoTLSNameServers.Add(h.sld.IP)
+	rc.QueryStatistics = new(QueryStatistics)
+	r = initTestResolver(t, rc)
+	_, _, status, err = r.IterativeLookup(context.Background(), &Question{Name: "ns1.example.test", Type: dns.TypeA, Class: dns.ClassINET})
+	require.NoError(t, err)
+	require.Equal(t, StatusNoError, status)
+	// the referrals to the SLD server are cached, so it's the only one queried
+	stats = rc.QueryStatistics.GetStatistics()
+	require.Equal(t, uint64(0), stats.DoT)
+	require.Equal(t, uint64(1), stats.UDP)
+}
+
+func TestHermeticExternalLookup(t *testing.T) {
+	h := newHermeticNetwork(t)
+	ports := h.network.Ports()
+	q := &Question{Name: "www.example.test", Type: dns.TypeA, Class: dns.ClassINET}
+
+	t.Run("UDP and TCP", func(t *testing.T) {
+		for _, mode := range []transportMode{UDPOnly, TCPOnly} {
+			rc := h.resolverConfig()
+			rc.TransportMode = mode
+			r := initTestResolver(t, rc)
+			res, _, status, err := r.ExternalLookup(context.Background(), q, nil)
+			require.NoError(t, err)
+			require.Equal(t, StatusNoError, status)
+			requireSingleA(t, res, "192.0.2.1")
+		}
+	})
+	t.Run("DNS over TLS", func(t *testing.T) {
+		rc := h.resolverConfig()
+		rc.DNSOverTLS = true
+		rc.RootCAs = x509.NewCertPool()
+		require.True(t, rc.RootCAs.AppendCertsFromPEM(h.network.CertificatePEM()))
+		rc.VerifyServerCert = true
+		ns := &NameServer{IP: h.sld.IP, Port: uint16(ports.TLS), DomainName: "localhost"}
+		rc.ExternalNameServersV4 = []NameServer{*ns}
+		r := initTestResolver(t, rc)
+		res, _, status, err := r.ExternalLookup(context.Background(), q, ns)
+		require.NoError(t, err)
+		require.Equal(t, StatusNoError, status)
+		requireSingleA(t, res, "192.0.2.1")
+	})
+	t.Run("DNS over HTTPS", func(t *testing.T) {
+		rc := h.resolverConfig()
+		rc.DNSOverHTTPS = true
+		ns := &NameServer{IP: h.sld.IP, Port: uint16(ports.HTTPS), DomainName: "https://" + h.sld.HTTPSAddr() + "/dns-query"}
+		rc.ExternalNameServersV4 = []NameServer{*ns}
+		r := initTestResolver(t, rc)
+		res, _, status, err := r.ExternalLookup(context.Background(), q, ns)
+		require.NoError(t, err)
+		require.Equal(t, StatusNoError, status)
+		requireSingleA(t, res, "192.0.2.1")
+	})
+	t.Run("DNS over QUIC", func(t *testing.T) {
+		rc := h.resolverConfig()
+		rc.DNSOverQUIC = true
+		rc.RootCAs = x509.NewCertPool()
+		require.True(t, rc.RootCAs.AppendCertsFromPEM(h.network.CertificatePEM()))
+		rc.VerifyServerCert = true
+		ns := &NameServer{IP: h.sld.IP, Port: uint16(ports.QUIC), DomainName: "localhost"}
+		rc.ExternalNameServersV4 = []NameServer{*ns}
+		r := initTestResolver(t, rc)
+		res, _, status, err := r.ExternalLookup(context.Background(), q, ns)
+		require.NoError(t, err)
+		require.Equal(t, StatusNoError, status)
+		requireSingleA(t, res, "192.0.2.1")
+		require.Equal(t, DoQProtocol, res.Protocol)
+		require.NotNil(t, res.TLSServerHandshake)
+
+		// a second query is sent on a new stream of the same connection
+		conn := r.connInfoIPv4Loopback.quicConn
+		require.NotNil(t, conn)
+		res, _, status, err = r.ExternalLookup(context.Background(), &Question{Name: "example.test", Type: dns.TypeA, Class: dns.ClassINET}, ns)
+		require.NoError(t, err)
+		require.Equal(t, StatusNoError, status)
+		requireSingleA(t, res, "192.0.2.1")
+		require.Same(t, conn, r.connInfoIPv4Loopback.quicConn)
+	})
+	t.Run("DNS over QUIC with an unverifiable certificate", func(t *testing.T) {
+		rc := h.resolverConfig()
+		rc.DNSOverQUIC = true
+		rc.RootCAs = x509.NewCertPool()
+		require.True(t, rc.RootCAs.AppendCertsFromPEM(h.network.CertificatePEM()))
+		rc.VerifyServerCert = true
+		ns := &NameServer{IP: h.sld.IP, Port: uint16(ports.QUIC), DomainName: "wrong.example"}
+		rc.ExternalNameServersV4 = []NameServer{*ns}
+		r := initTestResolver(t, rc)
+		_, _, status, err := r.ExternalLookup(context.Background(), q, ns)
+		require.Error(t, err)
+		require.NotEqual(t, StatusNoError, status)
+	})
+	t.Run("DNS over QUIC to an unresponsive server", func(t *testing.T) {
+		rc := h.resolverConfig()
+		rc.DNSOverQUIC = true
+		ns := &NameServer{IP: h.slow.IP, Port: uint16(ports.QUIC), DomainName: "localhost"}
+		rc.ExternalNameServersV4 = []NameServer{*ns}
+		r := initTestResolver(t, rc)
+		start := time.Now()
+		_, _, status, _ := r.ExternalLookup(context.Background(), q, ns)
+		require.Equal(t, StatusTimeout, status)
+		// the query is retried once, and each attempt stops reading at the network timeout
+		require.Less(t, time.Since(start), 4*rc.NetworkTimeout)
+	})
+	var transports []string
+	for _, query := range h.sld.Queries() {
+		transports = append(transports, query.Transport)
+	}
+	require.Equal(t, []string{testserver.TransportUDP, testserver.TransportTCP, testserver.TransportTLS, testserver.TransportHTTPS,
+		testserver.TransportQUIC, testserver.TransportQUIC}, transports)
+}
+
+// Test that the referred name servers of every layer are queried on the iteration port, and that none of them becomes
+// the default name server of later external lookups
+func TestHermeticLookupAllNameserversIterative(t *testing.T) {
+	h := newHermeticNetwork(t)
+	r := initTestResolver(t, h.resolverConfig())
+
+	res, _, status, err := r.LookupAllNameserversIterative(&Question{Name: "example.test", Type: dns.TypeA, Class: dns.ClassINET}, nil)
+	require.NoError(t, err)
+	require.Equal(t, StatusNoError, status)
+	for _, layer := range []string{".", "test", "example.test"} {
+		require.NotEmpty(t, res.LayeredResponses[layer], layer)
+		for _, result := range res.LayeredResponses[layer] {
+			require.Equal(t, StatusNoError, result.Status, layer)
+		}
+	}
+	require.Nil(t, r.lastUsedExternalNameServer)
+}
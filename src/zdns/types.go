@@ -23,6 +23,7 @@ import (
 const (
 	DoHProtocol = "DoH"
 	DoTProtocol = "DoT"
+	DoQProtocol = "DoQ"
 	UDPProtocol = "udp"
 	TCPProtocol = "tcp"
 )
@@ -39,6 +40,7 @@ const (
 	DefaultDNSPort = 53
 	DefaultDoHPort = 443
 	DefaultDoTPort = 853
+	DefaultDoQPort = 853
 )
 
 func GetTransportMode(useUDP, useTCP bool) transportMode {
@@ -129,6 +131,8 @@ func (ns *NameServer) String() string {
 	return ""
 }
 
+// PopulateDefaultPort sets the port of the name server to the default for the transport if it isn't already set.
+// DNS over QUIC uses the same default port as DNS over TLS, so usingDoT should be set for either.
 func (ns *NameServer) PopulateDefaultPort(usingDoT, usingDoH bool) {
 	if ns.Port != 0 {
 		return
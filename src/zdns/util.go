@@ -172,6 +172,12 @@ func SafeStatus(status Status) bool {
 	return status == StatusNoError
 }
 
+// IsMissingStatus returns true if a status means the name has no records of the type looked up, rather than that the
+// lookup failed
+func IsMissingStatus(status Status) bool {
+	return status == StatusNXDomain || status == StatusNoAnswer || status == StatusNoRecord
+}
+
 // Verify that A record is indeed IPv4 and AAAA is IPv6
 func VerifyAddress(ansType string, ip string) bool {
 	isIpv4 := false
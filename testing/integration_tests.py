@@ -543,6 +543,23 @@ class Tests(unittest.TestCase):
     }
 
     SPF_ANSWER = {"data": {"spf": "v=spf1 mx include:_spf.google.com -all"}}
+    SPF_TERMS = [
+        {"term": "mx", "qualifier": "pass", "mechanism": "mx"},
+        {
+            "term": "include:_spf.google.com",
+            "qualifier": "pass",
+            "mechanism": "include",
+        },
+        {"term": "-all", "qualifier": "fail", "mechanism": "all"},
+    ]
+    # networks of the mx mechanism from the exchanges in zdns-testing.com
+    SPF_MX_RANGES = [
+        "1.2.3.4/32",
+        "2.3.4.5/32",
+        "5.6.7.8/32",
+        "fdb3:ac76:a577::4/128",
+        "fdb3:ac76:a577::5/128",
+    ]
 
     SOA_ANSWERS = [
         {
@@ -681,6 +698,34 @@ class Tests(unittest.TestCase):
             recursiveSort(correct["results"]["NSLOOKUP"]["data"]["servers"]),
         )
 
+    def assertEqualSPF(self, res, name):
+        data = res["results"]["SPF"]["data"]
+        self.assertEqual(data["spf"], self.SPF_ANSWER["data"]["spf"])
+        self.assertEqual(
+            set(data.keys()),
+            {"spf", "ipv4_ranges", "ipv6_ranges", "dns_lookups", "policy"},
+        )
+        policy = data["policy"]
+        self.assertEqual(policy["domain"], name)
+        self.assertEqual(policy["record"], self.SPF_ANSWER["data"]["spf"])
+        terms = policy["terms"]
+        self.assertEqual(
+            [{k: t[k] for k in ("term", "qualifier", "mechanism")} for t in terms],
+            self.SPF_TERMS,
+        )
+        self.assertEqual(terms[0]["domain"], name)
+        self.assertCountEqual(
+            terms[0]["hosts"],
+            ["mx1.zdns-testing.com", "mx2.zdns-testing.com", "mx1.censys.io"],
+        )
+        for network in self.SPF_MX_RANGES:
+            self.assertIn(network, terms[0]["ranges"])
+            self.assertIn(network, data["ipv4_ranges"] + data["ipv6_ranges"])
+        self.assertEqual(terms[1]["domain"], "_spf.google.com")
+        self.assertEqual(terms[1]["policy"]["domain"], "_spf.google.com")
+        self.assertNotIn("error", terms[1]["policy"])
+        self.assertNotIn("ranges", terms[2])
+
     def assertEqualTypes(self, res, list):
         res_types = set()
         for rr in res["data"]["answers"]:
@@ -1130,28 +1175,28 @@ class Tests(unittest.TestCase):
         name = "zdns-testing.com"
         cmd, res = self.run_zdns(c, name)
         self.assertSuccess(res, cmd, "SPF")
-        self.assertEqual(res["results"]["SPF"]["data"], self.SPF_ANSWER["data"])
+        self.assertEqualSPF(res, name)
 
     def test_spf_lookup_iterative(self):
         c = "spf --iterative"
         name = "zdns-testing.com"
         cmd, res = self.run_zdns(c, name)
         self.assertSuccess(res, cmd, "SPF")
-        self.assertEqual(res["results"]["SPF"]["data"], self.SPF_ANSWER["data"])
+        self.assertEqualSPF(res, name)
 
     def test_dmarc_lookup(self):
         c = "dmarc"
         name = "_dmarc.zdns-testing.com"
         cmd, res = self.run_zdns(c, name)
         self.assertSuccess(res, cmd, "DMARC")
-        self.assertEqual(res["results"]["DMARC"]["data"], self.DMARC_ANSWER["data"])
+        self.assertEqual(res["results"]["DMARC"]["data"]["dmarc"], self.DMARC_ANSWER["data"]["dmarc"])
 
     def test_dmarc_lookup_iterative(self):
         c = "dmarc --iterative"
         name = "_dmarc.zdns-testing.com"
         cmd, res = self.run_zdns(c, name)
         self.assertSuccess(res, cmd, "DMARC")
-        self.assertEqual(res["results"]["DMARC"]["data"], self.DMARC_ANSWER["data"])
+        self.assertEqual(res["results"]["DMARC"]["data"]["dmarc"], self.DMARC_ANSWER["data"]["dmarc"])
 
     def test_ptr(self):
         c = "PTR"